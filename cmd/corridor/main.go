@@ -0,0 +1,62 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"trafficlightAPI/internal/corridor"
+)
+
+func main() {
+	corridorPath := flag.String("corridor", "./examples/corridor.yaml", "перекрестки магистрали: планы, положение и скорости")
+	outPath := flag.String("out", "", "файл для плана со смещениями в формате plans.yaml")
+	diagramPath := flag.String("diagram", "", "файл для пространственно-временной диаграммы (PNG)")
+	cycles := flag.Int("cycles", 3, "число циклов на диаграмме")
+	flag.Parse()
+
+	c, err := corridor.LoadCorridor(*corridorPath)
+	if err != nil {
+		slog.Error("ошибка при загрузке магистрали", "path", *corridorPath, "error", err)
+		os.Exit(1)
+	}
+
+	result, err := corridor.Optimize(c)
+	if err != nil {
+		slog.Error("ошибка расчета смещений", "error", err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("cycle: %ds, outbound band: %.1fs, inbound band: %.1fs\n", result.Cycle, result.Outbound.Width, result.Inbound.Width)
+	for _, light := range result.Plan.Lights {
+		fmt.Printf("%s: offset %ds\n", light.Name, light.Offset)
+	}
+
+	if *outPath != "" {
+		if err := writePlan(*outPath, result); err != nil {
+			slog.Error("ошибка при сохранении плана", "path", *outPath, "error", err)
+			os.Exit(1)
+		}
+	}
+
+	if *diagramPath != "" {
+		diagram, err := corridor.Diagram(c, result, *cycles)
+		if err == nil {
+			err = os.WriteFile(*diagramPath, diagram, 0644)
+		}
+		if err != nil {
+			slog.Error("ошибка при сохранении диаграммы", "path", *diagramPath, "error", err)
+			os.Exit(1)
+		}
+	}
+}
+
+func writePlan(path string, result corridor.Result) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	return corridor.WritePlan(file, result)
+}
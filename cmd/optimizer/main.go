@@ -0,0 +1,95 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"text/tabwriter"
+	"trafficlightAPI/internal/optimizer"
+	"trafficlightAPI/internal/simulator"
+)
+
+func main() {
+	intersectionPath := flag.String("intersection", "./examples/intersection.yaml", "описание светофоров и подходов перекрестка")
+	constraintsPath := flag.String("constraints", "./examples/optimizer.yaml", "целевая функция и границы длительностей")
+	countsPath := flag.String("counts", "", "CSV с подсчетами approach,start,duration,count (вместо rate)")
+	duration := flag.Float64("duration", 3600, "длительность одной симуляции, с")
+	seed := flag.Uint64("seed", 1, "зерно поиска и генератора прибытий")
+	workers := flag.Int("workers", 0, "число параллельных симуляций (0 - по числу ядер)")
+	population := flag.Int("population", 30, "размер популяции")
+	generations := flag.Int("generations", 20, "число поколений")
+	candidates := flag.Int("candidates", 5, "сколько лучших планов вывести")
+	replications := flag.Int("replications", 1, "прогонов симуляции на план")
+	outDir := flag.String("out-dir", "", "каталог для планов в формате plans.yaml")
+	flag.Parse()
+
+	intersection, err := simulator.LoadIntersection(*intersectionPath)
+	if err != nil {
+		slog.Error("ошибка при загрузке перекрестка", "path", *intersectionPath, "error", err)
+		os.Exit(1)
+	}
+	constraints, err := optimizer.LoadConstraints(*constraintsPath)
+	if err != nil {
+		slog.Error("ошибка при загрузке ограничений", "path", *constraintsPath, "error", err)
+		os.Exit(1)
+	}
+
+	opts := optimizer.Options{
+		Seed:         *seed,
+		Workers:      *workers,
+		Population:   *population,
+		Generations:  *generations,
+		Candidates:   *candidates,
+		Replications: *replications,
+		Simulation:   simulator.Options{Duration: *duration},
+	}
+	if *countsPath != "" {
+		if opts.Simulation.Counts, err = simulator.LoadCounts(*countsPath); err != nil {
+			slog.Error("ошибка при загрузке подсчетов", "path", *countsPath, "error", err)
+			os.Exit(1)
+		}
+	}
+
+	ranked, err := optimizer.Optimize(intersection, constraints, opts)
+	if err != nil {
+		slog.Error("ошибка оптимизации", "error", err)
+		os.Exit(1)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "rank\t%s\tavg_delay,s\tstops\tthroughput\tplan\n", constraints.Objective)
+	for _, c := range ranked {
+		plan := ""
+		for _, light := range c.Lights {
+			plan += fmt.Sprintf("%s=%v+%d ", light.Name, light.Durations, light.Offset)
+		}
+		fmt.Fprintf(w, "%d\t%.0f\t%.1f\t%.0f\t%.0f\t%s\n", c.Rank, c.Score, c.AverageDelay, c.Stops, c.Throughput, plan)
+	}
+	w.Flush()
+
+	if *outDir == "" {
+		return
+	}
+	if err := os.MkdirAll(*outDir, 0755); err != nil {
+		slog.Error("ошибка при создании каталога", "path", *outDir, "error", err)
+		os.Exit(1)
+	}
+	for _, c := range ranked {
+		if err := writePlan(filepath.Join(*outDir, fmt.Sprintf("plan_%02d.yaml", c.Rank)), c); err != nil {
+			slog.Error("ошибка при сохранении плана", "rank", c.Rank, "error", err)
+			os.Exit(1)
+		}
+	}
+}
+
+func writePlan(path string, c optimizer.Candidate) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	return optimizer.WritePlan(file, c)
+}
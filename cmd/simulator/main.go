@@ -0,0 +1,55 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"text/tabwriter"
+	"trafficlightAPI/internal/simulator"
+)
+
+func main() {
+	intersectionPath := flag.String("intersection", "./examples/intersection.yaml", "описание светофоров и подходов перекрестка")
+	countsPath := flag.String("counts", "", "CSV с подсчетами approach,start,duration,count (вместо rate)")
+	duration := flag.Float64("duration", 3600, "длительность симуляции, с")
+	seed := flag.Uint64("seed", 1, "зерно генератора прибытий")
+	asJSON := flag.Bool("json", false, "вывести отчет в JSON")
+	flag.Parse()
+
+	intersection, err := simulator.LoadIntersection(*intersectionPath)
+	if err != nil {
+		slog.Error("ошибка при загрузке перекрестка", "path", *intersectionPath, "error", err)
+		os.Exit(1)
+	}
+
+	opts := simulator.Options{Duration: *duration, Seed: *seed}
+	if *countsPath != "" {
+		if opts.Counts, err = simulator.LoadCounts(*countsPath); err != nil {
+			slog.Error("ошибка при загрузке подсчетов", "path", *countsPath, "error", err)
+			os.Exit(1)
+		}
+	}
+
+	report, err := simulator.Run(intersection, opts)
+	if err != nil {
+		slog.Error("ошибка симуляции", "error", err)
+		os.Exit(1)
+	}
+
+	if *asJSON {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		encoder.Encode(report)
+		return
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "approach\tarrivals\tthroughput\tavg_delay,s\tmax_queue\tstops\tremaining")
+	for _, a := range report.Approaches {
+		fmt.Fprintf(w, "%s\t%d\t%d\t%.1f\t%d\t%d\t%d\n", a.Name, a.Arrivals, a.Throughput, a.AverageDelay, a.MaxQueue, a.Stops, a.Remaining)
+	}
+	fmt.Fprintf(w, "total\t\t%d\t%.1f\t\t%d\t\n", report.Throughput, report.AverageDelay, report.Stops)
+	w.Flush()
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"flag"
+	"log/slog"
+	"os"
+	"trafficlightAPI/internal/models"
+	"trafficlightAPI/internal/sumo"
+
+	"gopkg.in/yaml.v3"
+)
+
+func main() {
+	importPath := flag.String("import", "", "файл SUMO (additional или net.xml) для преобразования в plans.yaml")
+	exportPath := flag.String("export", "", "файл plans.yaml для преобразования в tlLogic")
+	linksPath := flag.String("links", "", "карта связей светофоров для tlLogic перекрестков из netconvert")
+	flag.Parse()
+
+	switch {
+	case *importPath != "":
+		data, err := os.ReadFile(*importPath)
+		if err != nil {
+			slog.Error("ошибка при чтении файла", "path", *importPath, "error", err)
+			os.Exit(1)
+		}
+		var links sumo.LinkMap
+		if *linksPath != "" {
+			if links, err = sumo.LoadLinks(*linksPath); err != nil {
+				slog.Error("ошибка при чтении карты связей", "path", *linksPath, "error", err)
+				os.Exit(1)
+			}
+		}
+		defs, err := sumo.ImportLinks(data, links)
+		if err != nil {
+			slog.Error("ошибка импорта", "error", err)
+			os.Exit(1)
+		}
+		// Файл сети обычно содержит много tlLogic одного типа, сервер такой план не примет.
+		if err := models.ValidateDefinitions(defs); err != nil {
+			slog.Warn("план нельзя загрузить в plans_path или POST /plans/sumo без правки", "error", err)
+		}
+		encoder := yaml.NewEncoder(os.Stdout)
+		encoder.SetIndent(2)
+		encoder.Encode(defs)
+	case *exportPath != "":
+		defs, err := models.LoadDefinitions(*exportPath)
+		if err != nil {
+			slog.Error("ошибка при чтении планов", "path", *exportPath, "error", err)
+			os.Exit(1)
+		}
+		data, err := sumo.Export(defs)
+		if err != nil {
+			slog.Error("ошибка экспорта", "error", err)
+			os.Exit(1)
+		}
+		os.Stdout.Write(data)
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"trafficlightAPI/internal/audit"
+
+	"github.com/pkg/errors"
+)
+
+// runAudit выполняет подкоманду audit verify и возвращает код выхода:
+// 0 - цепочка цела, 1 - журнал изменен, 2 - ошибка запуска.
+func runAudit(args []string) int {
+	if len(args) == 0 || args[0] != "verify" {
+		fmt.Fprintln(os.Stderr, "использование: traffic_api audit verify [-path ./logs/audit.log] [-head HASH]")
+		return 2
+	}
+
+	flags := flag.NewFlagSet("audit verify", flag.ContinueOnError)
+	path := flags.String("path", "./logs/audit.log", "журнал аудита")
+	expected := flags.String("head", "", "хеш последней записи, сохраненный ранее, для обнаружения удаления записей с конца")
+	if err := flags.Parse(args[1:]); err != nil {
+		return 2
+	}
+
+	count, head, err := audit.VerifyFile(*path)
+	var tampered *audit.TamperError
+	switch {
+	case errors.As(err, &tampered):
+		fmt.Printf("журнал изменен: строка %d, запись %d: %s\n", tampered.Line, tampered.Seq, tampered.Reason)
+		fmt.Printf("целых записей до изменения: %d\n", count)
+		return 1
+	case err != nil:
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+
+	fmt.Printf("записей: %d, цепочка цела\nhead: %s\n", count, head)
+	if *expected != "" && *expected != head {
+		fmt.Printf("журнал изменен: последняя запись %s не совпадает с ожидаемой %s\n", head, *expected)
+		return 1
+	}
+	return 0
+}
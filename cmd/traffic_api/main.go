@@ -1,13 +1,20 @@
 package main
 
 import (
+	"os"
 	"trafficlightAPI/internal/config"
 	"trafficlightAPI/internal/handlers"
 	"trafficlightAPI/internal/middleware/logger"
 )
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "audit" {
+		os.Exit(runAudit(os.Args[2:]))
+	}
+
 	cfg := config.MustLoad()
 	logger := logger.InitLogger("", cfg.Env)
-	handlers.Run(cfg, logger)
+	if err := handlers.Run(cfg, logger); err != nil {
+		os.Exit(1)
+	}
 }
@@ -15,46 +15,271 @@ const docTemplate = `{
     "host": "{{.Host}}",
     "basePath": "{{.BasePath}}",
     "paths": {
-        "/trafficlight": {
+        "/cluster/apply": {
             "post": {
                 "consumes": [
                     "application/json"
                 ],
+                "tags": [
+                    "Cluster"
+                ],
+                "summary": "Apply a storage mutation forwarded by a follower (leader only)",
+                "parameters": [
+                    {
+                        "description": "Mutation",
+                        "name": "body",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/storage.Mutation"
+                        }
+                    }
+                ],
+                "responses": {
+                    "204": {
+                        "description": "No Content"
+                    },
+                    "400": {
+                        "description": "Invalid mutation",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "Record not found",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    },
+                    "503": {
+                        "description": "Not the leader",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/cluster/read-index": {
+            "get": {
                 "produces": [
                     "application/json"
                 ],
                 "tags": [
-                    "Trafficlight"
+                    "Cluster"
                 ],
-                "summary": "Processing of traffic light control request",
+                "summary": "Confirm leadership and return the index a follower must apply before a linearizable read",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/cluster.ReadIndexResponse"
+                        }
+                    },
+                    "503": {
+                        "description": "Not the leader",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/cluster/status": {
+            "get": {
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Cluster"
+                ],
+                "summary": "Raft state of this replica",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/cluster.Status"
+                        }
+                    }
+                }
+            }
+        },
+        "/devices": {
+            "get": {
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Devices"
+                ],
+                "summary": "Registered devices",
                 "parameters": [
                     {
-                        "enum": [
-                            1,
-                            2,
-                            3
-                        ],
                         "type": "integer",
-                        "description": "Type of the trafficlight",
+                        "description": "Trafficlight type",
                         "name": "type",
-                        "in": "query",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Intersection",
+                        "name": "intersection",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Tag",
+                        "name": "tag",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/storage.Device"
+                            }
+                        }
+                    },
+                    "400": {
+                        "description": "Invalid request data",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    },
+                    "503": {
+                        "description": "Registry disabled",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    }
+                }
+            },
+            "post": {
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Devices"
+                ],
+                "summary": "Register a device with its trafficlight type, intersection, location and tags",
+                "parameters": [
+                    {
+                        "description": "Device",
+                        "name": "body",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/handlers.DeviceRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "201": {
+                        "description": "Created",
+                        "schema": {
+                            "$ref": "#/definitions/storage.Device"
+                        }
+                    },
+                    "400": {
+                        "description": "Invalid request data",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    },
+                    "409": {
+                        "description": "Device already registered",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    },
+                    "503": {
+                        "description": "Registry disabled",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/devices/{uuid}": {
+            "get": {
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Devices"
+                ],
+                "summary": "Registered device",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Device UUID",
+                        "name": "uuid",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/storage.Device"
+                        }
+                    },
+                    "404": {
+                        "description": "Device not registered",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    },
+                    "503": {
+                        "description": "Registry disabled",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    }
+                }
+            },
+            "put": {
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Devices"
+                ],
+                "summary": "Replace data of a registered device",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Device UUID",
+                        "name": "uuid",
+                        "in": "path",
                         "required": true
                     },
                     {
-                        "description": "Json request",
+                        "description": "Device",
                         "name": "body",
                         "in": "body",
                         "required": true,
                         "schema": {
-                            "$ref": "#/definitions/models.TrafficRequest"
+                            "$ref": "#/definitions/handlers.DeviceRequest"
                         }
                     }
                 ],
                 "responses": {
                     "200": {
-                        "description": "Json response",
+                        "description": "OK",
                         "schema": {
-                            "$ref": "#/definitions/models.TrafficResponse"
+                            "$ref": "#/definitions/storage.Device"
                         }
                     },
                     "400": {
@@ -63,57 +288,2503 @@ const docTemplate = `{
                             "$ref": "#/definitions/models.ErrorResponse"
                         }
                     },
-                    "500": {
-                        "description": "Server error",
+                    "404": {
+                        "description": "Device not registered",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    },
+                    "503": {
+                        "description": "Registry disabled",
                         "schema": {
                             "$ref": "#/definitions/models.ErrorResponse"
                         }
                     }
                 }
-            }
-        }
-    },
-    "definitions": {
-        "models.ErrorResponse": {
-            "type": "object",
-            "properties": {
-                "error": {
-                    "type": "string"
+            },
+            "delete": {
+                "tags": [
+                    "Devices"
+                ],
+                "summary": "Unregister a device",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Device UUID",
+                        "name": "uuid",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "204": {
+                        "description": "No Content"
+                    },
+                    "404": {
+                        "description": "Device not registered",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    },
+                    "503": {
+                        "description": "Registry disabled",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    }
                 }
             }
         },
-        "models.TrafficRequest": {
-            "type": "object",
-            "properties": {
-                "current_state": {
-                    "type": "integer"
-                },
-                "current_time": {
-                    "description": "Указатель для проверки существования",
-                    "type": "integer"
-                },
-                "need_image": {
-                    "type": "boolean"
-                },
-                "uuid": {
-                    "type": "string"
+        "/events": {
+            "get": {
+                "produces": [
+                    "text/csv"
+                ],
+                "tags": [
+                    "Events"
+                ],
+                "summary": "Download high-resolution controller events of a trafficlight as CSV",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Trafficlight UUID",
+                        "name": "uuid",
+                        "in": "query",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Start of the interval, RFC3339 (default: an hour ago)",
+                        "name": "from",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "End of the interval, RFC3339 (default: now)",
+                        "name": "to",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "signal_id,timestamp,event_code,event_param",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "400": {
+                        "description": "Invalid request data",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    }
                 }
-            }
-        },
-        "models.TrafficResponse": {
-            "type": "object",
-            "properties": {
-                "image": {
-                    "type": "string"
-                },
-                "next_countdown_time": {
-                    "type": "string"
-                },
-                "next_state": {
-                    "type": "string"
-                },
-                "uuid": {
-                    "type": "string"
+            },
+            "post": {
+                "consumes": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Events"
+                ],
+                "summary": "Record detector actuations, preemption and pedestrian calls reported by a device",
+                "parameters": [
+                    {
+                        "description": "Device events",
+                        "name": "body",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/handlers.DeviceEvent"
+                            }
+                        }
+                    }
+                ],
+                "responses": {
+                    "204": {
+                        "description": "No Content"
+                    },
+                    "400": {
+                        "description": "Invalid request data",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    },
+                    "401": {
+                        "description": "Invalid device signature",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    },
+                    "403": {
+                        "description": "UUID of another device",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    },
+                    "503": {
+                        "description": "Event log disabled",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/faults": {
+            "get": {
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Faults"
+                ],
+                "summary": "Reported lamp faults",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Trafficlight UUID",
+                        "name": "uuid",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/storage.Fault"
+                            }
+                        }
+                    },
+                    "503": {
+                        "description": "Fault tracking disabled",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    }
+                }
+            },
+            "post": {
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Faults"
+                ],
+                "summary": "Report a failed lamp detected by a field controller",
+                "parameters": [
+                    {
+                        "description": "Lamp fault",
+                        "name": "body",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/handlers.FaultRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "201": {
+                        "description": "Created",
+                        "schema": {
+                            "$ref": "#/definitions/storage.Fault"
+                        }
+                    },
+                    "400": {
+                        "description": "Invalid request data",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    },
+                    "401": {
+                        "description": "Invalid device signature",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    },
+                    "403": {
+                        "description": "UUID of another device",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    },
+                    "503": {
+                        "description": "Fault tracking disabled",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/faults/{uuid}/{lamp}": {
+            "delete": {
+                "tags": [
+                    "Faults"
+                ],
+                "summary": "Clear a lamp fault after repair",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Trafficlight UUID",
+                        "name": "uuid",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "enum": [
+                            "red",
+                            "yellow",
+                            "green",
+                            "arrow"
+                        ],
+                        "type": "string",
+                        "description": "Lamp",
+                        "name": "lamp",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "204": {
+                        "description": "No Content"
+                    },
+                    "401": {
+                        "description": "Not authenticated",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    },
+                    "403": {
+                        "description": "Insufficient role",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "Fault not found",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    },
+                    "503": {
+                        "description": "Fault tracking disabled",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/geo/bbox": {
+            "get": {
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Geo"
+                ],
+                "summary": "Registered lights within a bounding box",
+                "parameters": [
+                    {
+                        "type": "number",
+                        "description": "South edge",
+                        "name": "min_lat",
+                        "in": "query",
+                        "required": true
+                    },
+                    {
+                        "type": "number",
+                        "description": "West edge",
+                        "name": "min_lon",
+                        "in": "query",
+                        "required": true
+                    },
+                    {
+                        "type": "number",
+                        "description": "North edge",
+                        "name": "max_lat",
+                        "in": "query",
+                        "required": true
+                    },
+                    {
+                        "type": "number",
+                        "description": "East edge",
+                        "name": "max_lon",
+                        "in": "query",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/handlers.GeoDevice"
+                            }
+                        }
+                    },
+                    "400": {
+                        "description": "Invalid request data",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    },
+                    "503": {
+                        "description": "Registry disabled",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/geo/lights": {
+            "get": {
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Geo"
+                ],
+                "summary": "Registered lights with coordinates and their live state as a GeoJSON FeatureCollection",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/geo.FeatureCollection"
+                        }
+                    },
+                    "503": {
+                        "description": "Registry disabled",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/geo/nearest": {
+            "get": {
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Geo"
+                ],
+                "summary": "Nearest registered light ahead along a heading",
+                "parameters": [
+                    {
+                        "type": "number",
+                        "description": "Latitude of the vehicle",
+                        "name": "lat",
+                        "in": "query",
+                        "required": true
+                    },
+                    {
+                        "type": "number",
+                        "description": "Longitude of the vehicle",
+                        "name": "lon",
+                        "in": "query",
+                        "required": true
+                    },
+                    {
+                        "type": "number",
+                        "description": "Heading in degrees clockwise from north, [0, 360)",
+                        "name": "heading",
+                        "in": "query",
+                        "required": true
+                    },
+                    {
+                        "type": "number",
+                        "description": "Max deviation from the heading in degrees (default 45)",
+                        "name": "tolerance",
+                        "in": "query"
+                    },
+                    {
+                        "type": "number",
+                        "description": "Search distance in meters (default 1000)",
+                        "name": "max_distance",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.GeoDevice"
+                        }
+                    },
+                    "400": {
+                        "description": "Invalid request data",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "No light ahead",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    },
+                    "503": {
+                        "description": "Registry disabled",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/geo/radius": {
+            "get": {
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Geo"
+                ],
+                "summary": "Registered lights within a radius, nearest first",
+                "parameters": [
+                    {
+                        "type": "number",
+                        "description": "Latitude of the center",
+                        "name": "lat",
+                        "in": "query",
+                        "required": true
+                    },
+                    {
+                        "type": "number",
+                        "description": "Longitude of the center",
+                        "name": "lon",
+                        "in": "query",
+                        "required": true
+                    },
+                    {
+                        "type": "number",
+                        "description": "Radius in meters",
+                        "name": "radius",
+                        "in": "query",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/handlers.GeoDevice"
+                            }
+                        }
+                    },
+                    "400": {
+                        "description": "Invalid request data",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    },
+                    "503": {
+                        "description": "Registry disabled",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/heartbeats": {
+            "get": {
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Devices"
+                ],
+                "summary": "Heartbeat status of tracked devices",
+                "parameters": [
+                    {
+                        "enum": [
+                            "online",
+                            "degraded",
+                            "offline"
+                        ],
+                        "type": "string",
+                        "description": "Filter by status",
+                        "name": "status",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/heartbeat.Device"
+                            }
+                        }
+                    },
+                    "400": {
+                        "description": "Invalid status",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    },
+                    "503": {
+                        "description": "Heartbeat tracking disabled",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/heartbeats/stale": {
+            "get": {
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Devices"
+                ],
+                "summary": "Devices that missed their expected heartbeats (degraded and offline)",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/heartbeat.Device"
+                            }
+                        }
+                    },
+                    "503": {
+                        "description": "Heartbeat tracking disabled",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/history": {
+            "get": {
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "History"
+                ],
+                "summary": "State, plan and mode of a trafficlight at a past moment with the surrounding transitions",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Trafficlight UUID",
+                        "name": "uuid",
+                        "in": "query",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Moment, RFC3339 (default: now)",
+                        "name": "at",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Transitions to return on each side (default: 5)",
+                        "name": "around",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/history.Snapshot"
+                        }
+                    },
+                    "400": {
+                        "description": "Invalid request data",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "No history at the moment",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    },
+                    "503": {
+                        "description": "History disabled",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/lights": {
+            "get": {
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Lights"
+                ],
+                "summary": "Trafficlights known to the service with their last known state",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/lights.Entry"
+                            }
+                        }
+                    },
+                    "503": {
+                        "description": "Registry disabled",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/monitor": {
+            "get": {
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Monitor"
+                ],
+                "summary": "Intersections latched in conflict monitor failsafe",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/storage.MonitorFault"
+                            }
+                        }
+                    },
+                    "503": {
+                        "description": "Conflict monitor disabled",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/monitor/outputs": {
+            "post": {
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Monitor"
+                ],
+                "summary": "Report the lamps a device actually drives to the conflict monitor",
+                "parameters": [
+                    {
+                        "description": "Device outputs",
+                        "name": "body",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/handlers.OutputReport"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.MonitorStatus"
+                        }
+                    },
+                    "400": {
+                        "description": "Light is not monitored",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    },
+                    "401": {
+                        "description": "Invalid device signature",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    },
+                    "403": {
+                        "description": "UUID of another device",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    },
+                    "503": {
+                        "description": "Conflict monitor disabled",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/monitor/{intersection}/reset": {
+            "post": {
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Monitor"
+                ],
+                "summary": "Reset a latched conflict monitor fault and resume normal operation",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Intersection",
+                        "name": "intersection",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "Reason of the reset",
+                        "name": "body",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/handlers.MonitorResetRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/storage.MonitorFault"
+                        }
+                    },
+                    "400": {
+                        "description": "Invalid request data",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    },
+                    "401": {
+                        "description": "Not authenticated",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    },
+                    "403": {
+                        "description": "Insufficient role",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "No latched fault",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    },
+                    "503": {
+                        "description": "Conflict monitor disabled",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/overrides": {
+            "get": {
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Overrides"
+                ],
+                "summary": "Active overrides",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/storage.Override"
+                            }
+                        }
+                    },
+                    "503": {
+                        "description": "Overrides disabled",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    }
+                }
+            },
+            "post": {
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Overrides"
+                ],
+                "summary": "Force a state, hold the current one or advance to the next one",
+                "parameters": [
+                    {
+                        "description": "Override",
+                        "name": "body",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/handlers.OverrideRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "201": {
+                        "description": "Created",
+                        "schema": {
+                            "$ref": "#/definitions/storage.Override"
+                        }
+                    },
+                    "400": {
+                        "description": "Invalid request data",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    },
+                    "401": {
+                        "description": "Not authenticated",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    },
+                    "403": {
+                        "description": "Insufficient role",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    },
+                    "503": {
+                        "description": "Overrides disabled",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/overrides/{id}": {
+            "delete": {
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Overrides"
+                ],
+                "summary": "Release an override",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Override ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Reason of the release",
+                        "name": "reason",
+                        "in": "query",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Operator, replaced by the authenticated user",
+                        "name": "user",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/storage.Override"
+                        }
+                    },
+                    "400": {
+                        "description": "Invalid request data",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    },
+                    "401": {
+                        "description": "Not authenticated",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    },
+                    "403": {
+                        "description": "Insufficient role",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "Override not found",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    },
+                    "503": {
+                        "description": "Overrides disabled",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/plans/sumo": {
+            "get": {
+                "produces": [
+                    "text/xml"
+                ],
+                "tags": [
+                    "Plans"
+                ],
+                "summary": "Export current trafficlight programs as SUMO tlLogic",
+                "responses": {
+                    "200": {
+                        "description": "SUMO additional file",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "500": {
+                        "description": "Server error",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    }
+                }
+            },
+            "post": {
+                "consumes": [
+                    "text/xml"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Plans"
+                ],
+                "summary": "Import SUMO tlLogic programs as trafficlight plans",
+                "parameters": [
+                    {
+                        "description": "SUMO additional or net file",
+                        "name": "body",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Applied definitions",
+                        "schema": {
+                            "$ref": "#/definitions/models.Definitions"
+                        }
+                    },
+                    "400": {
+                        "description": "Invalid program",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    },
+                    "401": {
+                        "description": "Not authenticated",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    },
+                    "403": {
+                        "description": "Insufficient role",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/plans/webster": {
+            "post": {
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Plans"
+                ],
+                "summary": "Webster cycle and green split calculation from traffic counts",
+                "parameters": [
+                    {
+                        "enum": [
+                            1,
+                            2,
+                            3
+                        ],
+                        "type": "integer",
+                        "description": "Type of the trafficlight",
+                        "name": "type",
+                        "in": "query",
+                        "required": true
+                    },
+                    {
+                        "description": "Approach flows and saturation flows",
+                        "name": "body",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/handlers.WebsterRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Recommended plan with delay estimates",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.WebsterResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Invalid request data",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    },
+                    "401": {
+                        "description": "Not authenticated",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    },
+                    "403": {
+                        "description": "Insufficient role",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/reports": {
+            "get": {
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Reports"
+                ],
+                "summary": "Performance measures of a trafficlight: arrivals on green, split monitor, pedestrian delay, split failures",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Trafficlight UUID",
+                        "name": "uuid",
+                        "in": "query",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Start of the interval, RFC3339 (default: a day ago)",
+                        "name": "from",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "End of the interval, RFC3339 (default: now)",
+                        "name": "to",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Comma-separated detector channels of the phase (default: all)",
+                        "name": "detectors",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/perfmeasures.Report"
+                        }
+                    },
+                    "400": {
+                        "description": "Invalid request data",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    },
+                    "503": {
+                        "description": "Event log disabled",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/reports/pcd": {
+            "get": {
+                "produces": [
+                    "image/png",
+                    "image/svg+xml"
+                ],
+                "tags": [
+                    "Reports"
+                ],
+                "summary": "Purdue coordination diagram of a trafficlight",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Trafficlight UUID",
+                        "name": "uuid",
+                        "in": "query",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Start of the interval, RFC3339 (default: a day ago)",
+                        "name": "from",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "End of the interval, RFC3339 (default: now)",
+                        "name": "to",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Comma-separated detector channels of the phase (default: all)",
+                        "name": "detectors",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "png (default) or svg",
+                        "name": "format",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "file"
+                        }
+                    },
+                    "400": {
+                        "description": "Invalid request data",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    },
+                    "503": {
+                        "description": "Event log disabled",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/stream/connections": {
+            "get": {
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Stream"
+                ],
+                "summary": "Open streaming connections with per-connection counters",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/stream.ConnectionStats"
+                            }
+                        }
+                    },
+                    "503": {
+                        "description": "Streaming disabled",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/stream/sse": {
+            "get": {
+                "description": "Sends the last known state of each light first, then every phase change with its countdown.\nEvents: \"state\" (stream.Event) and \"dropped\" ({\"dropped\": n}) when events were skipped for a slow client.",
+                "produces": [
+                    "text/event-stream"
+                ],
+                "tags": [
+                    "Stream"
+                ],
+                "summary": "Stream trafficlight state changes as Server-Sent Events",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Trafficlight UUIDs, comma-separated or repeated",
+                        "name": "uuid",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Intersections, comma-separated or repeated",
+                        "name": "intersection",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/stream.Event"
+                        }
+                    },
+                    "400": {
+                        "description": "No uuid or intersection",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    },
+                    "503": {
+                        "description": "Streaming disabled",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/stream/ws": {
+            "get": {
+                "description": "Initial subscription is taken from the query; the client changes it with\n{\"action\":\"subscribe|unsubscribe\",\"uuids\":[...],\"intersections\":[...]}.\nThe server sends handlers.StreamFrame messages: the current state on subscribe, then every phase change.",
+                "tags": [
+                    "Stream"
+                ],
+                "summary": "Stream trafficlight state changes over WebSocket",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Trafficlight UUIDs, comma-separated or repeated",
+                        "name": "uuid",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Intersections, comma-separated or repeated",
+                        "name": "intersection",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "101": {
+                        "description": "Switching Protocols",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.StreamFrame"
+                        }
+                    },
+                    "503": {
+                        "description": "Streaming disabled",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/trafficlight": {
+            "post": {
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Trafficlight"
+                ],
+                "summary": "Processing of traffic light control request",
+                "parameters": [
+                    {
+                        "enum": [
+                            1,
+                            2,
+                            3
+                        ],
+                        "type": "integer",
+                        "description": "Type of the trafficlight, required for unregistered devices",
+                        "name": "type",
+                        "in": "query"
+                    },
+                    {
+                        "description": "Json request",
+                        "name": "body",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/models.TrafficRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Json response",
+                        "schema": {
+                            "$ref": "#/definitions/models.TrafficResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Invalid request data",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    },
+                    "401": {
+                        "description": "Invalid device signature",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    },
+                    "403": {
+                        "description": "UUID of another device",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Server error",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        }
+    },
+    "definitions": {
+        "cluster.Peer": {
+            "type": "object",
+            "properties": {
+                "http_address": {
+                    "description": "Адрес HTTP API для пересылки лидеру, например http://10.0.0.2:8081",
+                    "type": "string"
+                },
+                "id": {
+                    "type": "string"
+                },
+                "raft_address": {
+                    "type": "string"
+                }
+            }
+        },
+        "cluster.ReadIndexResponse": {
+            "type": "object",
+            "properties": {
+                "index": {
+                    "type": "integer"
+                }
+            }
+        },
+        "cluster.Status": {
+            "type": "object",
+            "properties": {
+                "applied_index": {
+                    "type": "integer"
+                },
+                "id": {
+                    "type": "string"
+                },
+                "leader": {
+                    "type": "string"
+                },
+                "peers": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/cluster.Peer"
+                    }
+                },
+                "state": {
+                    "type": "string"
+                }
+            }
+        },
+        "geo.Feature": {
+            "type": "object",
+            "properties": {
+                "geometry": {
+                    "$ref": "#/definitions/geo.Point"
+                },
+                "id": {
+                    "type": "string"
+                },
+                "properties": {
+                    "type": "object",
+                    "additionalProperties": {}
+                },
+                "type": {
+                    "type": "string"
+                }
+            }
+        },
+        "geo.FeatureCollection": {
+            "type": "object",
+            "properties": {
+                "features": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/geo.Feature"
+                    }
+                },
+                "type": {
+                    "type": "string"
+                }
+            }
+        },
+        "geo.Point": {
+            "type": "object",
+            "properties": {
+                "coordinates": {
+                    "description": "Долгота, широта",
+                    "type": "array",
+                    "items": {
+                        "type": "number"
+                    }
+                },
+                "type": {
+                    "type": "string"
+                }
+            }
+        },
+        "handlers.DeviceEvent": {
+            "type": "object",
+            "properties": {
+                "kind": {
+                    "description": "detector_on, detector_off, preempt_on, preempt_off, pedestrian_call",
+                    "type": "string"
+                },
+                "param": {
+                    "type": "integer"
+                },
+                "uuid": {
+                    "type": "string"
+                }
+            }
+        },
+        "handlers.DeviceRequest": {
+            "type": "object",
+            "properties": {
+                "description": {
+                    "type": "string"
+                },
+                "heartbeat_interval": {
+                    "description": "Ожидаемый интервал опроса, с",
+                    "type": "integer"
+                },
+                "intersection": {
+                    "type": "string"
+                },
+                "location": {
+                    "$ref": "#/definitions/storage.Location"
+                },
+                "tags": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "type": {
+                    "type": "integer"
+                },
+                "uuid": {
+                    "description": "Для PUT берется из пути",
+                    "type": "string"
+                }
+            }
+        },
+        "handlers.FaultRequest": {
+            "type": "object",
+            "properties": {
+                "lamp": {
+                    "description": "red, yellow, green, arrow",
+                    "type": "string"
+                },
+                "reason": {
+                    "type": "string"
+                },
+                "type": {
+                    "description": "Для зарегистрированных и уже опрашивавших светофоров берется из реестра",
+                    "type": "integer"
+                },
+                "uuid": {
+                    "type": "string"
+                }
+            }
+        },
+        "handlers.GeoDevice": {
+            "type": "object",
+            "properties": {
+                "device": {
+                    "$ref": "#/definitions/storage.Device"
+                },
+                "distance_m": {
+                    "type": "number"
+                },
+                "state": {
+                    "$ref": "#/definitions/storage.State"
+                }
+            }
+        },
+        "handlers.MonitorResetRequest": {
+            "type": "object",
+            "properties": {
+                "reason": {
+                    "type": "string"
+                }
+            }
+        },
+        "handlers.MonitorStatus": {
+            "type": "object",
+            "properties": {
+                "intersection": {
+                    "type": "string"
+                },
+                "latched": {
+                    "description": "Перекресток в мигающем красном",
+                    "allOf": [
+                        {
+                            "$ref": "#/definitions/storage.MonitorFault"
+                        }
+                    ]
+                }
+            }
+        },
+        "handlers.OutputReport": {
+            "type": "object",
+            "properties": {
+                "lamps": {
+                    "description": "Фактически включенные лампы",
+                    "allOf": [
+                        {
+                            "$ref": "#/definitions/models.Lamps"
+                        }
+                    ]
+                },
+                "uuid": {
+                    "type": "string"
+                }
+            }
+        },
+        "handlers.OverrideRequest": {
+            "type": "object",
+            "properties": {
+                "action": {
+                    "description": "force, hold, advance",
+                    "type": "string"
+                },
+                "expires_in": {
+                    "description": "с, по умолчанию overrides.default_duration",
+                    "type": "integer"
+                },
+                "reason": {
+                    "type": "string"
+                },
+                "state": {
+                    "description": "Для force",
+                    "type": "integer"
+                },
+                "user": {
+                    "description": "Заменяется именем аутентифицированного пользователя",
+                    "type": "string"
+                },
+                "uuid": {
+                    "type": "string"
+                }
+            }
+        },
+        "handlers.StreamFrame": {
+            "type": "object",
+            "properties": {
+                "dropped": {
+                    "description": "Событий отброшено, пока клиент не успевал читать",
+                    "type": "integer"
+                },
+                "error": {
+                    "type": "string"
+                },
+                "event": {
+                    "$ref": "#/definitions/stream.Event"
+                },
+                "type": {
+                    "description": "state, dropped или error",
+                    "type": "string"
+                }
+            }
+        },
+        "handlers.WebsterPlan": {
+            "type": "object",
+            "properties": {
+                "durations": {
+                    "type": "array",
+                    "items": {
+                        "type": "integer"
+                    }
+                },
+                "estimate": {
+                    "$ref": "#/definitions/webster.DelayEstimate"
+                }
+            }
+        },
+        "handlers.WebsterRequest": {
+            "type": "object",
+            "properties": {
+                "apply": {
+                    "description": "План общий для типа: меняется у всех светофоров этого типа",
+                    "type": "boolean"
+                },
+                "approaches": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/webster.Approach"
+                    }
+                }
+            }
+        },
+        "handlers.WebsterResponse": {
+            "type": "object",
+            "properties": {
+                "applied": {
+                    "type": "boolean"
+                },
+                "calculation": {
+                    "$ref": "#/definitions/webster.Result"
+                },
+                "current": {
+                    "$ref": "#/definitions/handlers.WebsterPlan"
+                },
+                "recommended": {
+                    "$ref": "#/definitions/handlers.WebsterPlan"
+                },
+                "type": {
+                    "type": "integer"
+                }
+            }
+        },
+        "heartbeat.Device": {
+            "type": "object",
+            "properties": {
+                "expected_interval": {
+                    "type": "integer"
+                },
+                "last_seen": {
+                    "description": "nil - не опрашивало с запуска сервиса",
+                    "type": "string"
+                },
+                "since": {
+                    "description": "Начало текущего состояния",
+                    "type": "string"
+                },
+                "status": {
+                    "type": "string"
+                },
+                "type": {
+                    "type": "integer"
+                },
+                "uuid": {
+                    "type": "string"
+                }
+            }
+        },
+        "history.Record": {
+            "type": "object",
+            "properties": {
+                "from": {
+                    "type": "integer"
+                },
+                "mode": {
+                    "type": "string"
+                },
+                "plan": {
+                    "type": "array",
+                    "items": {
+                        "type": "integer"
+                    }
+                },
+                "state": {
+                    "type": "integer"
+                },
+                "time": {
+                    "type": "string"
+                },
+                "type": {
+                    "type": "integer"
+                },
+                "uuid": {
+                    "type": "string"
+                }
+            }
+        },
+        "history.Snapshot": {
+            "type": "object",
+            "properties": {
+                "after": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/history.Record"
+                    }
+                },
+                "at": {
+                    "type": "string"
+                },
+                "before": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/history.Record"
+                    }
+                },
+                "mode": {
+                    "type": "string"
+                },
+                "plan": {
+                    "type": "array",
+                    "items": {
+                        "type": "integer"
+                    }
+                },
+                "since": {
+                    "type": "string"
+                },
+                "state": {
+                    "type": "integer"
+                },
+                "type": {
+                    "type": "integer"
+                },
+                "uuid": {
+                    "type": "string"
+                }
+            }
+        },
+        "lights.Entry": {
+            "type": "object",
+            "properties": {
+                "light": {
+                    "$ref": "#/definitions/storage.Light"
+                },
+                "state": {
+                    "$ref": "#/definitions/storage.State"
+                }
+            }
+        },
+        "models.Definitions": {
+            "type": "object",
+            "properties": {
+                "lights": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/models.LightDefinition"
+                    }
+                }
+            }
+        },
+        "models.ErrorDetail": {
+            "type": "object",
+            "properties": {
+                "field": {
+                    "type": "string"
+                },
+                "message": {
+                    "type": "string"
+                }
+            }
+        },
+        "models.ErrorResponse": {
+            "type": "object",
+            "properties": {
+                "details": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/models.ErrorDetail"
+                    }
+                },
+                "error": {
+                    "type": "string"
+                }
+            }
+        },
+        "models.Lamps": {
+            "type": "object",
+            "properties": {
+                "arrow": {
+                    "type": "boolean"
+                },
+                "arrow_flashing": {
+                    "type": "boolean"
+                },
+                "green": {
+                    "type": "boolean"
+                },
+                "red": {
+                    "type": "boolean"
+                },
+                "yellow": {
+                    "type": "boolean"
+                }
+            }
+        },
+        "models.LightDefinition": {
+            "type": "object",
+            "properties": {
+                "durations": {
+                    "type": "array",
+                    "items": {
+                        "type": "integer"
+                    }
+                },
+                "name": {
+                    "type": "string"
+                },
+                "offset": {
+                    "type": "integer"
+                },
+                "type": {
+                    "type": "integer"
+                },
+                "uuid": {
+                    "description": "Собственный план светофора вместо плана типа",
+                    "type": "string"
+                }
+            }
+        },
+        "models.OverrideStatus": {
+            "type": "object",
+            "properties": {
+                "action": {
+                    "type": "string"
+                },
+                "expires_at": {
+                    "type": "string"
+                },
+                "id": {
+                    "type": "string"
+                },
+                "reason": {
+                    "type": "string"
+                }
+            }
+        },
+        "models.TrafficRequest": {
+            "type": "object",
+            "properties": {
+                "current_state": {
+                    "type": "integer"
+                },
+                "current_time": {
+                    "description": "Указатель для проверки существования",
+                    "type": "integer"
+                },
+                "need_image": {
+                    "type": "boolean"
+                },
+                "uuid": {
+                    "type": "string"
+                }
+            }
+        },
+        "models.TrafficResponse": {
+            "type": "object",
+            "properties": {
+                "degraded": {
+                    "description": "Действие политики неисправностей",
+                    "type": "string"
+                },
+                "failsafe": {
+                    "description": "Авария монитора конфликтов",
+                    "type": "string"
+                },
+                "faults": {
+                    "description": "Неисправные лампы",
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "flashing": {
+                    "description": "NextState мигает",
+                    "type": "boolean"
+                },
+                "image": {
+                    "type": "string"
+                },
+                "next_countdown_time": {
+                    "type": "string"
+                },
+                "next_state": {
+                    "type": "string"
+                },
+                "override": {
+                    "description": "Светофор под ручным управлением",
+                    "allOf": [
+                        {
+                            "$ref": "#/definitions/models.OverrideStatus"
+                        }
+                    ]
+                },
+                "uuid": {
+                    "type": "string"
+                }
+            }
+        },
+        "perfmeasures.ArrivalsOnGreen": {
+            "type": "object",
+            "properties": {
+                "arrivals": {
+                    "type": "integer"
+                },
+                "on_green": {
+                    "type": "integer"
+                },
+                "percent": {
+                    "type": "number"
+                }
+            }
+        },
+        "perfmeasures.PedestrianDelay": {
+            "type": "object",
+            "properties": {
+                "average": {
+                    "type": "number"
+                },
+                "calls": {
+                    "type": "integer"
+                },
+                "max": {
+                    "type": "number"
+                }
+            }
+        },
+        "perfmeasures.Report": {
+            "type": "object",
+            "properties": {
+                "arrivals_on_green": {
+                    "$ref": "#/definitions/perfmeasures.ArrivalsOnGreen"
+                },
+                "cycles": {
+                    "type": "integer"
+                },
+                "from": {
+                    "type": "string"
+                },
+                "pedestrian_delay": {
+                    "$ref": "#/definitions/perfmeasures.PedestrianDelay"
+                },
+                "signal_id": {
+                    "type": "string"
+                },
+                "split_failures": {
+                    "$ref": "#/definitions/perfmeasures.SplitFailures"
+                },
+                "split_monitor": {
+                    "$ref": "#/definitions/perfmeasures.SplitMonitor"
+                },
+                "to": {
+                    "type": "string"
+                }
+            }
+        },
+        "perfmeasures.Split": {
+            "type": "object",
+            "properties": {
+                "cycle_start": {
+                    "type": "string"
+                },
+                "green": {
+                    "type": "number"
+                },
+                "red": {
+                    "type": "number"
+                },
+                "yellow": {
+                    "type": "number"
+                }
+            }
+        },
+        "perfmeasures.SplitFailure": {
+            "type": "object",
+            "properties": {
+                "cycle_start": {
+                    "type": "string"
+                },
+                "failed": {
+                    "type": "boolean"
+                },
+                "green_occupancy": {
+                    "type": "number"
+                },
+                "red_occupancy_5s": {
+                    "type": "number"
+                }
+            }
+        },
+        "perfmeasures.SplitFailures": {
+            "type": "object",
+            "properties": {
+                "count": {
+                    "type": "integer"
+                },
+                "cycles": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/perfmeasures.SplitFailure"
+                    }
+                }
+            }
+        },
+        "perfmeasures.SplitMonitor": {
+            "type": "object",
+            "properties": {
+                "average": {
+                    "type": "number"
+                },
+                "max": {
+                    "type": "number"
+                },
+                "min": {
+                    "type": "number"
+                },
+                "p85": {
+                    "type": "number"
+                },
+                "splits": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/perfmeasures.Split"
+                    }
+                }
+            }
+        },
+        "storage.Device": {
+            "type": "object",
+            "properties": {
+                "created_at": {
+                    "type": "string"
+                },
+                "description": {
+                    "type": "string"
+                },
+                "heartbeat_interval": {
+                    "description": "Ожидаемый интервал опроса, с; 0 - по типу",
+                    "type": "integer"
+                },
+                "intersection": {
+                    "type": "string"
+                },
+                "location": {
+                    "$ref": "#/definitions/storage.Location"
+                },
+                "tags": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "type": {
+                    "type": "integer"
+                },
+                "updated_at": {
+                    "type": "string"
+                },
+                "uuid": {
+                    "type": "string"
+                }
+            }
+        },
+        "storage.Fault": {
+            "type": "object",
+            "properties": {
+                "lamp": {
+                    "description": "red, yellow, green или arrow",
+                    "type": "string"
+                },
+                "reason": {
+                    "type": "string"
+                },
+                "reported_at": {
+                    "type": "string"
+                },
+                "reported_by": {
+                    "type": "string"
+                },
+                "type": {
+                    "type": "integer"
+                },
+                "uuid": {
+                    "type": "string"
+                }
+            }
+        },
+        "storage.Light": {
+            "type": "object",
+            "properties": {
+                "registered_at": {
+                    "type": "string"
+                },
+                "type": {
+                    "type": "integer"
+                },
+                "uuid": {
+                    "type": "string"
+                }
+            }
+        },
+        "storage.Location": {
+            "type": "object",
+            "properties": {
+                "lat": {
+                    "type": "number"
+                },
+                "lon": {
+                    "type": "number"
+                }
+            }
+        },
+        "storage.MonitorFault": {
+            "type": "object",
+            "properties": {
+                "details": {
+                    "type": "string"
+                },
+                "detected_at": {
+                    "type": "string"
+                },
+                "intersection": {
+                    "type": "string"
+                },
+                "kind": {
+                    "description": "conflict, clearance или stuck",
+                    "type": "string"
+                },
+                "uuids": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                }
+            }
+        },
+        "storage.Mutation": {
+            "type": "object",
+            "properties": {
+                "device": {
+                    "$ref": "#/definitions/storage.Device"
+                },
+                "fault": {
+                    "$ref": "#/definitions/storage.Fault"
+                },
+                "key": {
+                    "description": "UUID, ID, ключ неисправности или перекресток для удаления",
+                    "type": "string"
+                },
+                "light": {
+                    "$ref": "#/definitions/storage.Light"
+                },
+                "monitor_fault": {
+                    "$ref": "#/definitions/storage.MonitorFault"
+                },
+                "op": {
+                    "type": "string"
+                },
+                "override": {
+                    "$ref": "#/definitions/storage.Override"
+                },
+                "plan": {
+                    "type": "array",
+                    "items": {
+                        "type": "integer"
+                    }
+                },
+                "state": {
+                    "$ref": "#/definitions/storage.State"
+                },
+                "type": {
+                    "type": "integer"
+                }
+            }
+        },
+        "storage.Override": {
+            "type": "object",
+            "properties": {
+                "action": {
+                    "type": "string"
+                },
+                "created_at": {
+                    "type": "string"
+                },
+                "expires_at": {
+                    "type": "string"
+                },
+                "id": {
+                    "type": "string"
+                },
+                "reason": {
+                    "type": "string"
+                },
+                "state": {
+                    "type": "integer"
+                },
+                "user": {
+                    "type": "string"
+                },
+                "uuid": {
+                    "type": "string"
+                }
+            }
+        },
+        "storage.State": {
+            "type": "object",
+            "properties": {
+                "mode": {
+                    "type": "string"
+                },
+                "since": {
+                    "type": "string"
+                },
+                "state": {
+                    "type": "integer"
+                },
+                "type": {
+                    "type": "integer"
+                },
+                "uuid": {
+                    "type": "string"
+                }
+            }
+        },
+        "stream.ConnectionStats": {
+            "type": "object",
+            "properties": {
+                "connected_at": {
+                    "type": "string"
+                },
+                "dropped": {
+                    "type": "integer"
+                },
+                "filter": {
+                    "$ref": "#/definitions/stream.Filter"
+                },
+                "id": {
+                    "type": "integer"
+                },
+                "queued": {
+                    "type": "integer"
+                },
+                "remote": {
+                    "type": "string"
+                },
+                "sent": {
+                    "type": "integer"
+                },
+                "transport": {
+                    "type": "string"
+                },
+                "user": {
+                    "type": "string"
+                }
+            }
+        },
+        "stream.Event": {
+            "type": "object",
+            "properties": {
+                "duration": {
+                    "description": "Длительность состояния по плану, с; только в режиме normal",
+                    "type": "integer"
+                },
+                "intersection": {
+                    "type": "string"
+                },
+                "lamps": {
+                    "$ref": "#/definitions/models.Lamps"
+                },
+                "mode": {
+                    "type": "string"
+                },
+                "next_at": {
+                    "description": "Ожидаемая смена состояния",
+                    "type": "string"
+                },
+                "since": {
+                    "type": "string"
+                },
+                "state": {
+                    "type": "integer"
+                },
+                "type": {
+                    "type": "integer"
+                },
+                "uuid": {
+                    "type": "string"
+                }
+            }
+        },
+        "stream.Filter": {
+            "type": "object",
+            "properties": {
+                "all": {
+                    "type": "boolean"
+                },
+                "intersections": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "uuids": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                }
+            }
+        },
+        "webster.Approach": {
+            "type": "object",
+            "properties": {
+                "flow": {
+                    "description": "авт/ч",
+                    "type": "number"
+                },
+                "name": {
+                    "type": "string"
+                },
+                "saturation_flow": {
+                    "description": "авт/ч зеленого",
+                    "type": "number"
+                },
+                "state": {
+                    "description": "Номер состояния светофора с зеленым для подхода",
+                    "type": "integer"
+                }
+            }
+        },
+        "webster.ApproachDelay": {
+            "type": "object",
+            "properties": {
+                "delay": {
+                    "description": "с/авт, отсутствует при перенасыщении",
+                    "type": "number"
+                },
+                "name": {
+                    "type": "string"
+                },
+                "oversaturated": {
+                    "type": "boolean"
+                },
+                "saturation": {
+                    "type": "number"
+                }
+            }
+        },
+        "webster.DelayEstimate": {
+            "type": "object",
+            "properties": {
+                "approaches": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/webster.ApproachDelay"
+                    }
+                },
+                "cycle": {
+                    "type": "number"
+                },
+                "delay": {
+                    "description": "Средневзвешенная по интенсивности задержка",
+                    "type": "number"
+                }
+            }
+        },
+        "webster.Result": {
+            "type": "object",
+            "properties": {
+                "cycle": {
+                    "type": "number"
+                },
+                "flow_ratio": {
+                    "type": "number"
+                },
+                "lost_time": {
+                    "type": "number"
+                },
+                "oversaturated": {
+                    "type": "boolean"
+                },
+                "splits": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/webster.Split"
+                    }
+                }
+            }
+        },
+        "webster.Split": {
+            "type": "object",
+            "properties": {
+                "flow_ratio": {
+                    "type": "number"
+                },
+                "green": {
+                    "type": "number"
+                },
+                "state": {
+                    "type": "integer"
                 }
             }
         }
@@ -0,0 +1,204 @@
+package atspm_test
+
+import (
+	"bytes"
+	"log/slog"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+	"trafficlightAPI/internal/atspm"
+	"trafficlightAPI/internal/models"
+)
+
+func TestTransitionEvents(t *testing.T) {
+	ts := time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name     string
+		typ      int
+		from, to int
+		want     [][2]int
+	}{
+		{name: "regular red to yellow", typ: 1, from: 1, to: 2, want: nil},
+		{name: "regular yellow to green", typ: 1, from: 2, to: 3, want: [][2]int{{atspm.CodePhaseBeginGreen, atspm.VehiclePhase}}},
+		{name: "regular green to red", typ: 1, from: 3, to: 1, want: [][2]int{{atspm.CodePhaseBeginRedClearance, atspm.VehiclePhase}}},
+		{name: "regular yellow to red", typ: 1, from: 2, to: 1, want: [][2]int{{atspm.CodePhaseBeginRedClearance, atspm.VehiclePhase}}},
+		{name: "green to yellow", typ: 2, from: 6, to: 7, want: [][2]int{{atspm.CodePhaseBeginYellowClearance, atspm.VehiclePhase}}},
+		{name: "arrow on", typ: 2, from: 1, to: 2, want: [][2]int{{atspm.CodeOverlapBeginGreen, atspm.ArrowOverlap}}},
+		{name: "arrow flashing", typ: 2, from: 2, to: 3, want: [][2]int{{atspm.CodeOverlapBeginTrailingGreen, atspm.ArrowOverlap}}},
+		{name: "arrow off", typ: 2, from: 3, to: 4, want: [][2]int{{atspm.CodeOverlapOff, atspm.ArrowOverlap}}},
+		{name: "red and yellow", typ: 2, from: 4, to: 5, want: nil},
+		{
+			name: "yellow to red", typ: 2, from: 7, to: 1,
+			want: [][2]int{{atspm.CodePhaseEndYellowClearance, atspm.VehiclePhase}, {atspm.CodePhaseBeginRedClearance, atspm.VehiclePhase}},
+		},
+		{name: "pedestrian walk", typ: 3, from: 1, to: 2, want: [][2]int{{atspm.CodePedestrianBeginWalk, atspm.PedestrianPhase}}},
+		{name: "pedestrian dont walk", typ: 3, from: 2, to: 1, want: [][2]int{{atspm.CodePedestrianBeginDontWalk, atspm.PedestrianPhase}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			events := atspm.TransitionEvents(models.Transition{UUID: "x", Type: tt.typ, From: tt.from, To: tt.to, Time: ts})
+			var got [][2]int
+			for _, e := range events {
+				if e.SignalID != "x" || !e.Timestamp.Equal(ts) {
+					t.Errorf("unexpected event %+v", e)
+				}
+				got = append(got, [2]int{e.Code, e.Param})
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRecorder(t *testing.T) {
+	dir := t.TempDir()
+	recorder, err := atspm.NewRecorder(dir, 200, 0, slog.Default())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer recorder.Close()
+
+	start := time.Date(2026, 10, 18, 23, 59, 50, 0, time.UTC)
+	for i := range 40 {
+		uuid := "a"
+		if i%2 == 1 {
+			uuid = "b"
+		}
+		err := recorder.Record(atspm.Event{SignalID: uuid, Timestamp: start.Add(time.Duration(i) * time.Second), Code: atspm.CodeDetectorOn, Param: i})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	files, _ := os.ReadDir(dir)
+	if len(files) < 4 {
+		t.Errorf("expected rotation by day and size, got %d files", len(files))
+	}
+
+	var params []int
+	err = recorder.Query("a", start.Add(5*time.Second), start.Add(15*time.Second), func(e atspm.Event) error {
+		params = append(params, e.Param)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if want := []int{6, 8, 10, 12, 14}; !reflect.DeepEqual(params, want) {
+		t.Errorf("got params %v across midnight, want %v", params, want)
+	}
+
+	var buf bytes.Buffer
+	if err := recorder.WriteCSV(&buf, "b", start, start.Add(4*time.Second)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := "signal_id,timestamp,event_code,event_param\nb,2026-10-18 23:59:51.000,82,1\nb,2026-10-18 23:59:53.000,82,3\n"
+	if buf.String() != want {
+		t.Errorf("got CSV:\n%s\nwant:\n%s", buf.String(), want)
+	}
+}
+
+func TestRecorderRemovesExpired(t *testing.T) {
+	dir := t.TempDir()
+	old := dir + "/events-20000101-001.csv"
+	if err := os.WriteFile(old, []byte("signal_id,timestamp,event_code,event_param\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	recorder, err := atspm.NewRecorder(dir, 0, 24*time.Hour, slog.Default())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer recorder.Close()
+	if err := recorder.Record(atspm.Event{SignalID: "a", Timestamp: time.Now(), Code: atspm.CodeDetectorOff}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if _, err := os.Stat(old); !os.IsNotExist(err) {
+		t.Errorf("expired file was not removed")
+	}
+	if _, err := atspm.DeviceEventCode("unknown"); err == nil || !strings.Contains(err.Error(), "unknown") {
+		t.Errorf("expected error for unknown kind, got %v", err)
+	}
+}
+
+func TestRecordTransitionSkipsRepeats(t *testing.T) {
+	recorder, err := atspm.NewRecorder(t.TempDir(), 0, 0, slog.Default())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer recorder.Close()
+
+	start := time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC)
+	// Светофор дважды опрашивает сервис в зеленом, прежде чем перейти в желтый.
+	for i, tr := range []models.Transition{
+		{UUID: "a", Type: 2, From: 6, To: 7},
+		{UUID: "a", Type: 2, From: 6, To: 7},
+		{UUID: "a", Type: 2, From: 7, To: 1},
+		{UUID: "b", Type: 2, From: 6, To: 7},
+	} {
+		tr.Time = start.Add(time.Duration(i) * time.Second)
+		if err := recorder.RecordTransition(tr); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	var codes []int
+	err = recorder.Query("a", start, start.Add(time.Minute), func(e atspm.Event) error {
+		codes = append(codes, e.Code)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := []int{atspm.CodePhaseBeginYellowClearance, atspm.CodePhaseEndYellowClearance, atspm.CodePhaseBeginRedClearance}
+	if !reflect.DeepEqual(codes, want) {
+		t.Errorf("got codes %v, want %v", codes, want)
+	}
+}
+
+func TestEnqueue(t *testing.T) {
+	dir := t.TempDir()
+	recorder, err := atspm.NewRecorder(dir, 0, 0, slog.Default())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	recorder.RunWriter()
+
+	start := time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC)
+	for i, tr := range []models.Transition{
+		{UUID: "a", Type: 2, From: 6, To: 7},
+		{UUID: "a", Type: 2, From: 6, To: 7},
+		{UUID: "a", Type: 2, From: 7, To: 1},
+	} {
+		tr.Time = start.Add(time.Duration(i) * time.Second)
+		if !recorder.Enqueue(tr) {
+			t.Fatalf("transition %d dropped", i)
+		}
+	}
+	// Close дописывает очередь.
+	if err := recorder.Close(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	recorder, err = atspm.NewRecorder(dir, 0, 0, slog.Default())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer recorder.Close()
+	var codes []int
+	err = recorder.Query("a", start, start.Add(time.Minute), func(e atspm.Event) error {
+		codes = append(codes, e.Code)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := []int{atspm.CodePhaseBeginYellowClearance, atspm.CodePhaseEndYellowClearance, atspm.CodePhaseBeginRedClearance}
+	if !reflect.DeepEqual(codes, want) {
+		t.Errorf("got codes %v, want %v", codes, want)
+	}
+}
@@ -0,0 +1,128 @@
+package atspm
+
+import (
+	"time"
+	"trafficlightAPI/internal/models"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	ErrUnknownEventKind = errors.New("неизвестный тип события")
+)
+
+// Коды событий по перечню Indiana Traffic Signal Hi Resolution Data Logger Enumerations.
+const (
+	CodePhaseBeginGreen           = 1
+	CodePhaseBeginYellowClearance = 8
+	CodePhaseEndYellowClearance   = 9
+	CodePhaseBeginRedClearance    = 10
+	CodePedestrianBeginWalk       = 21
+	CodePedestrianBeginDontWalk   = 23
+	CodePedestrianCallRegistered  = 45
+	CodeOverlapBeginGreen         = 61
+	CodeOverlapBeginTrailingGreen = 62
+	CodeOverlapOff                = 65
+	CodeDetectorOff               = 81
+	CodeDetectorOn                = 82
+	CodePreemptCallInputOn        = 102
+	CodePreemptCallInputOff       = 104
+)
+
+// Номера фаз и перекрытий, на которые отображаются светофоры сервиса:
+// основная транспортная фаза - 2, пешеходная - 2, стрелка направо - перекрытие A (1).
+const (
+	VehiclePhase    = 2
+	PedestrianPhase = 2
+	ArrowOverlap    = 1
+)
+
+type Event struct {
+	SignalID  string    `json:"signal_id"`
+	Timestamp time.Time `json:"timestamp"`
+	Code      int       `json:"event_code"`
+	Param     int       `json:"event_param"`
+}
+
+// Виды событий, о которых сообщают полевые устройства.
+var deviceEventCodes = map[string]int{
+	"detector_on":     CodeDetectorOn,
+	"detector_off":    CodeDetectorOff,
+	"preempt_on":      CodePreemptCallInputOn,
+	"preempt_off":     CodePreemptCallInputOff,
+	"pedestrian_call": CodePedestrianCallRegistered,
+}
+
+func DeviceEventCode(kind string) (int, error) {
+	code, ok := deviceEventCodes[kind]
+	if !ok {
+		return 0, errors.Wrapf(ErrUnknownEventKind, "kind:%s", kind)
+	}
+	return code, nil
+}
+
+// TransitionEvents переводит смену состояния светофора в события фаз по изменению сигналов.
+func TransitionEvents(t models.Transition) []Event {
+	from, err := models.StateLamps(t.Type, t.From)
+	if err != nil {
+		return nil
+	}
+	to, err := models.StateLamps(t.Type, t.To)
+	if err != nil {
+		return nil
+	}
+
+	var events []Event
+	add := func(code, param int) {
+		events = append(events, Event{SignalID: t.UUID, Timestamp: t.Time, Code: code, Param: param})
+	}
+
+	if t.Type == 3 {
+		switch {
+		case to.Green && !from.Green:
+			add(CodePedestrianBeginWalk, PedestrianPhase)
+		case to.Red && !from.Red:
+			add(CodePedestrianBeginDontWalk, PedestrianPhase)
+		}
+		return events
+	}
+
+	// Желтый перед зеленым (обычный светофор) не является желтым после зеленого,
+	// коды 8 и 9 пишутся только для желтого после зеленого.
+	switch {
+	case to.Green && !from.Green:
+		add(CodePhaseBeginGreen, VehiclePhase)
+	case to.Yellow && !to.Red && from.Green:
+		add(CodePhaseBeginYellowClearance, VehiclePhase)
+	case to.Red && !from.Red:
+		if yellowClearance(t.Type, t.From) {
+			add(CodePhaseEndYellowClearance, VehiclePhase)
+		}
+		add(CodePhaseBeginRedClearance, VehiclePhase)
+	}
+
+	switch {
+	case to.Arrow && !to.ArrowFlashing && !(from.Arrow && !from.ArrowFlashing):
+		add(CodeOverlapBeginGreen, ArrowOverlap)
+	case to.ArrowFlashing && !from.ArrowFlashing:
+		add(CodeOverlapBeginTrailingGreen, ArrowOverlap)
+	case !to.Arrow && from.Arrow:
+		add(CodeOverlapOff, ArrowOverlap)
+	}
+
+	return events
+}
+
+// yellowClearance сообщает, что состояние - желтый, который по циклу следует за зеленым.
+func yellowClearance(trafficType, state int) bool {
+	lamps, err := models.StateLamps(trafficType, state)
+	if err != nil || !lamps.Yellow || lamps.Red {
+		return false
+	}
+	prev := state - 1
+	if prev < 1 {
+		prev = models.StatesCount(trafficType)
+	}
+	before, err := models.StateLamps(trafficType, prev)
+	return err == nil && before.Green
+}
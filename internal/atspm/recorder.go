@@ -0,0 +1,401 @@
+package atspm
+
+import (
+	"bufio"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	prometheus "trafficlightAPI/internal/middleware/prometheus"
+	"trafficlightAPI/internal/models"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	ErrEventLog = errors.New("ошибка журнала событий контроллера")
+)
+
+const (
+	filePrefix      = "events-"
+	fileSuffix      = ".csv"
+	timestampLayout = "2006-01-02 15:04:05.000"
+	dayLayout       = "20060102"
+)
+
+var csvHeader = []string{"signal_id", "timestamp", "event_code", "event_param"}
+
+// Размер очереди записи, наибольшая пачка между сбросами на диск и предел
+// запомненных за день состояний.
+const (
+	queueSize = 4096
+	maxBatch  = 256
+	maxStates = 100000
+)
+
+// Recorder пишет события в CSV файлы по дням (UTC) с ротацией по размеру:
+// events-20261019-001.csv, events-20261019-002.csv, ...
+type Recorder struct {
+	dir     string
+	maxSize int64
+	maxAge  time.Duration
+	logger  *slog.Logger
+
+	mu     sync.Mutex
+	file   *os.File
+	writer *csv.Writer
+	day    string
+	seq    int
+	size   int64
+	// Последнее записанное состояние каждого светофора за день statesDay.
+	states    map[string]int
+	statesDay string
+
+	queue chan models.Transition
+	stop  chan struct{}
+	wg    sync.WaitGroup
+}
+
+func NewRecorder(dir string, maxSize int64, maxAge time.Duration, logger *slog.Logger) (*Recorder, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, errors.Wrap(ErrEventLog, err.Error())
+	}
+	return &Recorder{
+		dir: dir, maxSize: maxSize, maxAge: maxAge, logger: logger,
+		queue: make(chan models.Transition, queueSize), stop: make(chan struct{}),
+	}, nil
+}
+
+func fileName(day string, seq int) string {
+	return fmt.Sprintf("%s%s-%03d%s", filePrefix, day, seq, fileSuffix)
+}
+
+// parseFileName возвращает день и номер файла, ok=false для чужих файлов.
+func parseFileName(name string) (string, int, bool) {
+	if !strings.HasPrefix(name, filePrefix) || !strings.HasSuffix(name, fileSuffix) {
+		return "", 0, false
+	}
+	day, seqStr, found := strings.Cut(strings.TrimSuffix(strings.TrimPrefix(name, filePrefix), fileSuffix), "-")
+	if !found {
+		return "", 0, false
+	}
+	if _, err := time.Parse(dayLayout, day); err != nil {
+		return "", 0, false
+	}
+	seq, err := strconv.Atoi(seqStr)
+	if err != nil {
+		return "", 0, false
+	}
+	return day, seq, true
+}
+
+func (r *Recorder) files() ([]string, error) {
+	entries, err := os.ReadDir(r.dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for _, entry := range entries {
+		if _, _, ok := parseFileName(entry.Name()); ok && !entry.IsDir() {
+			names = append(names, entry.Name())
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+func (r *Recorder) rotate(day string) error {
+	if r.file != nil {
+		r.writer.Flush()
+		r.file.Close()
+		r.file = nil
+	}
+
+	if day != r.day {
+		r.day, r.seq = day, 0
+		names, err := r.files()
+		if err != nil {
+			return err
+		}
+		for _, name := range names {
+			if d, seq, _ := parseFileName(name); d == day {
+				r.seq = max(r.seq, seq)
+			}
+		}
+		r.seq = max(r.seq, 1)
+		r.removeExpired(names)
+	} else {
+		r.seq++
+	}
+
+	path := filepath.Join(r.dir, fileName(r.day, r.seq))
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+
+	r.file, r.writer, r.size = file, csv.NewWriter(file), info.Size()
+	if r.size == 0 {
+		r.writer.Write(csvHeader)
+	}
+	return nil
+}
+
+func (r *Recorder) removeExpired(names []string) {
+	if r.maxAge <= 0 {
+		return
+	}
+	cutoff := time.Now().UTC().Add(-r.maxAge).Format(dayLayout)
+	for _, name := range names {
+		if day, _, _ := parseFileName(name); day < cutoff {
+			if err := os.Remove(filepath.Join(r.dir, name)); err != nil {
+				r.logger.Warn("ошибка при удалении устаревшего журнала событий", slog.String("file", name), slog.Any("err", err))
+			}
+		}
+	}
+}
+
+func (r *Recorder) Record(events ...Event) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.record(events)
+}
+
+// RecordTransition пишет события смены состояния. Светофор, опрашивающий сервис
+// до перехода в новое состояние, получает ту же смену повторно, повторы не пишутся.
+func (r *Recorder) RecordTransition(transitions ...models.Transition) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var events []Event
+	for _, t := range transitions {
+		day := t.Time.UTC().Format(dayLayout)
+		if state, ok := r.states[t.UUID]; ok && state == t.To && r.statesDay == day {
+			continue
+		}
+		// Карта сбрасывается со сменой дня, а при переполнении раньше -
+		// ценой одного повтора на светофор.
+		if r.states == nil || r.statesDay != day || len(r.states) >= maxStates {
+			r.states, r.statesDay = make(map[string]int), day
+		}
+		r.states[t.UUID] = t.To
+		events = append(events, TransitionEvents(t)...)
+	}
+	return r.record(events)
+}
+
+// Enqueue ставит смену состояния в очередь записи (RunWriter) и не ждет
+// диска. При переполненной очереди смена отбрасывается и возвращается false.
+func (r *Recorder) Enqueue(t models.Transition) bool {
+	select {
+	case r.queue <- t:
+		return true
+	default:
+		prometheus.ControllerEventsDropped.Inc()
+		return false
+	}
+}
+
+// RunWriter записывает очередь пачками до вызова Close.
+func (r *Recorder) RunWriter() {
+	r.wg.Add(1)
+	go func() {
+		defer r.wg.Done()
+		for {
+			select {
+			case <-r.stop:
+				return
+			case t := <-r.queue:
+				r.write(t)
+			}
+		}
+	}()
+}
+
+// write записывает first и все, что накопилось в очереди, не больше maxBatch за сброс.
+func (r *Recorder) write(first models.Transition) {
+	batch := []models.Transition{first}
+	for empty := false; !empty && len(batch) < maxBatch; {
+		select {
+		case t := <-r.queue:
+			batch = append(batch, t)
+		default:
+			empty = true
+		}
+	}
+	if err := r.RecordTransition(batch...); err != nil {
+		r.logger.Error("ошибка записи событий контроллера", slog.Int("transitions", len(batch)), slog.Any("err", err))
+	}
+}
+
+func (r *Recorder) record(events []Event) error {
+	for _, e := range events {
+		ts := e.Timestamp.UTC()
+		day := ts.Format(dayLayout)
+		if r.file == nil || day != r.day || (r.maxSize > 0 && r.size >= r.maxSize) {
+			if err := r.rotate(day); err != nil {
+				return errors.Wrap(ErrEventLog, err.Error())
+			}
+		}
+
+		record := []string{e.SignalID, ts.Format(timestampLayout), strconv.Itoa(e.Code), strconv.Itoa(e.Param)}
+		if err := r.writer.Write(record); err != nil {
+			return errors.Wrap(ErrEventLog, err.Error())
+		}
+		r.size += int64(len(strings.Join(record, ",")) + 1)
+		prometheus.ControllerEvents.WithLabelValues(strconv.Itoa(e.Code)).Inc()
+	}
+
+	if r.writer == nil {
+		return nil
+	}
+	r.writer.Flush()
+	if err := r.writer.Error(); err != nil {
+		return errors.Wrap(ErrEventLog, err.Error())
+	}
+	return nil
+}
+
+// Query перебирает в порядке записи события светофора в интервале [from, to).
+// Пустой signalID означает все светофоры.
+func (r *Recorder) Query(signalID string, from, to time.Time, fn func(Event) error) error {
+	r.mu.Lock()
+	if r.writer != nil {
+		r.writer.Flush()
+	}
+	names, err := r.files()
+	r.mu.Unlock()
+	if err != nil {
+		return errors.Wrap(ErrEventLog, err.Error())
+	}
+
+	fromDay, toDay := from.UTC().Format(dayLayout), to.UTC().Format(dayLayout)
+	for _, name := range names {
+		if day, _, _ := parseFileName(name); day < fromDay || day > toDay {
+			continue
+		}
+		if err := r.scanFile(filepath.Join(r.dir, name), signalID, from, to, fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *Recorder) scanFile(path, signalID string, from, to time.Time, fn func(Event) error) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return errors.Wrap(ErrEventLog, err.Error())
+	}
+	defer file.Close()
+
+	reader := csv.NewReader(bufio.NewReader(file))
+	reader.FieldsPerRecord = len(csvHeader)
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return errors.Wrapf(ErrEventLog, "%s: %v", filepath.Base(path), err)
+		}
+		if record[0] == csvHeader[0] || (signalID != "" && record[0] != signalID) {
+			continue
+		}
+
+		ts, err1 := time.ParseInLocation(timestampLayout, record[1], time.UTC)
+		code, err2 := strconv.Atoi(record[2])
+		param, err3 := strconv.Atoi(record[3])
+		if err1 != nil || err2 != nil || err3 != nil {
+			return errors.Wrapf(ErrEventLog, "%s: некорректная запись %v", filepath.Base(path), record)
+		}
+		if ts.Before(from) || !ts.Before(to) {
+			continue
+		}
+
+		if err := fn(Event{SignalID: record[0], Timestamp: ts, Code: code, Param: param}); err != nil {
+			return err
+		}
+	}
+}
+
+// Close останавливает запись, дописывает очередь и закрывает файл.
+func (r *Recorder) Close() error {
+	close(r.stop)
+	r.wg.Wait()
+	for len(r.queue) > 0 {
+		r.write(<-r.queue)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.file == nil {
+		return nil
+	}
+	r.writer.Flush()
+	err := r.file.Close()
+	r.file = nil
+	return err
+}
+
+// WriteCSV выгружает события светофора за интервал в формате журнала.
+func (r *Recorder) WriteCSV(w io.Writer, signalID string, from, to time.Time) error {
+	writer := csv.NewWriter(w)
+	writer.Write(csvHeader)
+	err := r.Query(signalID, from, to, func(e Event) error {
+		return writer.Write([]string{e.SignalID, e.Timestamp.UTC().Format(timestampLayout), strconv.Itoa(e.Code), strconv.Itoa(e.Param)})
+	})
+	writer.Flush()
+	if err != nil {
+		return err
+	}
+	return writer.Error()
+}
+
+var (
+	defaultMu       sync.RWMutex
+	defaultRecorder *Recorder
+)
+
+func SetDefault(r *Recorder) {
+	defaultMu.Lock()
+	defer defaultMu.Unlock()
+	defaultRecorder = r
+}
+
+func Default() *Recorder {
+	defaultMu.RLock()
+	defer defaultMu.RUnlock()
+	return defaultRecorder
+}
+
+// Record пишет события в журнал по умолчанию, если он настроен.
+func Record(events ...Event) {
+	r := Default()
+	if r == nil || len(events) == 0 {
+		return
+	}
+	if err := r.Record(events...); err != nil {
+		r.logger.Error("ошибка записи событий контроллера", slog.Any("err", err))
+	}
+}
+
+// RecordTransition ставит смену состояния в очередь журнала по умолчанию, если он настроен.
+func RecordTransition(t models.Transition) {
+	if r := Default(); r != nil {
+		r.Enqueue(t)
+	}
+}
@@ -0,0 +1,264 @@
+package audit
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	ErrAudit    = errors.New("ошибка журнала аудита")
+	ErrTampered = errors.New("журнал аудита изменен")
+)
+
+// Действия, которые пишутся в журнал аудита.
+const (
+	ActionOverrideCreate  = "override_create"
+	ActionOverrideRelease = "override_release"
+	ActionOverrideExpire  = "override_expire"
+	ActionOverrideConsume = "override_consume"
+	ActionPlanChange      = "plan_change"
+	ActionModeChange      = "mode_change"
+	ActionPreemptStart    = "preempt_start"
+	ActionPreemptEnd      = "preempt_end"
+	ActionLampFault       = "lamp_fault"
+	ActionLampRepair      = "lamp_repair"
+	ActionMonitorFault    = "monitor_fault"
+	ActionMonitorReset    = "monitor_reset"
+)
+
+// GenesisHash - prev_hash первой записи.
+var GenesisHash = strings.Repeat("0", sha256.Size*2)
+
+const maxLine = 1 << 20
+
+// Entry - запись журнала. Hash - SHA-256 от JSON записи без поля hash,
+// включающей PrevHash, поэтому изменение любой записи ломает цепочку.
+type Entry struct {
+	Seq      uint64         `json:"seq"`
+	Time     time.Time      `json:"time"`
+	Action   string         `json:"action"`
+	UUID     string         `json:"uuid,omitempty"`
+	User     string         `json:"user,omitempty"`
+	Reason   string         `json:"reason,omitempty"`
+	Details  map[string]any `json:"details,omitempty"`
+	PrevHash string         `json:"prev_hash"`
+	Hash     string         `json:"hash,omitempty"` // Всегда последнее поле строки
+}
+
+// Log дописывает записи аудита в файл по одной JSON строке, связывая их в цепочку хешей.
+type Log struct {
+	mu   sync.Mutex
+	file *os.File
+	seq  uint64
+	head string
+}
+
+func Open(path string) (*Log, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, errors.Wrap(ErrAudit, err.Error())
+	}
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, errors.Wrap(ErrAudit, err.Error())
+	}
+
+	l := &Log{file: file, head: GenesisHash}
+	if err := l.loadHead(); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return l, nil
+}
+
+// loadHead продолжает цепочку с последней записи файла.
+func (l *Log) loadHead() error {
+	if _, err := l.file.Seek(0, io.SeekStart); err != nil {
+		return errors.Wrap(ErrAudit, err.Error())
+	}
+	scanner := bufio.NewScanner(l.file)
+	scanner.Buffer(make([]byte, 64*1024), maxLine)
+	var last []byte
+	for scanner.Scan() {
+		if len(bytes.TrimSpace(scanner.Bytes())) > 0 {
+			last = append(last[:0], scanner.Bytes()...)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return errors.Wrap(ErrAudit, err.Error())
+	}
+	if last == nil {
+		return nil
+	}
+
+	var e Entry
+	if err := json.Unmarshal(last, &e); err != nil || e.Hash == "" {
+		return errors.Wrapf(ErrAudit, "последняя запись повреждена: %v", err)
+	}
+	l.seq, l.head = e.Seq, e.Hash
+	return nil
+}
+
+// body возвращает JSON записи без hash и ее хеш.
+func body(e Entry) ([]byte, string, error) {
+	e.Hash = ""
+	data, err := json.Marshal(e)
+	if err != nil {
+		return nil, "", err
+	}
+	sum := sha256.Sum256(data)
+	return data, hex.EncodeToString(sum[:]), nil
+}
+
+func (l *Log) Write(e Entry) error {
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	e.Time = e.Time.UTC()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	e.Seq, e.PrevHash = l.seq+1, l.head
+	data, hash, err := body(e)
+	if err != nil {
+		return errors.Wrap(ErrAudit, err.Error())
+	}
+
+	line := make([]byte, 0, len(data)+len(hash)+12)
+	line = append(line, data[:len(data)-1]...)
+	line = append(line, `,"hash":"`...)
+	line = append(line, hash...)
+	line = append(line, "\"}\n"...)
+	if _, err := l.file.Write(line); err != nil {
+		return errors.Wrap(ErrAudit, err.Error())
+	}
+	if err := l.file.Sync(); err != nil {
+		return errors.Wrap(ErrAudit, err.Error())
+	}
+	l.seq, l.head = e.Seq, hash
+	return nil
+}
+
+// Head возвращает номер и хеш последней записи. Сохраненный отдельно хеш
+// позволяет обнаружить и удаление записей с конца журнала.
+func (l *Log) Head() (uint64, string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.seq, l.head
+}
+
+func (l *Log) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.file.Close()
+}
+
+// TamperError указывает первую запись, на которой нарушена цепочка.
+type TamperError struct {
+	Line   int
+	Seq    uint64
+	Reason string
+}
+
+func (e *TamperError) Error() string {
+	return fmt.Sprintf("%v: строка %d (seq %d): %s", ErrTampered, e.Line, e.Seq, e.Reason)
+}
+
+func (e *TamperError) Unwrap() error {
+	return ErrTampered
+}
+
+// Verify проверяет цепочку хешей и возвращает число проверенных записей
+// и хеш последней. При нарушении возвращает *TamperError.
+func Verify(r io.Reader) (int, string, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), maxLine)
+
+	head, count, line := GenesisHash, 0, 0
+	var seq uint64
+	for scanner.Scan() {
+		line++
+		raw := scanner.Bytes()
+		if len(bytes.TrimSpace(raw)) == 0 {
+			continue
+		}
+		tampered := func(reason string, args ...any) (int, string, error) {
+			return count, head, &TamperError{Line: line, Seq: seq + 1, Reason: fmt.Sprintf(reason, args...)}
+		}
+
+		var e Entry
+		if err := json.Unmarshal(raw, &e); err != nil {
+			return tampered("некорректный JSON: %v", err)
+		}
+		suffix := `,"hash":"` + e.Hash + `"}`
+		if len(e.Hash) != sha256.Size*2 || !bytes.HasSuffix(raw, []byte(suffix)) {
+			return tampered("отсутствует hash в конце записи")
+		}
+		if e.Seq != seq+1 {
+			return tampered("seq %d, ожидается %d", e.Seq, seq+1)
+		}
+		if e.PrevHash != head {
+			return tampered("prev_hash не совпадает с hash предыдущей записи")
+		}
+
+		data := append(append([]byte{}, raw[:len(raw)-len(suffix)]...), '}')
+		sum := sha256.Sum256(data)
+		if hex.EncodeToString(sum[:]) != e.Hash {
+			return tampered("hash не совпадает с содержимым")
+		}
+
+		seq, head = e.Seq, e.Hash
+		count++
+	}
+	if err := scanner.Err(); err != nil {
+		return count, head, errors.Wrap(ErrAudit, err.Error())
+	}
+	return count, head, nil
+}
+
+// VerifyFile проверяет журнал по пути path.
+func VerifyFile(path string) (int, string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, "", errors.Wrap(ErrAudit, err.Error())
+	}
+	defer file.Close()
+	return Verify(file)
+}
+
+var (
+	defaultMu  sync.RWMutex
+	defaultLog *Log
+)
+
+func SetDefault(l *Log) {
+	defaultMu.Lock()
+	defer defaultMu.Unlock()
+	defaultLog = l
+}
+
+func Default() *Log {
+	defaultMu.RLock()
+	defer defaultMu.RUnlock()
+	return defaultLog
+}
+
+// Record пишет запись в журнал по умолчанию, если он открыт.
+func Record(e Entry) error {
+	l := Default()
+	if l == nil {
+		return nil
+	}
+	return l.Write(e)
+}
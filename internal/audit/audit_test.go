@@ -0,0 +1,118 @@
+package audit_test
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"trafficlightAPI/internal/audit"
+
+	"github.com/pkg/errors"
+)
+
+func writeLog(t *testing.T, n int) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "audit.log")
+	for i := 0; i < n; i++ {
+		// Журнал переоткрывается, цепочка должна продолжаться.
+		l, err := audit.Open(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := l.Write(audit.Entry{Action: audit.ActionPlanChange, User: "ivanov", Reason: "webster", Details: map[string]any{"type": 1, "to": []int{30, 3, 30}}}); err != nil {
+			t.Fatal(err)
+		}
+		l.Close()
+	}
+	return path
+}
+
+func TestVerify(t *testing.T) {
+	tests := []struct {
+		name     string
+		tamper   func(lines []string) []string
+		wantLine int // 0 - цепочка цела
+	}{
+		{
+			name:   "intact",
+			tamper: func(lines []string) []string { return lines },
+		},
+		{
+			name: "edited field",
+			tamper: func(lines []string) []string {
+				lines[2] = strings.Replace(lines[2], "ivanov", "petrov", 1)
+				return lines
+			},
+			wantLine: 3,
+		},
+		{
+			name: "deleted entry",
+			tamper: func(lines []string) []string {
+				return append(lines[:1], lines[2:]...)
+			},
+			wantLine: 2,
+		},
+		{
+			name: "swapped entries",
+			tamper: func(lines []string) []string {
+				lines[3], lines[4] = lines[4], lines[3]
+				return lines
+			},
+			wantLine: 4,
+		},
+		{
+			name: "recomputed hash",
+			tamper: func(lines []string) []string {
+				// Подмена hash без пересчета следующих записей ломает prev_hash следующей.
+				lines[1] = lines[1][:strings.LastIndex(lines[1], `"hash":"`)] + `"hash":"` + strings.Repeat("a", 64) + `"}`
+				return lines
+			},
+			wantLine: 2,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, err := os.ReadFile(writeLog(t, 5))
+			if err != nil {
+				t.Fatal(err)
+			}
+			lines := tt.tamper(strings.Split(strings.TrimSpace(string(data)), "\n"))
+
+			count, _, err := audit.Verify(bytes.NewBufferString(strings.Join(lines, "\n")))
+			if tt.wantLine == 0 {
+				if err != nil || count != 5 {
+					t.Fatalf("count = %d, err = %v, want 5 entries", count, err)
+				}
+				return
+			}
+
+			var tampered *audit.TamperError
+			if !errors.As(err, &tampered) || !errors.Is(err, audit.ErrTampered) {
+				t.Fatalf("err = %v, want TamperError", err)
+			}
+			if tampered.Line != tt.wantLine || count != tt.wantLine-1 {
+				t.Errorf("line = %d, count = %d, want line %d", tampered.Line, count, tt.wantLine)
+			}
+		})
+	}
+}
+
+func TestHeadAfterReopen(t *testing.T) {
+	path := writeLog(t, 3)
+	l, err := audit.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	seq, head := l.Head()
+	count, verified, err := audit.VerifyFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if seq != 3 || count != 3 || head != verified {
+		t.Errorf("seq = %d, count = %d, head = %s, verified = %s", seq, count, head, verified)
+	}
+}
@@ -0,0 +1,447 @@
+package cluster
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+	"trafficlightAPI/internal/storage"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/raft"
+	raftboltdb "github.com/hashicorp/raft-boltdb/v2"
+	"github.com/pkg/errors"
+)
+
+var (
+	ErrCluster  = errors.New("ошибка кластера")
+	ErrNoLeader = errors.New("лидер кластера недоступен")
+	ErrForward  = errors.New("ошибка пересылки запроса лидеру")
+)
+
+// Согласованность чтения.
+const (
+	Linearizable = "linearizable" // Чтение видит все изменения, подтвержденные до его начала
+	Stale        = "stale"        // Чтение локальной реплики без обращения к лидеру
+)
+
+const (
+	retryInterval = 50 * time.Millisecond
+	snapshotsKept = 2
+)
+
+type Peer struct {
+	ID          string `yaml:"id" json:"id"`
+	RaftAddress string `yaml:"raft_address" json:"raft_address"`
+	HTTPAddress string `yaml:"http_address" json:"http_address"` // Адрес HTTP API для пересылки лидеру, например http://10.0.0.2:8081
+}
+
+type Config struct {
+	NodeID          string
+	RaftAddress     string
+	Dir             string
+	Bootstrap       bool
+	Peers           []Peer // Вместе с текущим узлом
+	ReadConsistency string
+	ApplyTimeout    time.Duration
+	APIKey          string // Ключ с ролью admin для пересылки лидеру при включенной аутентификации
+	InMemory        bool   // Журнал и снимки в памяти, для тестов
+}
+
+type Status struct {
+	ID           string `json:"id"`
+	State        string `json:"state"`
+	Leader       string `json:"leader"`
+	AppliedIndex uint64 `json:"applied_index"`
+	Peers        []Peer `json:"peers"`
+}
+
+// Node - реплика кластера. Реализует storage.Store: изменения проходят через
+// журнал Raft (с узла-последователя пересылаются лидеру по HTTP), чтение
+// выполняется из локального хранилища после синхронизации с лидером
+// или без нее в режиме Stale.
+type Node struct {
+	cfg       Config
+	local     storage.Store
+	fsm       *fsm
+	raft      *raft.Raft
+	transport *raft.NetworkTransport
+	logStore  *raftboltdb.BoltStore
+	client    *http.Client
+	logger    *slog.Logger
+
+	peersMu sync.RWMutex
+	peers   map[string]Peer
+}
+
+var _ storage.Store = (*Node)(nil)
+
+func Open(cfg Config, local storage.Store, logger *slog.Logger) (*Node, error) {
+	if cfg.ApplyTimeout <= 0 {
+		cfg.ApplyTimeout = 5 * time.Second
+	}
+	if cfg.ReadConsistency == "" {
+		cfg.ReadConsistency = Linearizable
+	}
+	if cfg.ReadConsistency != Linearizable && cfg.ReadConsistency != Stale {
+		return nil, errors.Wrapf(ErrCluster, "неизвестная согласованность чтения %q", cfg.ReadConsistency)
+	}
+
+	n := &Node{
+		cfg:    cfg,
+		local:  local,
+		fsm:    &fsm{store: local},
+		client: &http.Client{Timeout: cfg.ApplyTimeout},
+		logger: logger,
+		peers:  make(map[string]Peer),
+	}
+	for _, p := range cfg.Peers {
+		n.peers[p.ID] = p
+	}
+
+	raftCfg := raft.DefaultConfig()
+	raftCfg.LocalID = raft.ServerID(cfg.NodeID)
+	raftCfg.Logger = hclog.New(&hclog.LoggerOptions{Name: "raft", Level: hclog.Warn, Output: os.Stderr})
+
+	var logs raft.LogStore
+	var stable raft.StableStore
+	var snapshots raft.SnapshotStore
+	if cfg.InMemory {
+		inmem := raft.NewInmemStore()
+		logs, stable, snapshots = inmem, inmem, raft.NewInmemSnapshotStore()
+		raftCfg.HeartbeatTimeout = 200 * time.Millisecond
+		raftCfg.ElectionTimeout = 200 * time.Millisecond
+		raftCfg.LeaderLeaseTimeout = 100 * time.Millisecond
+	} else {
+		if err := os.MkdirAll(cfg.Dir, 0755); err != nil {
+			return nil, errors.Wrap(ErrCluster, err.Error())
+		}
+		store, err := raftboltdb.NewBoltStore(filepath.Join(cfg.Dir, "raft.db"))
+		if err != nil {
+			return nil, errors.Wrap(ErrCluster, err.Error())
+		}
+		n.logStore = store
+		logs, stable = store, store
+		if snapshots, err = raft.NewFileSnapshotStoreWithLogger(cfg.Dir, snapshotsKept, raftCfg.Logger); err != nil {
+			store.Close()
+			return nil, errors.Wrap(ErrCluster, err.Error())
+		}
+	}
+
+	addr, err := net.ResolveTCPAddr("tcp", cfg.RaftAddress)
+	if err != nil {
+		n.closeStores()
+		return nil, errors.Wrap(ErrCluster, err.Error())
+	}
+	var advertise net.Addr = addr
+	if addr.Port == 0 {
+		// Порт выбирается при открытии, объявляется адрес слушателя.
+		advertise = nil
+	}
+	n.transport, err = raft.NewTCPTransportWithLogger(cfg.RaftAddress, advertise, 3, 10*time.Second, raftCfg.Logger)
+	if err != nil {
+		n.closeStores()
+		return nil, errors.Wrap(ErrCluster, err.Error())
+	}
+
+	n.raft, err = raft.NewRaft(raftCfg, n.fsm, logs, stable, snapshots, n.transport)
+	if err != nil {
+		n.transport.Close()
+		n.closeStores()
+		return nil, errors.Wrap(ErrCluster, err.Error())
+	}
+
+	if cfg.Bootstrap {
+		configuration := raft.Configuration{Servers: []raft.Server{{ID: raftCfg.LocalID, Address: n.transport.LocalAddr()}}}
+		for _, p := range cfg.Peers {
+			if p.ID != cfg.NodeID {
+				configuration.Servers = append(configuration.Servers, raft.Server{ID: raft.ServerID(p.ID), Address: raft.ServerAddress(p.RaftAddress)})
+			}
+		}
+		// Повторный bootstrap узла с непустым журналом безопасно отклоняется.
+		if err := n.raft.BootstrapCluster(configuration).Error(); err != nil && !errors.Is(err, raft.ErrCantBootstrap) {
+			n.Close()
+			return nil, errors.Wrap(ErrCluster, err.Error())
+		}
+	}
+	return n, nil
+}
+
+func (n *Node) closeStores() {
+	if n.logStore != nil {
+		n.logStore.Close()
+	}
+}
+
+// OnApply регистрирует обработчик изменений, примененных к локальному хранилищу.
+func (n *Node) OnApply(hook func(storage.Mutation)) {
+	n.fsm.mu.Lock()
+	defer n.fsm.mu.Unlock()
+	n.fsm.onApply = append(n.fsm.onApply, hook)
+}
+
+func (n *Node) ID() string {
+	return n.cfg.NodeID
+}
+
+func (n *Node) RaftAddress() string {
+	return string(n.transport.LocalAddr())
+}
+
+func (n *Node) IsLeader() bool {
+	return n.raft.State() == raft.Leader
+}
+
+// RegisterPeer запоминает HTTP адрес узла для пересылки запросов.
+func (n *Node) RegisterPeer(p Peer) {
+	n.peersMu.Lock()
+	defer n.peersMu.Unlock()
+	n.peers[p.ID] = p
+}
+
+// Join добавляет узел в кластер, вызывается на лидере.
+func (n *Node) Join(p Peer) error {
+	n.RegisterPeer(p)
+	if err := n.raft.AddVoter(raft.ServerID(p.ID), raft.ServerAddress(p.RaftAddress), 0, n.cfg.ApplyTimeout).Error(); err != nil {
+		return errors.Wrap(ErrCluster, err.Error())
+	}
+	return nil
+}
+
+func (n *Node) Status() Status {
+	addr, id := n.raft.LeaderWithID()
+	status := Status{ID: n.cfg.NodeID, State: n.raft.State().String(), Leader: string(id), AppliedIndex: n.raft.AppliedIndex()}
+	if id == "" && addr != "" {
+		status.Leader = string(addr)
+	}
+
+	n.peersMu.RLock()
+	defer n.peersMu.RUnlock()
+	for _, p := range n.peers {
+		status.Peers = append(status.Peers, p)
+	}
+	sort.Slice(status.Peers, func(i, j int) bool { return status.Peers[i].ID < status.Peers[j].ID })
+	return status
+}
+
+func (n *Node) leader() (Peer, error) {
+	_, id := n.raft.LeaderWithID()
+	if id == "" {
+		return Peer{}, ErrNoLeader
+	}
+	n.peersMu.RLock()
+	defer n.peersMu.RUnlock()
+	p, ok := n.peers[string(id)]
+	if !ok || p.HTTPAddress == "" {
+		return Peer{}, errors.Wrapf(ErrNoLeader, "неизвестен HTTP адрес лидера %s", id)
+	}
+	return p, nil
+}
+
+// Apply добавляет изменение в журнал и ждет его применения. Только на лидере.
+func (n *Node) Apply(m storage.Mutation) error {
+	data, err := json.Marshal(m)
+	if err != nil {
+		return errors.Wrap(ErrCluster, err.Error())
+	}
+
+	future := n.raft.Apply(data, n.cfg.ApplyTimeout)
+	if err := future.Error(); err != nil {
+		if errors.Is(err, raft.ErrNotLeader) || errors.Is(err, raft.ErrLeadershipLost) {
+			return errors.Wrap(ErrNoLeader, err.Error())
+		}
+		return errors.Wrap(ErrCluster, err.Error())
+	}
+	if err, ok := future.Response().(error); ok {
+		return err
+	}
+	return nil
+}
+
+// ReadIndex подтверждает лидерство записью-барьером и возвращает индекс,
+// до которого реплика должна применить журнал для линеаризуемого чтения.
+func (n *Node) ReadIndex() (uint64, error) {
+	if err := n.raft.Barrier(n.cfg.ApplyTimeout).Error(); err != nil {
+		if errors.Is(err, raft.ErrNotLeader) || errors.Is(err, raft.ErrLeadershipLost) {
+			return 0, errors.Wrap(ErrNoLeader, err.Error())
+		}
+		return 0, errors.Wrap(ErrCluster, err.Error())
+	}
+	return n.raft.AppliedIndex(), nil
+}
+
+// retry повторяет операцию, пока лидер недоступен, в пределах ApplyTimeout.
+func (n *Node) retry(op func() error) error {
+	deadline := time.Now().Add(n.cfg.ApplyTimeout)
+	for {
+		err := op()
+		if err == nil || !errors.Is(err, ErrNoLeader) || time.Now().After(deadline) {
+			return err
+		}
+		time.Sleep(retryInterval)
+	}
+}
+
+func (n *Node) write(m storage.Mutation) error {
+	return n.retry(func() error {
+		if n.IsLeader() {
+			return n.Apply(m)
+		}
+		return n.forwardApply(m)
+	})
+}
+
+// sync ждет, пока локальная реплика применит все изменения, подтвержденные лидером.
+func (n *Node) sync() error {
+	if n.cfg.ReadConsistency == Stale {
+		return nil
+	}
+
+	var index uint64
+	err := n.retry(func() error {
+		var err error
+		if n.IsLeader() {
+			index, err = n.ReadIndex()
+		} else {
+			index, err = n.forwardReadIndex()
+		}
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	deadline := time.Now().Add(n.cfg.ApplyTimeout)
+	for n.raft.AppliedIndex() < index {
+		if time.Now().After(deadline) {
+			return errors.Wrapf(ErrCluster, "реплика не догнала лидера: применено %d из %d", n.raft.AppliedIndex(), index)
+		}
+		time.Sleep(time.Millisecond)
+	}
+	return nil
+}
+
+func (n *Node) Lights() ([]storage.Light, error) {
+	if err := n.sync(); err != nil {
+		return nil, err
+	}
+	return n.local.Lights()
+}
+
+func (n *Node) States() ([]storage.State, error) {
+	if err := n.sync(); err != nil {
+		return nil, err
+	}
+	return n.local.States()
+}
+
+func (n *Node) Overrides() ([]storage.Override, error) {
+	if err := n.sync(); err != nil {
+		return nil, err
+	}
+	return n.local.Overrides()
+}
+
+func (n *Node) Devices() ([]storage.Device, error) {
+	if err := n.sync(); err != nil {
+		return nil, err
+	}
+	return n.local.Devices()
+}
+
+func (n *Node) Faults() ([]storage.Fault, error) {
+	if err := n.sync(); err != nil {
+		return nil, err
+	}
+	return n.local.Faults()
+}
+
+func (n *Node) MonitorFaults() ([]storage.MonitorFault, error) {
+	if err := n.sync(); err != nil {
+		return nil, err
+	}
+	return n.local.MonitorFaults()
+}
+
+func (n *Node) Plans() (map[int][]int, error) {
+	if err := n.sync(); err != nil {
+		return nil, err
+	}
+	return n.local.Plans()
+}
+
+func (n *Node) PutLight(l storage.Light) error {
+	return n.write(storage.Mutation{Op: storage.OpPutLight, Light: &l})
+}
+
+func (n *Node) DeleteLight(uuid string) error {
+	return n.write(storage.Mutation{Op: storage.OpDeleteLight, Key: uuid})
+}
+
+func (n *Node) PutPlan(trafficType int, plan []int) error {
+	return n.write(storage.Mutation{Op: storage.OpPutPlan, Type: trafficType, Plan: plan})
+}
+
+func (n *Node) DeletePlan(trafficType int) error {
+	return n.write(storage.Mutation{Op: storage.OpDeletePlan, Type: trafficType})
+}
+
+func (n *Node) PutOverride(o storage.Override) error {
+	return n.write(storage.Mutation{Op: storage.OpPutOverride, Override: &o})
+}
+
+func (n *Node) DeleteOverride(id string) error {
+	return n.write(storage.Mutation{Op: storage.OpDeleteOverride, Key: id})
+}
+
+func (n *Node) PutState(s storage.State) error {
+	return n.write(storage.Mutation{Op: storage.OpPutState, State: &s})
+}
+
+func (n *Node) DeleteState(uuid string) error {
+	return n.write(storage.Mutation{Op: storage.OpDeleteState, Key: uuid})
+}
+
+func (n *Node) PutDevice(d storage.Device) error {
+	return n.write(storage.Mutation{Op: storage.OpPutDevice, Device: &d})
+}
+
+func (n *Node) DeleteDevice(uuid string) error {
+	return n.write(storage.Mutation{Op: storage.OpDeleteDevice, Key: uuid})
+}
+
+func (n *Node) PutFault(f storage.Fault) error {
+	return n.write(storage.Mutation{Op: storage.OpPutFault, Fault: &f})
+}
+
+func (n *Node) DeleteFault(key string) error {
+	return n.write(storage.Mutation{Op: storage.OpDeleteFault, Key: key})
+}
+
+func (n *Node) PutMonitorFault(f storage.MonitorFault) error {
+	return n.write(storage.Mutation{Op: storage.OpPutMonitorFault, MonitorFault: &f})
+}
+
+func (n *Node) DeleteMonitorFault(intersection string) error {
+	return n.write(storage.Mutation{Op: storage.OpDeleteMonitorFault, Key: intersection})
+}
+
+func (n *Node) SchemaVersion() (int, error) {
+	return n.local.SchemaVersion()
+}
+
+// Close останавливает реплику. Локальное хранилище закрывает владелец.
+func (n *Node) Close() error {
+	err := n.raft.Shutdown().Error()
+	n.transport.Close()
+	n.closeStores()
+	if err != nil {
+		return errors.Wrap(ErrCluster, err.Error())
+	}
+	return nil
+}
@@ -0,0 +1,202 @@
+package cluster_test
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+	"trafficlightAPI/internal/cluster"
+	"trafficlightAPI/internal/storage"
+
+	"github.com/pkg/errors"
+)
+
+type testNode struct {
+	*cluster.Node
+	peer   cluster.Peer
+	server *httptest.Server
+	closed bool
+}
+
+// startCluster поднимает n узлов на loopback: первый создает кластер, остальные присоединяются.
+func startCluster(t *testing.T, n int, consistency string) []*testNode {
+	t.Helper()
+	var nodes []*testNode
+	for i := range n {
+		local, err := storage.OpenBolt(filepath.Join(t.TempDir(), "state.db"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { local.Close() })
+
+		id := string(rune('a' + i))
+		node, err := cluster.Open(cluster.Config{
+			NodeID:          id,
+			RaftAddress:     "127.0.0.1:0",
+			Bootstrap:       i == 0,
+			ReadConsistency: consistency,
+			ApplyTimeout:    5 * time.Second,
+			InMemory:        true,
+		}, local, slog.Default())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		mux := http.NewServeMux()
+		mux.HandleFunc(cluster.ApplyPath, func(w http.ResponseWriter, r *http.Request) {
+			var m storage.Mutation
+			if err := json.NewDecoder(r.Body).Decode(&m); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			if err := node.Apply(m); err != nil {
+				http.Error(w, err.Error(), cluster.ErrorStatus(err))
+				return
+			}
+			w.WriteHeader(http.StatusNoContent)
+		})
+		mux.HandleFunc(cluster.ReadIndexPath, func(w http.ResponseWriter, r *http.Request) {
+			index, err := node.ReadIndex()
+			if err != nil {
+				http.Error(w, err.Error(), cluster.ErrorStatus(err))
+				return
+			}
+			json.NewEncoder(w).Encode(cluster.ReadIndexResponse{Index: index})
+		})
+
+		tn := &testNode{Node: node, server: httptest.NewServer(mux)}
+		tn.peer = cluster.Peer{ID: id, RaftAddress: node.RaftAddress(), HTTPAddress: tn.server.URL}
+		t.Cleanup(func() {
+			tn.server.Close()
+			if !tn.closed {
+				tn.Close()
+			}
+		})
+		nodes = append(nodes, tn)
+	}
+
+	waitLeader(t, nodes)
+	for _, a := range nodes {
+		for _, b := range nodes {
+			a.RegisterPeer(b.peer)
+		}
+	}
+	for _, tn := range nodes[1:] {
+		if err := nodes[0].Join(tn.peer); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return nodes
+}
+
+func waitLeader(t *testing.T, nodes []*testNode) *testNode {
+	t.Helper()
+	deadline := time.Now().Add(10 * time.Second)
+	for time.Now().Before(deadline) {
+		for _, tn := range nodes {
+			if !tn.closed && tn.IsLeader() {
+				return tn
+			}
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	t.Fatal("лидер не выбран")
+	return nil
+}
+
+func follower(nodes []*testNode) *testNode {
+	for _, tn := range nodes {
+		if !tn.closed && !tn.IsLeader() {
+			return tn
+		}
+	}
+	return nil
+}
+
+func TestReplication(t *testing.T) {
+	nodes := startCluster(t, 3, cluster.Linearizable)
+	since := time.Date(2026, 10, 19, 8, 0, 0, 0, time.UTC)
+	state := storage.State{UUID: "a", Type: 1, State: 3, Since: since, Mode: "normal"}
+
+	// Запись на последователе пересылается лидеру.
+	if err := follower(nodes).PutState(state); err != nil {
+		t.Fatal(err)
+	}
+	// Линеаризуемое чтение на любом узле сразу видит запись.
+	for _, tn := range nodes {
+		states, err := tn.States()
+		if err != nil || !reflect.DeepEqual(states, []storage.State{state}) {
+			t.Errorf("node %s: states = %+v, %v", tn.ID(), states, err)
+		}
+	}
+
+	if err := follower(nodes).DeleteOverride("missing"); !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("delete missing override: err = %v, want ErrNotFound", err)
+	}
+}
+
+func TestLeaderChange(t *testing.T) {
+	nodes := startCluster(t, 3, cluster.Linearizable)
+	since := time.Date(2026, 10, 19, 8, 0, 0, 0, time.UTC)
+	override := storage.Override{ID: "o1", UUID: "a", Action: "hold", Reason: "ДТП", CreatedAt: since, ExpiresAt: since.Add(time.Hour)}
+	if err := nodes[0].PutOverride(override); err != nil {
+		t.Fatal(err)
+	}
+
+	leader := waitLeader(t, nodes)
+	leader.closed = true
+	if err := leader.Close(); err != nil {
+		t.Fatal(err)
+	}
+	waitLeader(t, nodes)
+
+	// Новый лидер хранит те же абсолютные моменты, поэтому фаза не сдвигается.
+	for _, tn := range nodes {
+		if tn.closed {
+			continue
+		}
+		overrides, err := tn.Overrides()
+		if err != nil || !reflect.DeepEqual(overrides, []storage.Override{override}) {
+			t.Errorf("node %s: overrides = %+v, %v", tn.ID(), overrides, err)
+		}
+	}
+
+	if err := follower(nodes).PutPlan(1, []int{30, 3, 25}); err != nil {
+		t.Fatal(err)
+	}
+	for _, tn := range nodes {
+		if tn.closed {
+			continue
+		}
+		if plans, err := tn.Plans(); err != nil || !reflect.DeepEqual(plans[1], []int{30, 3, 25}) {
+			t.Errorf("node %s: plans = %v, %v", tn.ID(), plans, err)
+		}
+	}
+}
+
+func TestStaleRead(t *testing.T) {
+	nodes := startCluster(t, 2, cluster.Stale)
+	light := storage.Light{UUID: "a", Type: 2, RegisteredAt: time.Date(2026, 10, 19, 8, 0, 0, 0, time.UTC)}
+	if err := nodes[0].PutLight(light); err != nil {
+		t.Fatal(err)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		lights, err := nodes[1].Lights()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if reflect.DeepEqual(lights, []storage.Light{light}) {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("lights = %+v, реплика не получила запись", lights)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
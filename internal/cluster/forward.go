@@ -0,0 +1,123 @@
+package cluster
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"trafficlightAPI/internal/middleware/auth"
+	"trafficlightAPI/internal/models"
+	"trafficlightAPI/internal/storage"
+
+	"github.com/pkg/errors"
+)
+
+// Пути HTTP API лидера для пересылки с узлов-последователей.
+const (
+	ApplyPath     = "/cluster/apply"
+	ReadIndexPath = "/cluster/read-index"
+)
+
+type ReadIndexResponse struct {
+	Index uint64 `json:"index"`
+}
+
+// ErrorStatus возвращает HTTP статус ошибки Apply или ReadIndex, по которому
+// пересылающий узел восстанавливает ошибку.
+func ErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, storage.ErrNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, storage.ErrUnknownMutation):
+		return http.StatusBadRequest
+	case errors.Is(err, ErrNoLeader):
+		return http.StatusServiceUnavailable
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+func statusError(resp *http.Response) error {
+	var body models.ErrorResponse
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<16))
+	message := strings.TrimSpace(string(data))
+	if json.Unmarshal(data, &body) == nil && body.Error != "" {
+		message = body.Error
+		for _, d := range body.Details {
+			message += ": " + d.Message
+		}
+	}
+
+	switch resp.StatusCode {
+	case http.StatusNotFound:
+		return errors.Wrap(storage.ErrNotFound, message)
+	case http.StatusBadRequest:
+		return errors.Wrap(storage.ErrUnknownMutation, message)
+	case http.StatusServiceUnavailable:
+		return errors.Wrap(ErrNoLeader, message)
+	default:
+		return errors.Wrapf(ErrForward, "%d: %s", resp.StatusCode, message)
+	}
+}
+
+// do отправляет запрос лидеру с ключом узла, служебные пути требуют роли admin.
+func (n *Node) do(method string, leader Peer, path string, body io.Reader) (*http.Response, error) {
+	req, err := http.NewRequest(method, strings.TrimSuffix(leader.HTTPAddress, "/")+path, body)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if n.cfg.APIKey != "" {
+		req.Header.Set(auth.APIKeyHeader, n.cfg.APIKey)
+	}
+	return n.client.Do(req)
+}
+
+func (n *Node) forwardApply(m storage.Mutation) error {
+	leader, err := n.leader()
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(m)
+	if err != nil {
+		return errors.Wrap(ErrCluster, err.Error())
+	}
+
+	resp, err := n.do(http.MethodPost, leader, ApplyPath, bytes.NewReader(data))
+	if err != nil {
+		// Лидер мог смениться или выключиться - повторим после выборов.
+		return errors.Wrap(ErrNoLeader, err.Error())
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusOK {
+		return statusError(resp)
+	}
+	return nil
+}
+
+func (n *Node) forwardReadIndex() (uint64, error) {
+	leader, err := n.leader()
+	if err != nil {
+		return 0, err
+	}
+
+	resp, err := n.do(http.MethodGet, leader, ReadIndexPath, nil)
+	if err != nil {
+		return 0, errors.Wrap(ErrNoLeader, err.Error())
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return 0, statusError(resp)
+	}
+	var body ReadIndexResponse
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return 0, errors.Wrap(ErrForward, fmt.Sprintf("ответ read-index: %v", err))
+	}
+	return body.Index, nil
+}
@@ -0,0 +1,212 @@
+package cluster
+
+import (
+	"encoding/json"
+	"io"
+	"sync"
+	"trafficlightAPI/internal/storage"
+
+	"github.com/hashicorp/raft"
+	"github.com/pkg/errors"
+)
+
+// fsm применяет изменения из журнала Raft к локальному хранилищу реплики.
+type fsm struct {
+	store storage.Store
+
+	mu      sync.RWMutex
+	onApply []func(storage.Mutation)
+}
+
+func (f *fsm) notify(m storage.Mutation) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	for _, hook := range f.onApply {
+		hook(m)
+	}
+}
+
+func (f *fsm) Apply(l *raft.Log) any {
+	var m storage.Mutation
+	if err := json.Unmarshal(l.Data, &m); err != nil {
+		return errors.Wrapf(ErrCluster, "запись %d: %v", l.Index, err)
+	}
+	if err := m.Apply(f.store); err != nil {
+		return err
+	}
+	f.notify(m)
+	return nil
+}
+
+// dump - полное состояние хранилища для снимков Raft.
+type dump struct {
+	Lights    []storage.Light        `json:"lights"`
+	States    []storage.State        `json:"states"`
+	Overrides []storage.Override     `json:"overrides"`
+	Devices   []storage.Device       `json:"devices"`
+	Faults    []storage.Fault        `json:"faults"`
+	Monitor   []storage.MonitorFault `json:"monitor_faults"`
+	Plans     map[int][]int          `json:"plans"`
+}
+
+func (d dump) mutations() []storage.Mutation {
+	var mutations []storage.Mutation
+	for _, l := range d.Lights {
+		mutations = append(mutations, storage.Mutation{Op: storage.OpPutLight, Light: &l})
+	}
+	for _, s := range d.States {
+		mutations = append(mutations, storage.Mutation{Op: storage.OpPutState, State: &s})
+	}
+	for _, o := range d.Overrides {
+		mutations = append(mutations, storage.Mutation{Op: storage.OpPutOverride, Override: &o})
+	}
+	for _, dev := range d.Devices {
+		mutations = append(mutations, storage.Mutation{Op: storage.OpPutDevice, Device: &dev})
+	}
+	for _, fault := range d.Faults {
+		mutations = append(mutations, storage.Mutation{Op: storage.OpPutFault, Fault: &fault})
+	}
+	for _, mf := range d.Monitor {
+		mutations = append(mutations, storage.Mutation{Op: storage.OpPutMonitorFault, MonitorFault: &mf})
+	}
+	for t, plan := range d.Plans {
+		mutations = append(mutations, storage.Mutation{Op: storage.OpPutPlan, Type: t, Plan: plan})
+	}
+	return mutations
+}
+
+func (f *fsm) Snapshot() (raft.FSMSnapshot, error) {
+	var d dump
+	var err error
+	if d.Lights, err = f.store.Lights(); err != nil {
+		return nil, err
+	}
+	if d.States, err = f.store.States(); err != nil {
+		return nil, err
+	}
+	if d.Overrides, err = f.store.Overrides(); err != nil {
+		return nil, err
+	}
+	if d.Devices, err = f.store.Devices(); err != nil {
+		return nil, err
+	}
+	if d.Faults, err = f.store.Faults(); err != nil {
+		return nil, err
+	}
+	if d.Monitor, err = f.store.MonitorFaults(); err != nil {
+		return nil, err
+	}
+	if d.Plans, err = f.store.Plans(); err != nil {
+		return nil, err
+	}
+
+	data, err := json.Marshal(d)
+	if err != nil {
+		return nil, errors.Wrap(ErrCluster, err.Error())
+	}
+	return snapshot(data), nil
+}
+
+// Restore заменяет локальное состояние снимком лидера.
+func (f *fsm) Restore(rc io.ReadCloser) error {
+	defer rc.Close()
+	var d dump
+	if err := json.NewDecoder(rc).Decode(&d); err != nil {
+		return errors.Wrap(ErrCluster, err.Error())
+	}
+
+	lights, err := f.store.Lights()
+	if err != nil {
+		return err
+	}
+	for _, l := range lights {
+		if err := f.store.DeleteLight(l.UUID); err != nil {
+			return err
+		}
+		f.notify(storage.Mutation{Op: storage.OpDeleteLight, Key: l.UUID})
+	}
+	overrides, err := f.store.Overrides()
+	if err != nil {
+		return err
+	}
+	for _, o := range overrides {
+		if err := f.store.DeleteOverride(o.ID); err != nil {
+			return err
+		}
+		f.notify(storage.Mutation{Op: storage.OpDeleteOverride, Key: o.ID})
+	}
+	states, err := f.store.States()
+	if err != nil {
+		return err
+	}
+	for _, s := range states {
+		if err := f.store.DeleteState(s.UUID); err != nil {
+			return err
+		}
+		f.notify(storage.Mutation{Op: storage.OpDeleteState, Key: s.UUID})
+	}
+	devices, err := f.store.Devices()
+	if err != nil {
+		return err
+	}
+	for _, dev := range devices {
+		if err := f.store.DeleteDevice(dev.UUID); err != nil {
+			return err
+		}
+		f.notify(storage.Mutation{Op: storage.OpDeleteDevice, Key: dev.UUID})
+	}
+	faults, err := f.store.Faults()
+	if err != nil {
+		return err
+	}
+	for _, fault := range faults {
+		if err := f.store.DeleteFault(fault.Key()); err != nil {
+			return err
+		}
+		f.notify(storage.Mutation{Op: storage.OpDeleteFault, Key: fault.Key()})
+	}
+	monitorFaults, err := f.store.MonitorFaults()
+	if err != nil {
+		return err
+	}
+	for _, mf := range monitorFaults {
+		if err := f.store.DeleteMonitorFault(mf.Intersection); err != nil {
+			return err
+		}
+		f.notify(storage.Mutation{Op: storage.OpDeleteMonitorFault, Key: mf.Intersection})
+	}
+	// Планы из снимка перезаписываются, удаляются только отсутствующие в нем.
+	plans, err := f.store.Plans()
+	if err != nil {
+		return err
+	}
+	for t := range plans {
+		if _, ok := d.Plans[t]; ok {
+			continue
+		}
+		if err := f.store.DeletePlan(t); err != nil {
+			return err
+		}
+		f.notify(storage.Mutation{Op: storage.OpDeletePlan, Type: t})
+	}
+
+	for _, m := range d.mutations() {
+		if err := m.Apply(f.store); err != nil {
+			return err
+		}
+		f.notify(m)
+	}
+	return nil
+}
+
+type snapshot []byte
+
+func (s snapshot) Persist(sink raft.SnapshotSink) error {
+	if _, err := sink.Write(s); err != nil {
+		sink.Cancel()
+		return err
+	}
+	return sink.Close()
+}
+
+func (s snapshot) Release() {}
@@ -0,0 +1,334 @@
+package coap_test
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"log/slog"
+	"net"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+	"trafficlightAPI/internal/coap"
+	"trafficlightAPI/internal/middleware/auth"
+	"trafficlightAPI/internal/middleware/signing"
+	"trafficlightAPI/internal/models"
+	"trafficlightAPI/internal/stream"
+
+	"github.com/fxamacker/cbor/v2"
+	"github.com/pkg/errors"
+)
+
+func TestMessageRoundTrip(t *testing.T) {
+	tests := []struct {
+		name string
+		msg  coap.Message
+	}{
+		{name: "empty ack", msg: coap.Message{Type: coap.Acknowledgement, MessageID: 7}},
+		{
+			name: "request with path and query",
+			msg: coap.Message{
+				Type: coap.Confirmable, Code: coap.POST, MessageID: 0xbeef, Token: []byte{1, 2, 3, 4},
+				Options: []coap.Option{
+					{Number: coap.OptionURIPath, Value: []byte("trafficlight")},
+					{Number: coap.OptionContentFormat, Value: []byte{coap.FormatCBOR}},
+					{Number: coap.OptionURIQuery, Value: []byte("type=1")},
+				},
+				Payload: []byte{0xa1, 0x61, 0x61, 0x01},
+			},
+		},
+		{
+			name: "extended delta and length",
+			msg: coap.Message{
+				Type: coap.NonConfirmable, Code: coap.Content, MessageID: 1,
+				Options: []coap.Option{
+					{Number: 20, Value: bytes.Repeat([]byte("x"), 20)},
+					{Number: 2000, Value: bytes.Repeat([]byte("y"), 300)},
+				},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, err := tt.msg.Marshal()
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := coap.Unmarshal(data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(tt.msg.Token) == 0 {
+				tt.msg.Token = []byte{}
+			}
+			// Опции при кодировании сортируются по номеру.
+			if got.Type != tt.msg.Type || got.Code != tt.msg.Code || got.MessageID != tt.msg.MessageID ||
+				!bytes.Equal(got.Token, tt.msg.Token) || !bytes.Equal(got.Payload, tt.msg.Payload) || len(got.Options) != len(tt.msg.Options) {
+				t.Errorf("Unmarshal(Marshal()) = %+v, want %+v", got, tt.msg)
+			}
+		})
+	}
+
+	for _, data := range [][]byte{{0x40}, {0x00, 0x01, 0, 0}, {0x49, 0x01, 0, 0}, {0x40, 0x01, 0, 0, 0xff}, {0x40, 0x01, 0, 0, 0xf0}} {
+		if _, err := coap.Unmarshal(data); !errors.Is(err, coap.ErrInvalidMessage) {
+			t.Errorf("Unmarshal(%x) err = %v, want ErrInvalidMessage", data, err)
+		}
+	}
+	if got := coap.Code(69).String(); got != "2.05" {
+		t.Errorf("Content = %s", got)
+	}
+}
+
+type client struct {
+	t    *testing.T
+	srv  *coap.Server
+	conn net.Conn
+	id   uint16
+}
+
+// newClient запускает сервер; authn и verifier могут быть nil.
+func newClient(t *testing.T, authn *auth.Authenticator, verifier *signing.Verifier) *client {
+	t.Helper()
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := coap.NewServer(coap.Config{MaxObservers: 10, ConfirmEvery: 0}, authn, verifier, slog.Default())
+	go srv.Serve(pc)
+	t.Cleanup(func() { srv.Close() })
+
+	conn, err := net.Dial("udp", pc.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return &client{t: t, srv: srv, conn: conn}
+}
+
+func (c *client) send(m coap.Message) []byte {
+	c.t.Helper()
+	data, err := m.Marshal()
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	if _, err := c.conn.Write(data); err != nil {
+		c.t.Fatal(err)
+	}
+	return data
+}
+
+func (c *client) receive() (coap.Message, []byte) {
+	c.t.Helper()
+	buf := make([]byte, 2048)
+	c.conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	n, err := c.conn.Read(buf)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	m, err := coap.Unmarshal(buf[:n])
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	return m, buf[:n]
+}
+
+func (c *client) request(code coap.Code, path, query string, payload any, observe bool) coap.Message {
+	c.t.Helper()
+	c.id++
+	m := coap.Message{Type: coap.Confirmable, Code: code, MessageID: c.id, Token: []byte{byte(c.id), 0xaa}}
+	for _, segment := range strings.Split(path, "/") {
+		m.Options = append(m.Options, coap.Option{Number: coap.OptionURIPath, Value: []byte(segment)})
+	}
+	if query != "" {
+		for _, q := range strings.Split(query, "&") {
+			m.Options = append(m.Options, coap.Option{Number: coap.OptionURIQuery, Value: []byte(q)})
+		}
+	}
+	if observe {
+		m.SetUint(coap.OptionObserve, 0)
+	}
+	if payload != nil {
+		m.SetUint(coap.OptionContentFormat, coap.FormatCBOR)
+		m.Payload, _ = cbor.Marshal(payload)
+	}
+	c.send(m)
+	response, _ := c.receive()
+	if response.Type != coap.Acknowledgement || response.MessageID != m.MessageID || !bytes.Equal(response.Token, m.Token) {
+		c.t.Fatalf("response %+v does not match request %+v", response, m)
+	}
+	return response
+}
+
+func TestNextState(t *testing.T) {
+	c := newClient(t, nil, nil)
+	currentTime := 19
+
+	tests := []struct {
+		name    string
+		query   string
+		payload any
+		code    coap.Code
+		want    *models.TrafficResponse
+	}{
+		{
+			name: "regular light", query: "type=1",
+			payload: models.TrafficRequest{UUID: "a", CurrentState: 1, CurrentTime: &currentTime},
+			code:    coap.Content, want: &models.TrafficResponse{UUID: "a", NextState: "2"},
+		},
+		{
+			name: "missing current_time", query: "type=1",
+			payload: map[string]any{"uuid": "a", "current_state": 1},
+			code:    coap.BadRequest,
+		},
+		{
+			name:    "missing type of unregistered light",
+			payload: models.TrafficRequest{UUID: "a", CurrentState: 1, CurrentTime: &currentTime},
+			code:    coap.BadRequest,
+		},
+		{name: "not cbor", query: "type=1", payload: nil, code: coap.BadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			response := c.request(coap.POST, "trafficlight", tt.query, tt.payload, false)
+			if response.Code != tt.code {
+				t.Fatalf("code = %s, want %s (%s)", response.Code, tt.code, response.Payload)
+			}
+			if tt.want != nil {
+				var got models.TrafficResponse
+				if err := cbor.Unmarshal(response.Payload, &got); err != nil {
+					t.Fatal(err)
+				}
+				if !reflect.DeepEqual(got, *tt.want) {
+					t.Errorf("response = %+v, want %+v", got, *tt.want)
+				}
+			}
+		})
+	}
+
+	if response := c.request(coap.GET, "unknown", "", nil, false); response.Code != coap.NotFound {
+		t.Errorf("unknown path: code = %s", response.Code)
+	}
+
+	// Повтор CON с тем же ID получает тот же ответ без повторной обработки.
+	request := coap.Message{Type: coap.Confirmable, Code: coap.POST, MessageID: 500, Token: []byte{9}}
+	request.Options = []coap.Option{{Number: coap.OptionURIPath, Value: []byte("trafficlight")}, {Number: coap.OptionURIQuery, Value: []byte("type=3")}}
+	request.Payload, _ = cbor.Marshal(models.TrafficRequest{UUID: "p", CurrentState: 1, CurrentTime: &currentTime})
+	c.send(request)
+	_, first := c.receive()
+	c.send(request)
+	if _, second := c.receive(); !bytes.Equal(first, second) {
+		t.Errorf("duplicate response differs: %x != %x", first, second)
+	}
+}
+
+func TestObserve(t *testing.T) {
+	hub := stream.NewHub(stream.Config{Buffer: 4, MaxDrops: 4}, nil)
+	stream.SetDefault(hub)
+	t.Cleanup(func() { stream.SetDefault(nil) })
+	c := newClient(t, nil, nil)
+
+	response := c.request(coap.GET, "lights/a", "", nil, true)
+	seq, ok := response.Uint(coap.OptionObserve)
+	if response.Code != coap.Content || !ok {
+		t.Fatalf("registration = %s, observe %v", response.Code, ok)
+	}
+	if len(hub.Connections()) != 1 {
+		t.Fatalf("connections = %+v", hub.Connections())
+	}
+
+	hub.Publish(models.Transition{UUID: "a", Type: 1, To: 3, Time: time.Now(), Plan: []int{10, 3, 20}, Mode: models.ModeNormal})
+	notification, _ := c.receive()
+	next, _ := notification.Uint(coap.OptionObserve)
+	if notification.Type != coap.NonConfirmable || notification.Code != coap.Content || next <= seq || !bytes.Equal(notification.Token, response.Token) {
+		t.Fatalf("notification = %+v", notification)
+	}
+	var event stream.Event
+	if err := cbor.Unmarshal(notification.Payload, &event); err != nil {
+		t.Fatal(err)
+	}
+	if event.UUID != "a" || event.State != 3 || !event.Lamps.Green || event.Duration != 20 {
+		t.Errorf("event = %+v", event)
+	}
+
+	// Reset в ответ на уведомление отменяет наблюдение.
+	c.send(coap.Message{Type: coap.Reset, MessageID: notification.MessageID})
+	for deadline := time.Now().Add(2 * time.Second); c.srv.Observers() > 0; time.Sleep(time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("observer is not removed after reset")
+		}
+	}
+	if len(hub.Connections()) != 0 {
+		t.Errorf("connections after reset = %+v", hub.Connections())
+	}
+	hub.Publish(models.Transition{UUID: "a", Type: 1, To: 1, Time: time.Now(), Plan: []int{10, 3, 20}, Mode: models.ModeNormal})
+	c.conn.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+	if _, err := c.conn.Read(make([]byte, 64)); err == nil {
+		t.Error("notification after reset")
+	}
+}
+
+func TestSigning(t *testing.T) {
+	const secret = "0123456789abcdef0123"
+	verifier := signing.New(signing.Config{Enabled: true, Secrets: map[string][]byte{"a": []byte(secret)}}, nil)
+	c := newClient(t, nil, verifier)
+	currentTime := 19
+
+	// sign возвращает Uri-Query с подписью запроса к /trafficlight?type=1.
+	sign := func(device, key, nonce string, payload any) string {
+		body, _ := cbor.Marshal(payload)
+		timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+		signature := signing.Sign([]byte(key), signing.StringToSign("POST", "/trafficlight", "type=1", timestamp, nonce, body))
+		return "type=1&" + coap.DeviceQuery + "=" + device + "&" + coap.TimestampQuery + "=" + timestamp +
+			"&" + coap.NonceQuery + "=" + nonce + "&" + coap.SignatureQuery + "=" + signature
+	}
+	own := models.TrafficRequest{UUID: "a", CurrentState: 1, CurrentTime: &currentTime}
+	other := models.TrafficRequest{UUID: "b", CurrentState: 1, CurrentTime: &currentTime}
+
+	tests := []struct {
+		name    string
+		query   string
+		payload models.TrafficRequest
+		code    coap.Code
+	}{
+		{name: "unsigned", query: "type=1", payload: own, code: coap.Unauthorized},
+		{name: "wrong secret", query: sign("a", "wrong-secret-wrong-secret", "1", own), payload: own, code: coap.Unauthorized},
+		{name: "signed by the device", query: sign("a", secret, "2", own), payload: own, code: coap.Content},
+		{name: "replayed nonce", query: sign("a", secret, "2", own), payload: own, code: coap.Unauthorized},
+		{name: "uuid of another device", query: sign("a", secret, "3", other), payload: other, code: coap.Forbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if response := c.request(coap.POST, "trafficlight", tt.query, tt.payload, false); response.Code != tt.code {
+				t.Errorf("code = %s, want %s (%s)", response.Code, tt.code, response.Payload)
+			}
+		})
+	}
+}
+
+func TestObserveAuth(t *testing.T) {
+	hub := stream.NewHub(stream.Config{Buffer: 4, MaxDrops: 4}, nil)
+	stream.SetDefault(hub)
+	t.Cleanup(func() { stream.SetDefault(nil) })
+	hash := sha256.Sum256([]byte("viewer-key"))
+	authn, err := auth.New(auth.Config{Enabled: true, APIKeys: []auth.APIKey{{Name: "viewer", SHA256: hex.EncodeToString(hash[:]), Role: "viewer"}}}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := newClient(t, authn, nil)
+
+	if response := c.request(coap.GET, "lights/a", "", nil, true); response.Code != coap.Unauthorized {
+		t.Errorf("without key: code = %s, want %s", response.Code, coap.Unauthorized)
+	}
+	if response := c.request(coap.GET, "lights/a", coap.KeyQuery+"=wrong", nil, true); response.Code != coap.Unauthorized {
+		t.Errorf("wrong key: code = %s, want %s", response.Code, coap.Unauthorized)
+	}
+	if c.srv.Observers() != 0 {
+		t.Fatalf("observers = %d after denied requests", c.srv.Observers())
+	}
+	response := c.request(coap.GET, "lights/a", coap.KeyQuery+"=viewer-key", nil, true)
+	if _, ok := response.Uint(coap.OptionObserve); response.Code != coap.Content || !ok {
+		t.Errorf("with key: code = %s, observe %v", response.Code, ok)
+	}
+}
@@ -0,0 +1,256 @@
+package coap
+
+import (
+	"encoding/binary"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	ErrInvalidMessage = errors.New("некорректное сообщение CoAP")
+)
+
+// Type - тип сообщения CoAP (RFC 7252, 3).
+type Type uint8
+
+const (
+	Confirmable     Type = 0
+	NonConfirmable  Type = 1
+	Acknowledgement Type = 2
+	Reset           Type = 3
+)
+
+// Code - класс и детализация кода в виде c.dd (RFC 7252, 12.1).
+type Code uint8
+
+const (
+	Empty Code = 0
+
+	GET    Code = 1
+	POST   Code = 2
+	PUT    Code = 3
+	DELETE Code = 4
+	FETCH  Code = 5 // RFC 8132
+
+	Content                  Code = 2<<5 | 5
+	BadRequest               Code = 4<<5 | 0
+	Unauthorized             Code = 4<<5 | 1
+	BadOption                Code = 4<<5 | 2
+	Forbidden                Code = 4<<5 | 3
+	NotFound                 Code = 4<<5 | 4
+	MethodNotAllowed         Code = 4<<5 | 5
+	NotAcceptable            Code = 4<<5 | 6
+	UnsupportedContentFormat Code = 4<<5 | 15
+	InternalServerError      Code = 5<<5 | 0
+	ServiceUnavailable       Code = 5<<5 | 3
+)
+
+func (c Code) String() string {
+	return fmt.Sprintf("%d.%02d", c>>5, c&0x1f)
+}
+
+// Номера опций (RFC 7252, 5.10; RFC 7641).
+const (
+	OptionObserve       uint16 = 6
+	OptionURIPath       uint16 = 11
+	OptionContentFormat uint16 = 12
+	OptionMaxAge        uint16 = 14
+	OptionURIQuery      uint16 = 15
+	OptionAccept        uint16 = 17
+)
+
+// Форматы содержимого.
+const (
+	FormatText     = 0
+	FormatLinkCore = 40
+	FormatCBOR     = 60
+)
+
+type Option struct {
+	Number uint16
+	Value  []byte
+}
+
+type Message struct {
+	Type      Type
+	Code      Code
+	MessageID uint16
+	Token     []byte
+	Options   []Option
+	Payload   []byte
+}
+
+func (m *Message) Option(number uint16) ([]byte, bool) {
+	for _, o := range m.Options {
+		if o.Number == number {
+			return o.Value, true
+		}
+	}
+	return nil, false
+}
+
+// Uint возвращает опцию-число (big-endian без ведущих нулей).
+func (m *Message) Uint(number uint16) (uint32, bool) {
+	value, ok := m.Option(number)
+	if !ok || len(value) > 4 {
+		return 0, false
+	}
+	var v uint32
+	for _, b := range value {
+		v = v<<8 | uint32(b)
+	}
+	return v, true
+}
+
+func (m *Message) SetUint(number uint16, v uint32) {
+	var buf [4]byte
+	binary.BigEndian.PutUint32(buf[:], v)
+	i := 0
+	for i < 4 && buf[i] == 0 {
+		i++
+	}
+	m.Options = append(m.Options, Option{Number: number, Value: buf[i:]})
+}
+
+// Path возвращает Uri-Path без ведущего "/".
+func (m *Message) Path() []string {
+	var path []string
+	for _, o := range m.Options {
+		if o.Number == OptionURIPath {
+			path = append(path, string(o.Value))
+		}
+	}
+	return path
+}
+
+// Query возвращает параметр Uri-Query name=value.
+func (m *Message) Query(name string) string {
+	for _, o := range m.Options {
+		if o.Number != OptionURIQuery {
+			continue
+		}
+		if k, v, _ := strings.Cut(string(o.Value), "="); k == name {
+			return v
+		}
+	}
+	return ""
+}
+
+func (m *Message) Marshal() ([]byte, error) {
+	if len(m.Token) > 8 {
+		return nil, errors.Wrapf(ErrInvalidMessage, "token длиной %d", len(m.Token))
+	}
+	buf := make([]byte, 4, 4+len(m.Token)+len(m.Payload)+16)
+	buf[0] = 1<<6 | byte(m.Type)<<4 | byte(len(m.Token))
+	buf[1] = byte(m.Code)
+	binary.BigEndian.PutUint16(buf[2:], m.MessageID)
+	buf = append(buf, m.Token...)
+
+	options := append([]Option(nil), m.Options...)
+	sort.SliceStable(options, func(i, j int) bool { return options[i].Number < options[j].Number })
+	var last uint16
+	for _, o := range options {
+		delta, length := int(o.Number-last), len(o.Value)
+		last = o.Number
+		if length > 65535+269 {
+			return nil, errors.Wrapf(ErrInvalidMessage, "опция %d длиной %d", o.Number, length)
+		}
+		head := len(buf)
+		buf = append(buf, 0)
+		var d, l byte
+		buf, d = appendExt(buf, delta)
+		buf, l = appendExt(buf, length)
+		buf[head] = d<<4 | l
+		buf = append(buf, o.Value...)
+	}
+
+	if len(m.Payload) > 0 {
+		buf = append(buf, 0xff)
+		buf = append(buf, m.Payload...)
+	}
+	return buf, nil
+}
+
+// appendExt дописывает расширенное значение дельты или длины опции и возвращает 4-битное поле.
+func appendExt(buf []byte, v int) ([]byte, byte) {
+	switch {
+	case v < 13:
+		return buf, byte(v)
+	case v < 269:
+		return append(buf, byte(v-13)), 13
+	default:
+		return binary.BigEndian.AppendUint16(buf, uint16(v-269)), 14
+	}
+}
+
+func Unmarshal(data []byte) (Message, error) {
+	if len(data) < 4 {
+		return Message{}, errors.Wrap(ErrInvalidMessage, "короче заголовка")
+	}
+	if data[0]>>6 != 1 {
+		return Message{}, errors.Wrapf(ErrInvalidMessage, "версия %d", data[0]>>6)
+	}
+	m := Message{
+		Type:      Type(data[0] >> 4 & 0x3),
+		Code:      Code(data[1]),
+		MessageID: binary.BigEndian.Uint16(data[2:]),
+	}
+	tokenLength := int(data[0] & 0xf)
+	if tokenLength > 8 || len(data) < 4+tokenLength {
+		return Message{}, errors.Wrapf(ErrInvalidMessage, "token длиной %d", tokenLength)
+	}
+	m.Token = append([]byte(nil), data[4:4+tokenLength]...)
+
+	rest := data[4+tokenLength:]
+	var number int
+	for len(rest) > 0 {
+		if rest[0] == 0xff {
+			if len(rest) == 1 {
+				return Message{}, errors.Wrap(ErrInvalidMessage, "маркер без содержимого")
+			}
+			m.Payload = append([]byte(nil), rest[1:]...)
+			break
+		}
+		head := rest[0]
+		rest = rest[1:]
+		var delta, length int
+		var err error
+		if delta, rest, err = readExt(head>>4, rest); err != nil {
+			return Message{}, err
+		}
+		if length, rest, err = readExt(head&0xf, rest); err != nil {
+			return Message{}, err
+		}
+		if len(rest) < length {
+			return Message{}, errors.Wrap(ErrInvalidMessage, "опция длиннее сообщения")
+		}
+		number += delta
+		if number > 0xffff {
+			return Message{}, errors.Wrapf(ErrInvalidMessage, "номер опции %d", number)
+		}
+		m.Options = append(m.Options, Option{Number: uint16(number), Value: append([]byte(nil), rest[:length]...)})
+		rest = rest[length:]
+	}
+	return m, nil
+}
+
+func readExt(v byte, rest []byte) (int, []byte, error) {
+	switch v {
+	case 13:
+		if len(rest) < 1 {
+			return 0, nil, errors.Wrap(ErrInvalidMessage, "обрезанная опция")
+		}
+		return int(rest[0]) + 13, rest[1:], nil
+	case 14:
+		if len(rest) < 2 {
+			return 0, nil, errors.Wrap(ErrInvalidMessage, "обрезанная опция")
+		}
+		return int(binary.BigEndian.Uint16(rest)) + 269, rest[2:], nil
+	case 15:
+		return 0, nil, errors.Wrap(ErrInvalidMessage, "зарезервированное значение 15")
+	}
+	return int(v), rest, nil
+}
@@ -0,0 +1,494 @@
+// Package coap - CoAP-сервер (RFC 7252) с CBOR и Observe (RFC 7641) для
+// устройств, которые не умеют HTTP. Реализовано только нужное сервису: без
+// DTLS, блочной передачи и прокси.
+package coap
+
+import (
+	"context"
+	"crypto/rand"
+	"log/slog"
+	"math/big"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"trafficlightAPI/internal/control"
+	"trafficlightAPI/internal/middleware/auth"
+	"trafficlightAPI/internal/middleware/signing"
+	"trafficlightAPI/internal/models"
+	"trafficlightAPI/internal/stream"
+
+	"github.com/fxamacker/cbor/v2"
+	"github.com/pkg/errors"
+)
+
+var (
+	ErrInvalidType    = control.ErrInvalidType
+	ErrStreamDisabled = errors.New("потоковая передача состояний не настроена")
+)
+
+// Параметры передачи RFC 7252, 4.8.
+const (
+	ackTimeout       = 2 * time.Second
+	maxRetransmit    = 4
+	exchangeLifetime = 247 * time.Second
+)
+
+// Параметры Uri-Query: подпись устройства, значения как у заголовков
+// signing, и API ключ для /lights.
+const (
+	DeviceQuery    = "device"
+	TimestampQuery = "ts"
+	NonceQuery     = "nonce"
+	SignatureQuery = "sig"
+	KeyQuery       = "key"
+)
+
+type Config struct {
+	MaxObservers int // Наблюдений Observe одновременно
+	ConfirmEvery int // Каждое N-е уведомление подтверждаемое, чтобы находить пропавших наблюдателей
+}
+
+type exchange struct {
+	response []byte
+	at       time.Time
+}
+
+type observer struct {
+	key   string
+	addr  net.Addr
+	token []byte
+	hub   *stream.Hub
+	sub   *stream.Subscription
+	seq   uint32
+	count int
+	last  uint16 // ID последнего уведомления, под Server.mu
+}
+
+// Server обрабатывает запросы устройств:
+//   - POST /trafficlight?type=N - как /trafficlight, тело и ответ в CBOR;
+//   - GET /lights/{uuid} - последнее состояние, с Observe: 0 - и каждая смена;
+//   - GET /.well-known/core - список ресурсов (RFC 6690).
+//
+// При включенной подписи /trafficlight требует подпись устройства в Uri-Query
+// device, ts, nonce и sig: строка для подписи как у HTTP, с методом POST или
+// FETCH, путем /trafficlight, остальными параметрами Uri-Query и телом CBOR.
+// При включенной аутентификации /lights требует API ключ роли viewer в key.
+type Server struct {
+	cfg      Config
+	authn    *auth.Authenticator
+	verifier *signing.Verifier
+	logger   *slog.Logger
+	conn     net.PacketConn
+
+	mu        sync.Mutex
+	messageID uint16
+	recent    map[string]exchange // Ответы на CON для повторов, адрес/ID сообщения
+	pruned    time.Time
+	observers map[string]*observer // Адрес/token
+	pending   map[uint16]*observer // Подтверждаемые уведомления без ACK
+}
+
+func NewServer(cfg Config, authn *auth.Authenticator, verifier *signing.Verifier, logger *slog.Logger) *Server {
+	var id [2]byte
+	rand.Read(id[:])
+	return &Server{
+		cfg:       cfg,
+		authn:     authn,
+		verifier:  verifier,
+		logger:    logger,
+		messageID: uint16(id[0])<<8 | uint16(id[1]),
+		recent:    make(map[string]exchange),
+		observers: make(map[string]*observer),
+		pending:   make(map[uint16]*observer),
+	}
+}
+
+// Serve читает запросы из conn до его закрытия.
+func (s *Server) Serve(conn net.PacketConn) error {
+	s.mu.Lock()
+	s.conn = conn
+	s.mu.Unlock()
+
+	buf := make([]byte, 64<<10)
+	for {
+		n, addr, err := conn.ReadFrom(buf)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+		m, err := Unmarshal(buf[:n])
+		if err != nil {
+			s.logger.Debug("некорректное сообщение CoAP", slog.String("remote", addr.String()), slog.Any("err", err))
+			continue
+		}
+		s.receive(addr, m)
+	}
+}
+
+// Close отменяет наблюдения и закрывает соединение.
+func (s *Server) Close() error {
+	s.mu.Lock()
+	observers := make([]*observer, 0, len(s.observers))
+	for _, o := range s.observers {
+		observers = append(observers, o)
+	}
+	conn := s.conn
+	s.mu.Unlock()
+
+	for _, o := range observers {
+		s.forget(o, stream.ReasonShutdown)
+	}
+	if conn == nil {
+		return nil
+	}
+	return conn.Close()
+}
+
+func (s *Server) nextID() uint16 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.messageID++
+	return s.messageID
+}
+
+func (s *Server) send(addr net.Addr, m Message) []byte {
+	data, err := m.Marshal()
+	if err != nil {
+		s.logger.Error("ошибка кодирования сообщения CoAP", slog.Any("err", err))
+		return nil
+	}
+	if _, err := s.conn.WriteTo(data, addr); err != nil {
+		s.logger.Debug("ошибка отправки CoAP", slog.String("remote", addr.String()), slog.Any("err", err))
+	}
+	return data
+}
+
+func (s *Server) receive(addr net.Addr, m Message) {
+	switch m.Type {
+	case Acknowledgement, Reset:
+		s.mu.Lock()
+		o, ok := s.pending[m.MessageID]
+		delete(s.pending, m.MessageID)
+		if !ok && m.Type == Reset {
+			o, ok = s.notified(addr, m.MessageID)
+		}
+		s.mu.Unlock()
+		// Reset в ответ на уведомление - отказ от наблюдения (RFC 7641, 3.6).
+		if ok && m.Type == Reset {
+			s.forget(o, stream.ReasonClient)
+		}
+		return
+	}
+	if m.Code == Empty {
+		// CoAP ping: пустой CON, ответ - Reset.
+		if m.Type == Confirmable {
+			s.send(addr, Message{Type: Reset, MessageID: m.MessageID})
+		}
+		return
+	}
+
+	key := addr.String() + "/" + strconv.Itoa(int(m.MessageID))
+	if m.Type == Confirmable {
+		s.mu.Lock()
+		e, duplicate := s.recent[key]
+		s.mu.Unlock()
+		if duplicate {
+			s.conn.WriteTo(e.response, addr)
+			return
+		}
+	}
+
+	response := s.handle(addr, m)
+	response.Token = m.Token
+	if m.Type == Confirmable {
+		response.Type = Acknowledgement
+		response.MessageID = m.MessageID
+	} else {
+		response.Type = NonConfirmable
+		response.MessageID = s.nextID()
+	}
+	data := s.send(addr, response)
+
+	if m.Type == Confirmable {
+		now := time.Now()
+		s.mu.Lock()
+		s.recent[key] = exchange{response: data, at: now}
+		if now.Sub(s.pruned) > time.Second {
+			for k, e := range s.recent {
+				if now.Sub(e.at) > exchangeLifetime {
+					delete(s.recent, k)
+				}
+			}
+			s.pruned = now
+		}
+		s.mu.Unlock()
+	}
+}
+
+func diagnostic(code Code, err error) Message {
+	m := Message{Code: code, Payload: []byte(err.Error())}
+	m.SetUint(OptionContentFormat, FormatText)
+	return m
+}
+
+func cborMessage(v any) Message {
+	payload, err := cbor.Marshal(v)
+	if err != nil {
+		return diagnostic(InternalServerError, err)
+	}
+	m := Message{Code: Content, Payload: payload}
+	m.SetUint(OptionContentFormat, FormatCBOR)
+	return m
+}
+
+func (s *Server) handle(addr net.Addr, m Message) Message {
+	if accept, ok := m.Uint(OptionAccept); ok && accept != FormatCBOR && accept != FormatLinkCore {
+		return Message{Code: NotAcceptable}
+	}
+
+	path := m.Path()
+	switch {
+	case len(path) == 2 && path[0] == ".well-known" && path[1] == "core":
+		if m.Code != GET {
+			return Message{Code: MethodNotAllowed}
+		}
+		links := Message{Code: Content, Payload: []byte(`</trafficlight>;rt="trafficlight";ct=60,</lights>;rt="trafficlight.state";obs;ct=60`)}
+		links.SetUint(OptionContentFormat, FormatLinkCore)
+		return links
+	case len(path) == 1 && path[0] == "trafficlight":
+		if m.Code != POST && m.Code != FETCH {
+			return Message{Code: MethodNotAllowed}
+		}
+		return s.nextState(m)
+	case len(path) == 2 && path[0] == "lights":
+		if m.Code != GET {
+			return Message{Code: MethodNotAllowed}
+		}
+		return s.light(addr, m, path[1])
+	}
+	return Message{Code: NotFound}
+}
+
+// nextState вычисляет ответ, как ServeTrafficRoute. Изображение не
+// передается: оно не помещается в датаграмму без блочной передачи.
+func (s *Server) nextState(m Message) Message {
+	if format, ok := m.Uint(OptionContentFormat); ok && format != FormatCBOR {
+		return Message{Code: UnsupportedContentFormat}
+	}
+
+	var request models.TrafficRequest
+	if err := cbor.Unmarshal(m.Payload, &request); err != nil {
+		return diagnostic(BadRequest, errors.Wrap(err, "ошибка при разборе CBOR"))
+	}
+	request.NeedImage = false
+
+	requested := 0
+	if v := m.Query("type"); v != "" {
+		var err error
+		if requested, err = strconv.Atoi(v); err != nil {
+			return diagnostic(BadRequest, errors.Wrapf(ErrInvalidType, "type:%s", v))
+		}
+	}
+	ctx := context.Background()
+	if s.verifier != nil && s.verifier.Enabled() {
+		device, timestamp, nonce := m.Query(DeviceQuery), m.Query(TimestampQuery), m.Query(NonceQuery)
+		method := "POST"
+		if m.Code == FETCH {
+			method = "FETCH"
+		}
+		stringToSign := signing.StringToSign(method, "/trafficlight", signedQuery(m), timestamp, nonce, m.Payload)
+		if err := s.verifier.Check(device, timestamp, nonce, m.Query(SignatureQuery), stringToSign); err != nil {
+			if errors.Is(err, signing.ErrBusy) {
+				return diagnostic(ServiceUnavailable, err)
+			}
+			return diagnostic(Unauthorized, err)
+		}
+		ctx = signing.WithDevice(ctx, device)
+	}
+
+	response, err := control.NextState(ctx, "coap", request, requested)
+	switch {
+	case errors.Is(err, signing.ErrDevice):
+		return diagnostic(Forbidden, err)
+	case err != nil:
+		return diagnostic(BadRequest, err)
+	}
+	return cborMessage(response)
+}
+
+// signedQuery возвращает параметры Uri-Query без параметров подписи.
+func signedQuery(m Message) string {
+	var query []string
+	for _, o := range m.Options {
+		if o.Number != OptionURIQuery {
+			continue
+		}
+		switch k, _, _ := strings.Cut(string(o.Value), "="); k {
+		case DeviceQuery, TimestampQuery, NonceQuery, SignatureQuery:
+		default:
+			query = append(query, string(o.Value))
+		}
+	}
+	return strings.Join(query, "&")
+}
+
+// authorize проверяет API ключ из Uri-Query key.
+func (s *Server) authorize(m Message) (Code, error) {
+	if s.authn == nil || !s.authn.Enabled() {
+		return Content, nil
+	}
+	identity, err := s.authn.Credentials(m.Query(KeyQuery), "")
+	if err != nil {
+		return Unauthorized, err
+	}
+	if identity.Role < auth.RoleViewer {
+		return Forbidden, errors.Wrapf(auth.ErrForbidden, "%s: роль %s, требуется %s", identity.Name, identity.Role, auth.RoleViewer)
+	}
+	return Content, nil
+}
+
+// light отвечает последним состоянием светофора и управляет наблюдением.
+func (s *Server) light(addr net.Addr, m Message, uuid string) Message {
+	if code, err := s.authorize(m); err != nil {
+		return diagnostic(code, err)
+	}
+	hub := stream.Default()
+	if hub == nil {
+		return diagnostic(ServiceUnavailable, ErrStreamDisabled)
+	}
+	filter := stream.Filter{UUIDs: []string{uuid}}
+	key := addr.String() + "/" + string(m.Token)
+
+	s.mu.Lock()
+	previous := s.observers[key]
+	s.mu.Unlock()
+	if previous != nil {
+		s.forget(previous, stream.ReasonClient)
+	}
+
+	var response Message
+	if snapshot := hub.Snapshot(filter); len(snapshot) > 0 {
+		response = cborMessage(snapshot[0])
+	} else {
+		response = Message{Code: Content}
+		response.SetUint(OptionContentFormat, FormatCBOR)
+	}
+
+	observe, ok := m.Uint(OptionObserve)
+	if !ok || observe != 0 {
+		if response.Payload == nil {
+			return Message{Code: NotFound}
+		}
+		return response
+	}
+
+	s.mu.Lock()
+	full := s.cfg.MaxObservers > 0 && len(s.observers) >= s.cfg.MaxObservers
+	s.mu.Unlock()
+	if full {
+		// Без опции Observe клиент понимает, что наблюдение не принято.
+		return response
+	}
+
+	o := &observer{key: key, addr: addr, token: m.Token, hub: hub, seq: 1}
+	o.sub = hub.Subscribe("coap", addr.String(), "", filter)
+	s.mu.Lock()
+	s.observers[key] = o
+	s.mu.Unlock()
+	go s.notify(o)
+
+	response.SetUint(OptionObserve, o.seq)
+	return response
+}
+
+func (s *Server) notify(o *observer) {
+	for {
+		select {
+		case <-o.sub.Done():
+			s.forget(o, o.sub.Reason())
+			return
+		case e := <-o.sub.Events():
+			o.sub.Delivered()
+			m := cborMessage(e)
+			m.Token = o.token
+			m.MessageID = s.nextID()
+			s.mu.Lock()
+			o.last = m.MessageID
+			s.mu.Unlock()
+			o.seq = (o.seq + 1) & 0xffffff
+			m.SetUint(OptionObserve, o.seq)
+
+			o.count++
+			if s.cfg.ConfirmEvery > 0 && o.count%s.cfg.ConfirmEvery == 0 {
+				m.Type = Confirmable
+				s.confirm(o, m)
+				continue
+			}
+			m.Type = NonConfirmable
+			s.send(o.addr, m)
+		}
+	}
+}
+
+// confirm отправляет подтверждаемое уведомление с повторами по RFC 7252, 4.2.
+// Наблюдатель, не ответивший после всех повторов, забывается.
+func (s *Server) confirm(o *observer, m Message) {
+	s.mu.Lock()
+	s.pending[m.MessageID] = o
+	s.mu.Unlock()
+
+	jitter, _ := rand.Int(rand.Reader, big.NewInt(int64(ackTimeout/2)))
+	timeout := ackTimeout + time.Duration(jitter.Int64())
+	var attempt int
+	var retry func()
+	retry = func() {
+		s.mu.Lock()
+		_, waiting := s.pending[m.MessageID]
+		if waiting && attempt == maxRetransmit {
+			delete(s.pending, m.MessageID)
+		}
+		s.mu.Unlock()
+		switch {
+		case !waiting:
+		case attempt == maxRetransmit:
+			s.forget(o, stream.ReasonWriteError)
+		default:
+			attempt++
+			s.send(o.addr, m)
+			time.AfterFunc(timeout<<attempt, retry)
+		}
+	}
+	s.send(o.addr, m)
+	time.AfterFunc(timeout, retry)
+}
+
+// notified ищет наблюдателя по ID последнего уведомления, чтобы Reset
+// отменял наблюдение и в ответ на неподтверждаемое уведомление.
+func (s *Server) notified(addr net.Addr, id uint16) (*observer, bool) {
+	for _, o := range s.observers {
+		if o.last == id && o.addr.String() == addr.String() {
+			return o, true
+		}
+	}
+	return nil, false
+}
+
+func (s *Server) forget(o *observer, reason string) {
+	s.mu.Lock()
+	if s.observers[o.key] == o {
+		delete(s.observers, o.key)
+	}
+	s.mu.Unlock()
+	o.hub.Unsubscribe(o.sub, reason)
+}
+
+// Observers возвращает число активных наблюдений.
+func (s *Server) Observers() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.observers)
+}
@@ -12,7 +12,26 @@ type Config struct {
 	Env            string     `yaml:"env" env-default:"dev"`
 	Probability4xx float32    `yaml:"probability4xx" env-default:"0.5"`
 	Probability5xx float32    `yaml:"probability5xx" env-default:"1.0"`
+	PlansPath      string     `yaml:"plans_path"`
 	Server         HTTPServer `yaml:"http_server"`
+	Webster        Webster    `yaml:"webster"`
+	Events         Events     `yaml:"events"`
+	History        History    `yaml:"history"`
+	Storage        Storage    `yaml:"storage"`
+	Cluster        Cluster    `yaml:"cluster"`
+	Overrides      Overrides  `yaml:"overrides"`
+	Audit          Audit      `yaml:"audit"`
+	Auth           Auth       `yaml:"auth"`
+	Signing        Signing    `yaml:"signing"`
+	Heartbeat      Heartbeat  `yaml:"heartbeat"`
+	Faults         Faults     `yaml:"faults"`
+	Monitor        Monitor    `yaml:"conflict_monitor"`
+	Stream         Stream     `yaml:"stream"`
+	GRPC           GRPC       `yaml:"grpc"`
+	CoAP           CoAP       `yaml:"coap"`
+	Modbus         Modbus     `yaml:"modbus"`
+	SNMP           SNMP       `yaml:"snmp"`
+	MQTT           MQTT       `yaml:"mqtt"`
 }
 
 type HTTPServer struct {
@@ -21,6 +40,190 @@ type HTTPServer struct {
 	IdleTimeout time.Duration `yaml:"idle_timeout" env-default:"60s"`
 }
 
+type Webster struct {
+	LostTime float64 `yaml:"lost_time" env-default:"4"`
+	MinCycle float64 `yaml:"min_cycle" env-default:"30"`
+	MaxCycle float64 `yaml:"max_cycle" env-default:"120"`
+	MinGreen float64 `yaml:"min_green" env-default:"5"`
+}
+
+type Events struct {
+	Dir       string        `yaml:"dir" env-default:"./logs/events/"`
+	MaxSizeMB int64         `yaml:"max_size_mb" env-default:"100"`
+	MaxAge    time.Duration `yaml:"max_age" env-default:"2160h"`
+}
+
+type History struct {
+	Path           string        `yaml:"path" env-default:"./data/history.db"`
+	MaxAge         time.Duration `yaml:"max_age" env-default:"720h"`
+	MaxTransitions int           `yaml:"max_transitions" env-default:"100000"` // На один светофор
+	PruneInterval  time.Duration `yaml:"prune_interval" env-default:"1h"`
+}
+
+type Storage struct {
+	Path            string `yaml:"path" env-default:"./data/state.db"`
+	MaxUnregistered int    `yaml:"max_unregistered" env-default:"1000"` // Незарегистрированных светофоров в памяти
+}
+
+type Cluster struct {
+	Enabled         bool          `yaml:"enabled" env-default:"false"`
+	NodeID          string        `yaml:"node_id" env:"CLUSTER_NODE_ID"`
+	RaftAddress     string        `yaml:"raft_address" env:"CLUSTER_RAFT_ADDRESS" env-default:"127.0.0.1:7000"`
+	Dir             string        `yaml:"dir" env-default:"./data/raft/"`
+	Bootstrap       bool          `yaml:"bootstrap" env:"CLUSTER_BOOTSTRAP"`
+	Peers           []ClusterPeer `yaml:"peers"`
+	ReadConsistency string        `yaml:"read_consistency" env-default:"linearizable"` // linearizable или stale
+	ApplyTimeout    time.Duration `yaml:"apply_timeout" env-default:"5s"`
+	APIKey          string        `yaml:"api_key" env:"CLUSTER_API_KEY"`
+}
+
+type ClusterPeer struct {
+	ID          string `yaml:"id"`
+	RaftAddress string `yaml:"raft_address"`
+	HTTPAddress string `yaml:"http_address"`
+}
+
+type Overrides struct {
+	DefaultDuration time.Duration `yaml:"default_duration" env-default:"15m"`
+	MaxDuration     time.Duration `yaml:"max_duration" env-default:"8h"`
+	ExpiryInterval  time.Duration `yaml:"expiry_interval" env-default:"1s"`
+}
+
+type Audit struct {
+	Path string `yaml:"path" env-default:"./logs/audit.log"`
+}
+
+type Auth struct {
+	Enabled   bool         `yaml:"enabled" env:"AUTH_ENABLED" env-default:"false"`
+	APIKeys   []AuthAPIKey `yaml:"api_keys"`
+	JWKSPath  string       `yaml:"jwks_path"` // Пусто - JWT не принимаются
+	Issuer    string       `yaml:"issuer"`
+	Audience  string       `yaml:"audience"`
+	RoleClaim string       `yaml:"role_claim" env-default:"role"`
+}
+
+type AuthAPIKey struct {
+	Name   string `yaml:"name"`
+	SHA256 string `yaml:"sha256"` // echo -n "$KEY" | sha256sum
+	Role   string `yaml:"role"`   // viewer, operator, engineer или admin
+}
+
+type Signing struct {
+	Enabled     bool          `yaml:"enabled" env:"SIGNING_ENABLED" env-default:"false"`
+	SecretsPath string        `yaml:"secrets_path" env:"SIGNING_SECRETS_PATH" env-default:"./secrets/devices.yaml"`
+	MaxSkew     time.Duration `yaml:"max_skew" env-default:"30s"`
+	NonceCache  int           `yaml:"nonce_cache" env-default:"0"` // 0 - по числу устройств
+}
+
+type Heartbeat struct {
+	DefaultInterval time.Duration         `yaml:"default_interval" env-default:"5s"`
+	TypeIntervals   map[int]time.Duration `yaml:"type_intervals"` // Тип светофора -> ожидаемый интервал опроса
+	DegradedMisses  float64               `yaml:"degraded_misses" env-default:"2"`
+	OfflineMisses   float64               `yaml:"offline_misses" env-default:"5"`
+	CheckInterval   time.Duration         `yaml:"check_interval" env-default:"1s"`
+	ForgetAfter     time.Duration         `yaml:"forget_after" env-default:"1h"` // Незарегистрированные устройства без опросов забываются
+}
+
+type Faults struct {
+	Policy []FaultRule `yaml:"policy"` // Пусто - политика по умолчанию
+}
+
+type FaultRule struct {
+	Type   int    `yaml:"type"`
+	Lamp   string `yaml:"lamp"`   // red, yellow, green или arrow
+	Action string `yaml:"action"` // report, skip_phase или flash_yellow
+}
+
+type Monitor struct {
+	Enabled    bool          `yaml:"enabled" env:"CONFLICT_MONITOR_ENABLED" env-default:"false"`
+	MatrixPath string        `yaml:"matrix_path" env-default:"./conflicts.yaml"`
+	ReportTTL  time.Duration `yaml:"report_ttl" env-default:"2s"`
+	StuckAfter time.Duration `yaml:"stuck_after" env-default:"3s"`
+	MinYellow  time.Duration `yaml:"min_yellow" env-default:"0s"`
+}
+
+type Stream struct {
+	Buffer    int           `yaml:"buffer" env-default:"64"`      // Событий в очереди подключения
+	MaxDrops  int           `yaml:"max_drops" env-default:"256"`  // Отброшенных подряд событий до отключения клиента
+	KeepAlive time.Duration `yaml:"keep_alive" env-default:"15s"` // Не больше половины http_server.timeout
+}
+
+type GRPC struct {
+	Enabled   bool          `yaml:"enabled" env:"GRPC_ENABLED" env-default:"false"`
+	Address   string        `yaml:"address" env:"GRPC_ADDRESS" env-default:":9090"`
+	MaxBatch  int           `yaml:"max_batch" env-default:"100"`  // Запросов в GetNextStates
+	KeepAlive time.Duration `yaml:"keep_alive" env-default:"30s"` // Проверка простаивающих соединений
+}
+
+type CoAP struct {
+	Enabled      bool   `yaml:"enabled" env:"COAP_ENABLED" env-default:"false"`
+	Address      string `yaml:"address" env:"COAP_ADDRESS" env-default:":5683"`
+	MaxObservers int    `yaml:"max_observers" env-default:"1000"`
+	ConfirmEvery int    `yaml:"confirm_every" env-default:"20"` // Каждое N-е уведомление Observe подтверждаемое
+}
+
+type Modbus struct {
+	Enabled         bool            `yaml:"enabled" env:"MODBUS_ENABLED" env-default:"false"`
+	Address         string          `yaml:"address" env:"MODBUS_ADDRESS" env-default:":502"`
+	Lights          []string        `yaml:"lights"`                          // Порядок блоков, обязателен
+	BlockSize       int             `yaml:"block_size" env-default:"16"`     // Регистров на светофор
+	Registers       ModbusRegisters `yaml:"registers"`                       // Смещения внутри блока
+	CoilBlockSize   int             `yaml:"coil_block_size" env-default:"8"` // Катушек на светофор
+	Coils           ModbusCoils     `yaml:"coils"`                           // Смещения внутри блока
+	Commands        bool            `yaml:"commands" env-default:"false"`    // Разрешить запись катушек
+	CommandDuration time.Duration   `yaml:"command_duration" env-default:"15m"`
+	MaxConnections  int             `yaml:"max_connections" env-default:"16"`
+	IdleTimeout     time.Duration   `yaml:"idle_timeout" env-default:"60s"`
+}
+
+type ModbusRegisters struct {
+	Phase     int `yaml:"phase" env-default:"0"`
+	Remaining int `yaml:"remaining" env-default:"1"`
+	Mode      int `yaml:"mode" env-default:"2"`
+	Lamps     int `yaml:"lamps" env-default:"3"`
+	Type      int `yaml:"type" env-default:"4"`
+}
+
+type ModbusCoils struct {
+	Hold    int `yaml:"hold" env-default:"0"`
+	Advance int `yaml:"advance" env-default:"1"`
+	Flash   int `yaml:"flash" env-default:"2"`
+}
+
+type SNMP struct {
+	Enabled         bool          `yaml:"enabled" env:"SNMP_ENABLED" env-default:"false"`
+	Address         string        `yaml:"address" env:"SNMP_ADDRESS" env-default:":161"`
+	Phases          []string      `yaml:"phases"`    // Светофоры фаз 1..N; пусто - все светофоры в порядке UUID
+	EngineID        string        `yaml:"engine_id"` // hex; пусто - из имени хоста
+	BootsPath       string        `yaml:"boots_path" env-default:"./data/snmp_boots"`
+	Users           []SNMPUser    `yaml:"users"` // Пользователи SNMPv3, роль берется из API ключа с тем же именем
+	CommandDuration time.Duration `yaml:"command_duration" env-default:"15m"`
+}
+
+type SNMPUser struct {
+	Name           string `yaml:"name"`
+	AuthProtocol   string `yaml:"auth_protocol"` // MD5, SHA, SHA224, SHA256, SHA384, SHA512
+	AuthPassphrase string `yaml:"auth_passphrase"`
+	PrivProtocol   string `yaml:"priv_protocol"` // Пусто - без шифрования; DES, AES, AES192, AES256, AES192C, AES256C
+	PrivPassphrase string `yaml:"priv_passphrase"`
+}
+
+type MQTT struct {
+	Enabled  bool   `yaml:"enabled" env:"MQTT_ENABLED" env-default:"false"`
+	Broker   string `yaml:"broker" env:"MQTT_BROKER"`                       // tcp://host:1883; пусто - встроенный брокер
+	Address  string `yaml:"address" env:"MQTT_ADDRESS" env-default:":1883"` // Встроенного брокера
+	ClientID string `yaml:"client_id" env-default:"trafficlight"`
+	Username string `yaml:"username" env:"MQTT_USERNAME"`
+	Password string `yaml:"password" env:"MQTT_PASSWORD"`
+	Prefix   string `yaml:"prefix" env-default:"lights"`
+	QoS      byte   `yaml:"qos" env-default:"1"`
+	Commands bool   `yaml:"commands" env-default:"false"` // Принимать команды ручного управления
+	// Группа общей подписки на команды во внешнем брокере; пусто - каждая реплика получает все команды
+	ShareGroup string `yaml:"share_group" env-default:"trafficlight"`
+	// Секреты клиентов, подписывающих команды во внешнем брокере, формат как у signing.secrets_path
+	SecretsPath string `yaml:"secrets_path" env:"MQTT_SECRETS_PATH"`
+}
+
 func MustLoad() *Config {
 	configPath := "./config.yaml"
 	if _, err := os.Stat(configPath); os.IsNotExist(err) {
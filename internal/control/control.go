@@ -0,0 +1,51 @@
+// Package control вычисляет ответ светофору одинаково для HTTP, gRPC и CoAP:
+// тип из реестра устройств, проверка запроса и подписавшего устройства,
+// отметка связи и метрики.
+package control
+
+import (
+	"context"
+	"time"
+	"trafficlightAPI/internal/devices"
+	"trafficlightAPI/internal/heartbeat"
+	prometheus "trafficlightAPI/internal/middleware/prometheus"
+	"trafficlightAPI/internal/middleware/signing"
+	"trafficlightAPI/internal/models"
+
+	"github.com/pkg/errors"
+)
+
+var ErrInvalidType = errors.New("некорректный номер светофора")
+
+// NextState проверяет запрос устройства и вычисляет следующее состояние.
+// requested - тип из запроса, 0 для зарегистрированных устройств; transport -
+// метка протокола в метриках. Запрос, подписанный другим устройством,
+// отклоняется с signing.ErrDevice.
+func NextState(ctx context.Context, transport string, request models.TrafficRequest, requested int) (models.TrafficResponse, error) {
+	start := time.Now()
+	trafficType, err := devices.ResolveType(request.UUID, requested)
+	if err != nil {
+		return models.TrafficResponse{}, err
+	}
+	if trafficType < 1 || trafficType > models.TypesCount() {
+		return models.TrafficResponse{}, errors.Wrapf(ErrInvalidType, "type:%d", trafficType)
+	}
+	if err := models.ValidateRequest(request, trafficType); err != nil {
+		return models.TrafficResponse{}, err
+	}
+	if err := signing.CheckContext(ctx, request.UUID); err != nil {
+		return models.TrafficResponse{}, err
+	}
+
+	if monitor := heartbeat.Default(); monitor != nil {
+		monitor.Seen(request.UUID, trafficType, start)
+	}
+	prometheus.CountTrafficRequest(transport, trafficType, request.NeedImage)
+
+	response, err := models.NextState(request, trafficType)
+	if err != nil {
+		return models.TrafficResponse{}, err
+	}
+	prometheus.ObserveTrafficRequest(trafficType, request.NeedImage, time.Since(start))
+	return response, nil
+}
@@ -0,0 +1,312 @@
+package corridor
+
+import (
+	"image/color"
+	"io"
+	"math"
+	"os"
+	"trafficlightAPI/internal/image_generator"
+	"trafficlightAPI/internal/models"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+)
+
+var (
+	ErrInvalidCorridor = errors.New("некорректное описание магистрали")
+)
+
+const resolution = 0.1 // Шаг по времени при расчете ленты, с
+
+type Intersection struct {
+	Light        models.LightDefinition `yaml:"light"`
+	Position     float64                `yaml:"position"`                // м от начала магистрали
+	Speed        float64                `yaml:"speed"`                   // км/ч до следующего перекрестка в прямом направлении
+	InboundSpeed float64                `yaml:"inbound_speed,omitempty"` // км/ч от следующего перекрестка, по умолчанию Speed
+}
+
+type Corridor struct {
+	InboundWeight float64        `yaml:"inbound_weight"` // Вес обратного направления в целевой функции
+	Intersections []Intersection `yaml:"intersections"`
+}
+
+type Band struct {
+	Width float64 `yaml:"width" json:"width"` // с
+	Start float64 `yaml:"start" json:"start"` // Момент проезда первого перекрестка направления
+}
+
+type Result struct {
+	Cycle    int                `yaml:"cycle" json:"cycle"`
+	Outbound Band               `yaml:"outbound" json:"outbound"`
+	Inbound  Band               `yaml:"inbound" json:"inbound"`
+	Plan     models.Definitions `yaml:"plan" json:"plan"`
+}
+
+// window - зеленый для движения по магистрали в пределах цикла плана.
+type window struct {
+	start, length float64
+}
+
+type link struct {
+	outbound, inbound float64 // Время проезда до перекрестка от начала/конца магистрали, с
+}
+
+func LoadCorridor(path string) (Corridor, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Corridor{}, errors.Wrap(ErrInvalidCorridor, err.Error())
+	}
+
+	corridor := Corridor{InboundWeight: 1}
+	if err := yaml.Unmarshal(data, &corridor); err != nil {
+		return Corridor{}, errors.Wrap(ErrInvalidCorridor, err.Error())
+	}
+	return corridor, nil
+}
+
+func greenWindow(def models.LightDefinition) (window, error) {
+	start := 0.0
+	for i, d := range def.Durations {
+		lamps, err := models.StateLamps(def.Type, i+1)
+		if err != nil {
+			return window{}, err
+		}
+		if !lamps.Green {
+			start += float64(d)
+			continue
+		}
+
+		w := window{start: start}
+		for j := i; j < len(def.Durations); j++ {
+			if lamps, _ := models.StateLamps(def.Type, j+1); !lamps.Green {
+				break
+			}
+			w.length += float64(def.Durations[j])
+		}
+		return w, nil
+	}
+	return window{}, errors.Wrapf(ErrInvalidCorridor, "светофор %q не имеет зеленого состояния", def.Name)
+}
+
+func cycleOf(def models.LightDefinition) int {
+	cycle := 0
+	for _, d := range def.Durations {
+		cycle += d
+	}
+	return cycle
+}
+
+func (c Corridor) prepare() (int, []window, []link, error) {
+	if len(c.Intersections) < 2 {
+		return 0, nil, nil, errors.Wrap(ErrInvalidCorridor, "нужно не менее двух перекрестков")
+	}
+	if c.InboundWeight < 0 {
+		return 0, nil, nil, errors.Wrapf(ErrInvalidCorridor, "отрицательный вес %.2f", c.InboundWeight)
+	}
+
+	cycle := cycleOf(c.Intersections[0].Light)
+	windows := make([]window, len(c.Intersections))
+	links := make([]link, len(c.Intersections))
+	inbound := make([]float64, len(c.Intersections)) // Время проезда участка от i до i-1
+	for i, in := range c.Intersections {
+		if _, err := models.NewLight(in.Light); err != nil {
+			return 0, nil, nil, errors.Wrapf(ErrInvalidCorridor, "светофор %q: %v", in.Light.Name, err)
+		}
+		if cycleOf(in.Light) != cycle {
+			return 0, nil, nil, errors.Wrapf(ErrInvalidCorridor, "светофор %q: цикл %d отличается от общего %d", in.Light.Name, cycleOf(in.Light), cycle)
+		}
+		w, err := greenWindow(in.Light)
+		if err != nil {
+			return 0, nil, nil, err
+		}
+		windows[i] = w
+
+		if i == 0 {
+			continue
+		}
+		prev := c.Intersections[i-1]
+		distance := in.Position - prev.Position
+		inboundSpeed := prev.InboundSpeed
+		if inboundSpeed == 0 {
+			inboundSpeed = prev.Speed
+		}
+		if distance <= 0 || prev.Speed <= 0 || inboundSpeed <= 0 {
+			return 0, nil, nil, errors.Wrapf(ErrInvalidCorridor, "участок %q - %q: расстояние %.0f, скорости %.0f/%.0f",
+				prev.Light.Name, in.Light.Name, distance, prev.Speed, inboundSpeed)
+		}
+		links[i].outbound = links[i-1].outbound + distance/(prev.Speed/3.6)
+		inbound[i] = distance / (inboundSpeed / 3.6)
+	}
+
+	for i := len(links) - 2; i >= 0; i-- {
+		links[i].inbound = links[i+1].inbound + inbound[i+1]
+	}
+	return cycle, windows, links, nil
+}
+
+func isGreen(w window, offset int, cycle int, t float64) bool {
+	c := float64(cycle)
+	p := math.Mod(t+float64(offset)-w.start, c)
+	if p < 0 {
+		p += c
+	}
+	return p < w.length
+}
+
+// bandwidth возвращает самую длинную непрерывную ленту: интервал моментов
+// проезда первого перекрестка направления, при которых все перекрестки
+// проезжаются на зеленый.
+func bandwidth(cycle int, windows []window, offsets []int, travel func(i int) float64) Band {
+	steps := int(float64(cycle) / resolution)
+	ok := make([]bool, steps)
+	for s := range ok {
+		t := float64(s) * resolution
+		ok[s] = true
+		for i, w := range windows {
+			if !isGreen(w, offsets[i], cycle, t+travel(i)) {
+				ok[s] = false
+				break
+			}
+		}
+	}
+
+	best, bestStart, run := 0, 0, 0
+	for s := range 2 * steps {
+		if !ok[s%steps] {
+			run = 0
+			continue
+		}
+		run++
+		if run > best && run <= steps {
+			best, bestStart = run, s-run+1
+		}
+	}
+	return Band{Width: float64(best) * resolution, Start: float64(bestStart%steps) * resolution}
+}
+
+func bands(cycle int, windows []window, links []link, offsets []int) (Band, Band) {
+	outbound := bandwidth(cycle, windows, offsets, func(i int) float64 { return links[i].outbound })
+	inbound := bandwidth(cycle, windows, offsets, func(i int) float64 { return links[i].inbound })
+	return outbound, inbound
+}
+
+// Optimize подбирает смещения, максимизирующие сумму ширины ленты прямого
+// направления и взвешенной ленты обратного. Вместо смешанного целочисленного
+// программирования MAXBAND используется покоординатный перебор смещений с
+// шагом 1с из нескольких стартовых точек: одновременной работы и идеальной
+// координации в каждом направлении.
+func Optimize(c Corridor) (Result, error) {
+	cycle, windows, links, err := c.prepare()
+	if err != nil {
+		return Result{}, err
+	}
+
+	score := func(offsets []int) float64 {
+		outbound, inbound := bands(cycle, windows, links, offsets)
+		return outbound.Width + c.InboundWeight*inbound.Width
+	}
+
+	n := len(c.Intersections)
+	starts := [][]int{make([]int, n), make([]int, n), make([]int, n)}
+	for i := range n {
+		// Зеленый на перекрестке i начинается, когда к нему подъезжает начало ленты.
+		starts[1][i] = int(math.Round(windows[i].start - links[i].outbound))
+		starts[2][i] = int(math.Round(windows[i].start - links[i].inbound))
+	}
+
+	var best []int
+	bestScore := -1.0
+	for _, offsets := range starts {
+		for i := n - 1; i >= 0; i-- {
+			offsets[i] = mod(offsets[i]-offsets[0], cycle)
+		}
+		current := score(offsets)
+		for improved := true; improved; {
+			improved = false
+			for i := 1; i < n; i++ {
+				original := offsets[i]
+				for candidate := range cycle {
+					offsets[i] = candidate
+					if s := score(offsets); s > current+1e-9 {
+						current, original, improved = s, candidate, true
+					}
+				}
+				offsets[i] = original
+			}
+		}
+		if current > bestScore {
+			best, bestScore = append([]int(nil), offsets...), current
+		}
+	}
+
+	result := Result{Cycle: cycle}
+	result.Outbound, result.Inbound = bands(cycle, windows, links, best)
+	for i, in := range c.Intersections {
+		def := in.Light
+		def.Durations = append([]int(nil), in.Light.Durations...)
+		def.Offset = best[i]
+		result.Plan.Lights = append(result.Plan.Lights, def)
+	}
+	return result, nil
+}
+
+// WritePlan сохраняет план магистрали в формате plans.yaml. План проверяется
+// так же, как при загрузке сервером: смещение действует только для светофора
+// с uuid, поэтому перекрестки без uuid отклоняются.
+func WritePlan(w io.Writer, result Result) error {
+	if err := models.ValidateDefinitions(result.Plan); err != nil {
+		return errors.Wrap(ErrInvalidCorridor, err.Error())
+	}
+
+	encoder := yaml.NewEncoder(w)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(result.Plan); err != nil {
+		return err
+	}
+	return encoder.Close()
+}
+
+func mod(a, b int) int {
+	return (a%b + b) % b
+}
+
+// Diagram рисует пространственно-временную диаграмму плана с лентами обоих направлений.
+func Diagram(c Corridor, result Result, cycles int) ([]byte, error) {
+	_, _, links, err := c.prepare()
+	if err != nil {
+		return nil, err
+	}
+
+	lights := make([]image_generator.TimeSpaceLight, len(c.Intersections))
+	outbound := make([]float64, len(links))
+	inbound := make([]float64, len(links))
+	for i, in := range c.Intersections {
+		lights[i] = image_generator.TimeSpaceLight{Position: in.Position, Offset: result.Plan.Lights[i].Offset}
+		for s, d := range in.Light.Durations {
+			lamps, err := models.StateLamps(in.Light.Type, s+1)
+			if err != nil {
+				return nil, err
+			}
+			lights[i].Phases = append(lights[i].Phases, image_generator.TimeSpacePhase{Duration: d, Color: phaseColor(lamps)})
+		}
+		outbound[i], inbound[i] = links[i].outbound, links[i].inbound
+	}
+
+	return image_generator.TimeSpaceDiagram(lights, []image_generator.TimeSpaceBand{
+		{Start: result.Outbound.Start, Width: result.Outbound.Width, Travel: outbound, Color: color.RGBA{0, 0, 255, 255}},
+		{Start: result.Inbound.Start, Width: result.Inbound.Width, Travel: inbound, Color: color.RGBA{200, 0, 200, 255}},
+	}, result.Cycle, cycles)
+}
+
+func phaseColor(lamps models.Lamps) color.RGBA {
+	switch {
+	case lamps.Green:
+		return color.RGBA{0, 200, 0, 255}
+	case lamps.Red && lamps.Yellow:
+		return color.RGBA{255, 140, 0, 255}
+	case lamps.Yellow:
+		return color.RGBA{255, 220, 0, 255}
+	default:
+		return color.RGBA{220, 0, 0, 255}
+	}
+}
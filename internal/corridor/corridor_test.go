@@ -0,0 +1,164 @@
+package corridor_test
+
+import (
+	"bytes"
+	"image/png"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"trafficlightAPI/internal/corridor"
+	"trafficlightAPI/internal/models"
+
+	"github.com/pkg/errors"
+)
+
+func regular(name string, red, green int) models.LightDefinition {
+	return models.LightDefinition{Name: name, Type: 1, Durations: []int{red, 3, green}}
+}
+
+func TestOptimizeOneWayProgression(t *testing.T) {
+	// 500м при 36 км/ч - 50с, при цикле 60с идеальная координация в одну
+	// сторону дает ленту шириной во весь зеленый.
+	c := corridor.Corridor{
+		InboundWeight: 0,
+		Intersections: []corridor.Intersection{
+			{Light: regular("a", 27, 30), Position: 0, Speed: 36},
+			{Light: regular("b", 27, 30), Position: 500, Speed: 36},
+			{Light: regular("c", 27, 30), Position: 1000, Speed: 36},
+		},
+	}
+
+	result, err := corridor.Optimize(c)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if result.Cycle != 60 {
+		t.Errorf("got cycle %d, want 60", result.Cycle)
+	}
+	if result.Outbound.Width < 29.9 {
+		t.Errorf("got outbound band %.1f, want 30", result.Outbound.Width)
+	}
+
+	want := []int{0, 10, 20}
+	for i, light := range result.Plan.Lights {
+		if light.Offset != want[i] {
+			t.Errorf("light %s offset %d, want %d", light.Name, light.Offset, want[i])
+		}
+		if _, err := models.NewLight(light); err != nil {
+			t.Errorf("plan is not a valid definition: %v", err)
+		}
+	}
+
+	diagram, err := corridor.Diagram(c, result, 2)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := png.Decode(bytes.NewReader(diagram)); err != nil {
+		t.Errorf("diagram is not a PNG: %v", err)
+	}
+}
+
+func TestOptimizeTwoWay(t *testing.T) {
+	// Время проезда участка - половина цикла: возможна двусторонняя лента.
+	c := corridor.Corridor{
+		InboundWeight: 1,
+		Intersections: []corridor.Intersection{
+			{Light: regular("a", 27, 30), Position: 0, Speed: 36},
+			{Light: regular("b", 27, 30), Position: 300, Speed: 36},
+			{Light: regular("c", 27, 30), Position: 600, Speed: 36},
+		},
+	}
+
+	result, err := corridor.Optimize(c)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if result.Outbound.Width < 29.9 || result.Inbound.Width < 29.9 {
+		t.Errorf("got bands %.1f/%.1f, want 30/30", result.Outbound.Width, result.Inbound.Width)
+	}
+}
+
+func TestOptimizeInvalid(t *testing.T) {
+	tests := []struct {
+		name string
+		c    corridor.Corridor
+	}{
+		{name: "single intersection", c: corridor.Corridor{Intersections: []corridor.Intersection{{Light: regular("a", 27, 30), Speed: 36}}}},
+		{
+			name: "different cycles",
+			c: corridor.Corridor{Intersections: []corridor.Intersection{
+				{Light: regular("a", 27, 30), Position: 0, Speed: 36},
+				{Light: regular("b", 30, 30), Position: 300, Speed: 36},
+			}},
+		},
+		{
+			name: "unordered positions",
+			c: corridor.Corridor{Intersections: []corridor.Intersection{
+				{Light: regular("a", 27, 30), Position: 300, Speed: 36},
+				{Light: regular("b", 27, 30), Position: 0, Speed: 36},
+			}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := corridor.Optimize(tt.c); !errors.Is(err, corridor.ErrInvalidCorridor) {
+				t.Errorf("got error '%v', want '%v'", err, corridor.ErrInvalidCorridor)
+			}
+		})
+	}
+}
+
+// План магистрали должен загружаться сервером так же, как plans_path.
+func TestWritePlanLoadsOnServer(t *testing.T) {
+	c, err := corridor.LoadCorridor("../../examples/corridor.yaml")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	result, err := corridor.Optimize(c)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	path := filepath.Join(t.TempDir(), "plans.yaml")
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := corridor.WritePlan(file, result); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	file.Close()
+
+	defs, err := models.LoadDefinitions(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := models.ApplyDefinitions(defs); err != nil {
+		t.Fatalf("server rejected corridor plan: %v", err)
+	}
+	for _, light := range result.Plan.Lights {
+		plan, offset, ok := models.Coordinated(light.UUID)
+		if !ok || offset != light.Offset || !reflect.DeepEqual(plan, light.Durations) {
+			t.Errorf("%s: got plan %v offset %d (ok %v), want %v offset %d", light.Name, plan, offset, ok, light.Durations, light.Offset)
+		}
+	}
+}
+
+func TestWritePlanWithoutUUID(t *testing.T) {
+	c := corridor.Corridor{
+		Intersections: []corridor.Intersection{
+			{Light: regular("a", 27, 30), Position: 0, Speed: 36},
+			{Light: regular("b", 27, 30), Position: 500, Speed: 36},
+		},
+	}
+	result, err := corridor.Optimize(c)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := corridor.WritePlan(io.Discard, result); !errors.Is(err, corridor.ErrInvalidCorridor) {
+		t.Errorf("got error '%v', want '%v'", err, corridor.ErrInvalidCorridor)
+	}
+}
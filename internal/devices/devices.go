@@ -0,0 +1,229 @@
+package devices
+
+import (
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"trafficlightAPI/internal/geo"
+	"trafficlightAPI/internal/models"
+	"trafficlightAPI/internal/storage"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	ErrInvalidDevice  = errors.New("некорректное устройство")
+	ErrDeviceNotFound = errors.New("устройство не зарегистрировано")
+	ErrDeviceExists   = errors.New("устройство уже зарегистрировано")
+	ErrTypeRequired   = errors.New("не указан тип незарегистрированного светофора")
+	ErrTypeMismatch   = errors.New("тип не совпадает с зарегистрированным для устройства")
+)
+
+// Filter отбирает устройства для List, пустые поля не проверяются.
+type Filter struct {
+	Type         int
+	Intersection string
+	Tag          string
+}
+
+func (f Filter) match(d storage.Device) bool {
+	return (f.Type == 0 || d.Type == f.Type) &&
+		(f.Intersection == "" || d.Intersection == f.Intersection) &&
+		(f.Tag == "" || slices.Contains(d.Tags, f.Tag))
+}
+
+// Registry хранит зарегистрированные устройства в памяти и в storage.Store.
+// Координаты устройств дублируются в пространственном индексе.
+type Registry struct {
+	store storage.Store
+	index *geo.Index
+
+	mu     sync.RWMutex
+	byUUID map[string]storage.Device
+}
+
+func NewRegistry(store storage.Store) *Registry {
+	return &Registry{store: store, index: geo.NewIndex(), byUUID: make(map[string]storage.Device)}
+}
+
+// Geo возвращает пространственный индекс устройств с координатами.
+func (r *Registry) Geo() *geo.Index {
+	return r.index
+}
+
+// Restore загружает устройства из хранилища from, обычно локального.
+func (r *Registry) Restore(from storage.Store) error {
+	devices, err := from.Devices()
+	if err != nil {
+		return err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, d := range devices {
+		r.byUUID[d.UUID] = d
+		r.index.Put(d.UUID, d.Location)
+	}
+	return nil
+}
+
+func normalize(d storage.Device) (storage.Device, error) {
+	d.UUID = strings.TrimSpace(d.UUID)
+	d.Intersection = strings.TrimSpace(d.Intersection)
+	if d.UUID == "" {
+		return d, errors.Wrap(ErrInvalidDevice, "отсутствует uuid")
+	}
+	if d.Type < 1 || d.Type > models.TypesCount() {
+		return d, errors.Wrapf(ErrInvalidDevice, "неизвестный тип светофора %d", d.Type)
+	}
+	if l := d.Location; l != nil && (l.Lat < -90 || l.Lat > 90 || l.Lon < -180 || l.Lon > 180) {
+		return d, errors.Wrapf(ErrInvalidDevice, "координаты %f, %f", l.Lat, l.Lon)
+	}
+	if d.HeartbeatInterval < 0 {
+		return d, errors.Wrapf(ErrInvalidDevice, "интервал опроса %d", d.HeartbeatInterval)
+	}
+
+	tags := make([]string, 0, len(d.Tags))
+	for _, tag := range d.Tags {
+		if tag = strings.TrimSpace(tag); tag != "" && !slices.Contains(tags, tag) {
+			tags = append(tags, tag)
+		}
+	}
+	d.Tags = tags
+	if len(d.Tags) == 0 {
+		d.Tags = nil
+	}
+	return d, nil
+}
+
+// Create регистрирует новое устройство.
+func (r *Registry) Create(d storage.Device) (storage.Device, error) {
+	d, err := normalize(d)
+	if err != nil {
+		return storage.Device{}, err
+	}
+	if _, ok := r.Get(d.UUID); ok {
+		return storage.Device{}, errors.Wrapf(ErrDeviceExists, "uuid:%s", d.UUID)
+	}
+	d.CreatedAt = time.Now().UTC()
+	d.UpdatedAt = d.CreatedAt
+	return d, r.put(d)
+}
+
+// Update заменяет данные зарегистрированного устройства.
+func (r *Registry) Update(d storage.Device) (storage.Device, error) {
+	d, err := normalize(d)
+	if err != nil {
+		return storage.Device{}, err
+	}
+	current, ok := r.Get(d.UUID)
+	if !ok {
+		return storage.Device{}, errors.Wrapf(ErrDeviceNotFound, "uuid:%s", d.UUID)
+	}
+	d.CreatedAt = current.CreatedAt
+	d.UpdatedAt = time.Now().UTC()
+	return d, r.put(d)
+}
+
+func (r *Registry) put(d storage.Device) error {
+	if err := r.store.PutDevice(d); err != nil {
+		return err
+	}
+	r.mu.Lock()
+	r.byUUID[d.UUID] = d
+	r.index.Put(d.UUID, d.Location)
+	r.mu.Unlock()
+	return nil
+}
+
+func (r *Registry) Delete(uuid string) error {
+	if err := r.store.DeleteDevice(uuid); err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			return errors.Wrapf(ErrDeviceNotFound, "uuid:%s", uuid)
+		}
+		return err
+	}
+	r.mu.Lock()
+	delete(r.byUUID, uuid)
+	r.index.Put(uuid, nil)
+	r.mu.Unlock()
+	return nil
+}
+
+func (r *Registry) Get(uuid string) (storage.Device, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	d, ok := r.byUUID[uuid]
+	return d, ok
+}
+
+// List возвращает устройства, подходящие под фильтр, в порядке UUID.
+func (r *Registry) List(f Filter) []storage.Device {
+	r.mu.RLock()
+	devices := make([]storage.Device, 0, len(r.byUUID))
+	for _, d := range r.byUUID {
+		if f.match(d) {
+			devices = append(devices, d)
+		}
+	}
+	r.mu.RUnlock()
+
+	sort.Slice(devices, func(i, j int) bool { return devices[i].UUID < devices[j].UUID })
+	return devices
+}
+
+// ApplyMutation обновляет память по изменению, примененному другим узлом кластера.
+func (r *Registry) ApplyMutation(m storage.Mutation) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	switch {
+	case m.Op == storage.OpPutDevice && m.Device != nil:
+		r.byUUID[m.Device.UUID] = *m.Device
+		r.index.Put(m.Device.UUID, m.Device.Location)
+	case m.Op == storage.OpDeleteDevice:
+		delete(r.byUUID, m.Key)
+		r.index.Put(m.Key, nil)
+	}
+}
+
+var (
+	defaultMu       sync.RWMutex
+	defaultRegistry *Registry
+)
+
+func SetDefault(r *Registry) {
+	defaultMu.Lock()
+	defer defaultMu.Unlock()
+	defaultRegistry = r
+}
+
+func Default() *Registry {
+	defaultMu.RLock()
+	defer defaultMu.RUnlock()
+	return defaultRegistry
+}
+
+// Lookup возвращает устройство из реестра по умолчанию, если он настроен.
+func Lookup(uuid string) (storage.Device, bool) {
+	r := Default()
+	if r == nil {
+		return storage.Device{}, false
+	}
+	return r.Get(uuid)
+}
+
+// ResolveType возвращает тип светофора для запроса устройства: у зарегистрированных
+// устройств тип берется из реестра и requested может быть 0, у остальных он обязателен.
+func ResolveType(uuid string, requested int) (int, error) {
+	device, registered := Lookup(uuid)
+	switch {
+	case !registered && requested == 0:
+		return 0, errors.Wrapf(ErrTypeRequired, "uuid:%s", uuid)
+	case !registered:
+		return requested, nil
+	case requested != 0 && requested != device.Type:
+		return 0, errors.Wrapf(ErrTypeMismatch, "uuid:%s, type:%d, зарегистрирован тип %d", uuid, requested, device.Type)
+	}
+	return device.Type, nil
+}
@@ -0,0 +1,107 @@
+package devices_test
+
+import (
+	"path/filepath"
+	"testing"
+	"trafficlightAPI/internal/devices"
+	"trafficlightAPI/internal/storage"
+
+	"github.com/pkg/errors"
+)
+
+func newRegistry(t *testing.T) (*devices.Registry, storage.Store) {
+	t.Helper()
+	store, err := storage.OpenBolt(filepath.Join(t.TempDir(), "state.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { store.Close() })
+	return devices.NewRegistry(store), store
+}
+
+func TestCreateValidation(t *testing.T) {
+	registry, _ := newRegistry(t)
+	tests := []struct {
+		name   string
+		device storage.Device
+		want   error
+	}{
+		{name: "valid", device: storage.Device{UUID: "a", Type: 1, Tags: []string{" центр ", "центр", ""}}},
+		{name: "duplicate", device: storage.Device{UUID: "a", Type: 1}, want: devices.ErrDeviceExists},
+		{name: "no uuid", device: storage.Device{Type: 1}, want: devices.ErrInvalidDevice},
+		{name: "unknown type", device: storage.Device{UUID: "b", Type: 4}, want: devices.ErrInvalidDevice},
+		{name: "latitude", device: storage.Device{UUID: "b", Type: 1, Location: &storage.Location{Lat: 91}}, want: devices.ErrInvalidDevice},
+		{name: "longitude", device: storage.Device{UUID: "b", Type: 1, Location: &storage.Location{Lon: -181}}, want: devices.ErrInvalidDevice},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			device, err := registry.Create(tt.device)
+			if tt.want != nil {
+				if !errors.Is(err, tt.want) {
+					t.Errorf("err = %v, want %v", err, tt.want)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(device.Tags) != 1 || device.Tags[0] != "центр" || device.CreatedAt.IsZero() {
+				t.Errorf("device = %+v", device)
+			}
+		})
+	}
+}
+
+func TestLifecycle(t *testing.T) {
+	registry, store := newRegistry(t)
+	for _, d := range []storage.Device{
+		{UUID: "b", Type: 2, Intersection: "Ленина-Мира", Tags: []string{"центр"}},
+		{UUID: "a", Type: 1, Intersection: "Ленина-Мира"},
+		{UUID: "c", Type: 3, Tags: []string{"центр"}},
+	} {
+		if _, err := registry.Create(d); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	uuids := func(list []storage.Device) (out []string) {
+		for _, d := range list {
+			out = append(out, d.UUID)
+		}
+		return out
+	}
+	if got := uuids(registry.List(devices.Filter{Intersection: "Ленина-Мира"})); len(got) != 2 || got[0] != "a" || got[1] != "b" {
+		t.Errorf("intersection = %v, want [a b]", got)
+	}
+	if got := uuids(registry.List(devices.Filter{Tag: "центр", Type: 3})); len(got) != 1 || got[0] != "c" {
+		t.Errorf("tag and type = %v, want [c]", got)
+	}
+
+	created, _ := registry.Get("a")
+	updated, err := registry.Update(storage.Device{UUID: "a", Type: 2, Description: "перенесен"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if updated.Type != 2 || !updated.CreatedAt.Equal(created.CreatedAt) || updated.UpdatedAt.Before(created.UpdatedAt) {
+		t.Errorf("updated = %+v, created = %+v", updated, created)
+	}
+	if _, err := registry.Update(storage.Device{UUID: "zzz", Type: 1}); !errors.Is(err, devices.ErrDeviceNotFound) {
+		t.Errorf("update missing: err = %v", err)
+	}
+
+	if err := registry.Delete("a"); err != nil {
+		t.Fatal(err)
+	}
+	if err := registry.Delete("a"); !errors.Is(err, devices.ErrDeviceNotFound) {
+		t.Errorf("second delete: err = %v", err)
+	}
+
+	// Реестр восстанавливается из хранилища.
+	restored := devices.NewRegistry(store)
+	if err := restored.Restore(store); err != nil {
+		t.Fatal(err)
+	}
+	if got := uuids(restored.List(devices.Filter{})); len(got) != 2 || got[0] != "b" || got[1] != "c" {
+		t.Errorf("restored = %v, want [b c]", got)
+	}
+}
@@ -0,0 +1,359 @@
+package faults
+
+import (
+	"log/slog"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"trafficlightAPI/internal/audit"
+	prometheus "trafficlightAPI/internal/middleware/prometheus"
+	"trafficlightAPI/internal/models"
+	"trafficlightAPI/internal/storage"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	ErrInvalidFault  = errors.New("некорректная неисправность лампы")
+	ErrFaultNotFound = errors.New("неисправность лампы не найдена")
+	ErrInvalidPolicy = errors.New("некорректная политика неисправностей")
+)
+
+// Реакция на неисправную лампу.
+const (
+	ActionReport      = "report"       // Только сообщать в ответах
+	ActionSkipPhase   = "skip_phase"   // Пропускать состояния, в которых горит лампа
+	ActionFlashYellow = "flash_yellow" // Мигающий желтый вместо работы по плану
+)
+
+// Приоритет реакций, если неисправно несколько ламп.
+var actionRank = map[string]int{ActionReport: 0, ActionSkipPhase: 1, ActionFlashYellow: 2}
+
+type Rule struct {
+	Type   int
+	Lamp   string
+	Action string
+}
+
+// Policy задает реакцию на неисправность лампы для типа светофора.
+// Для ламп без правила действует ActionReport.
+type Policy []Rule
+
+func DefaultPolicy() Policy {
+	return Policy{
+		{Type: 1, Lamp: models.LampRed, Action: ActionFlashYellow},
+		{Type: 2, Lamp: models.LampRed, Action: ActionFlashYellow},
+		{Type: 2, Lamp: models.LampArrow, Action: ActionSkipPhase},
+	}
+}
+
+func (p Policy) Validate() error {
+	seen := make(map[string]bool, len(p))
+	for _, r := range p {
+		if !models.HasLamp(r.Type, r.Lamp) {
+			return errors.Wrapf(ErrInvalidPolicy, "у типа %d нет лампы %q", r.Type, r.Lamp)
+		}
+		if _, ok := actionRank[r.Action]; !ok {
+			return errors.Wrapf(ErrInvalidPolicy, "неизвестное действие %q", r.Action)
+		}
+		if r.Action == ActionFlashYellow && models.FlashState(r.Type) == 0 {
+			return errors.Wrapf(ErrInvalidPolicy, "у типа %d нет состояния с одним желтым", r.Type)
+		}
+		key := storage.FaultKey(strconv.Itoa(r.Type), r.Lamp)
+		if seen[key] {
+			return errors.Wrapf(ErrInvalidPolicy, "повторное правило для типа %d, лампы %s", r.Type, r.Lamp)
+		}
+		seen[key] = true
+	}
+	return nil
+}
+
+func (p Policy) action(trafficType int, lamp string) string {
+	for _, r := range p {
+		if r.Type == trafficType && r.Lamp == lamp {
+			return r.Action
+		}
+	}
+	return ActionReport
+}
+
+// Manager хранит неисправности ламп и применяет к ответам /trafficlight политику.
+type Manager struct {
+	store  storage.Store
+	policy Policy
+	audit  *audit.Log
+	logger *slog.Logger
+
+	mu     sync.Mutex
+	byUUID map[string]map[string]storage.Fault // uuid -> лампа -> неисправность
+}
+
+func NewManager(store storage.Store, policy Policy, auditLog *audit.Log, logger *slog.Logger) *Manager {
+	return &Manager{
+		store:  store,
+		policy: policy,
+		audit:  auditLog,
+		logger: logger,
+		byUUID: make(map[string]map[string]storage.Fault),
+	}
+}
+
+// Restore загружает неисправности из хранилища from, обычно локального.
+func (m *Manager) Restore(from storage.Store) error {
+	faults, err := from.Faults()
+	if err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, f := range faults {
+		m.put(f)
+	}
+	m.updateMetrics()
+	return nil
+}
+
+func (m *Manager) put(f storage.Fault) {
+	lamps, ok := m.byUUID[f.UUID]
+	if !ok {
+		lamps = make(map[string]storage.Fault)
+		m.byUUID[f.UUID] = lamps
+	}
+	lamps[f.Lamp] = f
+}
+
+func (m *Manager) delete(key string) {
+	uuid, lamp, _ := strings.Cut(key, "/")
+	delete(m.byUUID[uuid], lamp)
+	if len(m.byUUID[uuid]) == 0 {
+		delete(m.byUUID, uuid)
+	}
+}
+
+// updateMetrics пересчитывает lamp_faults, вызывается под m.mu.
+func (m *Manager) updateMetrics() {
+	prometheus.LampFaults.Reset()
+	for _, lamps := range m.byUUID {
+		for lamp, f := range lamps {
+			prometheus.LampFaults.WithLabelValues(strconv.Itoa(f.Type), lamp).Inc()
+		}
+	}
+}
+
+func (m *Manager) record(e audit.Entry) {
+	if m.audit == nil {
+		return
+	}
+	if err := m.audit.Write(e); err != nil {
+		m.logger.Error("ошибка записи аудита", slog.String("action", e.Action), slog.Any("err", err))
+	}
+}
+
+// Report сохраняет неисправность лампы. Повторное сообщение заменяет предыдущее.
+func (m *Manager) Report(f storage.Fault) (storage.Fault, error) {
+	f.UUID = strings.TrimSpace(f.UUID)
+	f.Lamp = strings.TrimSpace(f.Lamp)
+	if f.UUID == "" {
+		return storage.Fault{}, errors.Wrap(ErrInvalidFault, "отсутствует uuid")
+	}
+	if !models.HasLamp(f.Type, f.Lamp) {
+		return storage.Fault{}, errors.Wrapf(ErrInvalidFault, "у типа %d нет лампы %q", f.Type, f.Lamp)
+	}
+	if f.ReportedAt.IsZero() {
+		f.ReportedAt = time.Now().UTC()
+	}
+
+	if err := m.store.PutFault(f); err != nil {
+		return storage.Fault{}, err
+	}
+	m.mu.Lock()
+	m.put(f)
+	m.updateMetrics()
+	m.mu.Unlock()
+
+	m.record(audit.Entry{
+		Time: f.ReportedAt, Action: audit.ActionLampFault, UUID: f.UUID, User: f.ReportedBy, Reason: f.Reason,
+		Details: map[string]any{"lamp": f.Lamp, "type": f.Type, "policy": m.policy.action(f.Type, f.Lamp)},
+	})
+	return f, nil
+}
+
+// Clear снимает неисправность после ремонта лампы.
+func (m *Manager) Clear(uuid, lamp, user string) error {
+	key := storage.FaultKey(uuid, lamp)
+	if err := m.store.DeleteFault(key); err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			return errors.Wrapf(ErrFaultNotFound, "uuid:%s, лампа:%s", uuid, lamp)
+		}
+		return err
+	}
+	m.mu.Lock()
+	m.delete(key)
+	m.updateMetrics()
+	m.mu.Unlock()
+
+	m.record(audit.Entry{
+		Time: time.Now().UTC(), Action: audit.ActionLampRepair, UUID: uuid, User: user,
+		Details: map[string]any{"lamp": lamp},
+	})
+	return nil
+}
+
+// Has сообщает, известна ли неисправность лампы lamp светофора uuid.
+func (m *Manager) Has(uuid, lamp string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	_, ok := m.byUUID[uuid][lamp]
+	return ok
+}
+
+// List возвращает неисправности в порядке uuid и лампы. Пустой uuid - все.
+func (m *Manager) List(uuid string) []storage.Fault {
+	m.mu.Lock()
+	var faults []storage.Fault
+	for u, lamps := range m.byUUID {
+		if uuid != "" && u != uuid {
+			continue
+		}
+		for _, f := range lamps {
+			faults = append(faults, f)
+		}
+	}
+	m.mu.Unlock()
+
+	sort.Slice(faults, func(i, j int) bool { return faults[i].Key() < faults[j].Key() })
+	if faults == nil {
+		faults = []storage.Fault{}
+	}
+	return faults
+}
+
+// Pinned сообщает, что политика держит светофор в мигающем желтом.
+func (m *Manager) Pinned(data models.TrafficRequest, trafficType int) bool {
+	if state := models.FlashState(trafficType); state == 0 || state != data.CurrentState {
+		return false
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for lamp := range m.byUUID[data.UUID] {
+		if m.policy.action(trafficType, lamp) == ActionFlashYellow {
+			return true
+		}
+	}
+	return false
+}
+
+// Resolve добавляет к ответу /trafficlight неисправные лампы и применяет политику.
+// Возвращает true, если работа светофора изменена.
+func (m *Manager) Resolve(data models.TrafficRequest, trafficType int, response *models.TrafficResponse) bool {
+	m.mu.Lock()
+	failed := make([]string, 0, len(m.byUUID[data.UUID]))
+	for lamp := range m.byUUID[data.UUID] {
+		failed = append(failed, lamp)
+	}
+	m.mu.Unlock()
+	if len(failed) == 0 {
+		return false
+	}
+	sort.Strings(failed)
+	response.Faults = failed
+
+	action := ActionReport
+	var skip []string
+	for _, lamp := range failed {
+		a := m.policy.action(trafficType, lamp)
+		if a == ActionSkipPhase {
+			skip = append(skip, lamp)
+		}
+		if actionRank[a] > actionRank[action] {
+			action = a
+		}
+	}
+
+	imageState := data.CurrentState
+	state, err := strconv.Atoi(response.NextState)
+	switch {
+	case err != nil:
+		action = ActionReport
+	case action == ActionFlashYellow:
+		state = models.FlashState(trafficType)
+		imageState = state
+		response.Flashing = true
+	case action == ActionSkipPhase:
+		state = skipLit(trafficType, state, skip)
+	}
+	if action != ActionReport && strconv.Itoa(state) != response.NextState {
+		response.NextState = strconv.Itoa(state)
+		response.NextCountdownTime = ""
+	}
+
+	if data.NeedImage {
+		image, err := models.StateImage(trafficType, imageState, failed)
+		if err != nil {
+			m.logger.Error("ошибка создания изображения с неисправностями", slog.String("uuid", data.UUID), slog.Any("err", err))
+		} else if image != "" {
+			response.Image = image
+		}
+	}
+
+	if action == ActionReport {
+		return false
+	}
+	response.Degraded = action
+	prometheus.DegradedResponses.WithLabelValues(action).Inc()
+	return true
+}
+
+// skipLit возвращает первое начиная с state состояние, в котором не горит ни одна из ламп.
+// Если таких нет, state не меняется.
+func skipLit(trafficType, state int, lamps []string) int {
+	count := models.StatesCount(trafficType)
+	for next, i := state, 0; i < count; next, i = next%count+1, i+1 {
+		l, err := models.StateLamps(trafficType, next)
+		if err != nil {
+			return state
+		}
+		lit := false
+		for _, lamp := range lamps {
+			lit = lit || l.Lit(lamp)
+		}
+		if !lit {
+			return next
+		}
+	}
+	return state
+}
+
+// ApplyMutation обновляет память по изменению, примененному другим узлом кластера.
+func (m *Manager) ApplyMutation(mu storage.Mutation) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	switch {
+	case mu.Op == storage.OpPutFault && mu.Fault != nil:
+		m.put(*mu.Fault)
+	case mu.Op == storage.OpDeleteFault:
+		m.delete(mu.Key)
+	default:
+		return
+	}
+	m.updateMetrics()
+}
+
+var (
+	defaultMu      sync.RWMutex
+	defaultManager *Manager
+)
+
+func SetDefault(m *Manager) {
+	defaultMu.Lock()
+	defer defaultMu.Unlock()
+	defaultManager = m
+}
+
+func Default() *Manager {
+	defaultMu.RLock()
+	defer defaultMu.RUnlock()
+	return defaultManager
+}
@@ -0,0 +1,142 @@
+package faults_test
+
+import (
+	"log/slog"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"trafficlightAPI/internal/faults"
+	"trafficlightAPI/internal/models"
+	"trafficlightAPI/internal/storage"
+
+	"github.com/pkg/errors"
+)
+
+func intPtr(v int) *int { return &v }
+
+func newManager(t *testing.T) (*faults.Manager, storage.Store) {
+	t.Helper()
+	store, err := storage.OpenBolt(filepath.Join(t.TempDir(), "state.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { store.Close() })
+	return faults.NewManager(store, faults.DefaultPolicy(), nil, slog.Default()), store
+}
+
+func TestPolicyValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy faults.Policy
+		ok     bool
+	}{
+		{name: "default", policy: faults.DefaultPolicy(), ok: true},
+		{name: "no arrow on regular light", policy: faults.Policy{{Type: 1, Lamp: "arrow", Action: faults.ActionSkipPhase}}},
+		{name: "no yellow on pedestrian light", policy: faults.Policy{{Type: 3, Lamp: "red", Action: faults.ActionFlashYellow}}},
+		{name: "unknown action", policy: faults.Policy{{Type: 1, Lamp: "red", Action: "dark"}}},
+		{name: "duplicate", policy: faults.Policy{{Type: 1, Lamp: "red", Action: faults.ActionReport}, {Type: 1, Lamp: "red", Action: faults.ActionFlashYellow}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.policy.Validate()
+			if tt.ok != (err == nil) || (err != nil && !errors.Is(err, faults.ErrInvalidPolicy)) {
+				t.Errorf("Validate() = %v", err)
+			}
+		})
+	}
+}
+
+func TestResolve(t *testing.T) {
+	m, _ := newManager(t)
+	for _, f := range []storage.Fault{
+		{UUID: "regular", Type: 1, Lamp: "red"},
+		{UUID: "arrow", Type: 2, Lamp: "arrow"},
+		{UUID: "green", Type: 1, Lamp: "green"},
+	} {
+		if _, err := m.Report(f); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name     string
+		uuid     string
+		typ      int
+		state    int
+		next     string
+		want     models.TrafficResponse
+		degraded bool
+	}{
+		{
+			name: "red lamp forces flashing yellow", uuid: "regular", typ: 1, state: 3, next: "1",
+			want:     models.TrafficResponse{NextState: "2", Faults: []string{"red"}, Degraded: faults.ActionFlashYellow, Flashing: true},
+			degraded: true,
+		},
+		{
+			name: "arrow phases are skipped", uuid: "arrow", typ: 2, state: 1, next: "2",
+			want:     models.TrafficResponse{NextState: "4", Faults: []string{"arrow"}, Degraded: faults.ActionSkipPhase},
+			degraded: true,
+		},
+		{
+			name: "non-arrow phase is kept", uuid: "arrow", typ: 2, state: 5, next: "6",
+			want:     models.TrafficResponse{NextState: "6", Faults: []string{"arrow"}, Degraded: faults.ActionSkipPhase},
+			degraded: true,
+		},
+		{
+			name: "green lamp is only reported", uuid: "green", typ: 1, state: 2, next: "3",
+			want: models.TrafficResponse{NextState: "3", Faults: []string{"green"}},
+		},
+		{
+			name: "no faults", uuid: "other", typ: 1, state: 2, next: "3",
+			want: models.TrafficResponse{NextState: "3"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			response := models.TrafficResponse{NextState: tt.next}
+			degraded := m.Resolve(models.TrafficRequest{UUID: tt.uuid, CurrentState: tt.state, CurrentTime: intPtr(0)}, tt.typ, &response)
+			if degraded != tt.degraded || !reflect.DeepEqual(response, tt.want) {
+				t.Errorf("Resolve() = %v, %+v, want %v, %+v", degraded, response, tt.degraded, tt.want)
+			}
+		})
+	}
+
+	// Изображение перерисовывается с перечеркнутой лампой.
+	plain, _ := models.StateImage(1, 2, nil)
+	response := models.TrafficResponse{NextState: "1"}
+	m.Resolve(models.TrafficRequest{UUID: "regular", CurrentState: 3, CurrentTime: intPtr(0), NeedImage: true}, 1, &response)
+	if response.Image == "" || response.Image == plain {
+		t.Error("image does not show the failed lamp")
+	}
+}
+
+func TestReportClearRestore(t *testing.T) {
+	m, store := newManager(t)
+	if _, err := m.Report(storage.Fault{UUID: "a", Type: 3, Lamp: "yellow"}); !errors.Is(err, faults.ErrInvalidFault) {
+		t.Errorf("pedestrian yellow: err = %v, want ErrInvalidFault", err)
+	}
+	if _, err := m.Report(storage.Fault{UUID: "a", Type: 2, Lamp: "arrow", Reason: "перегорела"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.Report(storage.Fault{UUID: "a", Type: 2, Lamp: "green"}); err != nil {
+		t.Fatal(err)
+	}
+
+	restored := faults.NewManager(store, faults.DefaultPolicy(), nil, slog.Default())
+	if err := restored.Restore(store); err != nil {
+		t.Fatal(err)
+	}
+	if got := restored.List("a"); len(got) != 2 || got[0].Lamp != "arrow" || got[1].Lamp != "green" {
+		t.Errorf("restored = %+v", got)
+	}
+
+	if err := m.Clear("a", "arrow", "tech"); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Clear("a", "arrow", "tech"); !errors.Is(err, faults.ErrFaultNotFound) {
+		t.Errorf("second clear: err = %v, want ErrFaultNotFound", err)
+	}
+	if got := m.List(""); len(got) != 1 || got[0].Lamp != "green" {
+		t.Errorf("after clear = %+v", got)
+	}
+}
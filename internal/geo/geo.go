@@ -0,0 +1,228 @@
+package geo
+
+import (
+	"math"
+	"sort"
+	"sync"
+	"trafficlightAPI/internal/storage"
+
+	"github.com/pkg/errors"
+	"github.com/tidwall/rtree"
+)
+
+var (
+	ErrInvalidQuery = errors.New("некорректный географический запрос")
+)
+
+const earthRadius = 6371008.8 // Средний радиус Земли, м
+
+// Distance возвращает расстояние по большому кругу между точками, м.
+func Distance(a, b storage.Location) float64 {
+	lat1, lat2 := radians(a.Lat), radians(b.Lat)
+	dLat, dLon := lat2-lat1, radians(b.Lon-a.Lon)
+	h := math.Sin(dLat/2)*math.Sin(dLat/2) + math.Cos(lat1)*math.Cos(lat2)*math.Sin(dLon/2)*math.Sin(dLon/2)
+	return 2 * earthRadius * math.Asin(math.Min(1, math.Sqrt(h)))
+}
+
+// Bearing возвращает начальный азимут из a в b в градусах от севера по часовой стрелке, [0, 360).
+func Bearing(a, b storage.Location) float64 {
+	lat1, lat2 := radians(a.Lat), radians(b.Lat)
+	dLon := radians(b.Lon - a.Lon)
+	y := math.Sin(dLon) * math.Cos(lat2)
+	x := math.Cos(lat1)*math.Sin(lat2) - math.Sin(lat1)*math.Cos(lat2)*math.Cos(dLon)
+	return math.Mod(degrees(math.Atan2(y, x))+360, 360)
+}
+
+// angleDiff возвращает разницу направлений в градусах, [0, 180].
+func angleDiff(a, b float64) float64 {
+	d := math.Mod(math.Abs(a-b), 360)
+	return math.Min(d, 360-d)
+}
+
+func radians(d float64) float64 { return d * math.Pi / 180 }
+func degrees(r float64) float64 { return r * 180 / math.Pi }
+
+func validLocation(l storage.Location) bool {
+	return l.Lat >= -90 && l.Lat <= 90 && l.Lon >= -180 && l.Lon <= 180
+}
+
+// Box - прямоугольник в координатах WGS 84, без пересечения антимеридиана.
+type Box struct {
+	MinLat, MinLon, MaxLat, MaxLon float64
+}
+
+func (b Box) Validate() error {
+	if !validLocation(storage.Location{Lat: b.MinLat, Lon: b.MinLon}) || !validLocation(storage.Location{Lat: b.MaxLat, Lon: b.MaxLon}) {
+		return errors.Wrap(ErrInvalidQuery, "координаты вне диапазона")
+	}
+	if b.MinLat > b.MaxLat || b.MinLon > b.MaxLon {
+		return errors.Wrap(ErrInvalidQuery, "минимум больше максимума")
+	}
+	return nil
+}
+
+// around возвращает прямоугольник, содержащий круг радиуса radius вокруг center.
+func around(center storage.Location, radius float64) Box {
+	dLat := degrees(radius / earthRadius)
+	dLon := 180.0
+	if cos := math.Cos(radians(center.Lat)); cos > 1e-9 {
+		dLon = math.Min(180, dLat/cos)
+	}
+	return Box{
+		MinLat: math.Max(-90, center.Lat-dLat), MaxLat: math.Min(90, center.Lat+dLat),
+		MinLon: math.Max(-180, center.Lon-dLon), MaxLon: math.Min(180, center.Lon+dLon),
+	}
+}
+
+type Hit struct {
+	UUID     string
+	Location storage.Location
+	Distance float64 // м
+}
+
+// Index - пространственный индекс точек по UUID на R-дереве.
+type Index struct {
+	mu     sync.RWMutex
+	tree   rtree.RTreeG[string]
+	points map[string]storage.Location
+}
+
+func NewIndex() *Index {
+	return &Index{points: make(map[string]storage.Location)}
+}
+
+func point(l storage.Location) [2]float64 {
+	return [2]float64{l.Lon, l.Lat}
+}
+
+// Put добавляет или перемещает точку. nil удаляет ее из индекса.
+func (ix *Index) Put(uuid string, l *storage.Location) {
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+	if old, ok := ix.points[uuid]; ok {
+		ix.tree.Delete(point(old), point(old), uuid)
+		delete(ix.points, uuid)
+	}
+	if l != nil {
+		ix.tree.Insert(point(*l), point(*l), uuid)
+		ix.points[uuid] = *l
+	}
+}
+
+func (ix *Index) Len() int {
+	ix.mu.RLock()
+	defer ix.mu.RUnlock()
+	return len(ix.points)
+}
+
+// InBox возвращает точки в прямоугольнике в порядке UUID.
+func (ix *Index) InBox(b Box) ([]Hit, error) {
+	if err := b.Validate(); err != nil {
+		return nil, err
+	}
+	ix.mu.RLock()
+	var hits []Hit
+	ix.tree.Search([2]float64{b.MinLon, b.MinLat}, [2]float64{b.MaxLon, b.MaxLat}, func(min, _ [2]float64, uuid string) bool {
+		hits = append(hits, Hit{UUID: uuid, Location: storage.Location{Lat: min[1], Lon: min[0]}})
+		return true
+	})
+	ix.mu.RUnlock()
+
+	sort.Slice(hits, func(i, j int) bool { return hits[i].UUID < hits[j].UUID })
+	return hits, nil
+}
+
+// Within возвращает точки не дальше radius метров от center по возрастанию расстояния.
+func (ix *Index) Within(center storage.Location, radius float64) ([]Hit, error) {
+	if !validLocation(center) || radius <= 0 {
+		return nil, errors.Wrapf(ErrInvalidQuery, "центр %f, %f, радиус %f", center.Lat, center.Lon, radius)
+	}
+	b := around(center, radius)
+
+	ix.mu.RLock()
+	var hits []Hit
+	ix.tree.Search([2]float64{b.MinLon, b.MinLat}, [2]float64{b.MaxLon, b.MaxLat}, func(min, _ [2]float64, uuid string) bool {
+		l := storage.Location{Lat: min[1], Lon: min[0]}
+		if d := Distance(center, l); d <= radius {
+			hits = append(hits, Hit{UUID: uuid, Location: l, Distance: d})
+		}
+		return true
+	})
+	ix.mu.RUnlock()
+
+	sort.Slice(hits, func(i, j int) bool {
+		if hits[i].Distance != hits[j].Distance {
+			return hits[i].Distance < hits[j].Distance
+		}
+		return hits[i].UUID < hits[j].UUID
+	})
+	return hits, nil
+}
+
+// Ahead возвращает ближайшую точку не дальше maxDistance метров, азимут на
+// которую отличается от heading не больше чем на tolerance градусов.
+// Точки ближе minDistance метров (например, сам запрашивающий) пропускаются.
+func (ix *Index) Ahead(from storage.Location, heading, tolerance, minDistance, maxDistance float64) (Hit, bool, error) {
+	if !validLocation(from) || heading < 0 || heading >= 360 || tolerance <= 0 || tolerance > 180 || maxDistance <= 0 {
+		return Hit{}, false, errors.Wrapf(ErrInvalidQuery, "направление %f, допуск %f, расстояние %f", heading, tolerance, maxDistance)
+	}
+
+	var found Hit
+	var ok bool
+	ix.mu.RLock()
+	defer ix.mu.RUnlock()
+	// Узлы дерева обходятся по возрастанию нижней оценки расстояния до
+	// прямоугольника, поэтому первая подходящая точка - ближайшая.
+	ix.tree.Nearby(func(min, max [2]float64, _ string, _ bool) float64 {
+		nearest := storage.Location{
+			Lat: math.Max(min[1], math.Min(from.Lat, max[1])),
+			Lon: math.Max(min[0], math.Min(from.Lon, max[0])),
+		}
+		return Distance(from, nearest)
+	}, func(min, _ [2]float64, uuid string, dist float64) bool {
+		if dist > maxDistance {
+			return false
+		}
+		l := storage.Location{Lat: min[1], Lon: min[0]}
+		if dist < minDistance || angleDiff(Bearing(from, l), heading) > tolerance {
+			return true
+		}
+		found, ok = Hit{UUID: uuid, Location: l, Distance: dist}, true
+		return false
+	})
+	return found, ok, nil
+}
+
+// FeatureCollection - GeoJSON (RFC 7946).
+type FeatureCollection struct {
+	Type     string    `json:"type"`
+	Features []Feature `json:"features"`
+}
+
+type Feature struct {
+	Type       string         `json:"type"`
+	ID         string         `json:"id,omitempty"`
+	Geometry   Point          `json:"geometry"`
+	Properties map[string]any `json:"properties"`
+}
+
+type Point struct {
+	Type        string     `json:"type"`
+	Coordinates [2]float64 `json:"coordinates"` // Долгота, широта
+}
+
+func NewFeature(id string, l storage.Location, properties map[string]any) Feature {
+	return Feature{
+		Type:       "Feature",
+		ID:         id,
+		Geometry:   Point{Type: "Point", Coordinates: point(l)},
+		Properties: properties,
+	}
+}
+
+func NewFeatureCollection(features []Feature) FeatureCollection {
+	if features == nil {
+		features = []Feature{}
+	}
+	return FeatureCollection{Type: "FeatureCollection", Features: features}
+}
@@ -0,0 +1,162 @@
+package geo_test
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"testing"
+	"trafficlightAPI/internal/geo"
+	"trafficlightAPI/internal/storage"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	center = storage.Location{Lat: 55.7558, Lon: 37.6173}
+	north  = storage.Location{Lat: 55.7648, Lon: 37.6173} // ~1 км к северу
+	east   = storage.Location{Lat: 55.7558, Lon: 37.6333} // ~1 км к востоку
+	far    = storage.Location{Lat: 59.9386, Lon: 30.3141} // Санкт-Петербург
+)
+
+func TestDistanceBearing(t *testing.T) {
+	tests := []struct {
+		name     string
+		a, b     storage.Location
+		distance float64 // м
+		bearing  float64
+	}{
+		{name: "north", a: center, b: north, distance: 1000, bearing: 0},
+		{name: "east", a: center, b: east, distance: 1000, bearing: 90},
+		{name: "moscow to petersburg", a: center, b: far, distance: 634000, bearing: 320},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if d := geo.Distance(tt.a, tt.b); math.Abs(d-tt.distance) > tt.distance*0.01 {
+				t.Errorf("distance = %.0f, want %.0f", d, tt.distance)
+			}
+			if b := geo.Bearing(tt.a, tt.b); math.Abs(b-tt.bearing) > 1 {
+				t.Errorf("bearing = %.1f, want %.1f", b, tt.bearing)
+			}
+		})
+	}
+}
+
+func newIndex() *geo.Index {
+	ix := geo.NewIndex()
+	ix.Put("center", &center)
+	ix.Put("north", &north)
+	ix.Put("east", &east)
+	ix.Put("far", &far)
+	return ix
+}
+
+func uuids(hits []geo.Hit) []string {
+	out := make([]string, len(hits))
+	for i, h := range hits {
+		out[i] = h.UUID
+	}
+	return out
+}
+
+func TestQueries(t *testing.T) {
+	ix := newIndex()
+
+	hits, err := ix.Within(center, 1100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := fmt.Sprint(uuids(hits)); got != "[center east north]" && got != "[center north east]" {
+		t.Errorf("within = %s", got)
+	}
+	if hits[0].Distance != 0 || hits[1].Distance > 1100 {
+		t.Errorf("distances = %+v", hits)
+	}
+
+	hits, err = ix.InBox(geo.Box{MinLat: 55.75, MinLon: 37.61, MaxLat: 55.77, MaxLon: 37.62})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := fmt.Sprint(uuids(hits)); got != "[center north]" {
+		t.Errorf("bbox = %s", got)
+	}
+
+	// Из центра на восток ближайший светофор - east, сам центр пропускается.
+	hit, ok, err := ix.Ahead(center, 80, 30, 1, 5000)
+	if err != nil || !ok || hit.UUID != "east" {
+		t.Errorf("ahead east = %+v, %v, %v", hit, ok, err)
+	}
+	if _, ok, _ := ix.Ahead(center, 180, 30, 1, 5000); ok {
+		t.Error("found a light to the south")
+	}
+	hit, ok, _ = ix.Ahead(center, 320, 10, 1, 1_000_000)
+	if !ok || hit.UUID != "far" {
+		t.Errorf("ahead far = %+v, %v", hit, ok)
+	}
+
+	// Перемещение и удаление точек.
+	ix.Put("far", &storage.Location{Lat: 55.7559, Lon: 37.6174})
+	ix.Put("north", nil)
+	hits, _ = ix.Within(center, 1100)
+	if got := fmt.Sprint(uuids(hits)); got != "[center far east]" || ix.Len() != 3 {
+		t.Errorf("after update = %s, len %d", got, ix.Len())
+	}
+}
+
+func TestInvalidQueries(t *testing.T) {
+	ix := newIndex()
+	if _, err := ix.Within(center, 0); !errors.Is(err, geo.ErrInvalidQuery) {
+		t.Errorf("zero radius: err = %v", err)
+	}
+	if _, err := ix.InBox(geo.Box{MinLat: 56, MaxLat: 55, MaxLon: 1}); !errors.Is(err, geo.ErrInvalidQuery) {
+		t.Errorf("inverted box: err = %v", err)
+	}
+	if _, _, err := ix.Ahead(center, 360, 30, 0, 100); !errors.Is(err, geo.ErrInvalidQuery) {
+		t.Errorf("heading 360: err = %v", err)
+	}
+}
+
+// Результаты индекса совпадают с полным перебором.
+func TestIndexMatchesBruteForce(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	ix := geo.NewIndex()
+	points := make(map[string]storage.Location)
+	for i := 0; i < 20000; i++ {
+		l := storage.Location{Lat: 55.5 + rnd.Float64()*0.5, Lon: 37.3 + rnd.Float64()*0.6}
+		uuid := fmt.Sprintf("light%05d", i)
+		points[uuid] = l
+		ix.Put(uuid, &l)
+	}
+
+	for q := 0; q < 50; q++ {
+		from := storage.Location{Lat: 55.5 + rnd.Float64()*0.5, Lon: 37.3 + rnd.Float64()*0.6}
+		radius := 200 + rnd.Float64()*2000
+		heading := rnd.Float64() * 360
+
+		want, best, bestUUID := 0, math.Inf(1), ""
+		for uuid, l := range points {
+			d := geo.Distance(from, l)
+			if d <= radius {
+				want++
+			}
+			diff := math.Mod(math.Abs(geo.Bearing(from, l)-heading), 360)
+			if d <= radius && math.Min(diff, 360-diff) <= 20 && d < best {
+				best, bestUUID = d, uuid
+			}
+		}
+
+		hits, err := ix.Within(from, radius)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(hits) != want {
+			t.Fatalf("query %d: within = %d, want %d", q, len(hits), want)
+		}
+		hit, ok, err := ix.Ahead(from, heading, 20, 0, radius)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ok != (bestUUID != "") || (ok && hit.UUID != bestUUID) {
+			t.Fatalf("query %d: ahead = %s (%v), want %s", q, hit.UUID, ok, bestUUID)
+		}
+	}
+}
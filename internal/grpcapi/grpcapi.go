@@ -0,0 +1,408 @@
+// Package grpcapi - gRPC-сервер с той же логикой, что и /trafficlight и /stream.
+package grpcapi
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative -I pb pb/trafficlight.proto
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+	"trafficlightAPI/internal/control"
+	"trafficlightAPI/internal/devices"
+	"trafficlightAPI/internal/grpcapi/pb"
+	"trafficlightAPI/internal/middleware/auth"
+	prometheus "trafficlightAPI/internal/middleware/prometheus"
+	"trafficlightAPI/internal/middleware/signing"
+	"trafficlightAPI/internal/models"
+	"trafficlightAPI/internal/stream"
+
+	"github.com/pkg/errors"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/keepalive"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/reflection"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+var (
+	ErrInvalidType = control.ErrInvalidType
+	ErrBatchSize   = errors.New("слишком много запросов в пакете")
+)
+
+// Метаданные с учетными данными, как заголовки HTTP API.
+const (
+	APIKeyMetadata        = "x-api-key"
+	AuthorizationMetadata = "authorization"
+)
+
+// Метаданные подписи устройства, как заголовки signing.
+const (
+	DeviceMetadata    = "x-device-id"
+	TimestampMetadata = "x-timestamp"
+	NonceMetadata     = "x-nonce"
+	SignatureMetadata = "x-signature"
+)
+
+const stopTimeout = 5 * time.Second
+
+type Config struct {
+	MaxBatch  int
+	KeepAlive time.Duration
+}
+
+type Server struct {
+	pb.UnimplementedTrafficLightServer
+
+	cfg      Config
+	authn    *auth.Authenticator
+	verifier *signing.Verifier
+	logger   *slog.Logger
+	grpc     *grpc.Server
+	health   *health.Server
+}
+
+// New создает сервер с проверкой здоровья и reflection. При включенной
+// аутентификации методы TrafficLight требуют роль viewer. При включенной
+// подписи GetNextState и GetNextStates, как /trafficlight, вместо ключа
+// требуют подпись устройства в метаданных (StringToSign).
+func New(cfg Config, authn *auth.Authenticator, verifier *signing.Verifier, logger *slog.Logger) *Server {
+	s := &Server{cfg: cfg, authn: authn, verifier: verifier, logger: logger, health: health.NewServer()}
+
+	options := []grpc.ServerOption{
+		grpc.ChainUnaryInterceptor(s.unaryMetrics, s.unaryAuth),
+		grpc.ChainStreamInterceptor(s.streamMetrics, s.streamAuth),
+	}
+	if cfg.KeepAlive > 0 {
+		options = append(options,
+			grpc.KeepaliveParams(keepalive.ServerParameters{Time: cfg.KeepAlive, Timeout: cfg.KeepAlive / 3}),
+			grpc.KeepaliveEnforcementPolicy(keepalive.EnforcementPolicy{MinTime: cfg.KeepAlive / 3, PermitWithoutStream: true}),
+		)
+	}
+	s.grpc = grpc.NewServer(options...)
+
+	pb.RegisterTrafficLightServer(s.grpc, s)
+	healthpb.RegisterHealthServer(s.grpc, s.health)
+	reflection.Register(s.grpc)
+	s.health.SetServingStatus("", healthpb.HealthCheckResponse_SERVING)
+	s.health.SetServingStatus(pb.TrafficLight_ServiceDesc.ServiceName, healthpb.HealthCheckResponse_SERVING)
+	return s
+}
+
+func (s *Server) Serve(lis net.Listener) error {
+	return s.grpc.Serve(lis)
+}
+
+// Stop переводит проверку здоровья в NOT_SERVING и ждет завершения вызовов не дольше
+// stopTimeout. Подписки Subscribe сами не завершаются, их обрывает grpc.Server.Stop.
+func (s *Server) Stop() {
+	s.health.Shutdown()
+	done := make(chan struct{})
+	go func() {
+		s.grpc.GracefulStop()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(stopTimeout):
+		s.grpc.Stop()
+	}
+}
+
+// statusError переводит ошибку сервиса в код gRPC.
+func statusError(err error) error {
+	if _, ok := status.FromError(err); ok {
+		return err
+	}
+	code := codes.Internal
+	switch {
+	case errors.Is(err, models.ErrNoCurrentTime),
+		errors.Is(err, models.ErrNoCurrentState),
+		errors.Is(err, models.ErrNotValidData),
+		errors.Is(err, devices.ErrTypeRequired),
+		errors.Is(err, devices.ErrTypeMismatch),
+		errors.Is(err, ErrInvalidType),
+		errors.Is(err, ErrBatchSize):
+		code = codes.InvalidArgument
+	case errors.Is(err, auth.ErrUnauthenticated), errors.Is(err, auth.ErrInvalidCredentials):
+		code = codes.Unauthenticated
+	case errors.Is(err, auth.ErrForbidden), errors.Is(err, signing.ErrDevice):
+		code = codes.PermissionDenied
+	}
+	return status.Error(code, err.Error())
+}
+
+func (s *Server) GetNextState(ctx context.Context, req *pb.NextStateRequest) (*pb.NextStateResponse, error) {
+	response, err := s.nextState(ctx, req)
+	if err != nil {
+		return nil, statusError(err)
+	}
+	return response, nil
+}
+
+func (s *Server) GetNextStates(ctx context.Context, req *pb.BatchRequest) (*pb.BatchResponse, error) {
+	if s.cfg.MaxBatch > 0 && len(req.GetRequests()) > s.cfg.MaxBatch {
+		return nil, statusError(errors.Wrapf(ErrBatchSize, "%d, максимум %d", len(req.GetRequests()), s.cfg.MaxBatch))
+	}
+
+	results := make([]*pb.BatchResult, len(req.GetRequests()))
+	for i, r := range req.GetRequests() {
+		response, err := s.nextState(ctx, r)
+		if err != nil {
+			st, _ := status.FromError(statusError(err))
+			results[i] = &pb.BatchResult{Result: &pb.BatchResult_Error{Error: &pb.Error{Code: int32(st.Code()), Message: st.Message()}}}
+			continue
+		}
+		results[i] = &pb.BatchResult{Result: &pb.BatchResult_Response{Response: response}}
+	}
+	return &pb.BatchResponse{Results: results}, nil
+}
+
+// nextState вычисляет ответ, как ServeTrafficRoute.
+func (s *Server) nextState(ctx context.Context, req *pb.NextStateRequest) (*pb.NextStateResponse, error) {
+	request := models.TrafficRequest{
+		UUID:         req.GetUuid(),
+		CurrentState: int(req.GetCurrentState()),
+		NeedImage:    req.GetNeedImage(),
+	}
+	if req.CurrentTime != nil {
+		currentTime := int(req.GetCurrentTime())
+		request.CurrentTime = &currentTime
+	}
+
+	response, err := control.NextState(ctx, "grpc", request, int(req.GetType()))
+	if err != nil {
+		return nil, err
+	}
+	return toProto(response)
+}
+
+func toProto(r models.TrafficResponse) (*pb.NextStateResponse, error) {
+	next, err := strconv.Atoi(r.NextState)
+	if err != nil {
+		return nil, fmt.Errorf("некорректное состояние в ответе: %w", err)
+	}
+	response := &pb.NextStateResponse{
+		Uuid:      r.UUID,
+		NextState: int32(next),
+		Image:     r.Image,
+		Faults:    r.Faults,
+		Degraded:  r.Degraded,
+		Flashing:  r.Flashing,
+		Failsafe:  r.Failsafe,
+	}
+	if r.NextCountdownTime != "" {
+		countdown, err := strconv.Atoi(r.NextCountdownTime)
+		if err != nil {
+			return nil, fmt.Errorf("некорректный обратный отсчет в ответе: %w", err)
+		}
+		c := int32(countdown)
+		response.NextCountdownTime = &c
+	}
+	if o := r.Override; o != nil {
+		response.Override = &pb.Override{Id: o.ID, Action: o.Action, Reason: o.Reason, ExpiresAt: timestamppb.New(o.ExpiresAt)}
+	}
+	return response, nil
+}
+
+func toEvent(e stream.Event, dropped int64) *pb.StateEvent {
+	event := &pb.StateEvent{
+		Uuid:         e.UUID,
+		Type:         int32(e.Type),
+		Intersection: e.Intersection,
+		State:        int32(e.State),
+		Lamps: &pb.Lamps{
+			Red:           e.Lamps.Red,
+			Yellow:        e.Lamps.Yellow,
+			Green:         e.Lamps.Green,
+			Arrow:         e.Lamps.Arrow,
+			ArrowFlashing: e.Lamps.ArrowFlashing,
+		},
+		Mode:     e.Mode,
+		Since:    timestamppb.New(e.Since),
+		Duration: int32(e.Duration),
+		Dropped:  dropped,
+	}
+	if e.NextAt != nil {
+		event.NextAt = timestamppb.New(*e.NextAt)
+	}
+	return event
+}
+
+func (s *Server) Subscribe(req *pb.SubscribeRequest, srv grpc.ServerStreamingServer[pb.StateEvent]) error {
+	hub := stream.Default()
+	if hub == nil {
+		return status.Error(codes.Unavailable, "потоковая передача состояний не настроена")
+	}
+	filter := stream.Filter{UUIDs: req.GetUuids(), Intersections: req.GetIntersections()}
+	if filter.Empty() {
+		return status.Error(codes.InvalidArgument, "не указаны uuids или intersections")
+	}
+
+	ctx := srv.Context()
+	remote := ""
+	if p, ok := peer.FromContext(ctx); ok {
+		remote = p.Addr.String()
+	}
+	user := ""
+	if identity, ok := auth.FromContext(ctx); ok {
+		user = identity.Name
+	}
+
+	sub := hub.Subscribe("grpc", remote, user, filter)
+	reason := stream.ReasonClient
+	defer func() { hub.Unsubscribe(sub, reason) }()
+
+	for _, e := range hub.Snapshot(filter) {
+		if err := srv.Send(toEvent(e, 0)); err != nil {
+			reason = stream.ReasonWriteError
+			return err
+		}
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-sub.Done():
+			if sub.Reason() == stream.ReasonSlowConsumer {
+				return status.Error(codes.ResourceExhausted, "клиент не успевает читать события")
+			}
+			return status.Error(codes.Unavailable, "сервер завершает работу")
+		case e := <-sub.Events():
+			if err := srv.Send(toEvent(e, sub.Delivered())); err != nil {
+				reason = stream.ReasonWriteError
+				return err
+			}
+		}
+	}
+}
+
+// public сообщает, что метод доступен без аутентификации: проверка здоровья и reflection.
+func public(method string) bool {
+	return strings.HasPrefix(method, "/grpc.health.") || strings.HasPrefix(method, "/grpc.reflection.")
+}
+
+func (s *Server) authenticate(ctx context.Context, method string) (context.Context, error) {
+	if s.authn == nil || !s.authn.Enabled() || public(method) {
+		return ctx, nil
+	}
+	md, _ := metadata.FromIncomingContext(ctx)
+	first := func(key string) string {
+		if values := md.Get(key); len(values) > 0 {
+			return values[0]
+		}
+		return ""
+	}
+
+	identity, err := s.authn.Credentials(first(APIKeyMetadata), first(AuthorizationMetadata))
+	if err != nil {
+		return nil, statusError(err)
+	}
+	if identity.Role < auth.RoleViewer {
+		return nil, statusError(errors.Wrapf(auth.ErrForbidden, "%s: роль %s, требуется %s", identity.Name, identity.Role, auth.RoleViewer))
+	}
+	return auth.WithIdentity(ctx, identity), nil
+}
+
+// signed сообщает, что метод меняет состояние светофоров и при включенной
+// подписи вызывается устройством.
+func signed(method string) bool {
+	return method == pb.TrafficLight_GetNextState_FullMethodName || method == pb.TrafficLight_GetNextStates_FullMethodName
+}
+
+// StringToSign собирает подписываемую строку вызова: signing.StringToSign с
+// методом POST, полным именем метода gRPC и детерминированной сериализацией
+// protobuf запроса вместо тела.
+func StringToSign(method string, req proto.Message, timestamp, nonce string) (string, error) {
+	body, err := proto.MarshalOptions{Deterministic: true}.Marshal(req)
+	if err != nil {
+		return "", err
+	}
+	return signing.StringToSign("POST", method, "", timestamp, nonce, body), nil
+}
+
+// verify проверяет подпись устройства и возвращает контекст с его UUID.
+func (s *Server) verify(ctx context.Context, method string, req any) (context.Context, error) {
+	message, ok := req.(proto.Message)
+	if !ok {
+		return nil, status.Error(codes.Internal, "запрос не protobuf")
+	}
+	md, _ := metadata.FromIncomingContext(ctx)
+	first := func(key string) string {
+		if values := md.Get(key); len(values) > 0 {
+			return values[0]
+		}
+		return ""
+	}
+	device, timestamp, nonce := first(DeviceMetadata), first(TimestampMetadata), first(NonceMetadata)
+	stringToSign, err := StringToSign(method, message, timestamp, nonce)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	if err := s.verifier.Check(device, timestamp, nonce, first(SignatureMetadata), stringToSign); err != nil {
+		if errors.Is(err, signing.ErrBusy) {
+			return nil, status.Error(codes.Unavailable, err.Error())
+		}
+		return nil, status.Error(codes.Unauthenticated, err.Error())
+	}
+	return signing.WithDevice(ctx, device), nil
+}
+
+func (s *Server) unaryAuth(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	if s.verifier != nil && s.verifier.Enabled() && signed(info.FullMethod) {
+		ctx, err := s.verify(ctx, info.FullMethod, req)
+		if err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+	ctx, err := s.authenticate(ctx, info.FullMethod)
+	if err != nil {
+		return nil, err
+	}
+	return handler(ctx, req)
+}
+
+type authStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s authStream) Context() context.Context { return s.ctx }
+
+func (s *Server) streamAuth(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	ctx, err := s.authenticate(ss.Context(), info.FullMethod)
+	if err != nil {
+		return err
+	}
+	return handler(srv, authStream{ServerStream: ss, ctx: ctx})
+}
+
+func (s *Server) record(method string, err error) {
+	code := status.Code(err)
+	prometheus.GRPCRequests.WithLabelValues(method, code.String()).Inc()
+	if code != codes.OK && code != codes.Canceled {
+		s.logger.Debug("ошибка gRPC вызова", slog.String("method", method), slog.String("code", code.String()), slog.Any("err", err))
+	}
+}
+
+func (s *Server) unaryMetrics(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	resp, err := handler(ctx, req)
+	s.record(info.FullMethod, err)
+	return resp, err
+}
+
+func (s *Server) streamMetrics(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	err := handler(srv, ss)
+	s.record(info.FullMethod, err)
+	return err
+}
@@ -0,0 +1,261 @@
+package grpcapi_test
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"log/slog"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+	"trafficlightAPI/internal/grpcapi"
+	"trafficlightAPI/internal/grpcapi/pb"
+	"trafficlightAPI/internal/middleware/auth"
+	"trafficlightAPI/internal/middleware/signing"
+	"trafficlightAPI/internal/models"
+	"trafficlightAPI/internal/stream"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/proto"
+)
+
+func int32Ptr(v int32) *int32 { return &v }
+
+func newClient(t *testing.T, authn *auth.Authenticator, verifier *signing.Verifier) *grpc.ClientConn {
+	t.Helper()
+	if authn == nil {
+		authn, _ = auth.New(auth.Config{}, nil)
+	}
+	if verifier == nil {
+		verifier = signing.New(signing.Config{}, nil)
+	}
+	lis := bufconn.Listen(1 << 20)
+	srv := grpcapi.New(grpcapi.Config{MaxBatch: 3}, authn, verifier, slog.Default())
+	go srv.Serve(lis)
+	t.Cleanup(srv.Stop)
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+func TestGetNextState(t *testing.T) {
+	client := pb.NewTrafficLightClient(newClient(t, nil, nil))
+
+	tests := []struct {
+		name string
+		req  *pb.NextStateRequest
+		want *pb.NextStateResponse
+		code codes.Code
+	}{
+		{
+			name: "regular light switches to next state",
+			req:  &pb.NextStateRequest{Uuid: "a", Type: 1, CurrentState: 1, CurrentTime: int32Ptr(19)},
+			want: &pb.NextStateResponse{Uuid: "a", NextState: 2},
+		},
+		{
+			name: "pedestrian light has countdown",
+			req:  &pb.NextStateRequest{Uuid: "b", Type: 3, CurrentState: 1, CurrentTime: int32Ptr(0)},
+			want: &pb.NextStateResponse{Uuid: "b", NextState: 1, NextCountdownTime: int32Ptr(int32(models.Plan(3)[0]))},
+		},
+		{
+			name: "missing current_time",
+			req:  &pb.NextStateRequest{Uuid: "a", Type: 1, CurrentState: 1},
+			code: codes.InvalidArgument,
+		},
+		{
+			name: "unregistered light without type",
+			req:  &pb.NextStateRequest{Uuid: "a", CurrentState: 1, CurrentTime: int32Ptr(0)},
+			code: codes.InvalidArgument,
+		},
+		{
+			name: "unknown type",
+			req:  &pb.NextStateRequest{Uuid: "a", Type: 7, CurrentState: 1, CurrentTime: int32Ptr(0)},
+			code: codes.InvalidArgument,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := client.GetNextState(context.Background(), tt.req)
+			if status.Code(err) != tt.code {
+				t.Fatalf("GetNextState() code = %v, want %v (%v)", status.Code(err), tt.code, err)
+			}
+			if tt.want != nil && !proto.Equal(got, tt.want) {
+				t.Errorf("GetNextState() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGetNextStates(t *testing.T) {
+	client := pb.NewTrafficLightClient(newClient(t, nil, nil))
+
+	got, err := client.GetNextStates(context.Background(), &pb.BatchRequest{Requests: []*pb.NextStateRequest{
+		{Uuid: "a", Type: 1, CurrentState: 2, CurrentTime: int32Ptr(0)},
+		{Uuid: "b", Type: 1, CurrentState: 9, CurrentTime: int32Ptr(0)},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got.Results) != 2 || got.Results[0].GetResponse().GetNextState() != 2 || got.Results[1].GetError().GetCode() != int32(codes.InvalidArgument) {
+		t.Errorf("GetNextStates() = %v", got)
+	}
+
+	_, err = client.GetNextStates(context.Background(), &pb.BatchRequest{Requests: make([]*pb.NextStateRequest, 4)})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("oversized batch: code = %v, want InvalidArgument", status.Code(err))
+	}
+}
+
+func TestSubscribe(t *testing.T) {
+	hub := stream.NewHub(stream.Config{Buffer: 4, MaxDrops: 4}, nil)
+	stream.SetDefault(hub)
+	t.Cleanup(func() { stream.SetDefault(nil) })
+	client := pb.NewTrafficLightClient(newClient(t, nil, nil))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	empty, err := client.Subscribe(ctx, &pb.SubscribeRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := empty.Recv(); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("empty subscription: code = %v, want InvalidArgument", status.Code(err))
+	}
+
+	sub, err := client.Subscribe(ctx, &pb.SubscribeRequest{Uuids: []string{"a"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for len(hub.Connections()) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	hub.Publish(models.Transition{UUID: "a", Type: 1, To: 3, Time: time.Now(), Plan: []int{10, 3, 20}, Mode: models.ModeNormal})
+
+	event, err := sub.Recv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if event.Uuid != "a" || event.State != 3 || !event.Lamps.Green || event.Duration != 20 || event.NextAt == nil {
+		t.Errorf("event = %v", event)
+	}
+	if c := hub.Connections(); len(c) != 1 || c[0].Transport != "grpc" {
+		t.Errorf("connections = %+v", c)
+	}
+}
+
+func TestAuth(t *testing.T) {
+	hash := sha256.Sum256([]byte("secret"))
+	authn, err := auth.New(auth.Config{Enabled: true, APIKeys: []auth.APIKey{{Name: "edge", SHA256: hex.EncodeToString(hash[:]), Role: "viewer"}}}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn := newClient(t, authn, nil)
+	client := pb.NewTrafficLightClient(conn)
+	req := &pb.NextStateRequest{Uuid: "a", Type: 1, CurrentState: 1, CurrentTime: int32Ptr(0)}
+
+	if _, err := client.GetNextState(context.Background(), req); status.Code(err) != codes.Unauthenticated {
+		t.Errorf("without key: code = %v, want Unauthenticated", status.Code(err))
+	}
+	wrong := metadata.AppendToOutgoingContext(context.Background(), grpcapi.APIKeyMetadata, "wrong")
+	if _, err := client.GetNextState(wrong, req); status.Code(err) != codes.Unauthenticated {
+		t.Errorf("wrong key: code = %v, want Unauthenticated", status.Code(err))
+	}
+	ctx := metadata.AppendToOutgoingContext(context.Background(), grpcapi.APIKeyMetadata, "secret")
+	if _, err := client.GetNextState(ctx, req); err != nil {
+		t.Errorf("with key: %v", err)
+	}
+
+	// Проверка здоровья доступна без ключа.
+	resp, err := healthpb.NewHealthClient(conn).Check(context.Background(), &healthpb.HealthCheckRequest{Service: "trafficlight.v1.TrafficLight"})
+	if err != nil || resp.Status != healthpb.HealthCheckResponse_SERVING {
+		t.Errorf("health = %v, %v", resp, err)
+	}
+}
+
+func TestSigning(t *testing.T) {
+	const secret = "0123456789abcdef0123"
+	hash := sha256.Sum256([]byte("secret"))
+	authn, err := auth.New(auth.Config{Enabled: true, APIKeys: []auth.APIKey{{Name: "edge", SHA256: hex.EncodeToString(hash[:]), Role: "viewer"}}}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	verifier := signing.New(signing.Config{Enabled: true, Secrets: map[string][]byte{"a": []byte(secret)}}, nil)
+	client := pb.NewTrafficLightClient(newClient(t, authn, verifier))
+
+	sign := func(t *testing.T, device, key, nonce string, req *pb.NextStateRequest) context.Context {
+		t.Helper()
+		timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+		stringToSign, err := grpcapi.StringToSign(pb.TrafficLight_GetNextState_FullMethodName, req, timestamp, nonce)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return metadata.AppendToOutgoingContext(context.Background(),
+			grpcapi.DeviceMetadata, device,
+			grpcapi.TimestampMetadata, timestamp,
+			grpcapi.NonceMetadata, nonce,
+			grpcapi.SignatureMetadata, signing.Sign([]byte(key), stringToSign),
+		)
+	}
+	own := &pb.NextStateRequest{Uuid: "a", Type: 1, CurrentState: 1, CurrentTime: int32Ptr(0)}
+	other := &pb.NextStateRequest{Uuid: "b", Type: 1, CurrentState: 1, CurrentTime: int32Ptr(0)}
+
+	tests := []struct {
+		name string
+		ctx  func(t *testing.T) context.Context
+		req  *pb.NextStateRequest
+		code codes.Code
+	}{
+		{
+			name: "viewer key is not enough",
+			ctx: func(t *testing.T) context.Context {
+				return metadata.AppendToOutgoingContext(context.Background(), grpcapi.APIKeyMetadata, "secret")
+			},
+			req:  own,
+			code: codes.Unauthenticated,
+		},
+		{
+			name: "wrong secret",
+			ctx:  func(t *testing.T) context.Context { return sign(t, "a", "wrong-secret-wrong-secret", "1", own) },
+			req:  own,
+			code: codes.Unauthenticated,
+		},
+		{
+			name: "signed by the device",
+			ctx:  func(t *testing.T) context.Context { return sign(t, "a", secret, "2", own) },
+			req:  own,
+		},
+		{
+			name: "replayed nonce",
+			ctx:  func(t *testing.T) context.Context { return sign(t, "a", secret, "2", own) },
+			req:  own,
+			code: codes.Unauthenticated,
+		},
+		{
+			name: "uuid of another device",
+			ctx:  func(t *testing.T) context.Context { return sign(t, "a", secret, "3", other) },
+			req:  other,
+			code: codes.PermissionDenied,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := client.GetNextState(tt.ctx(t), tt.req); status.Code(err) != tt.code {
+				t.Errorf("GetNextState() code = %v, want %v (%v)", status.Code(err), tt.code, err)
+			}
+		})
+	}
+}
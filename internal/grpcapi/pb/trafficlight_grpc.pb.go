@@ -0,0 +1,215 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.5.1
+// - protoc             (unknown)
+// source: trafficlight.proto
+
+package pb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.64.0 or later.
+const _ = grpc.SupportPackageIsVersion9
+
+const (
+	TrafficLight_GetNextState_FullMethodName  = "/trafficlight.v1.TrafficLight/GetNextState"
+	TrafficLight_GetNextStates_FullMethodName = "/trafficlight.v1.TrafficLight/GetNextStates"
+	TrafficLight_Subscribe_FullMethodName     = "/trafficlight.v1.TrafficLight/Subscribe"
+)
+
+// TrafficLightClient is the client API for TrafficLight service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+//
+// TrafficLight - gRPC-версия /trafficlight с той же логикой, ручным
+// управлением, неисправностями ламп и монитором конфликтов.
+type TrafficLightClient interface {
+	// GetNextState вычисляет следующее состояние светофора.
+	GetNextState(ctx context.Context, in *NextStateRequest, opts ...grpc.CallOption) (*NextStateResponse, error)
+	// GetNextStates вычисляет состояния нескольких светофоров.
+	// Ошибка в одном запросе не прерывает остальные.
+	GetNextStates(ctx context.Context, in *BatchRequest, opts ...grpc.CallOption) (*BatchResponse, error)
+	// Subscribe отправляет последние известные состояния, затем каждую смену.
+	Subscribe(ctx context.Context, in *SubscribeRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[StateEvent], error)
+}
+
+type trafficLightClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewTrafficLightClient(cc grpc.ClientConnInterface) TrafficLightClient {
+	return &trafficLightClient{cc}
+}
+
+func (c *trafficLightClient) GetNextState(ctx context.Context, in *NextStateRequest, opts ...grpc.CallOption) (*NextStateResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(NextStateResponse)
+	err := c.cc.Invoke(ctx, TrafficLight_GetNextState_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *trafficLightClient) GetNextStates(ctx context.Context, in *BatchRequest, opts ...grpc.CallOption) (*BatchResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(BatchResponse)
+	err := c.cc.Invoke(ctx, TrafficLight_GetNextStates_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *trafficLightClient) Subscribe(ctx context.Context, in *SubscribeRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[StateEvent], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &TrafficLight_ServiceDesc.Streams[0], TrafficLight_Subscribe_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[SubscribeRequest, StateEvent]{ClientStream: stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type TrafficLight_SubscribeClient = grpc.ServerStreamingClient[StateEvent]
+
+// TrafficLightServer is the server API for TrafficLight service.
+// All implementations must embed UnimplementedTrafficLightServer
+// for forward compatibility.
+//
+// TrafficLight - gRPC-версия /trafficlight с той же логикой, ручным
+// управлением, неисправностями ламп и монитором конфликтов.
+type TrafficLightServer interface {
+	// GetNextState вычисляет следующее состояние светофора.
+	GetNextState(context.Context, *NextStateRequest) (*NextStateResponse, error)
+	// GetNextStates вычисляет состояния нескольких светофоров.
+	// Ошибка в одном запросе не прерывает остальные.
+	GetNextStates(context.Context, *BatchRequest) (*BatchResponse, error)
+	// Subscribe отправляет последние известные состояния, затем каждую смену.
+	Subscribe(*SubscribeRequest, grpc.ServerStreamingServer[StateEvent]) error
+	mustEmbedUnimplementedTrafficLightServer()
+}
+
+// UnimplementedTrafficLightServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedTrafficLightServer struct{}
+
+func (UnimplementedTrafficLightServer) GetNextState(context.Context, *NextStateRequest) (*NextStateResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetNextState not implemented")
+}
+func (UnimplementedTrafficLightServer) GetNextStates(context.Context, *BatchRequest) (*BatchResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetNextStates not implemented")
+}
+func (UnimplementedTrafficLightServer) Subscribe(*SubscribeRequest, grpc.ServerStreamingServer[StateEvent]) error {
+	return status.Errorf(codes.Unimplemented, "method Subscribe not implemented")
+}
+func (UnimplementedTrafficLightServer) mustEmbedUnimplementedTrafficLightServer() {}
+func (UnimplementedTrafficLightServer) testEmbeddedByValue()                      {}
+
+// UnsafeTrafficLightServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to TrafficLightServer will
+// result in compilation errors.
+type UnsafeTrafficLightServer interface {
+	mustEmbedUnimplementedTrafficLightServer()
+}
+
+func RegisterTrafficLightServer(s grpc.ServiceRegistrar, srv TrafficLightServer) {
+	// If the following call pancis, it indicates UnimplementedTrafficLightServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&TrafficLight_ServiceDesc, srv)
+}
+
+func _TrafficLight_GetNextState_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(NextStateRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(TrafficLightServer).GetNextState(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: TrafficLight_GetNextState_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(TrafficLightServer).GetNextState(ctx, req.(*NextStateRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _TrafficLight_GetNextStates_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(BatchRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(TrafficLightServer).GetNextStates(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: TrafficLight_GetNextStates_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(TrafficLightServer).GetNextStates(ctx, req.(*BatchRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _TrafficLight_Subscribe_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(SubscribeRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(TrafficLightServer).Subscribe(m, &grpc.GenericServerStream[SubscribeRequest, StateEvent]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type TrafficLight_SubscribeServer = grpc.ServerStreamingServer[StateEvent]
+
+// TrafficLight_ServiceDesc is the grpc.ServiceDesc for TrafficLight service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var TrafficLight_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "trafficlight.v1.TrafficLight",
+	HandlerType: (*TrafficLightServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "GetNextState",
+			Handler:    _TrafficLight_GetNextState_Handler,
+		},
+		{
+			MethodName: "GetNextStates",
+			Handler:    _TrafficLight_GetNextStates_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "Subscribe",
+			Handler:       _TrafficLight_Subscribe_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "trafficlight.proto",
+}
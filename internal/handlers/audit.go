@@ -0,0 +1,23 @@
+package handlers
+
+import (
+	"net/http"
+	"trafficlightAPI/internal/audit"
+	"trafficlightAPI/internal/middleware/auth"
+	logger "trafficlightAPI/internal/middleware/logger"
+)
+
+// recordAudit пишет запись в журнал аудита, ошибка записи не прерывает запрос.
+func recordAudit(e audit.Entry) {
+	if err := audit.Record(e); err != nil {
+		logger.LogError(http.StatusInternalServerError, err)
+	}
+}
+
+// auditUser возвращает имя аутентифицированного пользователя запроса.
+func auditUser(r *http.Request) string {
+	if identity, ok := auth.FromContext(r.Context()); ok {
+		return identity.Name
+	}
+	return ""
+}
@@ -0,0 +1,74 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"trafficlightAPI/internal/cluster"
+	"trafficlightAPI/internal/models"
+	"trafficlightAPI/internal/storage"
+)
+
+// @Summary     Apply a storage mutation forwarded by a follower (leader only)
+// @Tags        Cluster
+// @Accept      json
+// @Param       body body     storage.Mutation     true "Mutation"
+// @Success     204
+// @Failure     400  {object} models.ErrorResponse "Invalid mutation"
+// @Failure     404  {object} models.ErrorResponse "Record not found"
+// @Failure     503  {object} models.ErrorResponse "Not the leader"
+// @Router      /cluster/apply [post]
+func ServeClusterApply(node *cluster.Node) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var m storage.Mutation
+		if err := ParseJSON(r, &m); err != nil {
+			WriteError(w, http.StatusBadRequest, ErrUnmarshalingFromBody, err)
+			return
+		}
+		defer r.Body.Close()
+
+		// План применяет каждая реплика, некорректный план не должен попасть в журнал.
+		if m.Op == storage.OpPutPlan {
+			if _, err := models.NewLight(models.LightDefinition{Type: m.Type, Durations: m.Plan}); err != nil {
+				WriteError(w, http.StatusBadRequest, ErrApplyPlan, err)
+				return
+			}
+		}
+		if err := node.Apply(m); err != nil {
+			WriteError(w, cluster.ErrorStatus(err), err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// @Summary     Confirm leadership and return the index a follower must apply before a linearizable read
+// @Tags        Cluster
+// @Produce     json
+// @Success     200 {object} cluster.ReadIndexResponse
+// @Failure     503 {object} models.ErrorResponse "Not the leader"
+// @Router      /cluster/read-index [get]
+func ServeClusterReadIndex(node *cluster.Node) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		index, err := node.ReadIndex()
+		if err != nil {
+			WriteError(w, cluster.ErrorStatus(err), err)
+			return
+		}
+		if err := WriteJSON(w, http.StatusOK, cluster.ReadIndexResponse{Index: index}); err != nil {
+			WriteError(w, http.StatusInternalServerError, fmt.Errorf("ошибка при отправке JSON-ответа: %w", err))
+		}
+	}
+}
+
+// @Summary     Raft state of this replica
+// @Tags        Cluster
+// @Produce     json
+// @Success     200 {object} cluster.Status
+// @Router      /cluster/status [get]
+func ServeClusterStatus(node *cluster.Node) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := WriteJSON(w, http.StatusOK, node.Status()); err != nil {
+			WriteError(w, http.StatusInternalServerError, fmt.Errorf("ошибка при отправке JSON-ответа: %w", err))
+		}
+	}
+}
@@ -0,0 +1,214 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"trafficlightAPI/internal/devices"
+	"trafficlightAPI/internal/heartbeat"
+	"trafficlightAPI/internal/storage"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/pkg/errors"
+)
+
+var (
+	ErrDevicesDisabled = errors.New("реестр устройств не настроен")
+)
+
+type DeviceRequest struct {
+	UUID              string            `json:"uuid"` // Для PUT берется из пути
+	Type              int               `json:"type"`
+	Intersection      string            `json:"intersection,omitempty"`
+	Location          *storage.Location `json:"location,omitempty"`
+	Description       string            `json:"description,omitempty"`
+	Tags              []string          `json:"tags,omitempty"`
+	HeartbeatInterval int               `json:"heartbeat_interval,omitempty"` // Ожидаемый интервал опроса, с
+}
+
+func (d DeviceRequest) device() storage.Device {
+	return storage.Device{
+		UUID:              d.UUID,
+		Type:              d.Type,
+		Intersection:      d.Intersection,
+		Location:          d.Location,
+		Description:       d.Description,
+		Tags:              d.Tags,
+		HeartbeatInterval: d.HeartbeatInterval,
+	}
+}
+
+func deviceError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, devices.ErrInvalidDevice):
+		WriteError(w, http.StatusBadRequest, err)
+	case errors.Is(err, devices.ErrDeviceNotFound):
+		WriteError(w, http.StatusNotFound, err)
+	case errors.Is(err, devices.ErrDeviceExists):
+		WriteError(w, http.StatusConflict, err)
+	default:
+		WriteError(w, http.StatusInternalServerError, err)
+	}
+}
+
+// @Summary     Register a device with its trafficlight type, intersection, location and tags
+// @Tags        Devices
+// @Accept      json
+// @Produce     json
+// @Param       body body     handlers.DeviceRequest true "Device"
+// @Success     201  {object} storage.Device
+// @Failure     400  {object} models.ErrorResponse "Invalid request data"
+// @Failure     409  {object} models.ErrorResponse "Device already registered"
+// @Failure     503  {object} models.ErrorResponse "Registry disabled"
+// @Router      /devices [post]
+func ServeDeviceCreate(w http.ResponseWriter, r *http.Request) {
+	registry := devices.Default()
+	if registry == nil {
+		WriteError(w, http.StatusServiceUnavailable, ErrDevicesDisabled)
+		return
+	}
+
+	var request DeviceRequest
+	if err := ParseJSON(r, &request); err != nil {
+		WriteError(w, http.StatusBadRequest, ErrUnmarshalingFromBody, err)
+		return
+	}
+	defer r.Body.Close()
+
+	device, err := registry.Create(request.device())
+	if err != nil {
+		deviceError(w, err)
+		return
+	}
+	if monitor := heartbeat.Default(); monitor != nil {
+		monitor.Expect(device.UUID, device.Type, device.CreatedAt)
+	}
+	if err := WriteJSON(w, http.StatusCreated, device); err != nil {
+		WriteError(w, http.StatusInternalServerError, fmt.Errorf("ошибка при отправке JSON-ответа: %w", err))
+	}
+}
+
+// @Summary     Registered devices
+// @Tags        Devices
+// @Produce     json
+// @Param       type         query    int    false "Trafficlight type"
+// @Param       intersection query    string false "Intersection"
+// @Param       tag          query    string false "Tag"
+// @Success     200          {array}  storage.Device
+// @Failure     400          {object} models.ErrorResponse "Invalid request data"
+// @Failure     503          {object} models.ErrorResponse "Registry disabled"
+// @Router      /devices [get]
+func ServeDeviceList(w http.ResponseWriter, r *http.Request) {
+	registry := devices.Default()
+	if registry == nil {
+		WriteError(w, http.StatusServiceUnavailable, ErrDevicesDisabled)
+		return
+	}
+
+	query := r.URL.Query()
+	filter := devices.Filter{Intersection: query.Get("intersection"), Tag: query.Get("tag")}
+	if query.Has("type") {
+		trafficType, err := ParseTrafficType(query.Get("type"))
+		if err != nil {
+			WriteError(w, http.StatusBadRequest, ErrInvalidTrafficlightType, err)
+			return
+		}
+		filter.Type = trafficType
+	}
+
+	if err := WriteJSON(w, http.StatusOK, registry.List(filter)); err != nil {
+		WriteError(w, http.StatusInternalServerError, fmt.Errorf("ошибка при отправке JSON-ответа: %w", err))
+	}
+}
+
+// @Summary     Registered device
+// @Tags        Devices
+// @Produce     json
+// @Param       uuid path     string true "Device UUID"
+// @Success     200  {object} storage.Device
+// @Failure     404  {object} models.ErrorResponse "Device not registered"
+// @Failure     503  {object} models.ErrorResponse "Registry disabled"
+// @Router      /devices/{uuid} [get]
+func ServeDeviceGet(w http.ResponseWriter, r *http.Request) {
+	registry := devices.Default()
+	if registry == nil {
+		WriteError(w, http.StatusServiceUnavailable, ErrDevicesDisabled)
+		return
+	}
+
+	uuid := chi.URLParam(r, "uuid")
+	device, ok := registry.Get(uuid)
+	if !ok {
+		WriteError(w, http.StatusNotFound, errors.Wrapf(devices.ErrDeviceNotFound, "uuid:%s", uuid))
+		return
+	}
+	if err := WriteJSON(w, http.StatusOK, device); err != nil {
+		WriteError(w, http.StatusInternalServerError, fmt.Errorf("ошибка при отправке JSON-ответа: %w", err))
+	}
+}
+
+// @Summary     Replace data of a registered device
+// @Tags        Devices
+// @Accept      json
+// @Produce     json
+// @Param       uuid path     string                 true "Device UUID"
+// @Param       body body     handlers.DeviceRequest true "Device"
+// @Success     200  {object} storage.Device
+// @Failure     400  {object} models.ErrorResponse "Invalid request data"
+// @Failure     404  {object} models.ErrorResponse "Device not registered"
+// @Failure     503  {object} models.ErrorResponse "Registry disabled"
+// @Router      /devices/{uuid} [put]
+func ServeDeviceUpdate(w http.ResponseWriter, r *http.Request) {
+	registry := devices.Default()
+	if registry == nil {
+		WriteError(w, http.StatusServiceUnavailable, ErrDevicesDisabled)
+		return
+	}
+
+	var request DeviceRequest
+	if err := ParseJSON(r, &request); err != nil {
+		WriteError(w, http.StatusBadRequest, ErrUnmarshalingFromBody, err)
+		return
+	}
+	defer r.Body.Close()
+
+	uuid := chi.URLParam(r, "uuid")
+	if request.UUID != "" && request.UUID != uuid {
+		WriteError(w, http.StatusBadRequest, devices.ErrInvalidDevice, fmt.Errorf("uuid в теле %s, в пути %s", request.UUID, uuid))
+		return
+	}
+	request.UUID = uuid
+
+	device, err := registry.Update(request.device())
+	if err != nil {
+		deviceError(w, err)
+		return
+	}
+	if err := WriteJSON(w, http.StatusOK, device); err != nil {
+		WriteError(w, http.StatusInternalServerError, fmt.Errorf("ошибка при отправке JSON-ответа: %w", err))
+	}
+}
+
+// @Summary     Unregister a device
+// @Tags        Devices
+// @Param       uuid path     string true "Device UUID"
+// @Success     204
+// @Failure     404  {object} models.ErrorResponse "Device not registered"
+// @Failure     503  {object} models.ErrorResponse "Registry disabled"
+// @Router      /devices/{uuid} [delete]
+func ServeDeviceDelete(w http.ResponseWriter, r *http.Request) {
+	registry := devices.Default()
+	if registry == nil {
+		WriteError(w, http.StatusServiceUnavailable, ErrDevicesDisabled)
+		return
+	}
+
+	uuid := chi.URLParam(r, "uuid")
+	if err := registry.Delete(uuid); err != nil {
+		deviceError(w, err)
+		return
+	}
+	if monitor := heartbeat.Default(); monitor != nil {
+		monitor.Forget(uuid)
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,144 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+	"trafficlightAPI/internal/atspm"
+	"trafficlightAPI/internal/audit"
+	logger "trafficlightAPI/internal/middleware/logger"
+	"trafficlightAPI/internal/middleware/signing"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	ErrEventsDisabled   = errors.New("журнал событий контроллера не настроен")
+	ErrNoUUID           = errors.New("отсутствует параметр uuid")
+	ErrInvalidTimeRange = errors.New("некорректный интервал времени")
+	ErrInvalidEvent     = errors.New("некорректное событие")
+)
+
+type DeviceEvent struct {
+	UUID  string `json:"uuid"`
+	Kind  string `json:"kind"` // detector_on, detector_off, preempt_on, preempt_off, pedestrian_call
+	Param int    `json:"param"`
+}
+
+// @Summary     Record detector actuations, preemption and pedestrian calls reported by a device
+// @Tags        Events
+// @Accept      json
+// @Param       body body     []handlers.DeviceEvent  true "Device events"
+// @Success     204
+// @Failure     400  {object} models.ErrorResponse    "Invalid request data"
+// @Failure     401  {object} models.ErrorResponse    "Invalid device signature"
+// @Failure     403  {object} models.ErrorResponse    "UUID of another device"
+// @Failure     503  {object} models.ErrorResponse    "Event log disabled"
+// @Router      /events [post]
+func ServeEventsIngest(w http.ResponseWriter, r *http.Request) {
+	if atspm.Default() == nil {
+		WriteError(w, http.StatusServiceUnavailable, ErrEventsDisabled)
+		return
+	}
+
+	var request []DeviceEvent
+	if err := ParseJSON(r, &request); err != nil {
+		WriteError(w, http.StatusBadRequest, ErrUnmarshalingFromBody, err)
+		return
+	}
+	defer r.Body.Close()
+
+	now := time.Now()
+	events := make([]atspm.Event, 0, len(request))
+	for _, e := range request {
+		code, err := atspm.DeviceEventCode(e.Kind)
+		if err != nil || e.UUID == "" || e.Param < 0 {
+			WriteError(w, http.StatusBadRequest, ErrInvalidEvent, fmt.Errorf("uuid:%s, kind:%s, param:%d", e.UUID, e.Kind, e.Param), err)
+			return
+		}
+		if err := signing.CheckDevice(r, e.UUID); err != nil {
+			WriteError(w, http.StatusForbidden, err)
+			return
+		}
+		// Время события - время приема: часам устройства нельзя доверять.
+		events = append(events, atspm.Event{SignalID: e.UUID, Timestamp: now, Code: code, Param: e.Param})
+	}
+
+	if err := atspm.Default().Record(events...); err != nil {
+		WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+	for _, e := range events {
+		action := ""
+		switch e.Code {
+		case atspm.CodePreemptCallInputOn:
+			action = audit.ActionPreemptStart
+		case atspm.CodePreemptCallInputOff:
+			action = audit.ActionPreemptEnd
+		}
+		if action != "" {
+			recordAudit(audit.Entry{Time: e.Timestamp, Action: action, UUID: e.SignalID, Details: map[string]any{"channel": e.Param}})
+		}
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// @Summary     Download high-resolution controller events of a trafficlight as CSV
+// @Tags        Events
+// @Produce     text/csv
+// @Param       uuid query    string true  "Trafficlight UUID"
+// @Param       from query    string false "Start of the interval, RFC3339 (default: an hour ago)"
+// @Param       to   query    string false "End of the interval, RFC3339 (default: now)"
+// @Success     200  {string} string               "signal_id,timestamp,event_code,event_param"
+// @Failure     400  {object} models.ErrorResponse "Invalid request data"
+// @Router      /events [get]
+func ServeEventsDownload(w http.ResponseWriter, r *http.Request) {
+	recorder := atspm.Default()
+	if recorder == nil {
+		WriteError(w, http.StatusServiceUnavailable, ErrEventsDisabled)
+		return
+	}
+
+	uuid := r.URL.Query().Get("uuid")
+	if uuid == "" {
+		WriteError(w, http.StatusBadRequest, ErrNoUUID)
+		return
+	}
+	from, to, err := ParseTimeRange(r, time.Hour)
+	if err != nil {
+		WriteError(w, http.StatusBadRequest, ErrInvalidTimeRange, err)
+		return
+	}
+
+	w.Header().Add("Content-Type", "text/csv")
+	w.Header().Add("Content-Disposition", fmt.Sprintf("attachment; filename=%q", fmt.Sprintf("%s_%s.csv", uuid, from.UTC().Format("20060102T150405"))))
+	if err := recorder.WriteCSV(w, uuid, from, to); err != nil {
+		logger.LogError(http.StatusInternalServerError, err, fmt.Errorf("uuid:%s", uuid))
+	}
+}
+
+// ParseTimeRange разбирает параметры from и to (RFC3339); по умолчанию - последний интервал window.
+func ParseTimeRange(r *http.Request, window time.Duration) (time.Time, time.Time, error) {
+	to := time.Now()
+	if s := r.URL.Query().Get("to"); s != "" {
+		t, err := time.Parse(time.RFC3339, s)
+		if err != nil {
+			return time.Time{}, time.Time{}, err
+		}
+		to = t
+	}
+
+	from := to.Add(-window)
+	if s := r.URL.Query().Get("from"); s != "" {
+		t, err := time.Parse(time.RFC3339, s)
+		if err != nil {
+			return time.Time{}, time.Time{}, err
+		}
+		from = t
+	}
+
+	if !from.Before(to) {
+		return time.Time{}, time.Time{}, fmt.Errorf("from:%s, to:%s", from.Format(time.RFC3339), to.Format(time.RFC3339))
+	}
+	return from, to, nil
+}
@@ -0,0 +1,148 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"trafficlightAPI/internal/devices"
+	"trafficlightAPI/internal/faults"
+	"trafficlightAPI/internal/lights"
+	"trafficlightAPI/internal/middleware/signing"
+	"trafficlightAPI/internal/storage"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/pkg/errors"
+)
+
+var (
+	ErrFaultsDisabled = errors.New("учет неисправностей ламп не настроен")
+)
+
+type FaultRequest struct {
+	UUID   string `json:"uuid"`
+	Type   int    `json:"type,omitempty"` // Для зарегистрированных и уже опрашивавших светофоров берется из реестра
+	Lamp   string `json:"lamp"`           // red, yellow, green, arrow
+	Reason string `json:"reason,omitempty"`
+}
+
+func faultError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, faults.ErrInvalidFault):
+		WriteError(w, http.StatusBadRequest, err)
+	case errors.Is(err, faults.ErrFaultNotFound):
+		WriteError(w, http.StatusNotFound, err)
+	default:
+		WriteError(w, http.StatusInternalServerError, err)
+	}
+}
+
+// lightType возвращает тип светофора из реестра устройств или по последнему опросу.
+func lightType(uuid string) int {
+	if device, ok := devices.Lookup(uuid); ok {
+		return device.Type
+	}
+	if registry := lights.Default(); registry != nil {
+		if entry, ok := registry.Get(uuid); ok {
+			return entry.Light.Type
+		}
+	}
+	return 0
+}
+
+// @Summary     Report a failed lamp detected by a field controller
+// @Tags        Faults
+// @Accept      json
+// @Produce     json
+// @Param       body body     handlers.FaultRequest true "Lamp fault"
+// @Success     201  {object} storage.Fault
+// @Failure     400  {object} models.ErrorResponse  "Invalid request data"
+// @Failure     401  {object} models.ErrorResponse  "Invalid device signature"
+// @Failure     403  {object} models.ErrorResponse  "UUID of another device"
+// @Failure     503  {object} models.ErrorResponse  "Fault tracking disabled"
+// @Router      /faults [post]
+func ServeFaultReport(w http.ResponseWriter, r *http.Request) {
+	manager := faults.Default()
+	if manager == nil {
+		WriteError(w, http.StatusServiceUnavailable, ErrFaultsDisabled)
+		return
+	}
+
+	var request FaultRequest
+	if err := ParseJSON(r, &request); err != nil {
+		WriteError(w, http.StatusBadRequest, ErrUnmarshalingFromBody, err)
+		return
+	}
+	defer r.Body.Close()
+
+	if err := signing.CheckDevice(r, request.UUID); err != nil {
+		WriteError(w, http.StatusForbidden, err)
+		return
+	}
+	if known := lightType(request.UUID); known != 0 {
+		if request.Type != 0 && request.Type != known {
+			WriteError(w, http.StatusBadRequest, ErrTypeMismatch, fmt.Errorf("uuid:%s, type:%d, известен тип %d", request.UUID, request.Type, known))
+			return
+		}
+		request.Type = known
+	}
+
+	reportedBy := auditUser(r)
+	if device, ok := signing.DeviceFromContext(r.Context()); ok {
+		reportedBy = "device:" + device
+	}
+	fault, err := manager.Report(storage.Fault{
+		UUID:       request.UUID,
+		Type:       request.Type,
+		Lamp:       request.Lamp,
+		Reason:     request.Reason,
+		ReportedBy: reportedBy,
+	})
+	if err != nil {
+		faultError(w, err)
+		return
+	}
+	if err := WriteJSON(w, http.StatusCreated, fault); err != nil {
+		WriteError(w, http.StatusInternalServerError, fmt.Errorf("ошибка при отправке JSON-ответа: %w", err))
+	}
+}
+
+// @Summary     Reported lamp faults
+// @Tags        Faults
+// @Produce     json
+// @Param       uuid query    string false "Trafficlight UUID"
+// @Success     200  {array}  storage.Fault
+// @Failure     503  {object} models.ErrorResponse "Fault tracking disabled"
+// @Router      /faults [get]
+func ServeFaultList(w http.ResponseWriter, r *http.Request) {
+	manager := faults.Default()
+	if manager == nil {
+		WriteError(w, http.StatusServiceUnavailable, ErrFaultsDisabled)
+		return
+	}
+	if err := WriteJSON(w, http.StatusOK, manager.List(r.URL.Query().Get("uuid"))); err != nil {
+		WriteError(w, http.StatusInternalServerError, fmt.Errorf("ошибка при отправке JSON-ответа: %w", err))
+	}
+}
+
+// @Summary     Clear a lamp fault after repair
+// @Tags        Faults
+// @Param       uuid path     string true "Trafficlight UUID"
+// @Param       lamp path     string true "Lamp" Enums(red, yellow, green, arrow)
+// @Success     204
+// @Failure     404  {object} models.ErrorResponse "Fault not found"
+// @Failure     503  {object} models.ErrorResponse "Fault tracking disabled"
+// @Failure     401  {object} models.ErrorResponse "Not authenticated"
+// @Failure     403  {object} models.ErrorResponse "Insufficient role"
+// @Router      /faults/{uuid}/{lamp} [delete]
+func ServeFaultClear(w http.ResponseWriter, r *http.Request) {
+	manager := faults.Default()
+	if manager == nil {
+		WriteError(w, http.StatusServiceUnavailable, ErrFaultsDisabled)
+		return
+	}
+
+	if err := manager.Clear(chi.URLParam(r, "uuid"), chi.URLParam(r, "lamp"), auditUser(r)); err != nil {
+		faultError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,230 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"trafficlightAPI/internal/devices"
+	"trafficlightAPI/internal/geo"
+	"trafficlightAPI/internal/lights"
+	"trafficlightAPI/internal/storage"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	ErrInvalidCoordinates = errors.New("некорректные параметры координат")
+	ErrNoLightAhead       = errors.New("светофор в заданном направлении не найден")
+)
+
+const (
+	defaultHeadingTolerance = 45.0   // градусы
+	defaultAheadDistance    = 1000.0 // м
+	defaultAheadMinDistance = 1.0    // м
+)
+
+// GeoDevice - устройство с расстоянием до точки запроса и последним состоянием.
+type GeoDevice struct {
+	Device   storage.Device `json:"device"`
+	Distance *float64       `json:"distance_m,omitempty"`
+	State    *storage.State `json:"state,omitempty"`
+}
+
+// floatParams разбирает обязательные и необязательные (со значением по умолчанию) параметры.
+func floatParams(query url.Values, required []string, optional map[string]float64) (map[string]float64, error) {
+	values := make(map[string]float64, len(required)+len(optional))
+	for name, def := range optional {
+		values[name] = def
+		if s := query.Get(name); s != "" {
+			v, err := strconv.ParseFloat(s, 64)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", name, err)
+			}
+			values[name] = v
+		}
+	}
+	for _, name := range required {
+		s := query.Get(name)
+		if s == "" {
+			return nil, fmt.Errorf("отсутствует параметр %s", name)
+		}
+		v, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		values[name] = v
+	}
+	return values, nil
+}
+
+func geoDevices(registry *devices.Registry, hits []geo.Hit, withDistance bool) []GeoDevice {
+	states := lights.Default()
+	result := make([]GeoDevice, 0, len(hits))
+	for _, hit := range hits {
+		device, ok := registry.Get(hit.UUID)
+		if !ok {
+			continue
+		}
+		item := GeoDevice{Device: device}
+		if withDistance {
+			distance := hit.Distance
+			item.Distance = &distance
+		}
+		if states != nil {
+			if entry, ok := states.Get(hit.UUID); ok {
+				item.State = entry.State
+			}
+		}
+		result = append(result, item)
+	}
+	return result
+}
+
+func writeGeoDevices(w http.ResponseWriter, hits []geo.Hit, err error, withDistance bool) {
+	if err != nil {
+		WriteError(w, http.StatusBadRequest, ErrInvalidCoordinates, err)
+		return
+	}
+	if err := WriteJSON(w, http.StatusOK, geoDevices(devices.Default(), hits, withDistance)); err != nil {
+		WriteError(w, http.StatusInternalServerError, fmt.Errorf("ошибка при отправке JSON-ответа: %w", err))
+	}
+}
+
+// @Summary     Registered lights within a radius, nearest first
+// @Tags        Geo
+// @Produce     json
+// @Param       lat    query    number true "Latitude of the center"
+// @Param       lon    query    number true "Longitude of the center"
+// @Param       radius query    number true "Radius in meters"
+// @Success     200    {array}  handlers.GeoDevice
+// @Failure     400    {object} models.ErrorResponse "Invalid request data"
+// @Failure     503    {object} models.ErrorResponse "Registry disabled"
+// @Router      /geo/radius [get]
+func ServeGeoRadius(w http.ResponseWriter, r *http.Request) {
+	registry := devices.Default()
+	if registry == nil {
+		WriteError(w, http.StatusServiceUnavailable, ErrDevicesDisabled)
+		return
+	}
+	params, err := floatParams(r.URL.Query(), []string{"lat", "lon", "radius"}, nil)
+	if err != nil {
+		WriteError(w, http.StatusBadRequest, ErrInvalidCoordinates, err)
+		return
+	}
+
+	hits, err := registry.Geo().Within(storage.Location{Lat: params["lat"], Lon: params["lon"]}, params["radius"])
+	writeGeoDevices(w, hits, err, true)
+}
+
+// @Summary     Registered lights within a bounding box
+// @Tags        Geo
+// @Produce     json
+// @Param       min_lat query    number true "South edge"
+// @Param       min_lon query    number true "West edge"
+// @Param       max_lat query    number true "North edge"
+// @Param       max_lon query    number true "East edge"
+// @Success     200     {array}  handlers.GeoDevice
+// @Failure     400     {object} models.ErrorResponse "Invalid request data"
+// @Failure     503     {object} models.ErrorResponse "Registry disabled"
+// @Router      /geo/bbox [get]
+func ServeGeoBBox(w http.ResponseWriter, r *http.Request) {
+	registry := devices.Default()
+	if registry == nil {
+		WriteError(w, http.StatusServiceUnavailable, ErrDevicesDisabled)
+		return
+	}
+	params, err := floatParams(r.URL.Query(), []string{"min_lat", "min_lon", "max_lat", "max_lon"}, nil)
+	if err != nil {
+		WriteError(w, http.StatusBadRequest, ErrInvalidCoordinates, err)
+		return
+	}
+
+	hits, err := registry.Geo().InBox(geo.Box{MinLat: params["min_lat"], MinLon: params["min_lon"], MaxLat: params["max_lat"], MaxLon: params["max_lon"]})
+	writeGeoDevices(w, hits, err, false)
+}
+
+// @Summary     Nearest registered light ahead along a heading
+// @Tags        Geo
+// @Produce     json
+// @Param       lat          query    number true  "Latitude of the vehicle"
+// @Param       lon          query    number true  "Longitude of the vehicle"
+// @Param       heading      query    number true  "Heading in degrees clockwise from north, [0, 360)"
+// @Param       tolerance    query    number false "Max deviation from the heading in degrees (default 45)"
+// @Param       max_distance query    number false "Search distance in meters (default 1000)"
+// @Success     200          {object} handlers.GeoDevice
+// @Failure     400          {object} models.ErrorResponse "Invalid request data"
+// @Failure     404          {object} models.ErrorResponse "No light ahead"
+// @Failure     503          {object} models.ErrorResponse "Registry disabled"
+// @Router      /geo/nearest [get]
+func ServeGeoNearest(w http.ResponseWriter, r *http.Request) {
+	registry := devices.Default()
+	if registry == nil {
+		WriteError(w, http.StatusServiceUnavailable, ErrDevicesDisabled)
+		return
+	}
+	params, err := floatParams(r.URL.Query(), []string{"lat", "lon", "heading"}, map[string]float64{
+		"tolerance":    defaultHeadingTolerance,
+		"max_distance": defaultAheadDistance,
+	})
+	if err != nil {
+		WriteError(w, http.StatusBadRequest, ErrInvalidCoordinates, err)
+		return
+	}
+
+	from := storage.Location{Lat: params["lat"], Lon: params["lon"]}
+	hit, ok, err := registry.Geo().Ahead(from, params["heading"], params["tolerance"], defaultAheadMinDistance, params["max_distance"])
+	if err != nil {
+		WriteError(w, http.StatusBadRequest, ErrInvalidCoordinates, err)
+		return
+	}
+	found := geoDevices(registry, []geo.Hit{hit}, true)
+	if !ok || len(found) == 0 {
+		WriteError(w, http.StatusNotFound, ErrNoLightAhead)
+		return
+	}
+	if err := WriteJSON(w, http.StatusOK, found[0]); err != nil {
+		WriteError(w, http.StatusInternalServerError, fmt.Errorf("ошибка при отправке JSON-ответа: %w", err))
+	}
+}
+
+// @Summary     Registered lights with coordinates and their live state as a GeoJSON FeatureCollection
+// @Tags        Geo
+// @Produce     json
+// @Success     200 {object} geo.FeatureCollection
+// @Failure     503 {object} models.ErrorResponse "Registry disabled"
+// @Router      /geo/lights [get]
+func ServeGeoJSON(w http.ResponseWriter, r *http.Request) {
+	registry := devices.Default()
+	if registry == nil {
+		WriteError(w, http.StatusServiceUnavailable, ErrDevicesDisabled)
+		return
+	}
+
+	states := lights.Default()
+	var features []geo.Feature
+	for _, d := range registry.List(devices.Filter{}) {
+		if d.Location == nil {
+			continue
+		}
+		properties := map[string]any{
+			"uuid":         d.UUID,
+			"type":         d.Type,
+			"intersection": d.Intersection,
+			"description":  d.Description,
+			"tags":         d.Tags,
+		}
+		if states != nil {
+			if entry, ok := states.Get(d.UUID); ok && entry.State != nil {
+				properties["state"] = entry.State.State
+				properties["mode"] = entry.State.Mode
+				properties["since"] = entry.State.Since
+			}
+		}
+		features = append(features, geo.NewFeature(d.UUID, *d.Location, properties))
+	}
+
+	if err := WriteJSON(w, http.StatusOK, geo.NewFeatureCollection(features)); err != nil {
+		WriteError(w, http.StatusInternalServerError, fmt.Errorf("ошибка при отправке JSON-ответа: %w", err))
+	}
+}
@@ -1,13 +1,37 @@
 package handlers
 
 import (
+	"context"
+	"encoding/hex"
 	"fmt"
 	"log/slog"
+	"net"
 	"net/http"
+	"slices"
 	"time"
 	_ "trafficlightAPI/docs"
+	"trafficlightAPI/internal/atspm"
+	"trafficlightAPI/internal/audit"
+	"trafficlightAPI/internal/cluster"
+	"trafficlightAPI/internal/coap"
 	"trafficlightAPI/internal/config"
+	"trafficlightAPI/internal/control"
+	"trafficlightAPI/internal/devices"
+	"trafficlightAPI/internal/faults"
+	"trafficlightAPI/internal/grpcapi"
+	"trafficlightAPI/internal/heartbeat"
+	"trafficlightAPI/internal/history"
+	"trafficlightAPI/internal/lights"
+	"trafficlightAPI/internal/middleware/auth"
+	"trafficlightAPI/internal/middleware/signing"
+	"trafficlightAPI/internal/mmu"
+	"trafficlightAPI/internal/modbus"
 	"trafficlightAPI/internal/models"
+	"trafficlightAPI/internal/mqttapi"
+	"trafficlightAPI/internal/ntcip"
+	"trafficlightAPI/internal/overrides"
+	"trafficlightAPI/internal/storage"
+	"trafficlightAPI/internal/stream"
 
 	"github.com/pkg/errors"
 
@@ -26,21 +50,23 @@ var (
 	ErrUnmarshalingFromQuery   = errors.New("ошибка при разборе JSON из параметра")
 	ErrNoType                  = errors.New("отсутствует параметр type")
 	ErrInvalidTrafficlightType = errors.New("некорректный номер светофора")
+	ErrTypeMismatch            = devices.ErrTypeMismatch
 )
 
 // @Summary     Processing of traffic light control request
 // @Tags        Trafficlight
 // @Accept      json
 // @Produce     json
-// @Param       type query    int                    true "Type of the trafficlight" Enums(1, 2, 3)
+// @Param       type query    int                    false "Type of the trafficlight, required for unregistered devices" Enums(1, 2, 3)
 // @Param       body body     models.TrafficRequest  true "Json request"
 // @Success     200  {object} models.TrafficResponse      "Json response"
 // @Failure     400  {object} models.ErrorResponse        "Invalid request data"
+// @Failure     401  {object} models.ErrorResponse        "Invalid device signature"
+// @Failure     403  {object} models.ErrorResponse        "UUID of another device"
 // @Failure     500  {object} models.ErrorResponse        "Server error"
 // @Router      /trafficlight [post]
 func ServeTrafficRoute(w http.ResponseWriter, r *http.Request) {
 	var request models.TrafficRequest
-	start := time.Now()
 
 	if r.URL.Query().Has("data") {
 		dataJSON := r.URL.Query().Get("data")
@@ -56,75 +82,590 @@ func ServeTrafficRoute(w http.ResponseWriter, r *http.Request) {
 	}
 	defer r.Body.Close()
 
-	trafficTypeStr := r.URL.Query().Get("type")
-	if trafficTypeStr == "" {
-		WriteError(w, http.StatusBadRequest, ErrNoType)
+	// Тип зарегистрированного устройства известен, type можно не передавать.
+	requested := 0
+	if trafficTypeStr := r.URL.Query().Get("type"); trafficTypeStr != "" {
+		trafficType, err := ParseTrafficType(trafficTypeStr)
+		if err != nil {
+			WriteError(w, http.StatusBadRequest, ErrInvalidTrafficlightType, err)
+			return
+		}
+		requested = trafficType
+	}
+
+	response, err := control.NextState(r.Context(), "http", request, requested)
+	switch {
+	case errors.Is(err, devices.ErrTypeRequired):
+		WriteError(w, http.StatusBadRequest, ErrNoType, err)
+		return
+	case errors.Is(err, devices.ErrTypeMismatch):
+		WriteError(w, http.StatusBadRequest, ErrTypeMismatch, err)
+		return
+	case errors.Is(err, control.ErrInvalidType):
+		WriteError(w, http.StatusBadRequest, ErrInvalidTrafficlightType, err)
+		return
+	case errors.Is(err, signing.ErrDevice):
+		WriteError(w, http.StatusForbidden, err)
+		return
+	case err != nil:
+		WriteError(w, http.StatusBadRequest, err)
 		return
 	}
 
-	var trafficType int
+	if err := WriteJSON(w, http.StatusOK, response); err != nil {
+		WriteError(w, http.StatusInternalServerError, fmt.Errorf("ошибка при отправке JSON-ответа: %w. %+v", err, request))
+		return
+	}
+}
+
+func ParseTrafficType(trafficTypeStr string) (int, error) {
 	switch trafficTypeStr {
 	case "1":
-		trafficType = 1
+		return 1, nil
 	case "2":
-		trafficType = 2
+		return 2, nil
 	case "3":
-		trafficType = 3
+		return 3, nil
 	default:
-		WriteError(w, http.StatusBadRequest, ErrInvalidTrafficlightType, fmt.Errorf("номер в запросе: %s", trafficTypeStr))
-		return
+		return 0, fmt.Errorf("номер в запросе: %s", trafficTypeStr)
 	}
+}
 
-	if err := ValidateRequest(request, trafficType); err != nil {
-		WriteError(w, http.StatusBadRequest, err)
-		return
+// Run запускает сервис и блокируется до остановки HTTP сервера.
+// Ошибка запуска уже записана в лог, вызывающий только завершает процесс.
+func Run(cfg *config.Config, logger *slog.Logger) error {
+	if cfg.PlansPath != "" {
+		defs, err := models.LoadDefinitions(cfg.PlansPath)
+		if err == nil {
+			err = models.ApplyDefinitions(defs)
+		}
+		if err != nil {
+			logger.Error(
+				"ошибка при загрузке планов светофоров",
+				slog.String("path", cfg.PlansPath),
+				slog.Any("err", err),
+			)
+			return err
+		}
 	}
 
-	prometheus.RequestedTypes.WithLabelValues(fmt.Sprintf("trafficlight%d", trafficType)).Inc()
-	prometheus.RequestedTotal.Inc()
+	local, err := storage.OpenBolt(cfg.Storage.Path)
+	if err != nil {
+		logger.Error(
+			"ошибка при открытии хранилища",
+			slog.String("path", cfg.Storage.Path),
+			slog.Any("err", err),
+		)
+		return err
+	}
+	defer local.Close()
 
-	imageRequested := "false"
-	if request.NeedImage {
-		imageRequested = "true"
+	var store storage.Store = local
+	var node *cluster.Node
+	if cfg.Cluster.Enabled {
+		node, err = cluster.Open(clusterConfig(cfg.Cluster), local, logger)
+		if err != nil {
+			logger.Error(
+				"ошибка при запуске узла кластера",
+				slog.String("node", cfg.Cluster.NodeID),
+				slog.Any("err", err),
+			)
+			return err
+		}
+		defer node.Close()
+		store = node
 	}
 
-	if imageRequested == "true" {
-		prometheus.ImageRequest.WithLabelValues("image_requested").Inc()
-	} else {
-		prometheus.ImageRequest.WithLabelValues("image_not_requested").Inc()
+	registry := lights.NewRegistry(store, logger)
+	if node != nil {
+		node.OnApply(registry.ApplyMutation)
+		node.OnApply(func(m storage.Mutation) {
+			if m.Op == storage.OpPutPlan && !slices.Equal(models.Plan(m.Type), m.Plan) {
+				if err := models.ApplyPlan(m.Type, m.Plan); err != nil {
+					logger.Error("ошибка применения реплицированного плана", slog.Int("type", m.Type), slog.Any("err", err))
+				}
+			}
+		})
+	}
+	// Восстановление из локальной реплики не ждет выборов лидера.
+	if err := restore(local, registry); err != nil {
+		logger.Error(
+			"ошибка при восстановлении состояния из хранилища",
+			slog.String("path", cfg.Storage.Path),
+			slog.Any("err", err),
+		)
+		return err
+	}
+	registry.RunPersist()
+	defer registry.Close()
+	lights.SetDefault(registry)
+
+	deviceRegistry := devices.NewRegistry(store)
+	if node != nil {
+		node.OnApply(deviceRegistry.ApplyMutation)
+	}
+	if err := deviceRegistry.Restore(local); err != nil {
+		logger.Error(
+			"ошибка при восстановлении реестра устройств",
+			slog.String("path", cfg.Storage.Path),
+			slog.Any("err", err),
+		)
+		return err
+	}
+	devices.SetDefault(deviceRegistry)
+	registry.SetKnown(func(uuid string) bool {
+		_, ok := deviceRegistry.Get(uuid)
+		return ok
+	}, cfg.Storage.MaxUnregistered)
+
+	monitor := heartbeat.NewMonitor(heartbeatConfig(cfg.Heartbeat, cfg.Cluster.NodeID), func(uuid string) time.Duration {
+		device, _ := deviceRegistry.Get(uuid)
+		return time.Duration(device.HeartbeatInterval) * time.Second
+	})
+	// Зарегистрированные устройства, не опросившие сервис после запуска, тоже считаются пропавшими.
+	for _, d := range deviceRegistry.List(devices.Filter{}) {
+		monitor.Expect(d.UUID, d.Type, time.Now())
 	}
+	monitor.OnChange(func(c heartbeat.Change) {
+		level := slog.LevelWarn
+		if c.Device.Status == heartbeat.StatusOnline {
+			level = slog.LevelInfo
+		}
+		logger.Log(context.Background(), level,
+			"изменение связи с устройством",
+			slog.String("uuid", c.Device.UUID),
+			slog.String("from", c.From),
+			slog.String("to", c.Device.Status),
+		)
+	})
+	monitor.RunCheck(cfg.Heartbeat.CheckInterval)
+	defer monitor.Close()
+	heartbeat.SetDefault(monitor)
 
-	responseData, err := models.ManageLights(request, trafficType)
+	auditLog, err := audit.Open(cfg.Audit.Path)
 	if err != nil {
-		WriteError(w, http.StatusBadRequest, err)
-		return
+		logger.Error(
+			"ошибка при открытии журнала аудита",
+			slog.String("path", cfg.Audit.Path),
+			slog.Any("err", err),
+		)
+		return err
 	}
+	defer auditLog.Close()
+	audit.SetDefault(auditLog)
+	registry.OnModeChange(func(t models.Transition, from string) {
+		recordAudit(audit.Entry{
+			Time: t.Time, Action: audit.ActionModeChange, UUID: t.UUID,
+			Details: map[string]any{"from": from, "to": t.Mode, "state": t.To},
+		})
+	})
 
-	duration := float64(time.Since(start).Seconds())
-	prometheus.RequestDuration.WithLabelValues(
-		trafficTypeStr,
-		imageRequested,
-	).Observe(duration)
+	manager := overrides.NewManager(store, auditLog, logger)
+	if err := manager.Restore(local); err != nil {
+		logger.Error(
+			"ошибка при восстановлении ручного управления",
+			slog.String("path", cfg.Storage.Path),
+			slog.Any("err", err),
+		)
+		return err
+	}
+	if node != nil {
+		node.OnApply(manager.ApplyMutation)
+	}
+	manager.RunExpiry(cfg.Overrides.ExpiryInterval)
+	defer manager.Close()
+	overrides.SetDefault(manager)
+	models.SetOverride(manager.Resolve)
+	models.AddPin(manager.Pinned)
 
-	if err := WriteJSON(w, http.StatusOK, responseData); err != nil {
-		WriteError(w, http.StatusInternalServerError, fmt.Errorf("ошибка при отправке JSON-ответа: %w. %+v", err, request))
-		return
+	policy := faultPolicy(cfg.Faults)
+	if err := policy.Validate(); err != nil {
+		logger.Error("ошибка в политике неисправностей ламп", slog.Any("err", err))
+		return err
 	}
-}
+	faultManager := faults.NewManager(store, policy, auditLog, logger)
+	if err := faultManager.Restore(local); err != nil {
+		logger.Error(
+			"ошибка при восстановлении неисправностей ламп",
+			slog.String("path", cfg.Storage.Path),
+			slog.Any("err", err),
+		)
+		return err
+	}
+	if node != nil {
+		node.OnApply(faultManager.ApplyMutation)
+	}
+	faults.SetDefault(faultManager)
+	models.SetFaults(faultManager.Resolve)
+	models.AddPin(faultManager.Pinned)
+
+	if cfg.Monitor.Enabled {
+		matrix, err := mmu.LoadMatrix(cfg.Monitor.MatrixPath)
+		if err != nil {
+			logger.Error(
+				"ошибка загрузки матрицы конфликтов",
+				slog.String("path", cfg.Monitor.MatrixPath),
+				slog.Any("err", err),
+			)
+			return err
+		}
+		monitor := mmu.NewMonitor(mmu.Config{
+			ReportTTL:  cfg.Monitor.ReportTTL,
+			StuckAfter: cfg.Monitor.StuckAfter,
+			MinYellow:  cfg.Monitor.MinYellow,
+		}, matrix, store, auditLog, logger)
+		monitor.SetCommanded(func(uuid string) (int, int, bool) {
+			entry, ok := registry.Get(uuid)
+			if !ok || entry.State == nil {
+				return 0, 0, false
+			}
+			return entry.State.Type, entry.State.State, true
+		})
+		monitor.SetKnownFault(faultManager.Has)
+		if err := monitor.Restore(local); err != nil {
+			logger.Error(
+				"ошибка при восстановлении аварий монитора конфликтов",
+				slog.String("path", cfg.Storage.Path),
+				slog.Any("err", err),
+			)
+			return err
+		}
+		if node != nil {
+			node.OnApply(monitor.ApplyMutation)
+		}
+		mmu.SetDefault(monitor)
+		models.SetFailsafe(monitor.Resolve)
+		models.AddPin(monitor.Pinned)
+	}
+	models.OnTransition(registry.Observe)
+	models.OnPlanChange(func(trafficType int, plan []int) {
+		// План, пришедший из журнала кластера, уже сохранен.
+		if stored, err := local.Plans(); err == nil && slices.Equal(stored[trafficType], plan) {
+			return
+		}
+		if err := store.PutPlan(trafficType, plan); err != nil {
+			logger.Error("ошибка сохранения плана", slog.Int("type", trafficType), slog.Any("err", err))
+		}
+	})
+
+	recorder, err := atspm.NewRecorder(cfg.Events.Dir, cfg.Events.MaxSizeMB<<20, cfg.Events.MaxAge, logger)
+	if err != nil {
+		logger.Error(
+			"ошибка при открытии журнала событий контроллера",
+			slog.String("dir", cfg.Events.Dir),
+			slog.Any("err", err),
+		)
+		return err
+	}
+	defer recorder.Close()
+	recorder.RunWriter()
+	atspm.SetDefault(recorder)
+	models.OnTransition(atspm.RecordTransition)
+
+	states, err := history.Open(cfg.History.Path, cfg.History.MaxAge, cfg.History.MaxTransitions, logger)
+	if err != nil {
+		logger.Error(
+			"ошибка при открытии истории состояний",
+			slog.String("path", cfg.History.Path),
+			slog.Any("err", err),
+		)
+		return err
+	}
+	defer states.Close()
+	states.RunRetention(cfg.History.PruneInterval)
+	states.RunWriter()
+	history.SetDefault(states)
+	phases := history.NewPhases()
+	models.OnTransition(func(t models.Transition) {
+		// История ведется только для устройств из реестра, без повторов одной фазы.
+		if _, ok := deviceRegistry.Get(t.UUID); !ok || phases.Repeat(t) {
+			return
+		}
+		if !states.Enqueue(t) {
+			logger.Warn("очередь истории состояний переполнена, смена не записана", slog.String("uuid", t.UUID))
+		}
+	})
+
+	hub := stream.NewHub(stream.Config{Buffer: cfg.Stream.Buffer, MaxDrops: cfg.Stream.MaxDrops}, func(uuid string) string {
+		device, _ := deviceRegistry.Get(uuid)
+		return device.Intersection
+	})
+	defer hub.Close()
+	stream.SetDefault(hub)
+	models.OnTransition(hub.Publish)
+
+	authn, err := auth.New(authConfig(cfg.Auth), WriteError)
+	if err != nil {
+		logger.Error("ошибка настройки аутентификации", slog.Any("err", err))
+		return err
+	}
+	viewer := authn.Require(auth.RoleViewer)
+	operator := authn.Require(auth.RoleOperator)
+	engineer := authn.Require(auth.RoleEngineer)
+	admin := authn.Require(auth.RoleAdmin)
 
-func Run(cfg *config.Config, logger *slog.Logger) {
 	router := chi.NewRouter()
 
 	router.Use(prometheus.ResponseTimeMiddleware)
 
-	router.Get("/trafficlight", ServeTrafficRoute)
+	verifier, err := newVerifier(cfg.Signing)
+	if err != nil {
+		logger.Error(
+			"ошибка загрузки секретов устройств",
+			slog.String("path", cfg.Signing.SecretsPath),
+			slog.Any("err", err),
+		)
+		return err
+	}
+
+	// Запросы устройств подписываются секретом устройства вместо аутентификации.
+	router.With(verifier.Verify).Get("/trafficlight", ServeTrafficRoute)
+	router.With(verifier.Verify).Post("/events", ServeEventsIngest)
+	router.With(verifier.Verify).Post("/faults", ServeFaultReport)
+	router.With(verifier.Verify).Post("/monitor/outputs", ServeMonitorOutputs)
+
+	router.With(engineer).Post("/plans/webster", ServeWebsterRoute(cfg.Webster))
+	router.With(viewer).Get("/plans/sumo", ServeSUMOExport)
+	router.With(engineer).Post("/plans/sumo", ServeSUMOImport)
+
+	router.With(viewer).Get("/events", ServeEventsDownload)
+	router.With(viewer).Get("/reports", ServeReport)
+	router.With(viewer).Get("/reports/pcd", ServePurdueDiagram)
+	router.With(viewer).Get("/history", ServeHistory)
+	router.With(viewer).Get("/lights", ServeLights)
+	router.With(engineer).Post("/devices", ServeDeviceCreate)
+	router.With(viewer).Get("/devices", ServeDeviceList)
+	router.With(viewer).Get("/devices/{uuid}", ServeDeviceGet)
+	router.With(engineer).Put("/devices/{uuid}", ServeDeviceUpdate)
+	router.With(engineer).Delete("/devices/{uuid}", ServeDeviceDelete)
+	router.With(viewer).Get("/heartbeats", ServeHeartbeats)
+	router.With(viewer).Get("/heartbeats/stale", ServeStaleDevices)
+	router.With(viewer).Get("/geo/radius", ServeGeoRadius)
+	router.With(viewer).Get("/geo/bbox", ServeGeoBBox)
+	router.With(viewer).Get("/geo/nearest", ServeGeoNearest)
+	router.With(viewer).Get("/geo/lights", ServeGeoJSON)
+	router.With(viewer).Get("/stream/sse", ServeStreamSSE(cfg.Stream, cfg.Server.Timeout, logger))
+	router.With(viewer).Get("/stream/ws", ServeStreamWS(cfg.Stream, cfg.Server.Timeout, logger))
+	router.With(viewer).Get("/stream/connections", ServeStreamConnections)
+	router.With(viewer).Get("/faults", ServeFaultList)
+	router.With(operator).Delete("/faults/{uuid}/{lamp}", ServeFaultClear)
+	router.With(viewer).Get("/monitor", ServeMonitorFaults)
+	router.With(operator).Post("/monitor/{intersection}/reset", ServeMonitorReset)
+	router.With(operator).Post("/overrides", ServeOverrideCreate(cfg.Overrides))
+	router.With(viewer).Get("/overrides", ServeOverrideList)
+	router.With(operator).Delete("/overrides/{id}", ServeOverrideRelease)
+	if node != nil {
+		router.With(admin).Post(cluster.ApplyPath, ServeClusterApply(node))
+		router.With(admin).Get(cluster.ReadIndexPath, ServeClusterReadIndex(node))
+		router.With(viewer).Get("/cluster/status", ServeClusterStatus(node))
+	}
 
-	router.Get("/metrics", promhttp.InstrumentHandlerCounter(
+	router.With(viewer).Get("/metrics", promhttp.InstrumentHandlerCounter(
 		prometheus.RequestedTypes.MustCurryWith(promm.Labels{"type": "metrics"}),
 		promhttp.Handler(),
 	).ServeHTTP)
 
-	router.Get("/docs/*", httpSwagger.WrapHandler.ServeHTTP)
+	router.With(viewer).Get("/docs/*", httpSwagger.WrapHandler.ServeHTTP)
+
+	if cfg.GRPC.Enabled {
+		lis, err := net.Listen("tcp", cfg.GRPC.Address)
+		if err != nil {
+			logger.Error(
+				"ошибка при запуске gRPC сервера",
+				slog.String("address", cfg.GRPC.Address),
+				slog.Any("err", err),
+			)
+			return err
+		}
+		grpcServer := grpcapi.New(grpcapi.Config{MaxBatch: cfg.GRPC.MaxBatch, KeepAlive: cfg.GRPC.KeepAlive}, authn, verifier, logger)
+		defer grpcServer.Stop()
+		go func() {
+			logger.Info("запуск gRPC сервера", slog.String("address", cfg.GRPC.Address))
+			if err := grpcServer.Serve(lis); err != nil {
+				logger.Error("ошибка gRPC сервера", slog.Any("err", err))
+			}
+		}()
+	}
+
+	if cfg.CoAP.Enabled {
+		conn, err := net.ListenPacket("udp", cfg.CoAP.Address)
+		if err != nil {
+			logger.Error(
+				"ошибка при запуске CoAP сервера",
+				slog.String("address", cfg.CoAP.Address),
+				slog.Any("err", err),
+			)
+			return err
+		}
+		if cfg.Auth.Enabled {
+			logger.Warn("CoAP работает без DTLS, API ключи наблюдателей передаются открыто, ограничьте доступ к порту сетью", slog.String("address", cfg.CoAP.Address))
+		}
+		coapServer := coap.NewServer(coap.Config{MaxObservers: cfg.CoAP.MaxObservers, ConfirmEvery: cfg.CoAP.ConfirmEvery}, authn, verifier, logger)
+		defer coapServer.Close()
+		go func() {
+			logger.Info("запуск CoAP сервера", slog.String("address", cfg.CoAP.Address))
+			if err := coapServer.Serve(conn); err != nil {
+				logger.Error("ошибка CoAP сервера", slog.Any("err", err))
+			}
+		}()
+	}
+
+	if cfg.Modbus.Enabled {
+		modbusServer, err := modbus.NewServer(modbus.Config{
+			Lights:          cfg.Modbus.Lights,
+			BlockSize:       cfg.Modbus.BlockSize,
+			Registers:       modbus.RegisterMap(cfg.Modbus.Registers),
+			CoilBlockSize:   cfg.Modbus.CoilBlockSize,
+			Coils:           modbus.CoilMap(cfg.Modbus.Coils),
+			Commands:        cfg.Modbus.Commands,
+			CommandDuration: min(cfg.Modbus.CommandDuration, cfg.Overrides.MaxDuration),
+			AuthEnabled:     cfg.Auth.Enabled,
+			MaxConnections:  cfg.Modbus.MaxConnections,
+			IdleTimeout:     cfg.Modbus.IdleTimeout,
+		}, logger)
+		if err != nil {
+			logger.Error("ошибка настройки Modbus", slog.Any("err", err))
+			return err
+		}
+		lis, err := net.Listen("tcp", cfg.Modbus.Address)
+		if err != nil {
+			logger.Error(
+				"ошибка при запуске Modbus сервера",
+				slog.String("address", cfg.Modbus.Address),
+				slog.Any("err", err),
+			)
+			return err
+		}
+		if cfg.Modbus.Commands {
+			logger.Warn("команды Modbus выполняются без аутентификации, ограничьте доступ к порту сетью", slog.String("address", cfg.Modbus.Address))
+		}
+		defer modbusServer.Close()
+		go func() {
+			logger.Info("запуск Modbus сервера", slog.String("address", cfg.Modbus.Address))
+			if err := modbusServer.Serve(lis); err != nil {
+				logger.Error("ошибка Modbus сервера", slog.Any("err", err))
+			}
+		}()
+	}
+
+	if cfg.SNMP.Enabled {
+		engineID, err := hex.DecodeString(cfg.SNMP.EngineID)
+		if err != nil {
+			logger.Error("ошибка настройки SNMP", slog.String("engine_id", cfg.SNMP.EngineID), slog.Any("err", err))
+			return err
+		}
+		boots, err := ntcip.NextBoots(cfg.SNMP.BootsPath)
+		if err != nil {
+			logger.Error("ошибка счетчика перезапусков SNMP", slog.String("path", cfg.SNMP.BootsPath), slog.Any("err", err))
+			return err
+		}
+		users := make([]ntcip.User, len(cfg.SNMP.Users))
+		for i, u := range cfg.SNMP.Users {
+			users[i] = ntcip.User(u)
+		}
+		agent, err := ntcip.New(ntcip.Config{
+			Description:     "trafficlightAPI, NTCIP 1202",
+			Phases:          cfg.SNMP.Phases,
+			EngineID:        engineID,
+			Boots:           boots,
+			Users:           users,
+			CommandDuration: min(cfg.SNMP.CommandDuration, cfg.Overrides.MaxDuration),
+		}, authn, logger)
+		if err != nil {
+			logger.Error("ошибка настройки SNMP", slog.Any("err", err))
+			return err
+		}
+		conn, err := net.ListenPacket("udp", cfg.SNMP.Address)
+		if err != nil {
+			logger.Error(
+				"ошибка при запуске SNMP агента",
+				slog.String("address", cfg.SNMP.Address),
+				slog.Any("err", err),
+			)
+			return err
+		}
+		if !cfg.Auth.Enabled {
+			logger.Warn("аутентификация выключена, SNMP v2c отдает данные с любой community", slog.String("address", cfg.SNMP.Address))
+		}
+		defer agent.Close()
+		go func() {
+			logger.Info("запуск SNMP агента", slog.String("address", cfg.SNMP.Address))
+			if err := agent.Serve(conn); err != nil {
+				logger.Error("ошибка SNMP агента", slog.Any("err", err))
+			}
+		}()
+	}
+
+	if cfg.MQTT.Enabled {
+		var broker mqttapi.Broker
+		address := cfg.MQTT.Broker
+		if cfg.MQTT.Broker == "" {
+			embedded, err := mqttapi.NewEmbedded(cfg.MQTT.Address, cfg.MQTT.Prefix, cfg.MQTT.QoS, authn, logger)
+			if err != nil {
+				logger.Error("ошибка настройки MQTT", slog.Any("err", err))
+				return err
+			}
+			if err := embedded.Serve(); err != nil {
+				logger.Error(
+					"ошибка при запуске MQTT брокера",
+					slog.String("address", cfg.MQTT.Address),
+					slog.Any("err", err),
+				)
+				return err
+			}
+			if !cfg.Auth.Enabled {
+				logger.Warn("аутентификация выключена, MQTT брокер открыт для подписки и команд", slog.String("address", cfg.MQTT.Address))
+			}
+			broker, address = embedded, embedded.Address()
+		} else {
+			// Брокер отключает клиента при подключении другого с тем же ID,
+			// поэтому узлы кластера подключаются под своими ID.
+			clientID := cfg.MQTT.ClientID
+			if cfg.Cluster.Enabled {
+				clientID += "-" + cfg.Cluster.NodeID
+			}
+			external, err := mqttapi.NewExternal(cfg.MQTT.Broker, clientID, cfg.MQTT.Username, cfg.MQTT.Password, cfg.MQTT.ShareGroup, cfg.MQTT.QoS, logger)
+			if err != nil {
+				logger.Error("ошибка при подключении к MQTT брокеру", slog.String("broker", cfg.MQTT.Broker), slog.Any("err", err))
+				return err
+			}
+			if cfg.MQTT.Commands && !cfg.Auth.Enabled {
+				logger.Warn("аутентификация выключена, команды MQTT из внешнего брокера выполняются без проверки", slog.String("broker", cfg.MQTT.Broker))
+			}
+			broker = external
+		}
+		// Внешний брокер не сообщает автора команды, при включенной
+		// аутентификации команды подписываются секретами клиентов.
+		var commandVerifier *signing.Verifier
+		if cfg.MQTT.Commands && cfg.MQTT.Broker != "" && cfg.Auth.Enabled {
+			secrets, err := signing.LoadSecrets(cfg.MQTT.SecretsPath)
+			if err != nil {
+				logger.Error("ошибка загрузки секретов клиентов MQTT", slog.String("path", cfg.MQTT.SecretsPath), slog.Any("err", err))
+				broker.Close()
+				return err
+			}
+			commandVerifier = signing.New(signing.Config{
+				Enabled:    true,
+				Secrets:    secrets,
+				MaxSkew:    cfg.Signing.MaxSkew,
+				NonceCache: cfg.Signing.NonceCache,
+			}, WriteError)
+		}
+		defer broker.Close()
+		bridge := mqttapi.New(mqttapi.Config{
+			Prefix:          cfg.MQTT.Prefix,
+			Commands:        cfg.MQTT.Commands,
+			DefaultDuration: cfg.Overrides.DefaultDuration,
+			MaxDuration:     cfg.Overrides.MaxDuration,
+		}, broker, authn, commandVerifier, logger)
+		defer bridge.Close()
+		go func() {
+			logger.Info("запуск публикации MQTT", slog.String("broker", address), slog.String("prefix", cfg.MQTT.Prefix))
+			if err := bridge.Serve(); err != nil {
+				logger.Error("ошибка публикации MQTT", slog.Any("err", err))
+			}
+		}()
+	}
 
 	srv := &http.Server{
 		Addr:         cfg.Server.Address,
@@ -145,5 +686,92 @@ func Run(cfg *config.Config, logger *slog.Logger) {
 			"ошибка при запуске HTTP сервера",
 			slog.Any("err", err),
 		)
+		return err
+	}
+	return nil
+}
+
+// restore применяет сохраненные планы поверх plans_path и загружает светофоры с их последними состояниями.
+func restore(store storage.Store, registry *lights.Registry) error {
+	plans, err := store.Plans()
+	if err != nil {
+		return err
+	}
+	for trafficType, plan := range plans {
+		if err := models.ApplyPlan(trafficType, plan); err != nil {
+			return err
+		}
+	}
+	return registry.Restore(store)
+}
+
+// faultPolicy возвращает политику из конфига или политику по умолчанию.
+func faultPolicy(cfg config.Faults) faults.Policy {
+	if len(cfg.Policy) == 0 {
+		return faults.DefaultPolicy()
+	}
+	policy := make(faults.Policy, len(cfg.Policy))
+	for i, r := range cfg.Policy {
+		policy[i] = faults.Rule{Type: r.Type, Lamp: r.Lamp, Action: r.Action}
+	}
+	return policy
+}
+
+func heartbeatConfig(cfg config.Heartbeat, node string) heartbeat.Config {
+	return heartbeat.Config{
+		DefaultInterval: cfg.DefaultInterval,
+		TypeIntervals:   cfg.TypeIntervals,
+		DegradedMisses:  cfg.DegradedMisses,
+		OfflineMisses:   cfg.OfflineMisses,
+		ForgetAfter:     cfg.ForgetAfter,
+		Node:            node,
+	}
+}
+
+func clusterConfig(cfg config.Cluster) cluster.Config {
+	peers := make([]cluster.Peer, len(cfg.Peers))
+	for i, p := range cfg.Peers {
+		peers[i] = cluster.Peer{ID: p.ID, RaftAddress: p.RaftAddress, HTTPAddress: p.HTTPAddress}
+	}
+	return cluster.Config{
+		NodeID:          cfg.NodeID,
+		RaftAddress:     cfg.RaftAddress,
+		Dir:             cfg.Dir,
+		Bootstrap:       cfg.Bootstrap,
+		Peers:           peers,
+		ReadConsistency: cfg.ReadConsistency,
+		ApplyTimeout:    cfg.ApplyTimeout,
+		APIKey:          cfg.APIKey,
+	}
+}
+
+func newVerifier(cfg config.Signing) (*signing.Verifier, error) {
+	var secrets map[string][]byte
+	if cfg.Enabled {
+		var err error
+		if secrets, err = signing.LoadSecrets(cfg.SecretsPath); err != nil {
+			return nil, err
+		}
+	}
+	return signing.New(signing.Config{
+		Enabled:    cfg.Enabled,
+		Secrets:    secrets,
+		MaxSkew:    cfg.MaxSkew,
+		NonceCache: cfg.NonceCache,
+	}, WriteError), nil
+}
+
+func authConfig(cfg config.Auth) auth.Config {
+	keys := make([]auth.APIKey, len(cfg.APIKeys))
+	for i, k := range cfg.APIKeys {
+		keys[i] = auth.APIKey{Name: k.Name, SHA256: k.SHA256, Role: k.Role}
+	}
+	return auth.Config{
+		Enabled:   cfg.Enabled,
+		APIKeys:   keys,
+		JWKSPath:  cfg.JWKSPath,
+		Issuer:    cfg.Issuer,
+		Audience:  cfg.Audience,
+		RoleClaim: cfg.RoleClaim,
 	}
 }
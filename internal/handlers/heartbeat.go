@@ -0,0 +1,59 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"trafficlightAPI/internal/heartbeat"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	ErrHeartbeatDisabled = errors.New("отслеживание связи с устройствами не настроено")
+	ErrInvalidStatus     = errors.New("некорректное состояние связи")
+)
+
+// @Summary     Heartbeat status of tracked devices
+// @Tags        Devices
+// @Produce     json
+// @Param       status query    string false "Filter by status" Enums(online, degraded, offline)
+// @Success     200    {array}  heartbeat.Device
+// @Failure     400    {object} models.ErrorResponse "Invalid status"
+// @Failure     503    {object} models.ErrorResponse "Heartbeat tracking disabled"
+// @Router      /heartbeats [get]
+func ServeHeartbeats(w http.ResponseWriter, r *http.Request) {
+	monitor := heartbeat.Default()
+	if monitor == nil {
+		WriteError(w, http.StatusServiceUnavailable, ErrHeartbeatDisabled)
+		return
+	}
+	status := r.URL.Query().Get("status")
+	switch status {
+	case "", heartbeat.StatusOnline, heartbeat.StatusDegraded, heartbeat.StatusOffline:
+	default:
+		WriteError(w, http.StatusBadRequest, ErrInvalidStatus, fmt.Errorf("status:%s", status))
+		return
+	}
+
+	if err := WriteJSON(w, http.StatusOK, monitor.Devices(status)); err != nil {
+		WriteError(w, http.StatusInternalServerError, fmt.Errorf("ошибка при отправке JSON-ответа: %w", err))
+	}
+}
+
+// @Summary     Devices that missed their expected heartbeats (degraded and offline)
+// @Tags        Devices
+// @Produce     json
+// @Success     200 {array}  heartbeat.Device
+// @Failure     503 {object} models.ErrorResponse "Heartbeat tracking disabled"
+// @Router      /heartbeats/stale [get]
+func ServeStaleDevices(w http.ResponseWriter, r *http.Request) {
+	monitor := heartbeat.Default()
+	if monitor == nil {
+		WriteError(w, http.StatusServiceUnavailable, ErrHeartbeatDisabled)
+		return
+	}
+
+	if err := WriteJSON(w, http.StatusOK, monitor.Stale()); err != nil {
+		WriteError(w, http.StatusInternalServerError, fmt.Errorf("ошибка при отправке JSON-ответа: %w", err))
+	}
+}
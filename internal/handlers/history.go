@@ -0,0 +1,81 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+	"trafficlightAPI/internal/history"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	ErrHistoryDisabled = errors.New("история состояний не настроена")
+	ErrInvalidAt       = errors.New("некорректный момент времени")
+	ErrInvalidAround   = errors.New("некорректное число соседних смен состояний")
+)
+
+const (
+	historyDefaultAround = 5
+	historyMaxAround     = 100
+)
+
+// @Summary     State, plan and mode of a trafficlight at a past moment with the surrounding transitions
+// @Tags        History
+// @Produce     json
+// @Param       uuid   query    string true  "Trafficlight UUID"
+// @Param       at     query    string false "Moment, RFC3339 (default: now)"
+// @Param       around query    int    false "Transitions to return on each side (default: 5)"
+// @Success     200    {object} history.Snapshot
+// @Failure     400    {object} models.ErrorResponse "Invalid request data"
+// @Failure     404    {object} models.ErrorResponse "No history at the moment"
+// @Failure     503    {object} models.ErrorResponse "History disabled"
+// @Router      /history [get]
+func ServeHistory(w http.ResponseWriter, r *http.Request) {
+	store := history.Default()
+	if store == nil {
+		WriteError(w, http.StatusServiceUnavailable, ErrHistoryDisabled)
+		return
+	}
+
+	uuid := r.URL.Query().Get("uuid")
+	if uuid == "" {
+		WriteError(w, http.StatusBadRequest, ErrNoUUID)
+		return
+	}
+
+	at := time.Now()
+	if s := r.URL.Query().Get("at"); s != "" {
+		t, err := time.Parse(time.RFC3339Nano, s)
+		if err != nil {
+			WriteError(w, http.StatusBadRequest, ErrInvalidAt, err)
+			return
+		}
+		at = t
+	}
+
+	around := historyDefaultAround
+	if s := r.URL.Query().Get("around"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 0 || n > historyMaxAround {
+			WriteError(w, http.StatusBadRequest, ErrInvalidAround, fmt.Errorf("around:%s, допустимо от 0 до %d", s, historyMaxAround))
+			return
+		}
+		around = n
+	}
+
+	snapshot, err := store.At(uuid, at, around)
+	if errors.Is(err, history.ErrNoHistory) {
+		WriteError(w, http.StatusNotFound, err)
+		return
+	}
+	if err != nil {
+		WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	if err := WriteJSON(w, http.StatusOK, snapshot); err != nil {
+		WriteError(w, http.StatusInternalServerError, fmt.Errorf("ошибка при отправке JSON-ответа: %w", err))
+	}
+}
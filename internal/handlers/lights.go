@@ -0,0 +1,31 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"trafficlightAPI/internal/lights"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	ErrRegistryDisabled = errors.New("реестр светофоров не настроен")
+)
+
+// @Summary     Trafficlights known to the service with their last known state
+// @Tags        Lights
+// @Produce     json
+// @Success     200 {array}  lights.Entry
+// @Failure     503 {object} models.ErrorResponse "Registry disabled"
+// @Router      /lights [get]
+func ServeLights(w http.ResponseWriter, r *http.Request) {
+	registry := lights.Default()
+	if registry == nil {
+		WriteError(w, http.StatusServiceUnavailable, ErrRegistryDisabled)
+		return
+	}
+
+	if err := WriteJSON(w, http.StatusOK, registry.All()); err != nil {
+		WriteError(w, http.StatusInternalServerError, fmt.Errorf("ошибка при отправке JSON-ответа: %w", err))
+	}
+}
@@ -0,0 +1,149 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"trafficlightAPI/internal/middleware/signing"
+	"trafficlightAPI/internal/mmu"
+	"trafficlightAPI/internal/models"
+	"trafficlightAPI/internal/storage"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/pkg/errors"
+)
+
+var (
+	ErrMonitorDisabled = errors.New("монитор конфликтов не настроен")
+)
+
+type OutputReport struct {
+	UUID  string       `json:"uuid"`
+	Lamps models.Lamps `json:"lamps"` // Фактически включенные лампы
+}
+
+type MonitorStatus struct {
+	Intersection string                `json:"intersection"`
+	Latched      *storage.MonitorFault `json:"latched,omitempty"` // Перекресток в мигающем красном
+}
+
+type MonitorResetRequest struct {
+	Reason string `json:"reason"`
+}
+
+// @Summary     Report the lamps a device actually drives to the conflict monitor
+// @Tags        Monitor
+// @Accept      json
+// @Produce     json
+// @Param       body body     handlers.OutputReport true "Device outputs"
+// @Success     200  {object} handlers.MonitorStatus
+// @Failure     400  {object} models.ErrorResponse  "Light is not monitored"
+// @Failure     401  {object} models.ErrorResponse  "Invalid device signature"
+// @Failure     403  {object} models.ErrorResponse  "UUID of another device"
+// @Failure     503  {object} models.ErrorResponse  "Conflict monitor disabled"
+// @Router      /monitor/outputs [post]
+func ServeMonitorOutputs(w http.ResponseWriter, r *http.Request) {
+	monitor := mmu.Default()
+	if monitor == nil {
+		WriteError(w, http.StatusServiceUnavailable, ErrMonitorDisabled)
+		return
+	}
+
+	var request OutputReport
+	if err := ParseJSON(r, &request); err != nil {
+		WriteError(w, http.StatusBadRequest, ErrUnmarshalingFromBody, err)
+		return
+	}
+	defer r.Body.Close()
+
+	if err := signing.CheckDevice(r, request.UUID); err != nil {
+		WriteError(w, http.StatusForbidden, err)
+		return
+	}
+
+	fault, err := monitor.Report(mmu.Output{UUID: request.UUID, Lamps: request.Lamps})
+	switch {
+	case errors.Is(err, mmu.ErrUnknownLight):
+		WriteError(w, http.StatusBadRequest, err)
+		return
+	case err != nil:
+		// Авария уже действует в памяти узла, ошибка только в ее сохранении.
+		WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	status := MonitorStatus{}
+	status.Intersection, _ = monitor.Intersection(request.UUID)
+	if fault == nil {
+		for _, f := range monitor.Latched() {
+			if f.Intersection == status.Intersection {
+				fault = &f
+			}
+		}
+	}
+	status.Latched = fault
+	if err := WriteJSON(w, http.StatusOK, status); err != nil {
+		WriteError(w, http.StatusInternalServerError, fmt.Errorf("ошибка при отправке JSON-ответа: %w", err))
+	}
+}
+
+// @Summary     Intersections latched in conflict monitor failsafe
+// @Tags        Monitor
+// @Produce     json
+// @Success     200 {array}  storage.MonitorFault
+// @Failure     503 {object} models.ErrorResponse "Conflict monitor disabled"
+// @Router      /monitor [get]
+func ServeMonitorFaults(w http.ResponseWriter, r *http.Request) {
+	monitor := mmu.Default()
+	if monitor == nil {
+		WriteError(w, http.StatusServiceUnavailable, ErrMonitorDisabled)
+		return
+	}
+	if err := WriteJSON(w, http.StatusOK, monitor.Latched()); err != nil {
+		WriteError(w, http.StatusInternalServerError, fmt.Errorf("ошибка при отправке JSON-ответа: %w", err))
+	}
+}
+
+// @Summary     Reset a latched conflict monitor fault and resume normal operation
+// @Tags        Monitor
+// @Accept      json
+// @Produce     json
+// @Param       intersection path     string                       true "Intersection"
+// @Param       body         body     handlers.MonitorResetRequest true "Reason of the reset"
+// @Success     200          {object} storage.MonitorFault
+// @Failure     400          {object} models.ErrorResponse "Invalid request data"
+// @Failure     404          {object} models.ErrorResponse "No latched fault"
+// @Failure     503          {object} models.ErrorResponse "Conflict monitor disabled"
+// @Failure     401          {object} models.ErrorResponse "Not authenticated"
+// @Failure     403          {object} models.ErrorResponse "Insufficient role"
+// @Router      /monitor/{intersection}/reset [post]
+func ServeMonitorReset(w http.ResponseWriter, r *http.Request) {
+	monitor := mmu.Default()
+	if monitor == nil {
+		WriteError(w, http.StatusServiceUnavailable, ErrMonitorDisabled)
+		return
+	}
+
+	var request MonitorResetRequest
+	if err := ParseJSON(r, &request); err != nil {
+		WriteError(w, http.StatusBadRequest, ErrUnmarshalingFromBody, err)
+		return
+	}
+	defer r.Body.Close()
+
+	fault, err := monitor.Reset(chi.URLParam(r, "intersection"), auditUser(r), request.Reason)
+	switch {
+	case errors.Is(err, mmu.ErrInvalidReset):
+		WriteError(w, http.StatusBadRequest, err)
+		return
+	case errors.Is(err, mmu.ErrNotLatched):
+		WriteError(w, http.StatusNotFound, err)
+		return
+	case err != nil:
+		WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	if err := WriteJSON(w, http.StatusOK, fault); err != nil {
+		WriteError(w, http.StatusInternalServerError, fmt.Errorf("ошибка при отправке JSON-ответа: %w", err))
+	}
+}
@@ -0,0 +1,160 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+	"trafficlightAPI/internal/config"
+	"trafficlightAPI/internal/lights"
+	"trafficlightAPI/internal/middleware/auth"
+	"trafficlightAPI/internal/overrides"
+	"trafficlightAPI/internal/storage"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/pkg/errors"
+)
+
+var (
+	ErrOverridesDisabled = errors.New("ручное управление не настроено")
+	ErrInvalidExpiry     = errors.New("некорректный срок ручного управления")
+)
+
+type OverrideRequest struct {
+	UUID      string `json:"uuid"`
+	Action    string `json:"action"`          // force, hold, advance
+	State     int    `json:"state,omitempty"` // Для force
+	Reason    string `json:"reason"`
+	User      string `json:"user,omitempty"`       // Заменяется именем аутентифицированного пользователя
+	ExpiresIn int    `json:"expires_in,omitempty"` // с, по умолчанию overrides.default_duration
+}
+
+// @Summary     Force a state, hold the current one or advance to the next one
+// @Tags        Overrides
+// @Accept      json
+// @Produce     json
+// @Param       body body     handlers.OverrideRequest true "Override"
+// @Success     201  {object} storage.Override
+// @Failure     400  {object} models.ErrorResponse     "Invalid request data"
+// @Failure     503  {object} models.ErrorResponse     "Overrides disabled"
+// @Failure     401  {object} models.ErrorResponse "Not authenticated"
+// @Failure     403  {object} models.ErrorResponse "Insufficient role"
+// @Router      /overrides [post]
+func ServeOverrideCreate(cfg config.Overrides) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		manager := overrides.Default()
+		if manager == nil {
+			WriteError(w, http.StatusServiceUnavailable, ErrOverridesDisabled)
+			return
+		}
+
+		var request OverrideRequest
+		if err := ParseJSON(r, &request); err != nil {
+			WriteError(w, http.StatusBadRequest, ErrUnmarshalingFromBody, err)
+			return
+		}
+		defer r.Body.Close()
+
+		duration := cfg.DefaultDuration
+		if request.ExpiresIn != 0 {
+			duration = time.Duration(request.ExpiresIn) * time.Second
+		}
+		if duration <= 0 || duration > cfg.MaxDuration {
+			WriteError(w, http.StatusBadRequest, ErrInvalidExpiry, fmt.Errorf("expires_in:%d, максимум %d", request.ExpiresIn, int(cfg.MaxDuration.Seconds())))
+			return
+		}
+
+		if identity, ok := auth.FromContext(r.Context()); ok {
+			request.User = identity.Name
+		}
+
+		trafficType := 0
+		if registry := lights.Default(); registry != nil {
+			if entry, ok := registry.Get(request.UUID); ok {
+				trafficType = entry.Light.Type
+			}
+		}
+
+		now := time.Now()
+		override, err := manager.Create(storage.Override{
+			UUID:      request.UUID,
+			Action:    request.Action,
+			State:     request.State,
+			Reason:    request.Reason,
+			User:      request.User,
+			CreatedAt: now,
+			ExpiresAt: now.Add(duration),
+		}, trafficType)
+		if errors.Is(err, overrides.ErrInvalidOverride) {
+			WriteError(w, http.StatusBadRequest, err)
+			return
+		}
+		if err != nil {
+			WriteError(w, http.StatusInternalServerError, err)
+			return
+		}
+
+		if err := WriteJSON(w, http.StatusCreated, override); err != nil {
+			WriteError(w, http.StatusInternalServerError, fmt.Errorf("ошибка при отправке JSON-ответа: %w", err))
+		}
+	}
+}
+
+// @Summary     Active overrides
+// @Tags        Overrides
+// @Produce     json
+// @Success     200 {array}  storage.Override
+// @Failure     503 {object} models.ErrorResponse "Overrides disabled"
+// @Router      /overrides [get]
+func ServeOverrideList(w http.ResponseWriter, r *http.Request) {
+	manager := overrides.Default()
+	if manager == nil {
+		WriteError(w, http.StatusServiceUnavailable, ErrOverridesDisabled)
+		return
+	}
+	if err := WriteJSON(w, http.StatusOK, manager.Active(time.Now())); err != nil {
+		WriteError(w, http.StatusInternalServerError, fmt.Errorf("ошибка при отправке JSON-ответа: %w", err))
+	}
+}
+
+// @Summary     Release an override
+// @Tags        Overrides
+// @Produce     json
+// @Param       id     path     string true  "Override ID"
+// @Param       reason query    string true  "Reason of the release"
+// @Param       user   query    string false "Operator, replaced by the authenticated user"
+// @Success     200    {object} storage.Override
+// @Failure     400    {object} models.ErrorResponse "Invalid request data"
+// @Failure     404    {object} models.ErrorResponse "Override not found"
+// @Failure     503    {object} models.ErrorResponse "Overrides disabled"
+// @Failure     401    {object} models.ErrorResponse "Not authenticated"
+// @Failure     403    {object} models.ErrorResponse "Insufficient role"
+// @Router      /overrides/{id} [delete]
+func ServeOverrideRelease(w http.ResponseWriter, r *http.Request) {
+	manager := overrides.Default()
+	if manager == nil {
+		WriteError(w, http.StatusServiceUnavailable, ErrOverridesDisabled)
+		return
+	}
+
+	user := r.URL.Query().Get("user")
+	if identity, ok := auth.FromContext(r.Context()); ok {
+		user = identity.Name
+	}
+
+	override, err := manager.Release(chi.URLParam(r, "id"), r.URL.Query().Get("reason"), user)
+	switch {
+	case errors.Is(err, overrides.ErrInvalidOverride):
+		WriteError(w, http.StatusBadRequest, err)
+		return
+	case errors.Is(err, overrides.ErrOverrideNotFound):
+		WriteError(w, http.StatusNotFound, err)
+		return
+	case err != nil:
+		WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	if err := WriteJSON(w, http.StatusOK, override); err != nil {
+		WriteError(w, http.StatusInternalServerError, fmt.Errorf("ошибка при отправке JSON-ответа: %w", err))
+	}
+}
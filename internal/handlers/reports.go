@@ -0,0 +1,125 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"trafficlightAPI/internal/atspm"
+	"trafficlightAPI/internal/perfmeasures"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	ErrInvalidDetectors = errors.New("некорректный список детекторов")
+	ErrReport           = errors.New("ошибка при расчете показателей")
+)
+
+// reportRequest разбирает общие параметры отчетов: uuid, интервал и каналы детекторов.
+func reportRequest(r *http.Request) (string, time.Time, time.Time, perfmeasures.Options, int, error) {
+	var opts perfmeasures.Options
+	uuid := r.URL.Query().Get("uuid")
+	if uuid == "" {
+		return "", time.Time{}, time.Time{}, opts, http.StatusBadRequest, ErrNoUUID
+	}
+	from, to, err := ParseTimeRange(r, 24*time.Hour)
+	if err != nil {
+		return "", time.Time{}, time.Time{}, opts, http.StatusBadRequest, errors.Wrap(ErrInvalidTimeRange, err.Error())
+	}
+	if s := r.URL.Query().Get("detectors"); s != "" {
+		for _, part := range strings.Split(s, ",") {
+			channel, err := strconv.Atoi(strings.TrimSpace(part))
+			if err != nil {
+				return "", time.Time{}, time.Time{}, opts, http.StatusBadRequest, errors.Wrapf(ErrInvalidDetectors, "detectors:%s", s)
+			}
+			opts.Detectors = append(opts.Detectors, channel)
+		}
+	}
+	return uuid, from, to, opts, 0, nil
+}
+
+// @Summary     Performance measures of a trafficlight: arrivals on green, split monitor, pedestrian delay, split failures
+// @Tags        Reports
+// @Produce     json
+// @Param       uuid      query    string true  "Trafficlight UUID"
+// @Param       from      query    string false "Start of the interval, RFC3339 (default: a day ago)"
+// @Param       to        query    string false "End of the interval, RFC3339 (default: now)"
+// @Param       detectors query    string false "Comma-separated detector channels of the phase (default: all)"
+// @Success     200       {object} perfmeasures.Report
+// @Failure     400       {object} models.ErrorResponse "Invalid request data"
+// @Failure     503       {object} models.ErrorResponse "Event log disabled"
+// @Router      /reports [get]
+func ServeReport(w http.ResponseWriter, r *http.Request) {
+	recorder := atspm.Default()
+	if recorder == nil {
+		WriteError(w, http.StatusServiceUnavailable, ErrEventsDisabled)
+		return
+	}
+
+	uuid, from, to, opts, status, err := reportRequest(r)
+	if err != nil {
+		WriteError(w, status, err)
+		return
+	}
+	events, err := perfmeasures.Load(recorder, uuid, from, to)
+	if err != nil {
+		WriteError(w, http.StatusInternalServerError, ErrReport, err)
+		return
+	}
+
+	if err := WriteJSON(w, http.StatusOK, perfmeasures.Compute(uuid, from, to, events, opts)); err != nil {
+		WriteError(w, http.StatusInternalServerError, fmt.Errorf("ошибка при отправке JSON-ответа: %w", err))
+	}
+}
+
+// @Summary     Purdue coordination diagram of a trafficlight
+// @Tags        Reports
+// @Produce     image/png
+// @Produce     image/svg+xml
+// @Param       uuid      query    string true  "Trafficlight UUID"
+// @Param       from      query    string false "Start of the interval, RFC3339 (default: a day ago)"
+// @Param       to        query    string false "End of the interval, RFC3339 (default: now)"
+// @Param       detectors query    string false "Comma-separated detector channels of the phase (default: all)"
+// @Param       format    query    string false "png (default) or svg"
+// @Success     200       {file}   file
+// @Failure     400       {object} models.ErrorResponse "Invalid request data"
+// @Failure     503       {object} models.ErrorResponse "Event log disabled"
+// @Router      /reports/pcd [get]
+func ServePurdueDiagram(w http.ResponseWriter, r *http.Request) {
+	recorder := atspm.Default()
+	if recorder == nil {
+		WriteError(w, http.StatusServiceUnavailable, ErrEventsDisabled)
+		return
+	}
+
+	uuid, from, to, opts, status, err := reportRequest(r)
+	if err != nil {
+		WriteError(w, status, err)
+		return
+	}
+	events, err := perfmeasures.Load(recorder, uuid, from, to)
+	if err != nil {
+		WriteError(w, http.StatusInternalServerError, ErrReport, err)
+		return
+	}
+
+	format := r.URL.Query().Get("format")
+	diagram, err := perfmeasures.Diagram(from, to, events, opts, format)
+	if err != nil {
+		WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if format == "svg" {
+		w.Header().Add("Content-Type", "image/svg+xml")
+	} else {
+		w.Header().Add("Content-Type", "image/png")
+	}
+	w.WriteHeader(http.StatusOK)
+	if _, err := w.Write(diagram); err != nil {
+		WriteError(w, http.StatusInternalServerError, fmt.Errorf("ошибка при отправке диаграммы: %w", err))
+		return
+	}
+}
@@ -0,0 +1,316 @@
+package handlers
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+	"trafficlightAPI/internal/config"
+	"trafficlightAPI/internal/stream"
+
+	"github.com/bytedance/sonic"
+	"github.com/gorilla/websocket"
+	"github.com/pkg/errors"
+)
+
+var (
+	ErrStreamDisabled    = errors.New("потоковая передача состояний не настроена")
+	ErrEmptyStreamFilter = errors.New("не указаны uuid или intersection")
+	ErrStreamUnsupported = errors.New("соединение не поддерживает потоковую передачу")
+	ErrStreamAction      = errors.New("неизвестное действие, ожидается subscribe или unsubscribe")
+)
+
+// StreamMessage - сообщение WebSocket от клиента.
+type StreamMessage struct {
+	Action        string   `json:"action"` // subscribe или unsubscribe
+	UUIDs         []string `json:"uuids,omitempty"`
+	Intersections []string `json:"intersections,omitempty"`
+}
+
+// StreamFrame - сообщение WebSocket клиенту.
+type StreamFrame struct {
+	Type    string        `json:"type"` // state, dropped или error
+	Event   *stream.Event `json:"event,omitempty"`
+	Dropped int64         `json:"dropped,omitempty"` // Событий отброшено, пока клиент не успевал читать
+	Error   string        `json:"error,omitempty"`
+}
+
+// streamTimeouts возвращает интервал keep-alive и время на одну запись.
+// Keep-alive не длиннее половины таймаута сервера, чтобы прокси и клиенты
+// не закрывали простаивающие соединения.
+func streamTimeouts(cfg config.Stream, serverTimeout time.Duration) (time.Duration, time.Duration) {
+	keepAlive := cfg.KeepAlive
+	if half := serverTimeout / 2; half > 0 && (keepAlive <= 0 || keepAlive > half) {
+		keepAlive = half
+	}
+	if keepAlive <= 0 {
+		keepAlive = 15 * time.Second
+	}
+	writeTimeout := serverTimeout
+	if writeTimeout <= 0 {
+		writeTimeout = 10 * time.Second
+	}
+	return keepAlive, writeTimeout
+}
+
+// streamFilter читает uuid и intersection из запроса: параметры можно повторять
+// или перечислять через запятую.
+func streamFilter(r *http.Request) stream.Filter {
+	var f stream.Filter
+	for _, v := range r.URL.Query()["uuid"] {
+		f.UUIDs = append(f.UUIDs, splitList(v)...)
+	}
+	for _, v := range r.URL.Query()["intersection"] {
+		f.Intersections = append(f.Intersections, splitList(v)...)
+	}
+	return f
+}
+
+func splitList(v string) []string {
+	var items []string
+	for _, item := range strings.Split(v, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// @Summary     Stream trafficlight state changes as Server-Sent Events
+// @Description Sends the last known state of each light first, then every phase change with its countdown.
+// @Description Events: "state" (stream.Event) and "dropped" ({"dropped": n}) when events were skipped for a slow client.
+// @Tags        Stream
+// @Produce     text/event-stream
+// @Param       uuid         query    string false "Trafficlight UUIDs, comma-separated or repeated"
+// @Param       intersection query    string false "Intersections, comma-separated or repeated"
+// @Success     200          {object} stream.Event
+// @Failure     400          {object} models.ErrorResponse "No uuid or intersection"
+// @Failure     503          {object} models.ErrorResponse "Streaming disabled"
+// @Router      /stream/sse [get]
+func ServeStreamSSE(cfg config.Stream, serverTimeout time.Duration, logger *slog.Logger) http.HandlerFunc {
+	keepAlive, writeTimeout := streamTimeouts(cfg, serverTimeout)
+	return func(w http.ResponseWriter, r *http.Request) {
+		hub := stream.Default()
+		if hub == nil {
+			WriteError(w, http.StatusServiceUnavailable, ErrStreamDisabled)
+			return
+		}
+		filter := streamFilter(r)
+		if filter.Empty() {
+			WriteError(w, http.StatusBadRequest, ErrEmptyStreamFilter)
+			return
+		}
+
+		// Таймауты сервера рассчитаны на обычные запросы: чтение снимается,
+		// запись продлевается перед каждым событием.
+		rc := http.NewResponseController(w)
+		if err := rc.SetReadDeadline(time.Time{}); err != nil {
+			WriteError(w, http.StatusInternalServerError, ErrStreamUnsupported, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("X-Accel-Buffering", "no")
+		w.WriteHeader(http.StatusOK)
+
+		sub := hub.Subscribe("sse", r.RemoteAddr, auditUser(r), filter)
+		reason := stream.ReasonClient
+		defer func() { hub.Unsubscribe(sub, reason) }()
+
+		var id int64
+		write := func(event string, v any) error {
+			if err := rc.SetWriteDeadline(time.Now().Add(writeTimeout)); err != nil {
+				return err
+			}
+			if event != "" {
+				data, err := sonic.Marshal(v)
+				if err != nil {
+					return err
+				}
+				id++
+				if _, err := fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", id, event, data); err != nil {
+					return err
+				}
+			} else if _, err := fmt.Fprint(w, ": ping\n\n"); err != nil {
+				return err
+			}
+			return rc.Flush()
+		}
+
+		for _, e := range hub.Snapshot(filter) {
+			if err := write("state", e); err != nil {
+				reason = stream.ReasonWriteError
+				return
+			}
+		}
+
+		ticker := time.NewTicker(keepAlive)
+		defer ticker.Stop()
+		for {
+			var err error
+			select {
+			case <-r.Context().Done():
+				return
+			case <-sub.Done():
+				return
+			case <-ticker.C:
+				err = write("", nil)
+			case e := <-sub.Events():
+				if dropped := sub.Delivered(); dropped > 0 {
+					err = write("dropped", map[string]int64{"dropped": dropped})
+				}
+				if err == nil {
+					err = write("state", e)
+				}
+			}
+			if err != nil {
+				logger.Debug("ошибка записи события SSE", slog.String("remote", r.RemoteAddr), slog.Any("err", err))
+				reason = stream.ReasonWriteError
+				return
+			}
+		}
+	}
+}
+
+var upgrader = websocket.Upgrader{
+	ReadBufferSize:  1024,
+	WriteBufferSize: 1024,
+}
+
+// @Summary     Stream trafficlight state changes over WebSocket
+// @Description Initial subscription is taken from the query; the client changes it with
+// @Description {"action":"subscribe|unsubscribe","uuids":[...],"intersections":[...]}.
+// @Description The server sends handlers.StreamFrame messages: the current state on subscribe, then every phase change.
+// @Tags        Stream
+// @Param       uuid         query    string false "Trafficlight UUIDs, comma-separated or repeated"
+// @Param       intersection query    string false "Intersections, comma-separated or repeated"
+// @Success     101          {object} handlers.StreamFrame
+// @Failure     503          {object} models.ErrorResponse "Streaming disabled"
+// @Router      /stream/ws [get]
+func ServeStreamWS(cfg config.Stream, serverTimeout time.Duration, logger *slog.Logger) http.HandlerFunc {
+	keepAlive, writeTimeout := streamTimeouts(cfg, serverTimeout)
+	return func(w http.ResponseWriter, r *http.Request) {
+		hub := stream.Default()
+		if hub == nil {
+			WriteError(w, http.StatusServiceUnavailable, ErrStreamDisabled)
+			return
+		}
+		filter := streamFilter(r)
+
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			// Upgrade уже ответил клиенту.
+			logger.Debug("ошибка установки WebSocket-соединения", slog.String("remote", r.RemoteAddr), slog.Any("err", err))
+			return
+		}
+		defer conn.Close()
+
+		// После перехвата соединения таймауты сервера остаются на нем,
+		// поэтому чтение продлевается по pong, а запись - перед каждым сообщением.
+		readTimeout := 2*keepAlive + writeTimeout
+		conn.SetReadDeadline(time.Now().Add(readTimeout))
+		conn.SetPongHandler(func(string) error {
+			return conn.SetReadDeadline(time.Now().Add(readTimeout))
+		})
+
+		sub := hub.Subscribe("ws", r.RemoteAddr, auditUser(r), filter)
+		reason := stream.ReasonClient
+		defer func() { hub.Unsubscribe(sub, reason) }()
+
+		// Чтение идет в отдельной горутине, писать в соединение может только эта.
+		requests := make(chan StreamMessage, 8)
+		go func() {
+			defer hub.Unsubscribe(sub, stream.ReasonClient)
+			for {
+				var msg StreamMessage
+				if err := conn.ReadJSON(&msg); err != nil {
+					return
+				}
+				select {
+				case requests <- msg:
+				case <-sub.Done():
+					return
+				}
+			}
+		}()
+
+		write := func(frame StreamFrame) error {
+			if err := conn.SetWriteDeadline(time.Now().Add(writeTimeout)); err != nil {
+				return err
+			}
+			return conn.WriteJSON(frame)
+		}
+		snapshot := func(f stream.Filter) error {
+			for _, e := range hub.Snapshot(f) {
+				if err := write(StreamFrame{Type: "state", Event: &e}); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+
+		if err := snapshot(filter); err != nil {
+			reason = stream.ReasonWriteError
+			return
+		}
+
+		ticker := time.NewTicker(keepAlive)
+		defer ticker.Stop()
+		for {
+			var err error
+			select {
+			case <-sub.Done():
+				if sub.Reason() == stream.ReasonSlowConsumer || sub.Reason() == stream.ReasonShutdown {
+					conn.WriteControl(websocket.CloseMessage,
+						websocket.FormatCloseMessage(websocket.CloseTryAgainLater, sub.Reason()),
+						time.Now().Add(writeTimeout))
+				}
+				return
+			case <-ticker.C:
+				err = conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(writeTimeout))
+			case msg := <-requests:
+				f := stream.Filter{UUIDs: msg.UUIDs, Intersections: msg.Intersections}
+				switch msg.Action {
+				case "subscribe":
+					sub.Subscribe(f)
+					err = snapshot(f)
+				case "unsubscribe":
+					sub.Unsubscribe(f)
+				default:
+					err = write(StreamFrame{Type: "error", Error: errors.Wrapf(ErrStreamAction, "action:%s", msg.Action).Error()})
+				}
+			case e := <-sub.Events():
+				if dropped := sub.Delivered(); dropped > 0 {
+					err = write(StreamFrame{Type: "dropped", Dropped: dropped})
+				}
+				if err == nil {
+					err = write(StreamFrame{Type: "state", Event: &e})
+				}
+			}
+			if err != nil {
+				logger.Debug("ошибка записи в WebSocket", slog.String("remote", r.RemoteAddr), slog.Any("err", err))
+				reason = stream.ReasonWriteError
+				return
+			}
+		}
+	}
+}
+
+// @Summary     Open streaming connections with per-connection counters
+// @Tags        Stream
+// @Produce     json
+// @Success     200 {array}  stream.ConnectionStats
+// @Failure     503 {object} models.ErrorResponse "Streaming disabled"
+// @Router      /stream/connections [get]
+func ServeStreamConnections(w http.ResponseWriter, r *http.Request) {
+	hub := stream.Default()
+	if hub == nil {
+		WriteError(w, http.StatusServiceUnavailable, ErrStreamDisabled)
+		return
+	}
+	if err := WriteJSON(w, http.StatusOK, hub.Connections()); err != nil {
+		WriteError(w, http.StatusInternalServerError, fmt.Errorf("ошибка при отправке JSON-ответа: %w", err))
+	}
+}
@@ -0,0 +1,86 @@
+package handlers
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"trafficlightAPI/internal/audit"
+	"trafficlightAPI/internal/models"
+	"trafficlightAPI/internal/sumo"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	ErrSUMOExport = errors.New("ошибка экспорта программ SUMO")
+	ErrSUMOImport = errors.New("ошибка импорта программ SUMO")
+)
+
+const maxSUMOBodySize = 10 << 20
+
+// @Summary     Export current trafficlight programs as SUMO tlLogic
+// @Tags        Plans
+// @Produce     xml
+// @Success     200  {string} string                "SUMO additional file"
+// @Failure     500  {object} models.ErrorResponse  "Server error"
+// @Router      /plans/sumo [get]
+func ServeSUMOExport(w http.ResponseWriter, r *http.Request) {
+	data, err := sumo.Export(models.CurrentDefinitions())
+	if err != nil {
+		WriteError(w, http.StatusInternalServerError, ErrSUMOExport, err)
+		return
+	}
+
+	w.Header().Add("Content-Type", "application/xml")
+	w.WriteHeader(http.StatusOK)
+	if _, err := w.Write(data); err != nil {
+		WriteError(w, http.StatusInternalServerError, fmt.Errorf("ошибка при отправке программ SUMO: %w", err))
+		return
+	}
+}
+
+// @Summary     Import SUMO tlLogic programs as trafficlight plans
+// @Tags        Plans
+// @Accept      xml
+// @Produce     json
+// @Param       body body     string                true "SUMO additional or net file"
+// @Success     200  {object} models.Definitions          "Applied definitions"
+// @Failure     400  {object} models.ErrorResponse        "Invalid program"
+// @Failure     401  {object} models.ErrorResponse "Not authenticated"
+// @Failure     403  {object} models.ErrorResponse "Insufficient role"
+// @Router      /plans/sumo [post]
+func ServeSUMOImport(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(r.Body, maxSUMOBodySize))
+	if err != nil {
+		WriteError(w, http.StatusBadRequest, ErrSUMOImport, err)
+		return
+	}
+
+	defs, err := sumo.Import(data)
+	if err != nil {
+		WriteError(w, http.StatusBadRequest, ErrSUMOImport, err)
+		return
+	}
+	previous := make(map[int][]int)
+	for _, light := range models.CurrentDefinitions().Lights {
+		previous[light.Type] = light.Durations
+	}
+	// ApplyDefinitions проверяет файл целиком до применения: несколько tlLogic
+	// одного типа или смещение без uuid отклоняют импорт, не меняя планов.
+	if err := models.ApplyDefinitions(defs); err != nil {
+		WriteError(w, http.StatusBadRequest, ErrApplyPlan, err)
+		return
+	}
+	for _, light := range defs.Lights {
+		recordAudit(audit.Entry{
+			Action: audit.ActionPlanChange, User: auditUser(r), Reason: "sumo",
+			Details: map[string]any{"type": light.Type, "from": previous[light.Type], "to": models.Plan(light.Type)},
+		})
+	}
+
+	if err := WriteJSON(w, http.StatusOK, defs); err != nil {
+		WriteError(w, http.StatusInternalServerError, fmt.Errorf("ошибка при отправке JSON-ответа: %w", err))
+		return
+	}
+}
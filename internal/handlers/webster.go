@@ -0,0 +1,122 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"trafficlightAPI/internal/audit"
+	"trafficlightAPI/internal/config"
+	"trafficlightAPI/internal/models"
+	"trafficlightAPI/internal/webster"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	ErrWebsterCalculation = errors.New("ошибка расчета плана по методу Вебстера")
+	ErrApplyPlan          = errors.New("ошибка применения плана")
+)
+
+type WebsterRequest struct {
+	Approaches []webster.Approach `json:"approaches"`
+	Apply      bool               `json:"apply,omitempty"` // План общий для типа: меняется у всех светофоров этого типа
+}
+
+type WebsterPlan struct {
+	Durations []int                 `json:"durations"`
+	Estimate  webster.DelayEstimate `json:"estimate"`
+}
+
+type WebsterResponse struct {
+	Type        int            `json:"type"`
+	Calculation webster.Result `json:"calculation"`
+	Current     WebsterPlan    `json:"current"`
+	Recommended WebsterPlan    `json:"recommended"`
+	Applied     bool           `json:"applied"`
+}
+
+// @Summary     Webster cycle and green split calculation from traffic counts
+// @Tags        Plans
+// @Accept      json
+// @Produce     json
+// @Param       type query    int                      true "Type of the trafficlight" Enums(1, 2, 3)
+// @Param       body body     handlers.WebsterRequest  true "Approach flows and saturation flows"
+// @Success     200  {object} handlers.WebsterResponse      "Recommended plan with delay estimates"
+// @Failure     400  {object} models.ErrorResponse          "Invalid request data"
+// @Failure     401  {object} models.ErrorResponse "Not authenticated"
+// @Failure     403  {object} models.ErrorResponse "Insufficient role"
+// @Router      /plans/webster [post]
+func ServeWebsterRoute(cfg config.Webster) http.HandlerFunc {
+	params := webster.Params{
+		LostTime: cfg.LostTime,
+		MinCycle: cfg.MinCycle,
+		MaxCycle: cfg.MaxCycle,
+		MinGreen: cfg.MinGreen,
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		trafficTypeStr := r.URL.Query().Get("type")
+		if trafficTypeStr == "" {
+			WriteError(w, http.StatusBadRequest, ErrNoType)
+			return
+		}
+		trafficType, err := ParseTrafficType(trafficTypeStr)
+		if err != nil {
+			WriteError(w, http.StatusBadRequest, ErrInvalidTrafficlightType, err)
+			return
+		}
+
+		var request WebsterRequest
+		if err := ParseJSON(r, &request); err != nil {
+			WriteError(w, http.StatusBadRequest, ErrUnmarshalingFromBody, err)
+			return
+		}
+		defer r.Body.Close()
+
+		currentPlan := models.Plan(trafficType)
+		current, err := webster.EstimateDelay(request.Approaches, currentPlan)
+		if err != nil {
+			WriteError(w, http.StatusBadRequest, ErrWebsterCalculation, err)
+			return
+		}
+
+		calculation, err := webster.Compute(request.Approaches, params)
+		if err != nil {
+			WriteError(w, http.StatusBadRequest, ErrWebsterCalculation, err)
+			return
+		}
+
+		recommendedPlan, err := webster.PlanFromSplits(currentPlan, calculation.Splits)
+		if err != nil {
+			WriteError(w, http.StatusBadRequest, ErrWebsterCalculation, err)
+			return
+		}
+		recommended, err := webster.EstimateDelay(request.Approaches, recommendedPlan)
+		if err != nil {
+			WriteError(w, http.StatusBadRequest, ErrWebsterCalculation, err)
+			return
+		}
+
+		if request.Apply {
+			if err := models.ApplyPlan(trafficType, recommendedPlan); err != nil {
+				WriteError(w, http.StatusBadRequest, ErrApplyPlan, err)
+				return
+			}
+			recordAudit(audit.Entry{
+				Action: audit.ActionPlanChange, User: auditUser(r), Reason: "webster",
+				Details: map[string]any{"type": trafficType, "from": currentPlan, "to": recommendedPlan},
+			})
+		}
+
+		response := WebsterResponse{
+			Type:        trafficType,
+			Calculation: calculation,
+			Current:     WebsterPlan{Durations: currentPlan, Estimate: current},
+			Recommended: WebsterPlan{Durations: recommendedPlan, Estimate: recommended},
+			Applied:     request.Apply,
+		}
+		if err := WriteJSON(w, http.StatusOK, response); err != nil {
+			WriteError(w, http.StatusInternalServerError, fmt.Errorf("ошибка при отправке JSON-ответа: %w. %+v", err, request))
+			return
+		}
+	}
+}
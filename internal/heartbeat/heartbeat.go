@@ -0,0 +1,273 @@
+package heartbeat
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	prometheus "trafficlightAPI/internal/middleware/prometheus"
+)
+
+// Состояния связи с устройством.
+const (
+	StatusOnline   = "online"
+	StatusDegraded = "degraded" // Пропущено больше DegradedMisses опросов
+	StatusOffline  = "offline"  // Пропущено больше OfflineMisses опросов
+)
+
+type Config struct {
+	DefaultInterval time.Duration
+	TypeIntervals   map[int]time.Duration
+	DegradedMisses  float64
+	OfflineMisses   float64
+	ForgetAfter     time.Duration // Незарегистрированное устройство без опросов дольше забывается, 0 - никогда
+	Node            string        // Узел кластера для меток метрик: время опроса у каждого узла свое
+}
+
+// Device - состояние связи с устройством.
+type Device struct {
+	UUID             string        `json:"uuid"`
+	Type             int           `json:"type"`
+	LastSeen         *time.Time    `json:"last_seen,omitempty"` // nil - не опрашивало с запуска сервиса
+	ExpectedInterval time.Duration `json:"expected_interval" swaggertype:"integer"`
+	Status           string        `json:"status"`
+	Since            time.Time     `json:"since"` // Начало текущего состояния
+}
+
+// Change - смена состояния связи, передается обработчикам OnChange.
+type Change struct {
+	Device Device
+	From   string
+}
+
+type entry struct {
+	trafficType int
+	lastSeen    time.Time
+	seen        bool
+	expected    bool // Зарегистрировано, не забывается без опросов
+	status      string
+	since       time.Time
+}
+
+// Monitor отслеживает время последнего опроса устройств и помечает
+// пропустившие опросы устройства как degraded и offline.
+type Monitor struct {
+	cfg            Config
+	deviceInterval func(uuid string) time.Duration // Интервал, заданный для устройства, или 0
+
+	mu      sync.Mutex
+	devices map[string]*entry
+	hooks   []func(Change)
+
+	stop chan struct{}
+	wg   sync.WaitGroup
+}
+
+func NewMonitor(cfg Config, deviceInterval func(uuid string) time.Duration) *Monitor {
+	if cfg.DegradedMisses <= 0 {
+		cfg.DegradedMisses = 2
+	}
+	if cfg.OfflineMisses < cfg.DegradedMisses {
+		cfg.OfflineMisses = cfg.DegradedMisses
+	}
+	return &Monitor{
+		cfg:            cfg,
+		deviceInterval: deviceInterval,
+		devices:        make(map[string]*entry),
+		stop:           make(chan struct{}),
+	}
+}
+
+// OnChange регистрирует обработчик смены состояния связи.
+func (m *Monitor) OnChange(hook func(Change)) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.hooks = append(m.hooks, hook)
+}
+
+// Interval возвращает ожидаемый интервал опроса: заданный для устройства,
+// для типа светофора или по умолчанию.
+func (m *Monitor) Interval(uuid string, trafficType int) time.Duration {
+	if m.deviceInterval != nil {
+		if d := m.deviceInterval(uuid); d > 0 {
+			return d
+		}
+	}
+	if d, ok := m.cfg.TypeIntervals[trafficType]; ok && d > 0 {
+		return d
+	}
+	return m.cfg.DefaultInterval
+}
+
+// Expect начинает отслеживать устройство, которое еще не опрашивало сервис,
+// например зарегистрированное. Отсчет пропусков идет от at.
+func (m *Monitor) Expect(uuid string, trafficType int, at time.Time) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if e, ok := m.devices[uuid]; ok {
+		e.expected = true
+		return
+	}
+	m.devices[uuid] = &entry{trafficType: trafficType, lastSeen: at, expected: true, status: StatusOnline, since: at}
+}
+
+// Seen отмечает опрос устройства. Восстановление связи сообщается сразу.
+func (m *Monitor) Seen(uuid string, trafficType int, at time.Time) {
+	m.mu.Lock()
+	e, ok := m.devices[uuid]
+	if !ok {
+		e = &entry{status: StatusOnline, since: at}
+		m.devices[uuid] = e
+	}
+	e.trafficType, e.lastSeen, e.seen = trafficType, at, true
+
+	var changes []Change
+	if e.status != StatusOnline {
+		changes = append(changes, m.transition(uuid, e, StatusOnline, at))
+	}
+	hooks := m.hooks
+	m.mu.Unlock()
+
+	notify(hooks, changes)
+}
+
+func (m *Monitor) transition(uuid string, e *entry, status string, at time.Time) Change {
+	from := e.status
+	e.status, e.since = status, at
+	prometheus.HeartbeatTransitions.WithLabelValues(m.cfg.Node, status).Inc()
+	return Change{Device: m.device(uuid, e), From: from}
+}
+
+func notify(hooks []func(Change), changes []Change) {
+	for _, c := range changes {
+		for _, hook := range hooks {
+			hook(c)
+		}
+	}
+}
+
+func (m *Monitor) status(uuid string, e *entry, now time.Time) string {
+	interval := m.Interval(uuid, e.trafficType)
+	if interval <= 0 {
+		return StatusOnline
+	}
+	missed := float64(now.Sub(e.lastSeen)) / float64(interval)
+	switch {
+	case missed > m.cfg.OfflineMisses:
+		return StatusOffline
+	case missed > m.cfg.DegradedMisses:
+		return StatusDegraded
+	default:
+		return StatusOnline
+	}
+}
+
+// Check пересчитывает состояния на момент now, сообщает об изменениях,
+// забывает незарегистрированные устройства без опросов дольше ForgetAfter
+// и обновляет метрику devices_heartbeat.
+func (m *Monitor) Check(now time.Time) {
+	m.mu.Lock()
+	var changes []Change
+	counts := map[string]int{StatusOnline: 0, StatusDegraded: 0, StatusOffline: 0}
+	for uuid, e := range m.devices {
+		if !e.expected && m.cfg.ForgetAfter > 0 && now.Sub(e.lastSeen) > m.cfg.ForgetAfter {
+			delete(m.devices, uuid)
+			continue
+		}
+		if status := m.status(uuid, e, now); status != e.status {
+			changes = append(changes, m.transition(uuid, e, status, now))
+		}
+		counts[e.status]++
+	}
+	hooks := m.hooks
+	m.mu.Unlock()
+
+	for status, n := range counts {
+		prometheus.DevicesHeartbeat.WithLabelValues(m.cfg.Node, status).Set(float64(n))
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Device.UUID < changes[j].Device.UUID })
+	notify(hooks, changes)
+}
+
+func (m *Monitor) device(uuid string, e *entry) Device {
+	d := Device{
+		UUID:             uuid,
+		Type:             e.trafficType,
+		ExpectedInterval: m.Interval(uuid, e.trafficType),
+		Status:           e.status,
+		Since:            e.since,
+	}
+	if e.seen {
+		lastSeen := e.lastSeen
+		d.LastSeen = &lastSeen
+	}
+	return d
+}
+
+// Devices возвращает отслеживаемые устройства в порядке UUID. Пустой status - все.
+func (m *Monitor) Devices(status string) []Device {
+	m.mu.Lock()
+	devices := make([]Device, 0, len(m.devices))
+	for uuid, e := range m.devices {
+		if status == "" || e.status == status {
+			devices = append(devices, m.device(uuid, e))
+		}
+	}
+	m.mu.Unlock()
+
+	sort.Slice(devices, func(i, j int) bool { return devices[i].UUID < devices[j].UUID })
+	return devices
+}
+
+// Stale возвращает устройства в состоянии degraded и offline.
+func (m *Monitor) Stale() []Device {
+	stale := m.Devices(StatusDegraded)
+	return append(stale, m.Devices(StatusOffline)...)
+}
+
+// Forget прекращает отслеживание устройства.
+func (m *Monitor) Forget(uuid string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.devices, uuid)
+}
+
+// RunCheck периодически вызывает Check до вызова Close.
+func (m *Monitor) RunCheck(interval time.Duration) {
+	m.wg.Add(1)
+	go func() {
+		defer m.wg.Done()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-m.stop:
+				return
+			case now := <-ticker.C:
+				m.Check(now)
+			}
+		}
+	}()
+}
+
+func (m *Monitor) Close() {
+	close(m.stop)
+	m.wg.Wait()
+}
+
+var (
+	defaultMu      sync.RWMutex
+	defaultMonitor *Monitor
+)
+
+func SetDefault(m *Monitor) {
+	defaultMu.Lock()
+	defer defaultMu.Unlock()
+	defaultMonitor = m
+}
+
+func Default() *Monitor {
+	defaultMu.RLock()
+	defer defaultMu.RUnlock()
+	return defaultMonitor
+}
@@ -0,0 +1,125 @@
+package heartbeat_test
+
+import (
+	"testing"
+	"time"
+	"trafficlightAPI/internal/heartbeat"
+)
+
+var start = time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+func newMonitor() *heartbeat.Monitor {
+	return heartbeat.NewMonitor(heartbeat.Config{
+		DefaultInterval: 5 * time.Second,
+		TypeIntervals:   map[int]time.Duration{2: time.Second},
+		DegradedMisses:  2,
+		OfflineMisses:   5,
+	}, func(uuid string) time.Duration {
+		if uuid == "slow" {
+			return time.Minute
+		}
+		return 0
+	})
+}
+
+func TestInterval(t *testing.T) {
+	m := newMonitor()
+	tests := []struct {
+		uuid        string
+		trafficType int
+		want        time.Duration
+	}{
+		{uuid: "slow", trafficType: 2, want: time.Minute},
+		{uuid: "a", trafficType: 2, want: time.Second},
+		{uuid: "a", trafficType: 1, want: 5 * time.Second},
+	}
+	for _, tt := range tests {
+		if got := m.Interval(tt.uuid, tt.trafficType); got != tt.want {
+			t.Errorf("Interval(%s, %d) = %v, want %v", tt.uuid, tt.trafficType, got, tt.want)
+		}
+	}
+}
+
+func TestTransitions(t *testing.T) {
+	m := newMonitor()
+	var changes []string
+	m.OnChange(func(c heartbeat.Change) {
+		changes = append(changes, c.Device.UUID+":"+c.From+">"+c.Device.Status)
+	})
+
+	m.Seen("a", 1, start)
+	m.Seen("slow", 1, start)
+	m.Expect("registered", 1, start)
+
+	steps := []struct {
+		after time.Duration
+		stale []string
+	}{
+		{after: 10 * time.Second, stale: nil},
+		{after: 11 * time.Second, stale: []string{"a:degraded", "registered:degraded"}},
+		{after: 26 * time.Second, stale: []string{"a:offline", "registered:offline"}},
+		{after: 3 * time.Minute, stale: []string{"slow:degraded", "a:offline", "registered:offline"}},
+	}
+	for _, step := range steps {
+		m.Check(start.Add(step.after))
+		var stale []string
+		for _, d := range m.Stale() {
+			stale = append(stale, d.UUID+":"+d.Status)
+		}
+		if len(stale) != len(step.stale) {
+			t.Fatalf("after %v: stale = %v, want %v", step.after, stale, step.stale)
+		}
+		for i := range stale {
+			if stale[i] != step.stale[i] {
+				t.Fatalf("after %v: stale = %v, want %v", step.after, stale, step.stale)
+			}
+		}
+	}
+
+	// Восстановление сообщается при первом опросе.
+	m.Seen("a", 1, start.Add(4*time.Minute))
+	want := []string{
+		"a:online>degraded", "registered:online>degraded",
+		"a:degraded>offline", "registered:degraded>offline",
+		"slow:online>degraded",
+		"a:offline>online",
+	}
+	if len(changes) != len(want) {
+		t.Fatalf("changes = %v, want %v", changes, want)
+	}
+	for i := range want {
+		if changes[i] != want[i] {
+			t.Fatalf("changes = %v, want %v", changes, want)
+		}
+	}
+
+	devices := m.Devices(heartbeat.StatusOnline)
+	if len(devices) != 1 || devices[0].UUID != "a" || devices[0].LastSeen == nil || !devices[0].Since.Equal(start.Add(4*time.Minute)) {
+		t.Errorf("online = %+v", devices)
+	}
+	if d := m.Devices(heartbeat.StatusOffline); len(d) != 1 || d[0].LastSeen != nil {
+		t.Errorf("registered device without polls = %+v", d)
+	}
+
+	m.Forget("registered")
+	if d := m.Devices(""); len(d) != 2 {
+		t.Errorf("after forget = %+v", d)
+	}
+}
+
+func TestForgetAfter(t *testing.T) {
+	m := heartbeat.NewMonitor(heartbeat.Config{DefaultInterval: 5 * time.Second, ForgetAfter: time.Hour}, nil)
+	m.Seen("once", 1, start)
+	m.Seen("registered", 1, start)
+	m.Expect("registered", 1, start)
+
+	m.Check(start.Add(time.Hour))
+	if d := m.Devices(""); len(d) != 2 {
+		t.Fatalf("before forget_after = %+v", d)
+	}
+	// Зарегистрированное устройство отслеживается, пока его не удалят из реестра.
+	m.Check(start.Add(time.Hour + time.Second))
+	if d := m.Devices(""); len(d) != 1 || d[0].UUID != "registered" {
+		t.Errorf("after forget_after = %+v", d)
+	}
+}
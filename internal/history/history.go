@@ -0,0 +1,332 @@
+package history
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+	prometheus "trafficlightAPI/internal/middleware/prometheus"
+	"trafficlightAPI/internal/models"
+
+	"github.com/pkg/errors"
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	ErrHistory   = errors.New("ошибка истории состояний")
+	ErrNoHistory = errors.New("нет истории состояний светофора на указанный момент")
+)
+
+// Record - смена состояния светофора, наблюдаемая сервером.
+type Record struct {
+	UUID  string    `json:"uuid"`
+	Type  int       `json:"type"`
+	From  int       `json:"from"`
+	State int       `json:"state"`
+	Time  time.Time `json:"time"`
+	Plan  []int     `json:"plan"`
+	Mode  string    `json:"mode"`
+}
+
+// Snapshot - состояние светофора на момент At и окружающие его смены состояний.
+type Snapshot struct {
+	UUID   string    `json:"uuid"`
+	At     time.Time `json:"at"`
+	Type   int       `json:"type"`
+	State  int       `json:"state"`
+	Since  time.Time `json:"since"`
+	Plan   []int     `json:"plan"`
+	Mode   string    `json:"mode"`
+	Before []Record  `json:"before"`
+	After  []Record  `json:"after"`
+}
+
+// Phases отличает повторы смены состояния от новых фаз: светофор, опрашивающий
+// сервис до перехода в новое состояние, получает ту же смену повторно.
+type Phases struct {
+	mu   sync.Mutex
+	last map[string]phase
+}
+
+// phase - последняя записанная смена светофора и время начала фазы.
+type phase struct {
+	from, to int
+	start    time.Time
+}
+
+func NewPhases() *Phases {
+	return &Phases{last: make(map[string]phase)}
+}
+
+// Repeat сообщает, что смена повторяет последнюю записанную: те же from и to
+// в пределах цикла от начала фазы. Иначе смена запоминается как начало новой фазы.
+func (p *Phases) Repeat(t models.Transition) bool {
+	cycle := 0
+	for _, d := range t.Plan {
+		cycle += d
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if last, ok := p.last[t.UUID]; ok && last.from == t.From && last.to == t.To && t.Time.Sub(last.start) < time.Duration(cycle)*time.Second {
+		return true
+	}
+	p.last[t.UUID] = phase{from: t.From, to: t.To, start: t.Time}
+	return false
+}
+
+// Размер очереди записи и наибольшая пачка в одной транзакции.
+const (
+	queueSize = 4096
+	maxBatch  = 256
+)
+
+// Store хранит смены состояний в bbolt: бакет на светофор,
+// ключ - время в наносекундах и порядковый номер записи.
+type Store struct {
+	db             *bolt.DB
+	maxAge         time.Duration
+	maxTransitions int
+	logger         *slog.Logger
+
+	queue chan models.Transition
+	stop  chan struct{}
+	wg    sync.WaitGroup
+}
+
+func Open(path string, maxAge time.Duration, maxTransitions int, logger *slog.Logger) (*Store, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, errors.Wrap(ErrHistory, err.Error())
+	}
+	db, err := bolt.Open(path, 0644, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, errors.Wrapf(ErrHistory, "%s: %v", path, err)
+	}
+	return &Store{
+		db: db, maxAge: maxAge, maxTransitions: maxTransitions, logger: logger,
+		queue: make(chan models.Transition, queueSize), stop: make(chan struct{}),
+	}, nil
+}
+
+func key(t time.Time, seq uint64) []byte {
+	k := make([]byte, 16)
+	binary.BigEndian.PutUint64(k, uint64(t.UnixNano()))
+	binary.BigEndian.PutUint64(k[8:], seq)
+	return k
+}
+
+// Record записывает смены состояний одной транзакцией.
+func (s *Store) Record(transitions ...models.Transition) error {
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		for _, t := range transitions {
+			value, err := json.Marshal(Record{UUID: t.UUID, Type: t.Type, From: t.From, State: t.To, Time: t.Time.UTC(), Plan: t.Plan, Mode: t.Mode})
+			if err != nil {
+				return err
+			}
+			bucket, err := tx.CreateBucketIfNotExists([]byte(t.UUID))
+			if err != nil {
+				return err
+			}
+			seq, err := bucket.NextSequence()
+			if err != nil {
+				return err
+			}
+			if err := bucket.Put(key(t.Time, seq), value); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return errors.Wrap(ErrHistory, err.Error())
+	}
+	return nil
+}
+
+// Enqueue ставит смену состояния в очередь записи (RunWriter) и не ждет
+// диска. При переполненной очереди смена отбрасывается и возвращается false.
+func (s *Store) Enqueue(t models.Transition) bool {
+	select {
+	case s.queue <- t:
+		return true
+	default:
+		prometheus.HistoryDropped.Inc()
+		return false
+	}
+}
+
+// RunWriter записывает очередь пачками до вызова Close.
+func (s *Store) RunWriter() {
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		for {
+			select {
+			case <-s.stop:
+				return
+			case t := <-s.queue:
+				s.write(t)
+			}
+		}
+	}()
+}
+
+// write записывает first и все, что накопилось в очереди, не больше maxBatch за транзакцию.
+func (s *Store) write(first models.Transition) {
+	batch := []models.Transition{first}
+	for empty := false; !empty && len(batch) < maxBatch; {
+		select {
+		case t := <-s.queue:
+			batch = append(batch, t)
+		default:
+			empty = true
+		}
+	}
+	if err := s.Record(batch...); err != nil {
+		s.logger.Error("ошибка записи истории состояний", slog.Int("transitions", len(batch)), slog.Any("err", err))
+	}
+}
+
+// At возвращает состояние светофора на момент at по последней смене не позже at.
+// Before заканчивается этой сменой и содержит до around предыдущих, After - до around следующих.
+func (s *Store) At(uuid string, at time.Time, around int) (Snapshot, error) {
+	snapshot := Snapshot{UUID: uuid, At: at, Before: []Record{}, After: []Record{}}
+	err := s.db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte(uuid))
+		if bucket == nil {
+			return errors.Wrapf(ErrNoHistory, "uuid:%s", uuid)
+		}
+
+		c := bucket.Cursor()
+		// Первая запись строго позже at.
+		k, v := c.Seek(key(at, 0))
+		for k != nil && int64(binary.BigEndian.Uint64(k)) <= at.UnixNano() {
+			k, v = c.Next()
+		}
+		for next := 0; k != nil && next < around; next++ {
+			var r Record
+			if err := json.Unmarshal(v, &r); err != nil {
+				return err
+			}
+			snapshot.After = append(snapshot.After, r)
+			k, v = c.Next()
+		}
+
+		if k == nil {
+			k, v = c.Last()
+		} else {
+			k, v = c.Prev()
+		}
+		// Возвращаемся к последней записи не позже at.
+		for k != nil && int64(binary.BigEndian.Uint64(k)) > at.UnixNano() {
+			k, v = c.Prev()
+		}
+		if k == nil {
+			return errors.Wrapf(ErrNoHistory, "uuid:%s, at:%s", uuid, at.Format(time.RFC3339))
+		}
+
+		var current Record
+		if err := json.Unmarshal(v, &current); err != nil {
+			return err
+		}
+		snapshot.Type, snapshot.State, snapshot.Since = current.Type, current.State, current.Time
+		snapshot.Plan, snapshot.Mode = current.Plan, current.Mode
+
+		for ; k != nil && len(snapshot.Before) < around+1; k, v = c.Prev() {
+			var r Record
+			if err := json.Unmarshal(v, &r); err != nil {
+				return err
+			}
+			snapshot.Before = append([]Record{r}, snapshot.Before...)
+		}
+		return nil
+	})
+	if err != nil {
+		if errors.Is(err, ErrNoHistory) {
+			return Snapshot{}, err
+		}
+		return Snapshot{}, errors.Wrap(ErrHistory, err.Error())
+	}
+	return snapshot, nil
+}
+
+// Prune удаляет записи старше maxAge и сверх maxTransitions на светофор.
+func (s *Store) Prune(now time.Time) (int, error) {
+	removed := 0
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		return tx.ForEach(func(name []byte, bucket *bolt.Bucket) error {
+			extra := 0
+			if s.maxTransitions > 0 {
+				extra = bucket.Stats().KeyN - s.maxTransitions
+			}
+
+			c := bucket.Cursor()
+			for k, _ := c.First(); k != nil; k, _ = c.First() {
+				expired := s.maxAge > 0 && int64(binary.BigEndian.Uint64(k)) < now.Add(-s.maxAge).UnixNano()
+				if !expired && extra <= 0 {
+					break
+				}
+				if err := c.Delete(); err != nil {
+					return err
+				}
+				extra--
+				removed++
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return removed, errors.Wrap(ErrHistory, err.Error())
+	}
+	return removed, nil
+}
+
+// RunRetention периодически очищает историю до вызова Close.
+func (s *Store) RunRetention(interval time.Duration) {
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-s.stop:
+				return
+			case now := <-ticker.C:
+				if _, err := s.Prune(now); err != nil {
+					s.logger.Error("ошибка очистки истории состояний", slog.Any("err", err))
+				}
+			}
+		}
+	}()
+}
+
+// Close останавливает запись и очистку, дописывает очередь и закрывает базу.
+func (s *Store) Close() error {
+	close(s.stop)
+	s.wg.Wait()
+	for len(s.queue) > 0 {
+		s.write(<-s.queue)
+	}
+	return s.db.Close()
+}
+
+var (
+	defaultMu    sync.RWMutex
+	defaultStore *Store
+)
+
+func SetDefault(s *Store) {
+	defaultMu.Lock()
+	defer defaultMu.Unlock()
+	defaultStore = s
+}
+
+func Default() *Store {
+	defaultMu.RLock()
+	defer defaultMu.RUnlock()
+	return defaultStore
+}
@@ -0,0 +1,164 @@
+package history_test
+
+import (
+	"log/slog"
+	"path/filepath"
+	"testing"
+	"time"
+	"trafficlightAPI/internal/history"
+	"trafficlightAPI/internal/models"
+
+	"github.com/pkg/errors"
+)
+
+var base = time.Date(2026, 10, 19, 8, 0, 0, 0, time.UTC)
+
+func open(t *testing.T, maxAge time.Duration, maxTransitions int) *history.Store {
+	t.Helper()
+	store, err := history.Open(filepath.Join(t.TempDir(), "history.db"), maxAge, maxTransitions, slog.Default())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { store.Close() })
+	return store
+}
+
+// record пишет цикл обычного светофора 1 -> 2 -> 3 -> 1 со сменой каждые 10с.
+func record(t *testing.T, store *history.Store, n int) {
+	t.Helper()
+	for i := range n {
+		from := i%3 + 1
+		err := store.Record(models.Transition{
+			UUID: "a", Type: 1, From: from, To: from%3 + 1,
+			Time: base.Add(time.Duration(10*i) * time.Second),
+			Plan: []int{20, 20, 20}, Mode: models.ModeNormal,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestAt(t *testing.T) {
+	store := open(t, 0, 0)
+	record(t, store, 10)
+
+	tests := []struct {
+		name   string
+		at     time.Time
+		around int
+		state  int
+		since  time.Time
+		before int
+		after  int
+	}{
+		{name: "between transitions", at: base.Add(25 * time.Second), around: 2, state: 1, since: base.Add(20 * time.Second), before: 3, after: 2},
+		{name: "exact transition", at: base.Add(30 * time.Second), around: 1, state: 2, since: base.Add(30 * time.Second), before: 2, after: 1},
+		{name: "first transition", at: base, around: 3, state: 2, since: base, before: 1, after: 3},
+		{name: "after last", at: base.Add(time.Hour), around: 3, state: 2, since: base.Add(90 * time.Second), before: 4, after: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			snapshot, err := store.At("a", tt.at, tt.around)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if snapshot.State != tt.state || !snapshot.Since.Equal(tt.since) || snapshot.Mode != models.ModeNormal {
+				t.Errorf("state = %d since %s mode %q, want %d since %s", snapshot.State, snapshot.Since, snapshot.Mode, tt.state, tt.since)
+			}
+			if len(snapshot.Before) != tt.before || len(snapshot.After) != tt.after {
+				t.Errorf("before/after = %d/%d, want %d/%d", len(snapshot.Before), len(snapshot.After), tt.before, tt.after)
+			}
+			if n := len(snapshot.Before); n > 0 && !snapshot.Before[n-1].Time.Equal(tt.since) {
+				t.Errorf("last before = %s, want %s", snapshot.Before[n-1].Time, tt.since)
+			}
+		})
+	}
+
+	if _, err := store.At("a", base.Add(-time.Second), 1); !errors.Is(err, history.ErrNoHistory) {
+		t.Errorf("before history: err = %v, want ErrNoHistory", err)
+	}
+	if _, err := store.At("b", base, 1); !errors.Is(err, history.ErrNoHistory) {
+		t.Errorf("unknown uuid: err = %v, want ErrNoHistory", err)
+	}
+}
+
+func TestPrune(t *testing.T) {
+	store := open(t, time.Minute, 5)
+	record(t, store, 10)
+
+	// Старше минуты записи на 0..20с, сверх лимита - еще две.
+	removed, err := store.Prune(base.Add(85 * time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if removed != 5 {
+		t.Errorf("removed = %d, want 5", removed)
+	}
+
+	snapshot, err := store.At("a", base.Add(time.Hour), 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(snapshot.Before) != 5 || !snapshot.Before[0].Time.Equal(base.Add(50*time.Second)) {
+		t.Errorf("remaining = %d from %s, want 5 from %s", len(snapshot.Before), snapshot.Before[0].Time, base.Add(50*time.Second))
+	}
+}
+
+func TestEnqueue(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history.db")
+	store, err := history.Open(path, 0, 0, slog.Default())
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.RunWriter()
+	for i := range 5 {
+		if !store.Enqueue(models.Transition{UUID: "a", Type: 1, From: 1, To: 2, Time: base.Add(time.Duration(i) * time.Second), Mode: models.ModeNormal}) {
+			t.Fatalf("transition %d dropped", i)
+		}
+	}
+	// Close дописывает очередь.
+	if err := store.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	store, err = history.Open(path, 0, 0, slog.Default())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	snapshot, err := store.At("a", base.Add(time.Minute), 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(snapshot.Before) != 5 {
+		t.Errorf("before = %d records, want 5", len(snapshot.Before))
+	}
+}
+
+func TestPhasesRepeat(t *testing.T) {
+	phases := history.NewPhases()
+	plan := []int{27, 3, 30}
+	tests := []struct {
+		name       string
+		transition models.Transition
+		want       bool
+	}{
+		{"first", models.Transition{UUID: "a", From: 1, To: 2, Time: base}, false},
+		{"poll before next state", models.Transition{UUID: "a", From: 1, To: 2, Time: base.Add(time.Second)}, true},
+		{"other light", models.Transition{UUID: "b", From: 1, To: 2, Time: base.Add(time.Second)}, false},
+		{"next state", models.Transition{UUID: "a", From: 2, To: 3, Time: base.Add(3 * time.Second)}, false},
+		{"next cycle", models.Transition{UUID: "a", From: 1, To: 2, Time: base.Add(60 * time.Second)}, false},
+		// Промежуточные смены не дошли, но прошел цикл - это новая фаза.
+		{"cycle later", models.Transition{UUID: "a", From: 1, To: 2, Time: base.Add(120 * time.Second)}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.transition.Plan = plan
+			if got := phases.Repeat(tt.transition); got != tt.want {
+				t.Errorf("Repeat = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -14,6 +14,14 @@ type lightState struct {
 	Arrow  bool
 }
 
+// LampFaults - неисправные лампы, они рисуются перечеркнутыми.
+type LampFaults struct {
+	Lights [3]bool // Красный, желтый, зеленый
+	Arrow  bool
+}
+
+var faultColor = color.RGBA{160, 160, 160, 255}
+
 var tl1SectionStates = [3][3]bool{
 	{true, false, false},
 	{false, true, false},
@@ -21,6 +29,10 @@ var tl1SectionStates = [3][3]bool{
 }
 
 func TrafficLight1Image(nextState int) (string, error) {
+	return TrafficLight1ImageWithFaults(nextState, LampFaults{})
+}
+
+func TrafficLight1ImageWithFaults(nextState int, faults LampFaults) (string, error) {
 	img := image.NewRGBA(image.Rect(0, 0, 20, 60))
 	bg := image.NewUniform(color.RGBA{255, 255, 255, 255})
 	draw.Draw(img, img.Bounds(), bg, image.Point{}, draw.Src)
@@ -43,7 +55,7 @@ func TrafficLight1Image(nextState int) (string, error) {
 			fillColor = colors[i]
 		}
 
-		drawCircle(img, x, y, r, fillColor)
+		drawLamp(img, x, y, r, fillColor, faults.Lights[i])
 	}
 
 	var buffer bytes.Buffer
@@ -67,6 +79,10 @@ var tl2SectionStates = [7]lightState{
 }
 
 func TrafficLight2Image(nextState int) (string, error) {
+	return TrafficLight2ImageWithFaults(nextState, LampFaults{})
+}
+
+func TrafficLight2ImageWithFaults(nextState int, faults LampFaults) (string, error) {
 	img := image.NewRGBA(image.Rect(0, 0, 40, 60))
 	bg := image.NewUniform(color.RGBA{255, 255, 255, 255})
 	draw.Draw(img, img.Bounds(), bg, image.Point{}, draw.Src)
@@ -86,13 +102,13 @@ func TrafficLight2Image(nextState int) (string, error) {
 		if state.Lights[i] {
 			fillColor = colors[i]
 		}
-		drawCircle(img, x, y, r, fillColor)
+		drawLamp(img, x, y, r, fillColor, faults.Lights[i])
 	}
 
 	if state.Arrow {
-		drawCircle(img, 30, 50, 10, arrowColor)
+		drawLamp(img, 30, 50, 10, arrowColor, faults.Arrow)
 	} else {
-		drawCircle(img, 30, 50, 10, color.RGBA{255, 255, 255, 255})
+		drawLamp(img, 30, 50, 10, color.RGBA{255, 255, 255, 255}, faults.Arrow)
 	}
 
 	var buffer bytes.Buffer
@@ -102,6 +118,22 @@ func TrafficLight2Image(nextState int) (string, error) {
 	return base64.StdEncoding.EncodeToString(buffer.Bytes()), nil
 }
 
+// drawLamp рисует секцию; неисправная секция не горит и перечеркнута.
+func drawLamp(img *image.RGBA, x, y, r int, fill color.RGBA, failed bool) {
+	if !failed {
+		drawCircle(img, x, y, r, fill)
+		return
+	}
+	drawCircle(img, x, y, r, faultColor)
+	black := color.RGBA{0, 0, 0, 255}
+	for d := -r + 3; d <= r-3; d++ {
+		img.Set(x+d, y+d, black)
+		img.Set(x+d+1, y+d, black)
+		img.Set(x+d, y-d, black)
+		img.Set(x+d+1, y-d, black)
+	}
+}
+
 func drawCircle(img *image.RGBA, x, y, r int, fill color.RGBA) {
 	for dy := -r; dy <= r; dy++ {
 		for dx := -r; dx <= r; dx++ {
@@ -0,0 +1,114 @@
+package image_generator
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"math"
+)
+
+// PurdueCycle - цикл на диаграмме координации: время по оси X от начала
+// интервала, время внутри цикла по оси Y.
+type PurdueCycle struct {
+	Start    float64   // с от начала интервала
+	Green    float64   // Начало зеленого, с от начала цикла
+	Yellow   float64   // Начало желтого, с от начала цикла
+	End      float64   // Длительность цикла, с
+	Arrivals []float64 // Прибытия, с от начала цикла
+}
+
+const (
+	purdueWidth  = 800
+	purdueHeight = 300
+	purdueMargin = 20
+)
+
+var (
+	purdueGreen  = color.RGBA{0, 200, 0, 255}
+	purdueYellow = color.RGBA{255, 200, 0, 255}
+	purdueRed    = color.RGBA{220, 0, 0, 255}
+	purdueDot    = color.RGBA{0, 0, 0, 255}
+)
+
+type purdueScale struct {
+	x, y float64
+}
+
+func newPurdueScale(cycles []PurdueCycle, window float64) purdueScale {
+	longest := 1.0
+	for _, c := range cycles {
+		longest = math.Max(longest, c.End)
+	}
+	return purdueScale{x: purdueWidth / math.Max(window, 1), y: purdueHeight / longest}
+}
+
+func (s purdueScale) point(t, inCycle float64) (int, int) {
+	return purdueMargin + int(t*s.x), purdueMargin + purdueHeight - int(inCycle*s.y)
+}
+
+func PurdueDiagram(cycles []PurdueCycle, window float64) ([]byte, error) {
+	img := image.NewRGBA(image.Rect(0, 0, purdueWidth+2*purdueMargin, purdueHeight+2*purdueMargin))
+	draw.Draw(img, img.Bounds(), image.NewUniform(color.RGBA{255, 255, 255, 255}), image.Point{}, draw.Src)
+
+	scale := newPurdueScale(cycles, window)
+	x0, y0 := scale.point(0, 0)
+	drawLine(img, x0, y0, x0+purdueWidth, y0, purdueDot)
+	drawLine(img, x0, y0, x0, y0-purdueHeight, purdueDot)
+
+	for _, c := range cycles {
+		for _, line := range []struct {
+			at    float64
+			color color.RGBA
+		}{{c.Green, purdueGreen}, {c.Yellow, purdueYellow}, {c.End, purdueRed}} {
+			xa, ya := scale.point(c.Start, line.at)
+			xb, _ := scale.point(c.Start+c.End, line.at)
+			drawLine(img, xa, ya, xb, ya, line.color)
+		}
+		for _, a := range c.Arrivals {
+			x, y := scale.point(c.Start+a, a)
+			for dx := -1; dx <= 1; dx++ {
+				for dy := -1; dy <= 1; dy++ {
+					img.Set(x+dx, y+dy, purdueDot)
+				}
+			}
+		}
+	}
+
+	var buffer bytes.Buffer
+	if err := png.Encode(&buffer, img); err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
+
+func PurdueDiagramSVG(cycles []PurdueCycle, window float64) []byte {
+	var b bytes.Buffer
+	scale := newPurdueScale(cycles, window)
+	svgColor := func(c color.RGBA) string { return fmt.Sprintf("#%02x%02x%02x", c.R, c.G, c.B) }
+
+	fmt.Fprintf(&b, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d">`+"\n", purdueWidth+2*purdueMargin, purdueHeight+2*purdueMargin)
+	fmt.Fprintf(&b, `<rect width="100%%" height="100%%" fill="white"/>`+"\n")
+	x0, y0 := scale.point(0, 0)
+	fmt.Fprintf(&b, `<polyline points="%d,%d %d,%d %d,%d" fill="none" stroke="black"/>`+"\n", x0, y0-purdueHeight, x0, y0, x0+purdueWidth, y0)
+
+	for _, c := range cycles {
+		for _, line := range []struct {
+			at    float64
+			color color.RGBA
+		}{{c.Green, purdueGreen}, {c.Yellow, purdueYellow}, {c.End, purdueRed}} {
+			xa, ya := scale.point(c.Start, line.at)
+			xb, _ := scale.point(c.Start+c.End, line.at)
+			fmt.Fprintf(&b, `<line x1="%d" y1="%d" x2="%d" y2="%d" stroke="%s"/>`+"\n", xa, ya, xb, ya, svgColor(line.color))
+		}
+		for _, a := range c.Arrivals {
+			x, y := scale.point(c.Start+a, a)
+			fmt.Fprintf(&b, `<circle cx="%d" cy="%d" r="1.5" fill="%s"/>`+"\n", x, y, svgColor(purdueDot))
+		}
+	}
+
+	b.WriteString("</svg>\n")
+	return b.Bytes()
+}
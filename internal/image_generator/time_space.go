@@ -0,0 +1,124 @@
+package image_generator
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"math"
+)
+
+type TimeSpacePhase struct {
+	Duration int
+	Color    color.RGBA
+}
+
+type TimeSpaceLight struct {
+	Position float64 // м
+	Offset   int
+	Phases   []TimeSpacePhase
+}
+
+type TimeSpaceBand struct {
+	Start  float64   // Момент проезда первого перекрестка направления
+	Width  float64   // с
+	Travel []float64 // Время проезда до каждого перекрестка от начала ленты
+	Color  color.RGBA
+}
+
+const (
+	timeSpacePixelsPerSecond = 4
+	timeSpaceHeight          = 400
+	timeSpaceMargin          = 20
+)
+
+func TimeSpaceDiagram(lights []TimeSpaceLight, bands []TimeSpaceBand, cycle, cycles int) ([]byte, error) {
+	width := cycle*cycles*timeSpacePixelsPerSecond + 2*timeSpaceMargin
+	img := image.NewRGBA(image.Rect(0, 0, width, timeSpaceHeight+2*timeSpaceMargin))
+	draw.Draw(img, img.Bounds(), image.NewUniform(color.RGBA{255, 255, 255, 255}), image.Point{}, draw.Src)
+
+	minPos, maxPos := math.Inf(1), math.Inf(-1)
+	for _, light := range lights {
+		minPos, maxPos = math.Min(minPos, light.Position), math.Max(maxPos, light.Position)
+	}
+	scale := float64(timeSpaceHeight) / math.Max(maxPos-minPos, 1)
+	y := func(position float64) int {
+		return timeSpaceMargin + timeSpaceHeight - int((position-minPos)*scale)
+	}
+	x := func(t float64) int {
+		return timeSpaceMargin + int(t*timeSpacePixelsPerSecond)
+	}
+
+	for _, band := range bands {
+		for k := -1; k <= cycles; k++ {
+			for _, edge := range []float64{band.Start, band.Start + band.Width} {
+				t0 := edge + float64(k*cycle)
+				for i := 1; i < len(lights); i++ {
+					drawLine(img,
+						x(t0+band.Travel[i-1]), y(lights[i-1].Position),
+						x(t0+band.Travel[i]), y(lights[i].Position),
+						band.Color)
+				}
+			}
+		}
+	}
+
+	for _, light := range lights {
+		for px := 0; px < cycle*cycles*timeSpacePixelsPerSecond; px++ {
+			t := float64(px)/timeSpacePixelsPerSecond + float64(light.Offset)
+			position := int(t) % cycle
+			phaseColor := color.RGBA{0, 0, 0, 255}
+			for _, phase := range light.Phases {
+				if position < phase.Duration {
+					phaseColor = phase.Color
+					break
+				}
+				position -= phase.Duration
+			}
+			for dy := -2; dy <= 2; dy++ {
+				img.Set(timeSpaceMargin+px, y(light.Position)+dy, phaseColor)
+			}
+		}
+	}
+
+	var buffer bytes.Buffer
+	if err := png.Encode(&buffer, img); err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
+
+func drawLine(img *image.RGBA, x0, y0, x1, y1 int, c color.RGBA) {
+	dx, dy := abs(x1-x0), -abs(y1-y0)
+	sx, sy := 1, 1
+	if x0 > x1 {
+		sx = -1
+	}
+	if y0 > y1 {
+		sy = -1
+	}
+
+	for e := dx + dy; ; {
+		img.Set(x0, y0, c)
+		if x0 == x1 && y0 == y1 {
+			return
+		}
+		e2 := 2 * e
+		if e2 >= dy {
+			e += dy
+			x0 += sx
+		}
+		if e2 <= dx {
+			e += dx
+			y0 += sy
+		}
+	}
+}
+
+func abs(v int) int {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
@@ -0,0 +1,281 @@
+package lights
+
+import (
+	"log/slog"
+	"sort"
+	"sync"
+	"time"
+	"trafficlightAPI/internal/models"
+	"trafficlightAPI/internal/storage"
+)
+
+// Entry - светофор и его последнее известное состояние.
+type Entry struct {
+	Light storage.Light  `json:"light"`
+	State *storage.State `json:"state,omitempty"`
+}
+
+// Registry хранит в памяти светофоры, которые видел сервис, и их последние
+// состояния, сохраняя изменения в storage.Store в фоне (RunPersist).
+type Registry struct {
+	store  storage.Store
+	logger *slog.Logger
+
+	mu     sync.RWMutex
+	lights map[string]storage.Light
+	states map[string]storage.State
+
+	onModeChange func(t models.Transition, from string)
+
+	known           func(uuid string) bool
+	maxUnregistered int
+	unregistered    map[string]bool // Светофоры вне реестра устройств, только в памяти
+
+	pendingMu sync.Mutex
+	pending   map[string]write // uuid - несохраненное изменение
+	wake      chan struct{}
+	stop      chan struct{}
+	wg        sync.WaitGroup
+}
+
+// write - изменение светофора, ожидающее сохранения. Светофор сохраняется,
+// только если он новый или сменил тип.
+type write struct {
+	light *storage.Light
+	state storage.State
+}
+
+func NewRegistry(store storage.Store, logger *slog.Logger) *Registry {
+	return &Registry{
+		store:        store,
+		logger:       logger,
+		lights:       make(map[string]storage.Light),
+		states:       make(map[string]storage.State),
+		pending:      make(map[string]write),
+		unregistered: make(map[string]bool),
+		wake:         make(chan struct{}, 1),
+		stop:         make(chan struct{}),
+	}
+}
+
+// Restore загружает светофоры и состояния из хранилища from, обычно локального.
+func (r *Registry) Restore(from storage.Store) error {
+	lights, err := from.Lights()
+	if err != nil {
+		return err
+	}
+	states, err := from.States()
+	if err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, l := range lights {
+		r.lights[l.UUID] = l
+	}
+	for _, s := range states {
+		r.states[s.UUID] = s
+	}
+	return nil
+}
+
+// OnModeChange задает обработчик смены режима светофора, from - предыдущий режим.
+// Вызывается из Observe вне блокировки реестра.
+func (r *Registry) OnModeChange(hook func(t models.Transition, from string)) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.onModeChange = hook
+}
+
+// SetKnown ограничивает сохранение светофорами, для которых known возвращает
+// true (зарегистрированными устройствами). Остальные хранятся только в памяти,
+// не больше limit: при переполнении забывается светофор с самым старым состоянием.
+func (r *Registry) SetKnown(known func(uuid string) bool, limit int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.known, r.maxUnregistered = known, limit
+}
+
+// Observe учитывает смену состояния: регистрирует новый светофор и ставит
+// состояние зарегистрированного устройства в очередь на сохранение.
+// Хранилище кластера применяет запись через лидера, поэтому Observe его не
+// ждет и не задерживает ответ устройству.
+func (r *Registry) Observe(t models.Transition) {
+	state := storage.State{UUID: t.UUID, Type: t.Type, State: t.To, Since: t.Time.UTC(), Mode: t.Mode}
+	r.mu.RLock()
+	known := r.known
+	r.mu.RUnlock()
+	registered := known == nil || known(t.UUID)
+
+	r.mu.Lock()
+	light, seen := r.lights[t.UUID]
+	changed := !seen || light.Type != t.Type
+	if changed {
+		if !seen {
+			light = storage.Light{UUID: t.UUID, RegisteredAt: time.Now().UTC()}
+		}
+		light.Type = t.Type
+		r.lights[t.UUID] = light
+	}
+	from := models.ModeNormal
+	if previous, ok := r.states[t.UUID]; ok && previous.Mode != "" {
+		from = previous.Mode
+	}
+	r.states[t.UUID] = state
+	switch {
+	case registered && r.unregistered[t.UUID]:
+		// Устройство зарегистрировали после первых запросов.
+		delete(r.unregistered, t.UUID)
+		changed = true
+	case !registered && !r.unregistered[t.UUID]:
+		r.unregistered[t.UUID] = true
+		if len(r.unregistered) > r.maxUnregistered {
+			r.forgetOldest()
+		}
+	}
+	onModeChange := r.onModeChange
+	r.mu.Unlock()
+
+	if onModeChange != nil && t.Mode != "" && t.Mode != from {
+		onModeChange(t, from)
+	}
+
+	if !registered {
+		return
+	}
+	r.pendingMu.Lock()
+	w := r.pending[t.UUID]
+	if changed {
+		w.light = &light
+	}
+	// Несохраненное состояние заменяется новым, очередь не растет быстрее числа светофоров.
+	w.state = state
+	r.pending[t.UUID] = w
+	r.pendingMu.Unlock()
+	select {
+	case r.wake <- struct{}{}:
+	default:
+	}
+}
+
+// forgetOldest забывает незарегистрированный светофор с самым старым
+// состоянием. Вызывается под r.mu.
+func (r *Registry) forgetOldest() {
+	oldest := ""
+	for uuid := range r.unregistered {
+		if oldest == "" || r.states[uuid].Since.Before(r.states[oldest].Since) {
+			oldest = uuid
+		}
+	}
+	delete(r.unregistered, oldest)
+	delete(r.lights, oldest)
+	delete(r.states, oldest)
+}
+
+// RunPersist запускает сохранение изменений до Close.
+func (r *Registry) RunPersist() {
+	r.wg.Add(1)
+	go func() {
+		defer r.wg.Done()
+		for {
+			select {
+			case <-r.stop:
+				return
+			case <-r.wake:
+				r.Flush()
+			}
+		}
+	}()
+}
+
+// Flush сохраняет накопленные изменения.
+func (r *Registry) Flush() {
+	r.pendingMu.Lock()
+	pending := r.pending
+	r.pending = make(map[string]write, len(pending))
+	r.pendingMu.Unlock()
+
+	for uuid, w := range pending {
+		if w.light != nil {
+			if err := r.store.PutLight(*w.light); err != nil {
+				r.logger.Error("ошибка сохранения светофора", slog.String("uuid", uuid), slog.Any("err", err))
+			}
+		}
+		if err := r.store.PutState(w.state); err != nil {
+			r.logger.Error("ошибка сохранения состояния светофора", slog.String("uuid", uuid), slog.Any("err", err))
+		}
+	}
+}
+
+// Close останавливает сохранение и сохраняет оставшиеся изменения.
+func (r *Registry) Close() {
+	close(r.stop)
+	r.wg.Wait()
+	r.Flush()
+}
+
+// ApplyMutation обновляет память по изменению, примененному к хранилищу
+// другим узлом кластера.
+func (r *Registry) ApplyMutation(m storage.Mutation) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	switch {
+	case m.Op == storage.OpPutLight && m.Light != nil:
+		r.lights[m.Light.UUID] = *m.Light
+	case m.Op == storage.OpDeleteLight:
+		delete(r.lights, m.Key)
+		delete(r.states, m.Key)
+	case m.Op == storage.OpPutState && m.State != nil:
+		r.states[m.State.UUID] = *m.State
+	case m.Op == storage.OpDeleteState:
+		delete(r.states, m.Key)
+	}
+}
+
+func (r *Registry) Get(uuid string) (Entry, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	light, ok := r.lights[uuid]
+	if !ok {
+		return Entry{}, false
+	}
+	return r.entry(light), true
+}
+
+// All возвращает светофоры в порядке UUID.
+func (r *Registry) All() []Entry {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	entries := make([]Entry, 0, len(r.lights))
+	for _, light := range r.lights {
+		entries = append(entries, r.entry(light))
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Light.UUID < entries[j].Light.UUID })
+	return entries
+}
+
+func (r *Registry) entry(light storage.Light) Entry {
+	entry := Entry{Light: light}
+	if state, ok := r.states[light.UUID]; ok {
+		entry.State = &state
+	}
+	return entry
+}
+
+var (
+	defaultMu       sync.RWMutex
+	defaultRegistry *Registry
+)
+
+func SetDefault(r *Registry) {
+	defaultMu.Lock()
+	defer defaultMu.Unlock()
+	defaultRegistry = r
+}
+
+func Default() *Registry {
+	defaultMu.RLock()
+	defer defaultMu.RUnlock()
+	return defaultRegistry
+}
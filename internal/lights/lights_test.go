@@ -0,0 +1,83 @@
+package lights_test
+
+import (
+	"log/slog"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+	"trafficlightAPI/internal/lights"
+	"trafficlightAPI/internal/models"
+	"trafficlightAPI/internal/storage"
+)
+
+func TestPersist(t *testing.T) {
+	store, err := storage.OpenBolt(filepath.Join(t.TempDir(), "state.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	registry := lights.NewRegistry(store, slog.Default())
+	ts := time.Date(2026, 10, 19, 8, 0, 0, 0, time.UTC)
+	registry.Observe(models.Transition{UUID: "a", Type: 1, To: 3, Time: ts, Mode: models.ModeNormal})
+	registry.Observe(models.Transition{UUID: "a", Type: 1, To: 1, Time: ts.Add(time.Minute), Mode: models.ModeNormal})
+
+	// Состояние доступно сразу, в хранилище попадает при сохранении.
+	if entry, ok := registry.Get("a"); !ok || entry.State == nil || entry.State.State != 1 {
+		t.Fatalf("entry = %+v, %v, want red", entry, ok)
+	}
+	if states, err := store.States(); err != nil || len(states) != 0 {
+		t.Fatalf("states before flush = %+v, %v", states, err)
+	}
+
+	registry.RunPersist()
+	registry.Close()
+	if lights, err := store.Lights(); err != nil || len(lights) != 1 || lights[0].UUID != "a" || lights[0].Type != 1 {
+		t.Errorf("lights = %+v, %v", lights, err)
+	}
+	if states, err := store.States(); err != nil || len(states) != 1 || states[0].State != 1 || !states[0].Since.Equal(ts.Add(time.Minute)) {
+		t.Errorf("states = %+v, %v, want last red", states, err)
+	}
+}
+
+func TestUnregistered(t *testing.T) {
+	store, err := storage.OpenBolt(filepath.Join(t.TempDir(), "state.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	registered := map[string]bool{"known": true}
+	registry := lights.NewRegistry(store, slog.Default())
+	registry.SetKnown(func(uuid string) bool { return registered[uuid] }, 2)
+	ts := time.Date(2026, 10, 19, 8, 0, 0, 0, time.UTC)
+	for i, uuid := range []string{"x", "known", "y", "z"} {
+		registry.Observe(models.Transition{UUID: uuid, Type: 1, To: 1, Time: ts.Add(time.Duration(i) * time.Second), Mode: models.ModeNormal})
+	}
+
+	// Самый старый незарегистрированный светофор забыт при переполнении.
+	var uuids []string
+	for _, e := range registry.All() {
+		uuids = append(uuids, e.Light.UUID)
+	}
+	if !reflect.DeepEqual(uuids, []string{"known", "y", "z"}) {
+		t.Errorf("lights = %v, want known, y, z", uuids)
+	}
+
+	// После регистрации светофор сохраняется при следующей смене.
+	registered["y"] = true
+	registry.Observe(models.Transition{UUID: "y", Type: 1, To: 3, Time: ts.Add(time.Minute), Mode: models.ModeNormal})
+	registry.Flush()
+	lights, err := store.Lights()
+	if err != nil {
+		t.Fatal(err)
+	}
+	uuids = nil
+	for _, l := range lights {
+		uuids = append(uuids, l.UUID)
+	}
+	if !reflect.DeepEqual(uuids, []string{"known", "y"}) {
+		t.Errorf("stored lights = %v, want known, y", uuids)
+	}
+}
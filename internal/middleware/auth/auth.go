@@ -0,0 +1,287 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"net/http"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/pkg/errors"
+)
+
+var (
+	ErrUnauthenticated    = errors.New("требуется аутентификация")
+	ErrInvalidCredentials = errors.New("некорректные учетные данные")
+	ErrForbidden          = errors.New("недостаточно прав")
+	ErrInvalidRole        = errors.New("неизвестная роль")
+)
+
+const (
+	APIKeyHeader = "X-API-Key"
+
+	MethodAPIKey = "api_key"
+	MethodJWT    = "jwt"
+)
+
+// Role - роль пользователя, каждая следующая включает права предыдущих.
+type Role int
+
+const (
+	RoleViewer   Role = iota + 1 // Чтение состояния, отчетов, метрик и документации
+	RoleOperator                 // Ручное управление
+	RoleEngineer                 // Изменение планов
+	RoleAdmin                    // Служебные функции
+)
+
+var roleNames = map[Role]string{
+	RoleViewer:   "viewer",
+	RoleOperator: "operator",
+	RoleEngineer: "engineer",
+	RoleAdmin:    "admin",
+}
+
+func (r Role) String() string {
+	return roleNames[r]
+}
+
+func ParseRole(s string) (Role, error) {
+	for role, name := range roleNames {
+		if name == s {
+			return role, nil
+		}
+	}
+	return 0, errors.Wrapf(ErrInvalidRole, "%q", s)
+}
+
+type Identity struct {
+	Name   string `json:"name"`
+	Role   Role   `json:"role"`
+	Method string `json:"method"`
+}
+
+type APIKey struct {
+	Name   string
+	SHA256 string // Хеш ключа в hex
+	Role   string
+}
+
+type Config struct {
+	Enabled   bool
+	APIKeys   []APIKey
+	JWKSPath  string // Пусто - JWT не принимаются
+	Issuer    string
+	Audience  string
+	RoleClaim string
+}
+
+// ErrorWriter отправляет ответ об ошибке в формате сервиса.
+type ErrorWriter func(w http.ResponseWriter, status int, userErr error, errs ...error)
+
+type apiKey struct {
+	hash     [sha256.Size]byte
+	identity Identity
+}
+
+type Authenticator struct {
+	enabled    bool
+	keys       []apiKey
+	jwks       map[string]crypto.PublicKey
+	parser     *jwt.Parser
+	roleClaim  string
+	writeError ErrorWriter
+}
+
+func New(cfg Config, writeError ErrorWriter) (*Authenticator, error) {
+	a := &Authenticator{enabled: cfg.Enabled, roleClaim: cfg.RoleClaim, writeError: writeError}
+	if a.roleClaim == "" {
+		a.roleClaim = "role"
+	}
+	if !cfg.Enabled {
+		return a, nil
+	}
+
+	for _, k := range cfg.APIKeys {
+		role, err := ParseRole(k.Role)
+		if err != nil {
+			return nil, errors.Wrapf(err, "ключ %q", k.Name)
+		}
+		hash, err := hex.DecodeString(k.SHA256)
+		if err != nil || len(hash) != sha256.Size {
+			return nil, errors.Wrapf(ErrInvalidCredentials, "ключ %q: ожидается sha256 в hex", k.Name)
+		}
+		key := apiKey{identity: Identity{Name: k.Name, Role: role, Method: MethodAPIKey}}
+		copy(key.hash[:], hash)
+		a.keys = append(a.keys, key)
+	}
+
+	if cfg.JWKSPath != "" {
+		keys, err := LoadJWKS(cfg.JWKSPath)
+		if err != nil {
+			return nil, err
+		}
+		a.jwks = keys
+
+		options := []jwt.ParserOption{
+			jwt.WithValidMethods([]string{"RS256", "RS384", "RS512", "PS256", "PS384", "PS512", "ES256", "ES384", "ES512", "EdDSA"}),
+			jwt.WithExpirationRequired(),
+		}
+		if cfg.Issuer != "" {
+			options = append(options, jwt.WithIssuer(cfg.Issuer))
+		}
+		if cfg.Audience != "" {
+			options = append(options, jwt.WithAudience(cfg.Audience))
+		}
+		a.parser = jwt.NewParser(options...)
+	}
+	return a, nil
+}
+
+// Authenticate проверяет API ключ из X-API-Key или JWT из Authorization: Bearer.
+func (a *Authenticator) Authenticate(r *http.Request) (Identity, error) {
+	return a.Credentials(r.Header.Get(APIKeyHeader), r.Header.Get("Authorization"))
+}
+
+// Credentials проверяет API ключ или значение Authorization, переданные не в
+// заголовках HTTP, например в метаданных gRPC.
+func (a *Authenticator) Credentials(key, authorization string) (Identity, error) {
+	if key != "" {
+		return a.apiKey(key)
+	}
+	if token, ok := strings.CutPrefix(authorization, "Bearer "); ok {
+		return a.token(strings.TrimSpace(token))
+	}
+	return Identity{}, ErrUnauthenticated
+}
+
+// Enabled сообщает, включена ли аутентификация.
+func (a *Authenticator) Enabled() bool {
+	return a.enabled
+}
+
+// Named возвращает описание API ключа по имени. Нужно протоколам, которые
+// проверяют пользователя сами, например SNMPv3, и берут роль из ключа.
+func (a *Authenticator) Named(name string) (Identity, bool) {
+	for _, k := range a.keys {
+		if k.identity.Name == name {
+			return k.identity, true
+		}
+	}
+	return Identity{}, false
+}
+
+func (a *Authenticator) apiKey(key string) (Identity, error) {
+	hash := sha256.Sum256([]byte(key))
+	var found *Identity
+	// Сравниваются все ключи, чтобы время ответа не зависело от совпадения.
+	for i := range a.keys {
+		if subtle.ConstantTimeCompare(hash[:], a.keys[i].hash[:]) == 1 {
+			found = &a.keys[i].identity
+		}
+	}
+	if found == nil {
+		return Identity{}, errors.Wrap(ErrInvalidCredentials, "API ключ")
+	}
+	return *found, nil
+}
+
+func (a *Authenticator) token(raw string) (Identity, error) {
+	if a.parser == nil {
+		return Identity{}, errors.Wrap(ErrInvalidCredentials, "JWT не настроен")
+	}
+
+	claims := jwt.MapClaims{}
+	_, err := a.parser.ParseWithClaims(raw, claims, func(t *jwt.Token) (any, error) {
+		kid, _ := t.Header["kid"].(string)
+		if key, ok := a.jwks[kid]; ok {
+			return key, nil
+		}
+		if kid == "" && len(a.jwks) == 1 {
+			for _, key := range a.jwks {
+				return key, nil
+			}
+		}
+		return nil, errors.Errorf("неизвестный kid %q", kid)
+	})
+	if err != nil {
+		return Identity{}, errors.Wrap(ErrInvalidCredentials, err.Error())
+	}
+
+	role, err := a.claimRole(claims[a.roleClaim])
+	if err != nil {
+		return Identity{}, errors.Wrap(ErrInvalidCredentials, err.Error())
+	}
+	subject, _ := claims.GetSubject()
+	return Identity{Name: subject, Role: role, Method: MethodJWT}, nil
+}
+
+// claimRole принимает роль строкой или списком, из списка берется старшая.
+func (a *Authenticator) claimRole(claim any) (Role, error) {
+	var names []string
+	switch v := claim.(type) {
+	case string:
+		names = []string{v}
+	case []any:
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				names = append(names, s)
+			}
+		}
+	}
+
+	var best Role
+	for _, name := range names {
+		if role, err := ParseRole(name); err == nil {
+			best = max(best, role)
+		}
+	}
+	if best == 0 {
+		return 0, errors.Wrapf(ErrInvalidRole, "claim %q: %v", a.roleClaim, claim)
+	}
+	return best, nil
+}
+
+type contextKey struct{}
+
+// WithIdentity сохраняет пользователя в контексте для FromContext.
+func WithIdentity(ctx context.Context, identity Identity) context.Context {
+	return context.WithValue(ctx, contextKey{}, identity)
+}
+
+func FromContext(ctx context.Context) (Identity, bool) {
+	identity, ok := ctx.Value(contextKey{}).(Identity)
+	return identity, ok
+}
+
+// Require пропускает запросы пользователей с ролью не ниже role.
+// При выключенной аутентификации пропускает все запросы.
+func (a *Authenticator) Require(role Role) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !a.enabled {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			identity, err := a.Authenticate(r)
+			if err != nil {
+				w.Header().Set("WWW-Authenticate", `Bearer, ApiKey header="`+APIKeyHeader+`"`)
+				if errors.Is(err, ErrUnauthenticated) {
+					a.writeError(w, http.StatusUnauthorized, err)
+				} else {
+					a.writeError(w, http.StatusUnauthorized, ErrInvalidCredentials, err)
+				}
+				return
+			}
+			if identity.Role < role {
+				a.writeError(w, http.StatusForbidden, ErrForbidden, errors.Errorf("%s: роль %s, требуется %s", identity.Name, identity.Role, role))
+				return
+			}
+
+			next.ServeHTTP(w, r.WithContext(WithIdentity(r.Context(), identity)))
+		})
+	}
+}
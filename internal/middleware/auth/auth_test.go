@@ -0,0 +1,175 @@
+package auth_test
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+	"trafficlightAPI/internal/middleware/auth"
+	"trafficlightAPI/internal/models"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func writeError(w http.ResponseWriter, status int, userErr error, errs ...error) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(models.ErrorResponse{Error: userErr.Error()})
+}
+
+func hash(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+func writeJWKS(t *testing.T, key *rsa.PublicKey) string {
+	t.Helper()
+	set := map[string]any{"keys": []map[string]string{{
+		"kty": "RSA",
+		"kid": "test",
+		"use": "sig",
+		"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+		"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+	}}}
+	data, err := json.Marshal(set)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func sign(t *testing.T, key *rsa.PrivateKey, kid string, claims jwt.MapClaims) string {
+	t.Helper()
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = kid
+	signed, err := token.SignedString(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed
+}
+
+func TestRequire(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	authn, err := auth.New(auth.Config{
+		Enabled: true,
+		APIKeys: []auth.APIKey{
+			{Name: "grafana", SHA256: hash("viewer-key"), Role: "viewer"},
+			{Name: "dispatcher", SHA256: hash("operator-key"), Role: "operator"},
+		},
+		JWKSPath: writeJWKS(t, &key.PublicKey),
+		Issuer:   "https://sso.example",
+		Audience: "trafficlight",
+	}, writeError)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	claims := func(role any, exp time.Duration) jwt.MapClaims {
+		return jwt.MapClaims{
+			"sub": "ivanov", "role": role, "iss": "https://sso.example", "aud": "trafficlight",
+			"exp": time.Now().Add(exp).Unix(),
+		}
+	}
+	other, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		required auth.Role
+		header   string
+		value    string
+		want     int
+		wantUser string
+	}{
+		{name: "no credentials", required: auth.RoleViewer, want: http.StatusUnauthorized},
+		{name: "unknown key", required: auth.RoleViewer, header: auth.APIKeyHeader, value: "nope", want: http.StatusUnauthorized},
+		{name: "viewer key", required: auth.RoleViewer, header: auth.APIKeyHeader, value: "viewer-key", want: http.StatusOK, wantUser: "grafana"},
+		{name: "viewer key on operator route", required: auth.RoleOperator, header: auth.APIKeyHeader, value: "viewer-key", want: http.StatusForbidden},
+		{name: "operator key", required: auth.RoleOperator, header: auth.APIKeyHeader, value: "operator-key", want: http.StatusOK, wantUser: "dispatcher"},
+		{name: "jwt engineer", required: auth.RoleEngineer, header: "Authorization", value: "Bearer " + sign(t, key, "test", claims("engineer", time.Minute)), want: http.StatusOK, wantUser: "ivanov"},
+		{name: "jwt roles list", required: auth.RoleAdmin, header: "Authorization", value: "Bearer " + sign(t, key, "test", claims([]any{"viewer", "admin"}, time.Minute)), want: http.StatusOK, wantUser: "ivanov"},
+		{name: "jwt operator on engineer route", required: auth.RoleEngineer, header: "Authorization", value: "Bearer " + sign(t, key, "test", claims("operator", time.Minute)), want: http.StatusForbidden},
+		{name: "jwt expired", required: auth.RoleViewer, header: "Authorization", value: "Bearer " + sign(t, key, "test", claims("admin", -time.Minute)), want: http.StatusUnauthorized},
+		{name: "jwt foreign key", required: auth.RoleViewer, header: "Authorization", value: "Bearer " + sign(t, other, "test", claims("admin", time.Minute)), want: http.StatusUnauthorized},
+		{name: "jwt unknown role", required: auth.RoleViewer, header: "Authorization", value: "Bearer " + sign(t, key, "test", claims("root", time.Minute)), want: http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var user string
+			handler := authn.Require(tt.required)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				identity, _ := auth.FromContext(r.Context())
+				user = identity.Name
+			}))
+
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tt.header != "" {
+				req.Header.Set(tt.header, tt.value)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			if rec.Code != tt.want {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.want, rec.Body.String())
+			}
+			if tt.want != http.StatusOK {
+				var body models.ErrorResponse
+				if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil || body.Error == "" {
+					t.Errorf("body = %q, want ErrorResponse", rec.Body.String())
+				}
+				return
+			}
+			if user != tt.wantUser {
+				t.Errorf("user = %q, want %q", user, tt.wantUser)
+			}
+		})
+	}
+}
+
+func TestDisabled(t *testing.T) {
+	authn, err := auth.New(auth.Config{}, writeError)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec := httptest.NewRecorder()
+	authn.Require(auth.RoleAdmin)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := auth.FromContext(r.Context()); ok {
+			t.Error("identity set with auth disabled")
+		}
+	})).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("status = %d, want 200", rec.Code)
+	}
+}
+
+func TestInvalidConfig(t *testing.T) {
+	tests := []auth.Config{
+		{Enabled: true, APIKeys: []auth.APIKey{{Name: "a", SHA256: hash("k"), Role: "root"}}},
+		{Enabled: true, APIKeys: []auth.APIKey{{Name: "a", SHA256: "k", Role: "admin"}}},
+		{Enabled: true, JWKSPath: filepath.Join(t.TempDir(), "missing.json")},
+	}
+	for _, cfg := range tests {
+		if _, err := auth.New(cfg, writeError); err == nil {
+			t.Errorf("New(%+v) succeeded", cfg)
+		}
+	}
+}
@@ -2,8 +2,10 @@ package prometheus
 
 import (
 	"net/http"
+	"strconv"
 	"time"
 
+	"github.com/go-chi/chi/v5"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promauto"
 )
@@ -35,6 +37,116 @@ var (
 		Buckets: []float64{0.005, 0.01, 0.025, 0.05, 0.075, 0.1, 0.25, 0.5, 0.75, 1.0, 2.5, 5.0, 7.5, 10.0},
 	}, []string{"trafficlight", "need_image"})
 
+	TrafficRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "trafficlight_requests_total",
+		Help: "Number of next state requests by protocol (http, grpc, coap)",
+	}, []string{"protocol"})
+
+	ControllerEvents = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "controller_events_total",
+		Help: "Number of recorded high-resolution controller events by event code",
+	}, []string{"code"})
+
+	SignatureFailures = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "signature_failures_total",
+		Help: "Number of rejected device requests by signature verification failure reason",
+	}, []string{"reason"})
+
+	DevicesHeartbeat = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "devices_heartbeat",
+		Help: "Number of tracked devices by cluster node and heartbeat status (online, degraded, offline)",
+	}, []string{"node", "status"})
+
+	HeartbeatTransitions = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "heartbeat_transitions_total",
+		Help: "Number of device heartbeat status changes by cluster node and new status",
+	}, []string{"node", "status"})
+
+	LampFaults = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "lamp_faults",
+		Help: "Number of reported lamp faults by trafficlight type and lamp",
+	}, []string{"type", "lamp"})
+
+	DegradedResponses = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "degraded_responses_total",
+		Help: "Number of trafficlight responses changed by the lamp fault policy by action",
+	}, []string{"action"})
+
+	MonitorFaults = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "monitor_faults_total",
+		Help: "Number of faults latched by the conflict monitor by kind",
+	}, []string{"kind"})
+
+	MonitorLatched = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "monitor_latched",
+		Help: "1 if the intersection is in conflict monitor failsafe (flashing red), 0 otherwise",
+	}, []string{"intersection"})
+
+	StreamConnections = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "stream_connections",
+		Help: "Number of open state streaming connections by transport",
+	}, []string{"transport"})
+
+	StreamEventsSent = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "stream_events_sent_total",
+		Help: "Number of state change events written to streaming clients by transport",
+	}, []string{"transport"})
+
+	StreamEventsDropped = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "stream_events_dropped_total",
+		Help: "Number of state change events dropped for slow streaming clients by transport",
+	}, []string{"transport"})
+
+	StreamDisconnects = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "stream_disconnects_total",
+		Help: "Number of closed streaming connections by transport and reason",
+	}, []string{"transport", "reason"})
+
+	GRPCRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "grpc_requests_total",
+		Help: "Number of gRPC calls by method and status code",
+	}, []string{"method", "code"})
+
+	ModbusConnections = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "modbus_connections",
+		Help: "Number of open Modbus TCP connections",
+	})
+
+	ModbusRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "modbus_requests_total",
+		Help: "Number of Modbus requests by function code and exception code (0 - success)",
+	}, []string{"function", "exception"})
+
+	SNMPRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "snmp_requests_total",
+		Help: "Number of SNMP requests by PDU type and error status",
+	}, []string{"pdu", "error"})
+
+	MQTTPublished = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "mqtt_published_total",
+		Help: "Number of light states published to MQTT by result",
+	}, []string{"result"})
+
+	MQTTCommands = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "mqtt_commands_total",
+		Help: "Number of MQTT override commands by action and result",
+	}, []string{"action", "result"})
+
+	MQTTConnections = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "mqtt_connections_total",
+		Help: "Number of MQTT connection events by result",
+	}, []string{"result"})
+
+	HistoryDropped = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "history_dropped_total",
+		Help: "Number of state changes not written to history because the write queue was full",
+	})
+
+	ControllerEventsDropped = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "controller_events_dropped_total",
+		Help: "Number of state changes not written to the controller event log because the write queue was full",
+	})
+
 	ErrorsAmount = prometheus.NewCounterVec(prometheus.CounterOpts{
 		Name: "errors_amount_total",
 		Help: "Http errors",
@@ -45,11 +157,35 @@ func init() {
 	prometheus.MustRegister(ErrorsAmount)
 }
 
+// ResponseTimeMiddleware помечает время ответа шаблоном маршрута chi, а не
+// путем запроса: uuid в пути и случайные адреса не плодят серии метрики.
 func ResponseTimeMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		start := time.Now()
 		next.ServeHTTP(w, r)
 		duration := time.Since(start).Nanoseconds()
-		ResponseTime.WithLabelValues(r.URL.Path).Set(float64(duration))
+		route := "unmatched"
+		if rctx := chi.RouteContext(r.Context()); rctx != nil && rctx.RoutePattern() != "" {
+			route = rctx.RoutePattern()
+		}
+		ResponseTime.WithLabelValues(route).Set(float64(duration))
 	})
 }
+
+// CountTrafficRequest учитывает принятый запрос следующего состояния.
+// Общая для всех протоколов, чтобы метрики не зависели от способа подключения устройства.
+func CountTrafficRequest(protocol string, trafficType int, needImage bool) {
+	TrafficRequests.WithLabelValues(protocol).Inc()
+	RequestedTypes.WithLabelValues("trafficlight" + strconv.Itoa(trafficType)).Inc()
+	RequestedTotal.Inc()
+	if needImage {
+		ImageRequest.WithLabelValues("image_requested").Inc()
+	} else {
+		ImageRequest.WithLabelValues("image_not_requested").Inc()
+	}
+}
+
+// ObserveTrafficRequest учитывает время обработки успешного запроса следующего состояния.
+func ObserveTrafficRequest(trafficType int, needImage bool, duration time.Duration) {
+	RequestDuration.WithLabelValues(strconv.Itoa(trafficType), strconv.FormatBool(needImage)).Observe(duration.Seconds())
+}
@@ -0,0 +1,299 @@
+package signing
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	prometheus "trafficlightAPI/internal/middleware/prometheus"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+)
+
+var (
+	ErrSignature = errors.New("некорректная подпись запроса")
+	ErrSecrets   = errors.New("ошибка загрузки секретов устройств")
+	ErrDevice    = errors.New("uuid не совпадает с подписавшим устройством")
+	ErrBusy      = errors.New("кеш nonce заполнен, повторите запрос позже")
+)
+
+// Заголовки подписанного запроса.
+const (
+	DeviceHeader    = "X-Device-ID"
+	TimestampHeader = "X-Timestamp" // Unix время в секундах
+	NonceHeader     = "X-Nonce"
+	SignatureHeader = "X-Signature" // hex HMAC-SHA256 от StringToSign
+)
+
+// Причины отказа, значения метки reason счетчика signature_failures_total.
+const (
+	ReasonMissing       = "missing"
+	ReasonUnknownDevice = "unknown_device"
+	ReasonTimestamp     = "timestamp"
+	ReasonStale         = "stale"
+	ReasonSignature     = "signature"
+	ReasonReplay        = "replay"
+	ReasonBody          = "body"
+	ReasonDevice        = "device_mismatch"
+	ReasonBusy          = "nonce_cache_full"
+)
+
+const maxBodySize = 1 << 20
+
+// nonceRate - запросов в секунду на устройство, под которые рассчитывается
+// кеш nonce по умолчанию: устройство опрашивает сервис раз в секунду, запас вдвое.
+const nonceRate = 2
+
+type Config struct {
+	Enabled    bool
+	Secrets    map[string][]byte // Секреты по UUID устройства
+	MaxSkew    time.Duration     // Допустимое расхождение X-Timestamp с часами сервера
+	NonceCache int               // Максимум запомненных nonce, 0 - по числу устройств
+}
+
+// ErrorWriter отправляет ответ об ошибке в формате сервиса.
+type ErrorWriter func(w http.ResponseWriter, status int, userErr error, errs ...error)
+
+// LoadSecrets читает секреты устройств из YAML файла со списком devices: [{uuid, secret}].
+func LoadSecrets(path string) (map[string][]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrap(ErrSecrets, err.Error())
+	}
+	var file struct {
+		Devices []struct {
+			UUID   string `yaml:"uuid"`
+			Secret string `yaml:"secret"`
+		} `yaml:"devices"`
+	}
+	if err := yaml.Unmarshal(data, &file); err != nil {
+		return nil, errors.Wrapf(ErrSecrets, "%s: %v", path, err)
+	}
+
+	secrets := make(map[string][]byte, len(file.Devices))
+	for _, d := range file.Devices {
+		if d.UUID == "" || len(d.Secret) < 16 {
+			return nil, errors.Wrapf(ErrSecrets, "устройство %q: нужен uuid и секрет не короче 16 символов", d.UUID)
+		}
+		secrets[d.UUID] = []byte(d.Secret)
+	}
+	return secrets, nil
+}
+
+// StringToSign собирает подписываемую строку: метод, путь, отсортированный
+// query, время, nonce и SHA-256 тела, разделенные переводом строки.
+func StringToSign(method, path, rawQuery, timestamp, nonce string, body []byte) string {
+	query := rawQuery
+	if values, err := url.ParseQuery(rawQuery); err == nil {
+		query = values.Encode()
+	}
+	sum := sha256.Sum256(body)
+	return strings.Join([]string{method, path, query, timestamp, nonce, hex.EncodeToString(sum[:])}, "\n")
+}
+
+// Sign возвращает подпись запроса для заголовка X-Signature.
+func Sign(secret []byte, stringToSign string) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(stringToSign))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verifier помнит nonce в памяти процесса. Реплики кластера кеш не делят,
+// поэтому запрос, перехваченный в пределах окна, можно повторить на другой
+// реплике: запросы устройства должны приходить на одну реплику.
+type Verifier struct {
+	cfg        Config
+	nonces     *nonceCache
+	writeError ErrorWriter
+	now        func() time.Time
+}
+
+func New(cfg Config, writeError ErrorWriter) *Verifier {
+	if cfg.MaxSkew <= 0 {
+		cfg.MaxSkew = 30 * time.Second
+	}
+	// Nonce хранятся два max_skew, поэтому кеш по умолчанию вмещает
+	// nonceRate запросов в секунду от каждого устройства за это время.
+	if cfg.NonceCache <= 0 {
+		cfg.NonceCache = max(len(cfg.Secrets), 1) * int((2 * cfg.MaxSkew).Seconds()) * nonceRate
+	}
+	return &Verifier{cfg: cfg, nonces: newNonceCache(cfg.NonceCache), writeError: writeError, now: time.Now}
+}
+
+// SetClock подменяет часы, для тестов.
+func (v *Verifier) SetClock(now func() time.Time) {
+	v.now = now
+}
+
+func (v *Verifier) fail(w http.ResponseWriter, reason string, err error) {
+	prometheus.SignatureFailures.WithLabelValues(reason).Inc()
+	if reason == ReasonBusy {
+		v.writeError(w, http.StatusServiceUnavailable, ErrBusy, err)
+		return
+	}
+	v.writeError(w, http.StatusUnauthorized, ErrSignature, errors.Wrap(err, reason))
+}
+
+// Verify проверяет подпись, время и неповторяемость nonce. При выключенной
+// проверке пропускает все запросы.
+func (v *Verifier) Verify(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !v.cfg.Enabled {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		device, timestamp := r.Header.Get(DeviceHeader), r.Header.Get(TimestampHeader)
+		nonce, signature := r.Header.Get(NonceHeader), r.Header.Get(SignatureHeader)
+		if device == "" || timestamp == "" || nonce == "" || signature == "" {
+			v.fail(w, ReasonMissing, errors.New("нет заголовков подписи"))
+			return
+		}
+		body, err := io.ReadAll(io.LimitReader(r.Body, maxBodySize+1))
+		r.Body.Close()
+		if err != nil || len(body) > maxBodySize {
+			v.fail(w, ReasonBody, errors.Errorf("тело запроса: %v", err))
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		if reason, err := v.check(device, timestamp, nonce, signature, StringToSign(r.Method, r.URL.Path, r.URL.RawQuery, timestamp, nonce, body)); err != nil {
+			v.fail(w, reason, err)
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(WithDevice(r.Context(), device)))
+	})
+}
+
+// Enabled сообщает, что запросы устройств должны быть подписаны.
+func (v *Verifier) Enabled() bool {
+	return v.cfg.Enabled
+}
+
+// Check проверяет подпись сообщения протокола без HTTP заголовков: значения
+// полей те же, stringToSign собирается StringToSign из метода и пути протокола.
+// Ошибка оборачивает ErrSignature и причину отказа или ErrBusy.
+func (v *Verifier) Check(device, timestamp, nonce, signature, stringToSign string) error {
+	if device == "" || timestamp == "" || nonce == "" || signature == "" {
+		prometheus.SignatureFailures.WithLabelValues(ReasonMissing).Inc()
+		return errors.Wrap(ErrSignature, ReasonMissing)
+	}
+	if reason, err := v.check(device, timestamp, nonce, signature, stringToSign); err != nil {
+		prometheus.SignatureFailures.WithLabelValues(reason).Inc()
+		if reason == ReasonBusy {
+			return errors.Wrap(ErrBusy, err.Error())
+		}
+		return errors.Wrapf(ErrSignature, "%s: %v", reason, err)
+	}
+	return nil
+}
+
+// check проверяет устройство, время, подпись и неповторяемость nonce и
+// возвращает причину отказа.
+func (v *Verifier) check(device, timestamp, nonce, signature, stringToSign string) (string, error) {
+	secret, ok := v.cfg.Secrets[device]
+	if !ok {
+		return ReasonUnknownDevice, errors.Errorf("устройство %s", device)
+	}
+	seconds, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return ReasonTimestamp, err
+	}
+	now := v.now()
+	at := time.Unix(seconds, 0)
+	if skew := now.Sub(at); skew > v.cfg.MaxSkew || skew < -v.cfg.MaxSkew {
+		return ReasonStale, errors.Errorf("расхождение времени %s", skew.Truncate(time.Second))
+	}
+	if !hmac.Equal([]byte(Sign(secret, stringToSign)), []byte(strings.ToLower(signature))) {
+		return ReasonSignature, errors.Errorf("устройство %s", device)
+	}
+	// Nonce запоминается только после проверки подписи, чтобы
+	// неподписанные запросы не вытесняли записи из кеша.
+	if reason := v.nonces.add(device+"\n"+nonce, now, now.Add(-2*v.cfg.MaxSkew)); reason != "" {
+		return reason, errors.Errorf("устройство %s, nonce %s", device, nonce)
+	}
+	return "", nil
+}
+
+// CheckDevice проверяет, что uuid из запроса принадлежит подписавшему устройству.
+// Для неподписанных запросов (проверка выключена) всегда nil.
+func CheckDevice(r *http.Request, uuid string) error {
+	return CheckContext(r.Context(), uuid)
+}
+
+// CheckContext - CheckDevice для устройства из контекста вызова любого протокола.
+func CheckContext(ctx context.Context, uuid string) error {
+	device, ok := DeviceFromContext(ctx)
+	if !ok || device == uuid {
+		return nil
+	}
+	prometheus.SignatureFailures.WithLabelValues(ReasonDevice).Inc()
+	return errors.Wrapf(ErrDevice, "подписано %s, uuid %s", device, uuid)
+}
+
+type contextKey struct{}
+
+// WithDevice возвращает контекст с UUID устройства, подписавшего запрос.
+func WithDevice(ctx context.Context, device string) context.Context {
+	return context.WithValue(ctx, contextKey{}, device)
+}
+
+func DeviceFromContext(ctx context.Context) (string, bool) {
+	device, ok := ctx.Value(contextKey{}).(string)
+	return device, ok
+}
+
+// nonceCache помнит nonce в порядке поступления. Записи старше окна
+// удаляются. Живые записи не вытесняются: иначе вытесненный nonce можно
+// повторить, поэтому при переполнении новые запросы отклоняются.
+type nonceCache struct {
+	mu    sync.Mutex
+	limit int
+	seen  map[string]time.Time
+	order []string
+	head  int
+}
+
+func newNonceCache(limit int) *nonceCache {
+	return &nonceCache{limit: limit, seen: make(map[string]time.Time, limit), order: make([]string, 0, limit)}
+}
+
+// add запоминает ключ и возвращает причину отказа: ReasonReplay, если ключ уже
+// встречался, ReasonBusy, если кеш заполнен. Записи до before удаляются.
+func (c *nonceCache) add(key string, now, before time.Time) string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for c.head < len(c.order) && !c.seen[c.order[c.head]].After(before) {
+		c.evict()
+	}
+	if _, ok := c.seen[key]; ok {
+		return ReasonReplay
+	}
+	if len(c.seen) >= c.limit {
+		return ReasonBusy
+	}
+	if c.head > len(c.order)/2 {
+		c.order = append(c.order[:0], c.order[c.head:]...)
+		c.head = 0
+	}
+
+	c.seen[key] = now
+	c.order = append(c.order, key)
+	return ""
+}
+
+func (c *nonceCache) evict() {
+	delete(c.seen, c.order[c.head])
+	c.head++
+}
@@ -0,0 +1,199 @@
+package signing_test
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+	"trafficlightAPI/internal/middleware/signing"
+
+	"github.com/pkg/errors"
+)
+
+const secret = "0123456789abcdef0123"
+
+func writeError(w http.ResponseWriter, status int, userErr error, errs ...error) {
+	w.WriteHeader(status)
+}
+
+type request struct {
+	device    string
+	secret    string
+	timestamp time.Time
+	nonce     string
+	query     string
+	body      string
+	tamper    string // Тело, подмененное после подписи
+}
+
+func (rq request) build() *http.Request {
+	body := rq.body
+	if rq.tamper != "" {
+		body = rq.tamper
+	}
+	r := httptest.NewRequest(http.MethodPost, "/events?"+rq.query, strings.NewReader(body))
+	ts := strconv.FormatInt(rq.timestamp.Unix(), 10)
+	r.Header.Set(signing.DeviceHeader, rq.device)
+	r.Header.Set(signing.TimestampHeader, ts)
+	r.Header.Set(signing.NonceHeader, rq.nonce)
+	r.Header.Set(signing.SignatureHeader, signing.Sign([]byte(rq.secret), signing.StringToSign(http.MethodPost, "/events", rq.query, ts, rq.nonce, []byte(rq.body))))
+	return r
+}
+
+func TestVerify(t *testing.T) {
+	now := time.Unix(1_700_000_000, 0)
+	verifier := signing.New(signing.Config{
+		Enabled:    true,
+		Secrets:    map[string][]byte{"abcde": []byte(secret)},
+		MaxSkew:    30 * time.Second,
+		NonceCache: 100,
+	}, writeError)
+	verifier.SetClock(func() time.Time { return now })
+
+	var gotBody, gotDevice string
+	handler := verifier.Verify(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := io.ReadAll(r.Body)
+		gotBody = string(data)
+		gotDevice, _ = signing.DeviceFromContext(r.Context())
+	}))
+
+	valid := request{device: "abcde", secret: secret, timestamp: now, nonce: "n1", query: "type=1&b=2", body: `[{"uuid":"abcde"}]`}
+	tests := []struct {
+		name    string
+		request request
+		want    int
+	}{
+		{name: "valid", request: valid, want: http.StatusOK},
+		{name: "replay", request: valid, want: http.StatusUnauthorized},
+		{name: "new nonce", request: request{device: "abcde", secret: secret, timestamp: now.Add(-20 * time.Second), nonce: "n2", body: "{}"}, want: http.StatusOK},
+		{name: "stale", request: request{device: "abcde", secret: secret, timestamp: now.Add(-time.Minute), nonce: "n3"}, want: http.StatusUnauthorized},
+		{name: "future", request: request{device: "abcde", secret: secret, timestamp: now.Add(time.Minute), nonce: "n4"}, want: http.StatusUnauthorized},
+		{name: "wrong secret", request: request{device: "abcde", secret: "another-secret-value", timestamp: now, nonce: "n5"}, want: http.StatusUnauthorized},
+		{name: "unknown device", request: request{device: "zzz", secret: secret, timestamp: now, nonce: "n6"}, want: http.StatusUnauthorized},
+		{name: "tampered body", request: request{device: "abcde", secret: secret, timestamp: now, nonce: "n7", body: `{"a":1}`, tamper: `{"a":2}`}, want: http.StatusUnauthorized},
+		// Подпись не зависит от порядка параметров query.
+		{name: "reordered query", request: request{device: "abcde", secret: secret, timestamp: now, nonce: "n8", query: "b=2&type=1"}, want: http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := tt.request.build()
+			if tt.name == "reordered query" {
+				r.URL.RawQuery = "type=1&b=2"
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, r)
+			if rec.Code != tt.want {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.want)
+			}
+			if tt.want == http.StatusOK && (gotBody != tt.request.body || gotDevice != "abcde") {
+				t.Errorf("body = %q, device = %q", gotBody, gotDevice)
+			}
+		})
+	}
+
+	t.Run("missing headers", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/events", nil))
+		if rec.Code != http.StatusUnauthorized {
+			t.Errorf("status = %d, want 401", rec.Code)
+		}
+	})
+}
+
+func TestNonceCacheBounded(t *testing.T) {
+	now := time.Unix(1_700_000_000, 0)
+	verifier := signing.New(signing.Config{
+		Enabled:    true,
+		Secrets:    map[string][]byte{"abcde": []byte(secret)},
+		MaxSkew:    30 * time.Second,
+		NonceCache: 2,
+	}, writeError)
+	verifier.SetClock(func() time.Time { return now })
+	handler := verifier.Verify(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	send := func(nonce string) int {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, request{device: "abcde", secret: secret, timestamp: now, nonce: nonce}.build())
+		return rec.Code
+	}
+	for _, nonce := range []string{"a", "b"} {
+		if code := send(nonce); code != http.StatusOK {
+			t.Fatalf("nonce %s: status = %d", nonce, code)
+		}
+	}
+	// Живые nonce не вытесняются: при переполнении новые запросы отклоняются.
+	if send("b") != http.StatusUnauthorized || send("a") != http.StatusUnauthorized {
+		t.Error("recent nonce accepted twice")
+	}
+	if code := send("c"); code != http.StatusServiceUnavailable {
+		t.Errorf("nonce over limit: status = %d, want %d", code, http.StatusServiceUnavailable)
+	}
+	// Через два max_skew старые записи удаляются и место освобождается.
+	now = now.Add(time.Minute)
+	if code := send("c"); code != http.StatusOK {
+		t.Errorf("nonce after window: status = %d", code)
+	}
+}
+
+func TestNonceCacheDefault(t *testing.T) {
+	now := time.Unix(1_700_000_000, 0)
+	// Одно устройство, окно 2 x 1 с, два запроса в секунду - четыре записи.
+	verifier := signing.New(signing.Config{
+		Enabled: true,
+		Secrets: map[string][]byte{"abcde": []byte(secret)},
+		MaxSkew: time.Second,
+	}, writeError)
+	verifier.SetClock(func() time.Time { return now })
+	handler := verifier.Verify(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	for i := range 5 {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, request{device: "abcde", secret: secret, timestamp: now, nonce: strconv.Itoa(i)}.build())
+		want := http.StatusOK
+		if i == 4 {
+			want = http.StatusServiceUnavailable
+		}
+		if rec.Code != want {
+			t.Errorf("request %d: status = %d, want %d", i, rec.Code, want)
+		}
+	}
+}
+
+func TestCheckDevice(t *testing.T) {
+	verifier := signing.New(signing.Config{Enabled: true, Secrets: map[string][]byte{"abcde": []byte(secret)}}, writeError)
+	var err error
+	handler := verifier.Verify(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		err = signing.CheckDevice(r, "other")
+	}))
+	handler.ServeHTTP(httptest.NewRecorder(), request{device: "abcde", secret: secret, timestamp: time.Now(), nonce: "x"}.build())
+	if !errors.Is(err, signing.ErrDevice) {
+		t.Errorf("err = %v, want ErrDevice", err)
+	}
+
+	// Без подписи проверка не выполняется.
+	if err := signing.CheckDevice(httptest.NewRequest(http.MethodGet, "/", nil), "other"); err != nil {
+		t.Errorf("unsigned: err = %v", err)
+	}
+}
+
+func TestLoadSecrets(t *testing.T) {
+	dir := t.TempDir()
+	valid := filepath.Join(dir, "devices.yaml")
+	os.WriteFile(valid, []byte("devices:\n  - uuid: abcde\n    secret: \""+secret+"\"\n"), 0o600)
+	short := filepath.Join(dir, "short.yaml")
+	os.WriteFile(short, []byte("devices:\n  - uuid: abcde\n    secret: x\n"), 0o600)
+
+	secrets, err := signing.LoadSecrets(valid)
+	if err != nil || string(secrets["abcde"]) != secret {
+		t.Errorf("secrets = %v, err = %v", secrets, err)
+	}
+	if _, err := signing.LoadSecrets(short); !errors.Is(err, signing.ErrSecrets) {
+		t.Errorf("short secret: err = %v, want ErrSecrets", err)
+	}
+}
@@ -0,0 +1,487 @@
+package mmu
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"trafficlightAPI/internal/audit"
+	prometheus "trafficlightAPI/internal/middleware/prometheus"
+	"trafficlightAPI/internal/models"
+	"trafficlightAPI/internal/storage"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+)
+
+var (
+	ErrInvalidMatrix = errors.New("некорректная матрица конфликтов")
+	ErrUnknownLight  = errors.New("светофор не входит ни в один перекресток монитора")
+	ErrNotLatched    = errors.New("авария перекрестка не зафиксирована")
+	ErrInvalidReset  = errors.New("некорректный сброс аварии")
+)
+
+// Виды аварий.
+const (
+	KindConflict  = "conflict"  // Одновременно разрешены конфликтующие направления
+	KindClearance = "clearance" // Переход с зеленого на красный без желтого
+	KindStuck     = "stuck"     // Выходы не совпадают с заданным состоянием
+)
+
+// Intersection - перекресток: его светофоры и пары светофоров, которым
+// нельзя одновременно разрешать движение.
+type Intersection struct {
+	Name      string      `yaml:"name" json:"name"`
+	Lights    []string    `yaml:"lights" json:"lights"`
+	Conflicts [][2]string `yaml:"conflicts" json:"conflicts"`
+}
+
+type Matrix struct {
+	Intersections []Intersection `yaml:"intersections" json:"intersections"`
+}
+
+func LoadMatrix(path string) (Matrix, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Matrix{}, errors.Wrap(ErrInvalidMatrix, err.Error())
+	}
+	var matrix Matrix
+	if err := yaml.Unmarshal(data, &matrix); err != nil {
+		return Matrix{}, errors.Wrap(ErrInvalidMatrix, err.Error())
+	}
+	return matrix, matrix.Validate()
+}
+
+func (m Matrix) Validate() error {
+	names := make(map[string]bool, len(m.Intersections))
+	owner := make(map[string]string)
+	for _, in := range m.Intersections {
+		if in.Name == "" || names[in.Name] {
+			return errors.Wrapf(ErrInvalidMatrix, "пустое или повторяющееся имя перекрестка %q", in.Name)
+		}
+		names[in.Name] = true
+		for _, uuid := range in.Lights {
+			if other, ok := owner[uuid]; ok || uuid == "" {
+				return errors.Wrapf(ErrInvalidMatrix, "светофор %q перекрестка %q уже входит в %q", uuid, in.Name, other)
+			}
+			owner[uuid] = in.Name
+		}
+		for _, pair := range in.Conflicts {
+			for _, uuid := range pair {
+				if owner[uuid] != in.Name {
+					return errors.Wrapf(ErrInvalidMatrix, "конфликт %v перекрестка %q ссылается на чужой светофор %q", pair, in.Name, uuid)
+				}
+			}
+			if pair[0] == pair[1] {
+				return errors.Wrapf(ErrInvalidMatrix, "конфликт светофора %q с самим собой", pair[0])
+			}
+		}
+	}
+	return nil
+}
+
+type Config struct {
+	ReportTTL  time.Duration // Отчеты старше не участвуют в проверке конфликтов
+	StuckAfter time.Duration // Допустимое расхождение выходов с заданным состоянием
+	MinYellow  time.Duration // Минимальный желтый между зеленым и красным, 0 - хотя бы один отчет
+}
+
+// Output - лампы, которые устройство фактически включило.
+type Output struct {
+	UUID  string
+	Lamps models.Lamps
+	Time  time.Time
+}
+
+type lightState struct {
+	output        Output
+	reported      bool
+	greenOn       bool      // Горел зеленый, желтый еще не завершил такт
+	yellowSince   time.Time // Начало желтого после зеленого
+	mismatchSince time.Time // Начало расхождения с заданным состоянием
+}
+
+// Monitor - монитор конфликтов (MMU). Сравнивает фактические выходы
+// устройств с заданными состояниями и матрицей конфликтов и при нарушении
+// фиксирует аварию, переводя весь перекресток в мигающий красный.
+type Monitor struct {
+	cfg    Config
+	store  storage.Store
+	audit  *audit.Log
+	logger *slog.Logger
+
+	intersections map[string]Intersection
+	owner         map[string]string // uuid -> перекресток
+
+	commanded  func(uuid string) (trafficType, state int, ok bool)
+	knownFault func(uuid, lamp string) bool
+
+	mu      sync.Mutex
+	lights  map[string]*lightState
+	latched map[string]storage.MonitorFault
+}
+
+func NewMonitor(cfg Config, matrix Matrix, store storage.Store, auditLog *audit.Log, logger *slog.Logger) *Monitor {
+	m := &Monitor{
+		cfg:           cfg,
+		store:         store,
+		audit:         auditLog,
+		logger:        logger,
+		intersections: make(map[string]Intersection, len(matrix.Intersections)),
+		owner:         make(map[string]string),
+		lights:        make(map[string]*lightState),
+		latched:       make(map[string]storage.MonitorFault),
+	}
+	for _, in := range matrix.Intersections {
+		m.intersections[in.Name] = in
+		for _, uuid := range in.Lights {
+			m.owner[uuid] = in.Name
+		}
+	}
+	return m
+}
+
+// SetCommanded задает источник заданных состояний светофоров.
+func (m *Monitor) SetCommanded(commanded func(uuid string) (trafficType, state int, ok bool)) {
+	m.commanded = commanded
+}
+
+// SetKnownFault задает проверку известных неисправных ламп: погасшая
+// неисправная лампа не считается расхождением.
+func (m *Monitor) SetKnownFault(knownFault func(uuid, lamp string) bool) {
+	m.knownFault = knownFault
+}
+
+// Restore загружает зафиксированные аварии из хранилища from, обычно локального.
+func (m *Monitor) Restore(from storage.Store) error {
+	faults, err := from.MonitorFaults()
+	if err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, f := range faults {
+		m.latched[f.Intersection] = f
+	}
+	m.updateMetrics()
+	return nil
+}
+
+// updateMetrics обновляет monitor_latched, вызывается под m.mu.
+func (m *Monitor) updateMetrics() {
+	for name := range m.intersections {
+		v := 0.0
+		if _, ok := m.latched[name]; ok {
+			v = 1
+		}
+		prometheus.MonitorLatched.WithLabelValues(name).Set(v)
+	}
+}
+
+func (m *Monitor) record(e audit.Entry) {
+	if m.audit == nil {
+		return
+	}
+	if err := m.audit.Write(e); err != nil {
+		m.logger.Error("ошибка записи аудита", slog.String("action", e.Action), slog.Any("err", err))
+	}
+}
+
+// Intersection возвращает перекресток светофора.
+func (m *Monitor) Intersection(uuid string) (string, bool) {
+	name, ok := m.owner[uuid]
+	return name, ok
+}
+
+// Report проверяет отчет о выходах устройства. Если отчет приводит к
+// аварии, она фиксируется и возвращается.
+func (m *Monitor) Report(o Output) (*storage.MonitorFault, error) {
+	name, ok := m.owner[o.UUID]
+	if !ok {
+		return nil, errors.Wrapf(ErrUnknownLight, "uuid:%s", o.UUID)
+	}
+	if o.Time.IsZero() {
+		o.Time = time.Now().UTC()
+	}
+
+	m.mu.Lock()
+	if _, latched := m.latched[name]; latched {
+		// Перекресток уже в мигающем красном, выходы не проверяются до сброса.
+		m.mu.Unlock()
+		return nil, nil
+	}
+	fault := m.check(name, o)
+	if fault != nil {
+		m.latched[name] = *fault
+		m.updateMetrics()
+	}
+	m.mu.Unlock()
+
+	if fault == nil {
+		return nil, nil
+	}
+	prometheus.MonitorFaults.WithLabelValues(fault.Kind).Inc()
+	m.logger.Error(
+		"авария монитора конфликтов, перекресток переведен в мигающий красный",
+		slog.String("intersection", name),
+		slog.String("kind", fault.Kind),
+		slog.Any("uuids", fault.UUIDs),
+		slog.String("details", fault.Details),
+	)
+	if err := m.store.PutMonitorFault(*fault); err != nil {
+		return fault, err
+	}
+	m.record(audit.Entry{
+		Time: fault.DetectedAt, Action: audit.ActionMonitorFault,
+		Details: map[string]any{"intersection": name, "kind": fault.Kind, "uuids": fault.UUIDs, "details": fault.Details},
+	})
+	return fault, nil
+}
+
+// check обновляет состояние светофора по отчету и ищет нарушения, вызывается под m.mu.
+func (m *Monitor) check(name string, o Output) *storage.MonitorFault {
+	light, ok := m.lights[o.UUID]
+	if !ok {
+		light = &lightState{}
+		m.lights[o.UUID] = light
+	}
+	light.output, light.reported = o, true
+	latch := func(kind string, uuids []string, details string) *storage.MonitorFault {
+		return &storage.MonitorFault{Intersection: name, Kind: kind, UUIDs: uuids, Details: details, DetectedAt: o.Time}
+	}
+
+	if permissive(o.Lamps) {
+		for _, pair := range m.intersections[name].Conflicts {
+			other := ""
+			switch o.UUID {
+			case pair[0]:
+				other = pair[1]
+			case pair[1]:
+				other = pair[0]
+			default:
+				continue
+			}
+			if s, ok := m.lights[other]; ok && s.reported && o.Time.Sub(s.output.Time) <= m.cfg.ReportTTL && permissive(s.output.Lamps) && m.permitted(other) {
+				return latch(KindConflict, []string{o.UUID, other}, "разрешено движение конфликтующим направлениям")
+			}
+		}
+	}
+
+	trafficType, state, known := 0, 0, false
+	if m.commanded != nil {
+		trafficType, state, known = m.commanded(o.UUID)
+	}
+
+	if known && clearanceRequired(trafficType) {
+		switch {
+		case o.Lamps.Green:
+			light.greenOn, light.yellowSince = true, time.Time{}
+		case o.Lamps.Yellow && light.greenOn:
+			if light.yellowSince.IsZero() {
+				light.yellowSince = o.Time
+			}
+		case o.Lamps.Red && light.greenOn:
+			yellow := time.Duration(-1)
+			if !light.yellowSince.IsZero() {
+				yellow = o.Time.Sub(light.yellowSince)
+			}
+			light.greenOn, light.yellowSince = false, time.Time{}
+			if yellow < 0 || yellow < m.cfg.MinYellow {
+				return latch(KindClearance, []string{o.UUID}, fmt.Sprintf("красный после зеленого без желтого (желтый %v, минимум %v)", max(yellow, 0), m.cfg.MinYellow))
+			}
+		}
+	}
+
+	if !known {
+		return nil
+	}
+	want, err := models.StateLamps(trafficType, state)
+	if err != nil {
+		return nil
+	}
+	mismatch := m.mismatch(o.UUID, want, o.Lamps)
+	switch {
+	case mismatch == "":
+		light.mismatchSince = time.Time{}
+	case light.mismatchSince.IsZero():
+		light.mismatchSince = o.Time
+	case o.Time.Sub(light.mismatchSince) >= m.cfg.StuckAfter:
+		return latch(KindStuck, []string{o.UUID}, fmt.Sprintf("состояние %d типа %d: %s дольше %v", state, trafficType, mismatch, m.cfg.StuckAfter))
+	}
+	return nil
+}
+
+// permitted сообщает, разрешает ли движение заданное сейчас состояние светофора.
+// Отчет другого светофора может быть старше его последней смены: при передаче
+// движения его зеленый в отчете уже сменился красным. Такой отчет не участвует
+// в проверке конфликта, а если светофор действительно остался зеленым, конфликт
+// найдет его следующий отчет, а расхождение - проверка stuck.
+func (m *Monitor) permitted(uuid string) bool {
+	if m.commanded == nil {
+		return true
+	}
+	trafficType, state, known := m.commanded(uuid)
+	if !known {
+		return true
+	}
+	lamps, err := models.StateLamps(trafficType, state)
+	return err != nil || permissive(lamps)
+}
+
+// clearanceRequired сообщает, идет ли в плане типа после зеленого желтый без зеленого.
+func clearanceRequired(trafficType int) bool {
+	count := models.StatesCount(trafficType)
+	for state := 1; state <= count; state++ {
+		current, _ := models.StateLamps(trafficType, state)
+		next, _ := models.StateLamps(trafficType, state%count+1)
+		if current.Green && next.Yellow && !next.Green {
+			return true
+		}
+	}
+	return false
+}
+
+// permissive сообщает, разрешает ли набор сигналов движение.
+func permissive(l models.Lamps) bool {
+	return l.Green || l.Arrow
+}
+
+// mismatch описывает расхождение фактических ламп с заданными или возвращает "".
+func (m *Monitor) mismatch(uuid string, want, got models.Lamps) string {
+	for _, lamp := range []string{models.LampRed, models.LampYellow, models.LampGreen, models.LampArrow} {
+		if lamp == models.LampArrow && want.ArrowFlashing {
+			continue // Мигающая стрелка в отчете может быть погашена
+		}
+		w, g := want.Lit(lamp), got.Lit(lamp)
+		switch {
+		case w == g:
+		case w && m.knownFault != nil && m.knownFault(uuid, lamp):
+		case g:
+			return lamp + " горит, хотя должна быть погашена"
+		default:
+			return lamp + " погашена, хотя должна гореть"
+		}
+	}
+	return ""
+}
+
+// Latched возвращает зафиксированные аварии в порядке перекрестков.
+func (m *Monitor) Latched() []storage.MonitorFault {
+	m.mu.Lock()
+	faults := make([]storage.MonitorFault, 0, len(m.latched))
+	for _, f := range m.latched {
+		faults = append(faults, f)
+	}
+	m.mu.Unlock()
+
+	sort.Slice(faults, func(i, j int) bool { return faults[i].Intersection < faults[j].Intersection })
+	return faults
+}
+
+// Reset снимает аварию перекрестка после проверки оператором.
+func (m *Monitor) Reset(intersection, user, reason string) (storage.MonitorFault, error) {
+	if strings.TrimSpace(reason) == "" {
+		return storage.MonitorFault{}, errors.Wrap(ErrInvalidReset, "отсутствует причина")
+	}
+	m.mu.Lock()
+	fault, ok := m.latched[intersection]
+	m.mu.Unlock()
+	if !ok {
+		return storage.MonitorFault{}, errors.Wrapf(ErrNotLatched, "перекресток:%s", intersection)
+	}
+
+	if err := m.store.DeleteMonitorFault(intersection); err != nil && !errors.Is(err, storage.ErrNotFound) {
+		return storage.MonitorFault{}, err
+	}
+	m.mu.Lock()
+	delete(m.latched, intersection)
+	// Проверки начинаются заново, без истории до аварии.
+	for _, uuid := range m.intersections[intersection].Lights {
+		delete(m.lights, uuid)
+	}
+	m.updateMetrics()
+	m.mu.Unlock()
+
+	m.record(audit.Entry{
+		Time: time.Now().UTC(), Action: audit.ActionMonitorReset, User: user, Reason: reason,
+		Details: map[string]any{"intersection": intersection, "kind": fault.Kind, "detected_at": fault.DetectedAt},
+	})
+	return fault, nil
+}
+
+// Pinned сообщает, что авария держит светофор в мигающем красном.
+func (m *Monitor) Pinned(data models.TrafficRequest, trafficType int) bool {
+	name, ok := m.owner[data.UUID]
+	if state := models.FailsafeState(trafficType); !ok || state == 0 || state != data.CurrentState {
+		return false
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	_, latched := m.latched[name]
+	return latched
+}
+
+// Resolve переводит светофоры перекрестка с зафиксированной аварией в мигающий красный.
+func (m *Monitor) Resolve(data models.TrafficRequest, trafficType int, response *models.TrafficResponse) bool {
+	name, ok := m.owner[data.UUID]
+	if !ok {
+		return false
+	}
+	m.mu.Lock()
+	fault, latched := m.latched[name]
+	m.mu.Unlock()
+	state := models.FailsafeState(trafficType)
+	if !latched || state == 0 {
+		return false
+	}
+
+	response.NextState = strconv.Itoa(state)
+	response.NextCountdownTime = ""
+	response.Flashing = true
+	response.Failsafe = fault.Kind
+	if data.NeedImage {
+		if image, err := models.StateImage(trafficType, state, response.Faults); err == nil && image != "" {
+			response.Image = image
+		}
+	}
+	return true
+}
+
+// ApplyMutation обновляет память по изменению, примененному другим узлом кластера.
+func (m *Monitor) ApplyMutation(mu storage.Mutation) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	switch {
+	case mu.Op == storage.OpPutMonitorFault && mu.MonitorFault != nil:
+		m.latched[mu.MonitorFault.Intersection] = *mu.MonitorFault
+	case mu.Op == storage.OpDeleteMonitorFault:
+		delete(m.latched, mu.Key)
+		for _, uuid := range m.intersections[mu.Key].Lights {
+			delete(m.lights, uuid)
+		}
+	default:
+		return
+	}
+	m.updateMetrics()
+}
+
+var (
+	defaultMu      sync.RWMutex
+	defaultMonitor *Monitor
+)
+
+func SetDefault(m *Monitor) {
+	defaultMu.Lock()
+	defer defaultMu.Unlock()
+	defaultMonitor = m
+}
+
+func Default() *Monitor {
+	defaultMu.RLock()
+	defer defaultMu.RUnlock()
+	return defaultMonitor
+}
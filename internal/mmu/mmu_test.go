@@ -0,0 +1,220 @@
+package mmu_test
+
+import (
+	"log/slog"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+	"trafficlightAPI/internal/mmu"
+	"trafficlightAPI/internal/models"
+	"trafficlightAPI/internal/storage"
+
+	"github.com/pkg/errors"
+)
+
+var start = time.Date(2026, 10, 19, 8, 0, 0, 0, time.UTC)
+
+func intPtr(v int) *int { return &v }
+
+var matrix = mmu.Matrix{Intersections: []mmu.Intersection{{
+	Name:      "Ленина-Мира",
+	Lights:    []string{"ns", "ew", "arrow"},
+	Conflicts: [][2]string{{"ns", "ew"}},
+}}}
+
+// newMonitor возвращает монитор, заданные состояния которого задаются через commanded.
+func newMonitor(t *testing.T) (*mmu.Monitor, map[string][2]int, storage.Store) {
+	t.Helper()
+	store, err := storage.OpenBolt(filepath.Join(t.TempDir(), "state.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { store.Close() })
+
+	commanded := map[string][2]int{}
+	m := mmu.NewMonitor(mmu.Config{ReportTTL: 2 * time.Second, StuckAfter: 3 * time.Second}, matrix, store, nil, slog.Default())
+	m.SetCommanded(func(uuid string) (int, int, bool) {
+		c, ok := commanded[uuid]
+		return c[0], c[1], ok
+	})
+	return m, commanded, store
+}
+
+func TestMatrixValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		matrix mmu.Matrix
+		ok     bool
+	}{
+		{name: "valid", matrix: matrix, ok: true},
+		{name: "foreign light", matrix: mmu.Matrix{Intersections: []mmu.Intersection{{Name: "a", Lights: []string{"x"}, Conflicts: [][2]string{{"x", "y"}}}}}},
+		{name: "light in two intersections", matrix: mmu.Matrix{Intersections: []mmu.Intersection{{Name: "a", Lights: []string{"x"}}, {Name: "b", Lights: []string{"x"}}}}},
+		{name: "self conflict", matrix: mmu.Matrix{Intersections: []mmu.Intersection{{Name: "a", Lights: []string{"x"}, Conflicts: [][2]string{{"x", "x"}}}}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.matrix.Validate()
+			if tt.ok != (err == nil) || (err != nil && !errors.Is(err, mmu.ErrInvalidMatrix)) {
+				t.Errorf("Validate() = %v", err)
+			}
+		})
+	}
+}
+
+func TestFaults(t *testing.T) {
+	red, yellow, green := models.Lamps{Red: true}, models.Lamps{Yellow: true}, models.Lamps{Green: true}
+	type report struct {
+		uuid  string
+		lamps models.Lamps
+		after time.Duration
+	}
+	tests := []struct {
+		name      string
+		commanded map[string][2]int
+		reports   []report
+		kind      string // Пусто - аварии нет
+	}{
+		{
+			name:      "conflicting greens",
+			commanded: map[string][2]int{"ns": {1, 3}, "ew": {1, 1}},
+			reports:   []report{{"ns", green, 0}, {"ew", green, time.Second}},
+			kind:      mmu.KindConflict,
+		},
+		{
+			name:      "stale report is not a conflict",
+			commanded: map[string][2]int{"ns": {1, 3}, "ew": {1, 3}},
+			reports:   []report{{"ns", green, 0}, {"ew", green, 5 * time.Second}},
+		},
+		{
+			name:      "green to red without yellow",
+			commanded: map[string][2]int{"arrow": {2, 1}},
+			reports:   []report{{"arrow", green, 0}, {"arrow", red, time.Second}},
+			kind:      mmu.KindClearance,
+		},
+		{
+			name:      "green, yellow, red",
+			commanded: map[string][2]int{"arrow": {2, 1}},
+			reports:   []report{{"arrow", green, 0}, {"arrow", yellow, time.Second}, {"arrow", red, 3 * time.Second}},
+		},
+		{
+			// У типа 1 по плану за зеленым сразу идет красный.
+			name:      "regular light has no clearance",
+			commanded: map[string][2]int{"ns": {1, 1}},
+			reports:   []report{{"ns", green, 0}, {"ns", red, 0}},
+		},
+		{
+			name:      "stuck green",
+			commanded: map[string][2]int{"ns": {1, 1}},
+			reports:   []report{{"ns", green, 0}, {"ns", green, 2 * time.Second}, {"ns", green, 3 * time.Second}},
+			kind:      mmu.KindStuck,
+		},
+		{
+			name:      "short mismatch after a transition",
+			commanded: map[string][2]int{"ns": {1, 2}},
+			reports:   []report{{"ns", red, 0}, {"ns", yellow, time.Second}, {"ns", yellow, 5 * time.Second}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, commanded, _ := newMonitor(t)
+			for uuid, c := range tt.commanded {
+				commanded[uuid] = c
+			}
+			var fault *storage.MonitorFault
+			for _, r := range tt.reports {
+				f, err := m.Report(mmu.Output{UUID: r.uuid, Lamps: r.lamps, Time: start.Add(r.after)})
+				if err != nil {
+					t.Fatal(err)
+				}
+				if f != nil {
+					fault = f
+				}
+			}
+			switch {
+			case tt.kind == "" && fault != nil:
+				t.Errorf("unexpected fault %+v", fault)
+			case tt.kind != "" && (fault == nil || fault.Kind != tt.kind):
+				t.Errorf("fault = %+v, want %s", fault, tt.kind)
+			}
+		})
+	}
+}
+
+// При передаче движения последний отчет светофора, ушедшего в красный, еще
+// зеленый и не старше ReportTTL. Конфликтом это становится, только если
+// светофор сообщает зеленый и после смены.
+func TestHandover(t *testing.T) {
+	green := models.Lamps{Green: true}
+	m, commanded, _ := newMonitor(t)
+	commanded["ns"], commanded["ew"] = [2]int{1, 3}, [2]int{1, 1}
+
+	steps := []struct {
+		uuid     string
+		after    time.Duration
+		conflict bool
+	}{
+		{uuid: "ns", after: 0},
+		{uuid: "ew", after: time.Second},
+		{uuid: "ns", after: 1500 * time.Millisecond, conflict: true},
+	}
+	for i, step := range steps {
+		if i == 1 {
+			commanded["ns"], commanded["ew"] = [2]int{1, 1}, [2]int{1, 3}
+		}
+		fault, err := m.Report(mmu.Output{UUID: step.uuid, Lamps: green, Time: start.Add(step.after)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if (fault != nil) != step.conflict || (fault != nil && fault.Kind != mmu.KindConflict) {
+			t.Fatalf("step %d: fault = %+v, want conflict %v", i, fault, step.conflict)
+		}
+	}
+}
+
+func TestFailsafeAndReset(t *testing.T) {
+	m, commanded, store := newMonitor(t)
+	commanded["ns"], commanded["ew"] = [2]int{1, 3}, [2]int{1, 3}
+	if _, err := m.Report(mmu.Output{UUID: "unknown", Time: start}); !errors.Is(err, mmu.ErrUnknownLight) {
+		t.Errorf("unknown light: err = %v", err)
+	}
+	m.Report(mmu.Output{UUID: "ns", Lamps: models.Lamps{Green: true}, Time: start})
+	m.Report(mmu.Output{UUID: "ew", Lamps: models.Lamps{Green: true}, Time: start})
+
+	// Весь перекресток, включая светофор без нарушений, мигает красным.
+	for _, light := range []struct {
+		uuid string
+		typ  int
+	}{{"ns", 1}, {"arrow", 2}} {
+		response := models.TrafficResponse{NextState: "3"}
+		if !m.Resolve(models.TrafficRequest{UUID: light.uuid, CurrentState: 3, CurrentTime: intPtr(0)}, light.typ, &response) {
+			t.Fatalf("%s is not in failsafe", light.uuid)
+		}
+		if response.NextState != strconv.Itoa(models.FailsafeState(light.typ)) || !response.Flashing || response.Failsafe != mmu.KindConflict {
+			t.Errorf("%s response = %+v", light.uuid, response)
+		}
+	}
+
+	// Авария переживает перезапуск.
+	restored := mmu.NewMonitor(mmu.Config{}, matrix, store, nil, slog.Default())
+	if err := restored.Restore(store); err != nil {
+		t.Fatal(err)
+	}
+	if latched := restored.Latched(); len(latched) != 1 || latched[0].Intersection != "Ленина-Мира" {
+		t.Errorf("restored = %+v", latched)
+	}
+
+	if _, err := m.Reset("Ленина-Мира", "operator", ""); !errors.Is(err, mmu.ErrInvalidReset) {
+		t.Errorf("reset without reason: err = %v", err)
+	}
+	if _, err := m.Reset("Ленина-Мира", "operator", "проверено на месте"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.Reset("Ленина-Мира", "operator", "повторно"); !errors.Is(err, mmu.ErrNotLatched) {
+		t.Errorf("second reset: err = %v", err)
+	}
+	response := models.TrafficResponse{NextState: "3"}
+	if m.Resolve(models.TrafficRequest{UUID: "ns", CurrentState: 3, CurrentTime: intPtr(0)}, 1, &response) || response.NextState != "3" {
+		t.Errorf("after reset response = %+v", response)
+	}
+}
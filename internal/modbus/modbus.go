@@ -0,0 +1,348 @@
+package modbus
+
+import (
+	"encoding/binary"
+	"io"
+	"log/slog"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+	"trafficlightAPI/internal/middleware/prometheus"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	ErrInvalidMap       = errors.New("некорректная карта регистров Modbus")
+	ErrCommandsWithAuth = errors.New("команды Modbus не принимаются при включенной аутентификации")
+)
+
+// User - имя пользователя в ручном управлении и аудите для команд Modbus.
+const User = "modbus"
+
+// Коды функций Modbus.
+const (
+	ReadCoils            byte = 0x01
+	ReadHoldingRegisters byte = 0x03
+	ReadInputRegisters   byte = 0x04
+	WriteSingleCoil      byte = 0x05
+	WriteMultipleCoils   byte = 0x0f
+)
+
+// Exception - код исключения Modbus, 0 - успех.
+type Exception byte
+
+const (
+	IllegalFunction     Exception = 1
+	IllegalDataAddress  Exception = 2
+	IllegalDataValue    Exception = 3
+	ServerDeviceFailure Exception = 4
+)
+
+const (
+	headerSize   = 7 // MBAP: транзакция, протокол, длина, устройство
+	maxPDU       = 253
+	maxRegisters = 125
+	maxCoils     = 2000
+	maxWrite     = 1968
+)
+
+type Config struct {
+	Lights          []string // Порядок блоков, обязателен
+	BlockSize       int      // Регистров на светофор
+	Registers       RegisterMap
+	CoilBlockSize   int // Катушек на светофор
+	Coils           CoilMap
+	Commands        bool // Разрешить запись катушек
+	CommandDuration time.Duration
+	AuthEnabled     bool // Аутентификация сервиса включена, Modbus TCP ее не поддерживает
+	MaxConnections  int
+	IdleTimeout     time.Duration
+}
+
+// Server - ведомое устройство Modbus TCP. Светофор i занимает регистры
+// [i*BlockSize, (i+1)*BlockSize) и катушки [i*CoilBlockSize, (i+1)*CoilBlockSize).
+// Holding и input регистры совпадают. Номер устройства в запросе не проверяется.
+type Server struct {
+	cfg    Config
+	logger *slog.Logger
+	coils  map[int]string // Смещение катушки в блоке - команда
+
+	mu     sync.Mutex
+	lis    net.Listener
+	conns  map[net.Conn]struct{}
+	closed bool
+	wg     sync.WaitGroup
+}
+
+func NewServer(cfg Config, logger *slog.Logger) (*Server, error) {
+	// Блоки по реестру сдвигались бы при появлении нового светофора, и ПЛК
+	// читал и переключал бы не тот светофор.
+	if len(cfg.Lights) == 0 {
+		return nil, errors.Wrap(ErrInvalidMap, "не задан список светофоров lights")
+	}
+	seen := make(map[string]bool, len(cfg.Lights))
+	for _, uuid := range cfg.Lights {
+		if uuid == "" || seen[uuid] {
+			return nil, errors.Wrapf(ErrInvalidMap, "светофор %q в lights пустой или повторяется", uuid)
+		}
+		seen[uuid] = true
+	}
+	r, c := cfg.Registers, cfg.Coils
+	if err := offsets(cfg.BlockSize, map[string]int{
+		"phase": r.Phase, "remaining": r.Remaining, "mode": r.Mode, "lamps": r.Lamps, "type": r.Type,
+	}); err != nil {
+		return nil, errors.Wrap(err, "регистры")
+	}
+	coils := map[string]int{coilHold: c.Hold, coilAdvance: c.Advance, coilFlash: c.Flash}
+	if err := offsets(cfg.CoilBlockSize, coils); err != nil {
+		return nil, errors.Wrap(err, "катушки")
+	}
+	// Запись катушек без проверки клиента обходила бы роли остальных протоколов.
+	if cfg.Commands && cfg.AuthEnabled {
+		return nil, ErrCommandsWithAuth
+	}
+	if cfg.Commands && cfg.CommandDuration <= 0 {
+		return nil, errors.Wrapf(ErrInvalidMap, "срок команды %s", cfg.CommandDuration)
+	}
+
+	s := &Server{cfg: cfg, logger: logger, coils: make(map[int]string, len(coils)), conns: make(map[net.Conn]struct{})}
+	for name, offset := range coils {
+		s.coils[offset] = name
+	}
+	return s, nil
+}
+
+// Serve принимает подключения из lis до Close.
+func (s *Server) Serve(lis net.Listener) error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		lis.Close()
+		return nil
+	}
+	s.lis = lis
+	s.mu.Unlock()
+
+	for {
+		conn, err := lis.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+
+		s.mu.Lock()
+		full := s.cfg.MaxConnections > 0 && len(s.conns) >= s.cfg.MaxConnections
+		if s.closed || full {
+			s.mu.Unlock()
+			if full {
+				s.logger.Warn("превышено число подключений Modbus", slog.String("remote", conn.RemoteAddr().String()))
+			}
+			conn.Close()
+			continue
+		}
+		s.conns[conn] = struct{}{}
+		s.wg.Add(1)
+		s.mu.Unlock()
+		prometheus.ModbusConnections.Inc()
+
+		go func() {
+			defer s.wg.Done()
+			defer prometheus.ModbusConnections.Dec()
+			s.serveConn(conn)
+		}()
+	}
+}
+
+// Close закрывает слушатель и все подключения.
+func (s *Server) Close() error {
+	s.mu.Lock()
+	s.closed = true
+	lis := s.lis
+	for conn := range s.conns {
+		conn.Close()
+	}
+	s.mu.Unlock()
+
+	var err error
+	if lis != nil {
+		err = lis.Close()
+	}
+	s.wg.Wait()
+	return err
+}
+
+func (s *Server) serveConn(conn net.Conn) {
+	defer func() {
+		s.mu.Lock()
+		delete(s.conns, conn)
+		s.mu.Unlock()
+		conn.Close()
+	}()
+	remote := conn.RemoteAddr().String()
+
+	header := make([]byte, headerSize)
+	pdu := make([]byte, maxPDU)
+	for {
+		if s.cfg.IdleTimeout > 0 {
+			conn.SetReadDeadline(time.Now().Add(s.cfg.IdleTimeout))
+		}
+		if _, err := io.ReadFull(conn, header); err != nil {
+			return
+		}
+		// Длина учитывает номер устройства и PDU. Кадр с другим протоколом
+		// или длиной не разобрать, поэтому соединение закрывается.
+		length := int(binary.BigEndian.Uint16(header[4:]))
+		if binary.BigEndian.Uint16(header[2:]) != 0 || length < 2 || length > maxPDU+1 {
+			s.logger.Debug("некорректный кадр Modbus", slog.String("remote", remote), slog.Int("length", length))
+			return
+		}
+		if _, err := io.ReadFull(conn, pdu[:length-1]); err != nil {
+			return
+		}
+
+		response := s.handle(pdu[:length-1], remote)
+		frame := make([]byte, headerSize, headerSize+len(response))
+		copy(frame, header[:4])
+		binary.BigEndian.PutUint16(frame[4:], uint16(len(response)+1))
+		frame[6] = header[6]
+		if _, err := conn.Write(append(frame, response...)); err != nil {
+			return
+		}
+	}
+}
+
+// handle обрабатывает PDU запроса и возвращает PDU ответа.
+func (s *Server) handle(pdu []byte, remote string) []byte {
+	function := pdu[0]
+	data, exception := s.dispatch(function, pdu[1:], remote)
+	prometheus.ModbusRequests.WithLabelValues(strconv.Itoa(int(function)), strconv.Itoa(int(exception))).Inc()
+	if exception != 0 {
+		return []byte{function | 0x80, byte(exception)}
+	}
+	return append([]byte{function}, data...)
+}
+
+func (s *Server) dispatch(function byte, data []byte, remote string) ([]byte, Exception) {
+	switch function {
+	case ReadHoldingRegisters, ReadInputRegisters:
+		if len(data) != 4 {
+			return nil, IllegalDataValue
+		}
+		start, quantity := int(binary.BigEndian.Uint16(data)), int(binary.BigEndian.Uint16(data[2:]))
+		if quantity < 1 || quantity > maxRegisters {
+			return nil, IllegalDataValue
+		}
+		return s.readRegisters(start, quantity)
+
+	case ReadCoils:
+		if len(data) != 4 {
+			return nil, IllegalDataValue
+		}
+		start, quantity := int(binary.BigEndian.Uint16(data)), int(binary.BigEndian.Uint16(data[2:]))
+		if quantity < 1 || quantity > maxCoils {
+			return nil, IllegalDataValue
+		}
+		return s.readCoils(start, quantity)
+
+	case WriteSingleCoil:
+		if !s.cfg.Commands {
+			return nil, IllegalFunction
+		}
+		if len(data) != 4 {
+			return nil, IllegalDataValue
+		}
+		var on bool
+		switch binary.BigEndian.Uint16(data[2:]) {
+		case 0xff00:
+			on = true
+		case 0x0000:
+		default:
+			return nil, IllegalDataValue
+		}
+		if exception := s.writeCoils(int(binary.BigEndian.Uint16(data)), []bool{on}, remote); exception != 0 {
+			return nil, exception
+		}
+		return data, 0
+
+	case WriteMultipleCoils:
+		if !s.cfg.Commands {
+			return nil, IllegalFunction
+		}
+		if len(data) < 5 {
+			return nil, IllegalDataValue
+		}
+		start, quantity := int(binary.BigEndian.Uint16(data)), int(binary.BigEndian.Uint16(data[2:]))
+		if quantity < 1 || quantity > maxWrite || int(data[4]) != (quantity+7)/8 || len(data) != 5+int(data[4]) {
+			return nil, IllegalDataValue
+		}
+		values := make([]bool, quantity)
+		for i := range values {
+			values[i] = data[5+i/8]&(1<<(i%8)) != 0
+		}
+		if exception := s.writeCoils(start, values, remote); exception != 0 {
+			return nil, exception
+		}
+		return data[:4], 0
+	}
+	return nil, IllegalFunction
+}
+
+func (s *Server) readRegisters(start, quantity int) ([]byte, Exception) {
+	uuids := s.blocks()
+	if start+quantity > len(uuids)*s.cfg.BlockSize {
+		return nil, IllegalDataAddress
+	}
+	now := time.Now()
+	data := make([]byte, 1, 1+2*quantity)
+	data[0] = byte(2 * quantity)
+	var values []uint16
+	for address := start; address < start+quantity; address++ {
+		offset := address % s.cfg.BlockSize
+		if values == nil || offset == 0 {
+			values = s.registerBlock(uuids[address/s.cfg.BlockSize], now)
+		}
+		data = binary.BigEndian.AppendUint16(data, values[offset])
+	}
+	return data, 0
+}
+
+func (s *Server) readCoils(start, quantity int) ([]byte, Exception) {
+	uuids := s.blocks()
+	if start+quantity > len(uuids)*s.cfg.CoilBlockSize {
+		return nil, IllegalDataAddress
+	}
+	now := time.Now()
+	data := make([]byte, 1+(quantity+7)/8)
+	data[0] = byte(len(data) - 1)
+	var values []bool
+	for i := 0; i < quantity; i++ {
+		address := start + i
+		offset := address % s.cfg.CoilBlockSize
+		if values == nil || offset == 0 {
+			values = s.coilBlock(uuids[address/s.cfg.CoilBlockSize], now)
+		}
+		if values[offset] {
+			data[1+i/8] |= 1 << (i % 8)
+		}
+	}
+	return data, 0
+}
+
+// writeCoils применяет команды по порядку адресов и останавливается на первой ошибке.
+func (s *Server) writeCoils(start int, values []bool, remote string) Exception {
+	uuids := s.blocks()
+	if start+len(values) > len(uuids)*s.cfg.CoilBlockSize {
+		return IllegalDataAddress
+	}
+	for i, on := range values {
+		address := start + i
+		if exception := s.setCoil(uuids[address/s.cfg.CoilBlockSize], address%s.cfg.CoilBlockSize, on, remote); exception != 0 {
+			return exception
+		}
+	}
+	return 0
+}
@@ -0,0 +1,294 @@
+package modbus_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"log/slog"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+	"trafficlightAPI/internal/audit"
+	"trafficlightAPI/internal/lights"
+	"trafficlightAPI/internal/modbus"
+	"trafficlightAPI/internal/models"
+	"trafficlightAPI/internal/overrides"
+	"trafficlightAPI/internal/storage"
+
+	"github.com/pkg/errors"
+)
+
+var testConfig = modbus.Config{
+	Lights:          []string{"a", "b"},
+	BlockSize:       8,
+	Registers:       modbus.RegisterMap{Phase: 0, Remaining: 1, Mode: 2, Lamps: 3, Type: 4},
+	CoilBlockSize:   4,
+	Coils:           modbus.CoilMap{Hold: 0, Advance: 1, Flash: 2},
+	CommandDuration: time.Minute,
+}
+
+// setup регистрирует светофор "a" (тип 1, зеленый) и "b" (тип 3, стоять).
+func setup(t *testing.T) *overrides.Manager {
+	t.Helper()
+	dir := t.TempDir()
+	store, err := storage.OpenBolt(filepath.Join(dir, "state.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { store.Close() })
+	auditLog, err := audit.Open(filepath.Join(dir, "audit.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { auditLog.Close() })
+
+	registry := lights.NewRegistry(store, slog.Default())
+	registry.Observe(models.Transition{UUID: "a", Type: 1, To: 3, Time: time.Now(), Mode: models.ModeNormal})
+	registry.Observe(models.Transition{UUID: "b", Type: 3, To: 1, Time: time.Now(), Mode: models.ModeManual})
+	lights.SetDefault(registry)
+	manager := overrides.NewManager(store, auditLog, slog.Default())
+	overrides.SetDefault(manager)
+	t.Cleanup(func() {
+		lights.SetDefault(nil)
+		overrides.SetDefault(nil)
+	})
+	return manager
+}
+
+type client struct {
+	t    *testing.T
+	conn net.Conn
+	id   uint16
+}
+
+func newClient(t *testing.T, cfg modbus.Config) *client {
+	t.Helper()
+	srv, err := modbus.NewServer(cfg, slog.Default())
+	if err != nil {
+		t.Fatal(err)
+	}
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go srv.Serve(lis)
+	t.Cleanup(func() { srv.Close() })
+
+	conn, err := net.Dial("tcp", lis.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return &client{t: t, conn: conn}
+}
+
+// call отправляет PDU и возвращает PDU ответа.
+func (c *client) call(pdu ...byte) []byte {
+	c.t.Helper()
+	c.id++
+	frame := binary.BigEndian.AppendUint16(nil, c.id)
+	frame = binary.BigEndian.AppendUint16(frame, 0)
+	frame = binary.BigEndian.AppendUint16(frame, uint16(len(pdu)+1))
+	frame = append(append(frame, 1), pdu...)
+	if _, err := c.conn.Write(frame); err != nil {
+		c.t.Fatal(err)
+	}
+
+	c.conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	header := make([]byte, 7)
+	if _, err := io.ReadFull(c.conn, header); err != nil {
+		c.t.Fatal(err)
+	}
+	if binary.BigEndian.Uint16(header) != c.id || header[6] != 1 {
+		c.t.Fatalf("header = %x", header)
+	}
+	response := make([]byte, binary.BigEndian.Uint16(header[4:])-1)
+	if _, err := io.ReadFull(c.conn, response); err != nil {
+		c.t.Fatal(err)
+	}
+	return response
+}
+
+func read(function byte, start, quantity uint16) []byte {
+	pdu := binary.BigEndian.AppendUint16([]byte{function}, start)
+	return binary.BigEndian.AppendUint16(pdu, quantity)
+}
+
+func TestNewServer(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(cfg *modbus.Config)
+	}{
+		{name: "register outside block", modify: func(cfg *modbus.Config) { cfg.Registers.Type = 8 }},
+		{name: "duplicate register", modify: func(cfg *modbus.Config) { cfg.Registers.Mode = 0 }},
+		{name: "duplicate coil", modify: func(cfg *modbus.Config) { cfg.Coils.Flash = 1 }},
+		{name: "empty coil block", modify: func(cfg *modbus.Config) { cfg.CoilBlockSize = 0 }},
+		{name: "no lights", modify: func(cfg *modbus.Config) { cfg.Lights = nil }},
+		{name: "duplicate light", modify: func(cfg *modbus.Config) { cfg.Lights = []string{"a", "a"} }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := testConfig
+			tt.modify(&cfg)
+			if _, err := modbus.NewServer(cfg, slog.Default()); !errors.Is(err, modbus.ErrInvalidMap) {
+				t.Errorf("NewServer() err = %v, want ErrInvalidMap", err)
+			}
+		})
+	}
+}
+
+func TestNewServerCommandsWithAuth(t *testing.T) {
+	cfg := testConfig
+	cfg.Commands, cfg.AuthEnabled = true, true
+	if _, err := modbus.NewServer(cfg, slog.Default()); !errors.Is(err, modbus.ErrCommandsWithAuth) {
+		t.Errorf("NewServer() err = %v, want ErrCommandsWithAuth", err)
+	}
+	cfg.Commands = false
+	if _, err := modbus.NewServer(cfg, slog.Default()); err != nil {
+		t.Errorf("read-only server with auth: %v", err)
+	}
+}
+
+func TestReadRegisters(t *testing.T) {
+	setup(t)
+	c := newClient(t, testConfig)
+
+	response := c.call(read(modbus.ReadHoldingRegisters, 0, 16)...)
+	if len(response) != 2+32 || response[0] != modbus.ReadHoldingRegisters || response[1] != 32 {
+		t.Fatalf("response = %x", response)
+	}
+	registers := make([]uint16, 16)
+	for i := range registers {
+		registers[i] = binary.BigEndian.Uint16(response[2+2*i:])
+	}
+	a, b := registers[:8], registers[8:]
+	if a[0] != 3 || a[2] != modbus.ModeNormal || a[3] != modbus.LampGreen || a[4] != 1 {
+		t.Errorf("block a = %v", a)
+	}
+	if plan := models.Plan(1); a[1] == 0 || int(a[1]) > plan[2] {
+		t.Errorf("remaining = %d, plan %v", a[1], plan)
+	}
+	if b[0] != 1 || b[1] != 0 || b[2] != modbus.ModeManual || b[3] != modbus.LampRed || b[4] != 3 {
+		t.Errorf("block b = %v", b)
+	}
+
+	// Input регистры совпадают с holding.
+	if input := c.call(read(modbus.ReadInputRegisters, 3, 1)...); !bytes.Equal(input, []byte{modbus.ReadInputRegisters, 2, 0, byte(modbus.LampGreen)}) {
+		t.Errorf("input register = %x", input)
+	}
+
+	tests := []struct {
+		name string
+		pdu  []byte
+		want []byte
+	}{
+		{name: "beyond last block", pdu: read(modbus.ReadHoldingRegisters, 15, 2), want: []byte{0x83, byte(modbus.IllegalDataAddress)}},
+		{name: "zero quantity", pdu: read(modbus.ReadHoldingRegisters, 0, 0), want: []byte{0x83, byte(modbus.IllegalDataValue)}},
+		{name: "unsupported function", pdu: []byte{0x2b, 0x0e, 0x01, 0x00}, want: []byte{0xab, byte(modbus.IllegalFunction)}},
+		{name: "commands disabled", pdu: []byte{modbus.WriteSingleCoil, 0, 0, 0xff, 0}, want: []byte{0x85, byte(modbus.IllegalFunction)}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := c.call(tt.pdu...); !bytes.Equal(got, tt.want) {
+				t.Errorf("response = %x, want %x", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCoils(t *testing.T) {
+	manager := setup(t)
+	cfg := testConfig
+	cfg.Commands = true
+	c := newClient(t, cfg)
+
+	coil := func(address uint16, on bool) []byte {
+		value := uint16(0)
+		if on {
+			value = 0xff00
+		}
+		return c.call(read(modbus.WriteSingleCoil, address, value)...)
+	}
+	coils := func() byte {
+		response := c.call(read(modbus.ReadCoils, 0, 8)...)
+		if len(response) != 3 || response[1] != 1 {
+			t.Fatalf("read coils = %x", response)
+		}
+		return response[2]
+	}
+	activeAction := func() string {
+		for _, o := range manager.Active(time.Now()) {
+			if o.UUID == "a" {
+				return o.Action
+			}
+		}
+		return ""
+	}
+
+	if got := coil(0, true); !bytes.Equal(got, read(modbus.WriteSingleCoil, 0, 0xff00)) {
+		t.Fatalf("hold = %x", got)
+	}
+	if activeAction() != overrides.ActionHold || coils() != 0b0001 {
+		t.Errorf("after hold: action %q, coils %04b", activeAction(), coils())
+	}
+	// Повторная запись не создает новое ручное управление.
+	first := manager.Active(time.Now())[0].ID
+	coil(0, true)
+	if manager.Active(time.Now())[0].ID != first {
+		t.Error("repeated write replaced override")
+	}
+
+	coil(2, true)
+	if activeAction() != overrides.ActionForce || coils() != 0b0100 {
+		t.Errorf("after flash: action %q, coils %04b", activeAction(), coils())
+	}
+	coil(0, false) // Держание уже заменено, снимать нечего.
+	if activeAction() != overrides.ActionForce {
+		t.Errorf("releasing inactive coil removed %q", activeAction())
+	}
+	coil(2, false)
+	if activeAction() != "" || coils() != 0 {
+		t.Errorf("after release: action %q, coils %04b", activeAction(), coils())
+	}
+
+	// Держание оператора видно в катушке, но сброс катушки его не снимает.
+	now := time.Now()
+	operator, err := manager.Create(storage.Override{UUID: "a", Action: overrides.ActionHold, Reason: "ДТП", User: "ivanov", CreatedAt: now, ExpiresAt: now.Add(time.Minute)}, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	coil(0, false)
+	if activeAction() != overrides.ActionHold || coils() != 0b0001 {
+		t.Errorf("coil write released operator override: action %q, coils %04b", activeAction(), coils())
+	}
+	if _, err := manager.Release(operator.ID, "готово", "ivanov"); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		pdu  []byte
+		want []byte
+	}{
+		{name: "pedestrian light cannot flash", pdu: read(modbus.WriteSingleCoil, 6, 0xff00), want: []byte{0x85, byte(modbus.IllegalDataValue)}},
+		{name: "invalid coil value", pdu: read(modbus.WriteSingleCoil, 0, 0x1234), want: []byte{0x85, byte(modbus.IllegalDataValue)}},
+		{name: "unmapped coil", pdu: read(modbus.WriteSingleCoil, 3, 0xff00), want: []byte{0x85, byte(modbus.IllegalDataValue)}},
+		{name: "beyond last block", pdu: read(modbus.WriteSingleCoil, 8, 0xff00), want: []byte{0x85, byte(modbus.IllegalDataAddress)}},
+		{
+			name: "write multiple coils",
+			pdu:  append(read(modbus.WriteMultipleCoils, 4, 4), 1, 0b0001),
+			want: read(modbus.WriteMultipleCoils, 4, 4),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := c.call(tt.pdu...); !bytes.Equal(got, tt.want) {
+				t.Errorf("response = %x, want %x", got, tt.want)
+			}
+		})
+	}
+	if response := c.call(read(modbus.ReadCoils, 4, 4)...); !bytes.Equal(response, []byte{modbus.ReadCoils, 1, 0b0001}) {
+		t.Errorf("coils of b = %x", response)
+	}
+}
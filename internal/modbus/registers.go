@@ -0,0 +1,245 @@
+package modbus
+
+import (
+	"log/slog"
+	"math"
+	"time"
+	"trafficlightAPI/internal/lights"
+	"trafficlightAPI/internal/models"
+	"trafficlightAPI/internal/overrides"
+	"trafficlightAPI/internal/storage"
+	"trafficlightAPI/internal/stream"
+
+	"github.com/pkg/errors"
+)
+
+// RegisterMap - смещения регистров внутри блока светофора.
+type RegisterMap struct {
+	Phase     int // Текущее состояние, 1..N; 0 - неизвестно
+	Remaining int // Оставшееся время состояния, с; 0 вне обычного режима
+	Mode      int // Код режима, см. ModeCode
+	Lamps     int // Биты горящих ламп, см. LampBits
+	Type      int // Тип светофора
+}
+
+// CoilMap - смещения катушек внутри блока светофора.
+type CoilMap struct {
+	Hold    int // Держать текущее состояние
+	Advance int // Однократно перейти к следующему состоянию
+	Flash   int // Мигающий желтый
+}
+
+// Коды режима в регистре Mode.
+const (
+	ModeUnknown uint16 = iota
+	ModeNormal
+	ModeManual
+	ModeDegraded
+	ModeFailsafe
+)
+
+// Биты регистра Lamps.
+const (
+	LampRed uint16 = 1 << iota
+	LampYellow
+	LampGreen
+	LampArrow
+	LampArrowFlashing
+)
+
+const (
+	coilHold    = "hold"
+	coilAdvance = "advance"
+	coilFlash   = "flash"
+)
+
+func ModeCode(mode string) uint16 {
+	switch mode {
+	case models.ModeNormal, "":
+		return ModeNormal
+	case models.ModeManual:
+		return ModeManual
+	case models.ModeDegraded:
+		return ModeDegraded
+	case models.ModeFailsafe:
+		return ModeFailsafe
+	default:
+		return ModeUnknown
+	}
+}
+
+func LampBits(l models.Lamps) uint16 {
+	var bits uint16
+	for _, lamp := range []struct {
+		lit bool
+		bit uint16
+	}{
+		{l.Red, LampRed}, {l.Yellow, LampYellow}, {l.Green, LampGreen}, {l.Arrow, LampArrow}, {l.ArrowFlashing, LampArrowFlashing},
+	} {
+		if lamp.lit {
+			bits |= lamp.bit
+		}
+	}
+	return bits
+}
+
+// offsets проверяет, что смещения различны и помещаются в блок размера size.
+func offsets(size int, named map[string]int) error {
+	if size < 1 {
+		return errors.Wrapf(ErrInvalidMap, "размер блока %d", size)
+	}
+	used := make(map[int]string, len(named))
+	for name, offset := range named {
+		if offset < 0 || offset >= size {
+			return errors.Wrapf(ErrInvalidMap, "%s: смещение %d вне блока из %d", name, offset, size)
+		}
+		if other, ok := used[offset]; ok {
+			return errors.Wrapf(ErrInvalidMap, "%s и %s: одно смещение %d", name, other, offset)
+		}
+		used[offset] = name
+	}
+	return nil
+}
+
+// blocks возвращает uuid светофоров в порядке блоков.
+func (s *Server) blocks() []string {
+	return s.cfg.Lights
+}
+
+// registerBlock возвращает регистры светофора uuid. Неизвестный светофор - нули.
+func (s *Server) registerBlock(uuid string, now time.Time) []uint16 {
+	values := make([]uint16, s.cfg.BlockSize)
+	registry := lights.Default()
+	if registry == nil {
+		return values
+	}
+	entry, ok := registry.Get(uuid)
+	if !ok || entry.State == nil || entry.State.Type < 1 || entry.State.Type > models.TypesCount() {
+		return values
+	}
+	state := entry.State
+	e := stream.NewEvent(uuid, state.Type, state.State, state.Mode, state.Since, models.Plan(state.Type), "")
+
+	r := s.cfg.Registers
+	values[r.Phase] = uint16(state.State)
+	values[r.Mode] = ModeCode(state.Mode)
+	values[r.Lamps] = LampBits(e.Lamps)
+	values[r.Type] = uint16(state.Type)
+	if e.NextAt != nil {
+		remaining := math.Ceil(e.NextAt.Sub(now).Seconds())
+		values[r.Remaining] = uint16(max(0, min(remaining, math.MaxUint16)))
+	}
+	return values
+}
+
+func lightType(uuid string) int {
+	if registry := lights.Default(); registry != nil {
+		if entry, ok := registry.Get(uuid); ok {
+			return entry.Light.Type
+		}
+	}
+	return 0
+}
+
+// coilOf возвращает катушку, которой соответствует ручное управление o.
+func coilOf(o storage.Override, trafficType int) string {
+	switch o.Action {
+	case overrides.ActionHold:
+		return coilHold
+	case overrides.ActionAdvance:
+		return coilAdvance
+	case overrides.ActionForce:
+		if trafficType >= 1 && trafficType <= models.TypesCount() && o.State == models.FlashState(trafficType) {
+			return coilFlash
+		}
+	}
+	return ""
+}
+
+// active возвращает действующее ручное управление светофором uuid.
+func active(manager *overrides.Manager, uuid string, now time.Time) (storage.Override, bool) {
+	for _, o := range manager.Active(now) {
+		if o.UUID == uuid {
+			return o, true
+		}
+	}
+	return storage.Override{}, false
+}
+
+// coilBlock возвращает катушки светофора uuid: включена та, чье ручное управление действует.
+func (s *Server) coilBlock(uuid string, now time.Time) []bool {
+	values := make([]bool, s.cfg.CoilBlockSize)
+	manager := overrides.Default()
+	if manager == nil {
+		return values
+	}
+	o, ok := active(manager, uuid, now)
+	if !ok {
+		return values
+	}
+	coil := coilOf(o, lightType(uuid))
+	for offset, name := range s.coils {
+		values[offset] = name == coil
+	}
+	return values
+}
+
+// setCoil включает или снимает ручное управление по катушке. Повторная запись
+// того же значения ничего не меняет, поэтому ПЛК может писать катушку каждый цикл.
+// Сброс катушки снимает только ручное управление, включенное по Modbus.
+func (s *Server) setCoil(uuid string, offset int, on bool, remote string) Exception {
+	coil, mapped := s.coils[offset]
+	if !mapped {
+		if on {
+			return IllegalDataValue
+		}
+		return 0
+	}
+	manager := overrides.Default()
+	if manager == nil {
+		return ServerDeviceFailure
+	}
+
+	now := time.Now()
+	trafficType := lightType(uuid)
+	current, ok := active(manager, uuid, now)
+	if on == (ok && coilOf(current, trafficType) == coil) {
+		return 0
+	}
+	reason := "Modbus " + remote
+
+	if !on {
+		// Ручное управление оператора или другого протокола катушкой не снимается.
+		if current.User != User {
+			return 0
+		}
+		_, err := manager.Release(current.ID, reason, User)
+		if err != nil && !errors.Is(err, overrides.ErrOverrideNotFound) {
+			s.logger.Error("ошибка снятия ручного управления по Modbus", slog.String("uuid", uuid), slog.Any("err", err))
+			return ServerDeviceFailure
+		}
+		return 0
+	}
+
+	o := storage.Override{UUID: uuid, Reason: reason, User: User, CreatedAt: now, ExpiresAt: now.Add(s.cfg.CommandDuration)}
+	switch coil {
+	case coilHold:
+		o.Action = overrides.ActionHold
+	case coilAdvance:
+		o.Action = overrides.ActionAdvance
+	case coilFlash:
+		if trafficType < 1 || trafficType > models.TypesCount() || models.FlashState(trafficType) == 0 {
+			return IllegalDataValue
+		}
+		o.Action, o.State = overrides.ActionForce, models.FlashState(trafficType)
+	}
+	if _, err := manager.Create(o, trafficType); err != nil {
+		if errors.Is(err, overrides.ErrInvalidOverride) {
+			return IllegalDataValue
+		}
+		s.logger.Error("ошибка ручного управления по Modbus", slog.String("uuid", uuid), slog.Any("err", err))
+		return ServerDeviceFailure
+	}
+	s.logger.Info("ручное управление по Modbus", slog.String("uuid", uuid), slog.String("coil", coil), slog.String("remote", remote))
+	return 0
+}
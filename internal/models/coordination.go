@@ -0,0 +1,94 @@
+package models
+
+import (
+	"strconv"
+	"sync"
+	"time"
+)
+
+// coordinationWindow - допуск опроса устройства, с: красный завершается, если
+// позиция цикла попала в окно, иначе светофор ждет следующего цикла.
+const coordinationWindow = 3
+
+// coordinated - план конкретного светофора магистрали со смещением.
+// Позиция в цикле считается от часов сервера: (unix-время + смещение) mod цикл,
+// поэтому светофоры с общим циклом держат заданные смещения между собой.
+type coordinated struct {
+	trafficType int
+	light       TrafficLight
+	offset      int
+	cycle       int
+}
+
+var (
+	coordinationMu sync.RWMutex
+	coordination   = make(map[string]coordinated)
+	clock          = time.Now
+)
+
+// SetClock подменяет часы координации, используется в тестах.
+func SetClock(now func() time.Time) {
+	coordinationMu.Lock()
+	defer coordinationMu.Unlock()
+	clock = now
+}
+
+// Coordinated возвращает план и смещение светофора uuid, если он координирован.
+func Coordinated(uuid string) (plan []int, offset int, ok bool) {
+	coordinationMu.RLock()
+	defer coordinationMu.RUnlock()
+	c, ok := coordination[uuid]
+	if !ok {
+		return nil, 0, false
+	}
+	return c.light.Plan(), c.offset, true
+}
+
+func setCoordinated(def LightDefinition, light TrafficLight) {
+	cycle := 0
+	for _, d := range light.Plan() {
+		cycle += d
+	}
+	coordinationMu.Lock()
+	defer coordinationMu.Unlock()
+	coordination[def.UUID] = coordinated{trafficType: def.Type, light: light, offset: def.Offset, cycle: cycle}
+}
+
+// lightFor возвращает план светофора: собственный для координированного
+// светофора того же типа, иначе план типа.
+func lightFor(uuid string, trafficType int) (TrafficLight, *coordinated) {
+	coordinationMu.RLock()
+	c, ok := coordination[uuid]
+	coordinationMu.RUnlock()
+	if ok && c.trafficType == trafficType {
+		return c.light, &c
+	}
+	return Light(trafficType), nil
+}
+
+// synchronize удерживает красный (состояние 1), пока позиция цикла не дойдет
+// до конца красного по плану. Остальные состояния идут по длительностям,
+// поэтому после первого цикла светофор держит смещение без удержаний.
+// Возвращает true, если красный удержан.
+func (c *coordinated) synchronize(data TrafficRequest, response *TrafficResponse) bool {
+	if data.CurrentState != 1 || response.NextState == "1" {
+		return false
+	}
+
+	coordinationMu.RLock()
+	now := clock()
+	coordinationMu.RUnlock()
+
+	end := c.light.Plan()[0] - 1
+	position := int((now.Unix() + int64(c.offset)) % int64(c.cycle))
+	wait := ((end-position)%c.cycle + c.cycle) % c.cycle
+	if wait == 0 || wait > c.cycle-coordinationWindow {
+		return false
+	}
+
+	response.NextState = "1"
+	if response.NextCountdownTime != "" {
+		response.NextCountdownTime = strconv.Itoa(wait + 1)
+	}
+	return true
+}
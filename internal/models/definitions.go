@@ -0,0 +1,115 @@
+package models
+
+import (
+	"fmt"
+	"os"
+	"sync"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+)
+
+var (
+	ErrReadDefinitions = errors.New("ошибка при чтении определений светофоров")
+)
+
+type LightDefinition struct {
+	Name      string `yaml:"name,omitempty" json:"name,omitempty"`
+	UUID      string `yaml:"uuid,omitempty" json:"uuid,omitempty"` // Собственный план светофора вместо плана типа
+	Type      int    `yaml:"type" json:"type"`
+	Durations []int  `yaml:"durations" json:"durations"`
+	Offset    int    `yaml:"offset,omitempty" json:"offset,omitempty"`
+}
+
+type Definitions struct {
+	Lights []LightDefinition `yaml:"lights" json:"lights"`
+}
+
+func NewLight(def LightDefinition) (TrafficLight, error) {
+	if def.Type < 1 || def.Type > len(trafficLights) {
+		return nil, errors.Wrapf(ErrInvalidPlan, "неизвестный тип светофора %d", def.Type)
+	}
+	if def.Offset < 0 {
+		return nil, errors.Wrapf(ErrInvalidPlan, "отрицательное смещение %d", def.Offset)
+	}
+	return Light(def.Type).WithPlan(def.Durations)
+}
+
+func LoadDefinitions(path string) (Definitions, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Definitions{}, errors.Wrap(ErrReadDefinitions, err.Error())
+	}
+
+	var defs Definitions
+	if err := yaml.Unmarshal(data, &defs); err != nil {
+		return Definitions{}, errors.Wrap(ErrReadDefinitions, err.Error())
+	}
+	return defs, nil
+}
+
+// ValidateDefinitions проверяет, что определения можно применить к серверу.
+// Определение без uuid задает план типа: такой план один на тип и без смещения,
+// иначе действовало бы последнее определение типа, а смещение молча терялось.
+// Определение с uuid задает план одного светофора и может иметь смещение.
+func ValidateDefinitions(defs Definitions) error {
+	names := make(map[int]string, len(defs.Lights))
+	uuids := make(map[string]string)
+	for _, def := range defs.Lights {
+		if _, err := NewLight(def); err != nil {
+			return errors.Wrapf(err, "светофор %q", def.Name)
+		}
+		if def.UUID != "" {
+			if other, ok := uuids[def.UUID]; ok {
+				return errors.Wrapf(ErrInvalidPlan, "светофоры %q и %q: несколько планов для uuid %s", other, def.Name, def.UUID)
+			}
+			uuids[def.UUID] = def.Name
+			continue
+		}
+		if def.Offset != 0 {
+			return errors.Wrapf(ErrInvalidPlan, "светофор %q: смещение %d задается только для светофора с uuid", def.Name, def.Offset)
+		}
+		if other, ok := names[def.Type]; ok {
+			return errors.Wrapf(ErrInvalidPlan, "светофоры %q и %q: несколько планов для типа %d", other, def.Name, def.Type)
+		}
+		names[def.Type] = def.Name
+	}
+	return nil
+}
+
+// definitionsMu не дает двум файлам планов применяться вперемешку.
+var definitionsMu sync.Mutex
+
+// ApplyDefinitions проверяет все определения и только затем применяет планы,
+// поэтому ошибка в любом определении не меняет ни одного плана.
+// Планы светофоров с uuid добавляются к действующим, а не заменяют их.
+func ApplyDefinitions(defs Definitions) error {
+	if err := ValidateDefinitions(defs); err != nil {
+		return err
+	}
+	definitionsMu.Lock()
+	defer definitionsMu.Unlock()
+	for _, def := range defs.Lights {
+		if def.UUID != "" {
+			light, _ := NewLight(def)
+			setCoordinated(def, light)
+			continue
+		}
+		if err := ApplyPlan(def.Type, def.Durations); err != nil {
+			return errors.Wrapf(err, "светофор %q", def.Name)
+		}
+	}
+	return nil
+}
+
+func CurrentDefinitions() Definitions {
+	var defs Definitions
+	for trafficType := 1; trafficType <= len(trafficLights); trafficType++ {
+		defs.Lights = append(defs.Lights, LightDefinition{
+			Name:      fmt.Sprintf("trafficlight%d", trafficType),
+			Type:      trafficType,
+			Durations: Plan(trafficType),
+		})
+	}
+	return defs
+}
@@ -0,0 +1,108 @@
+package models
+
+import "github.com/pkg/errors"
+
+var (
+	ErrUnknownState = errors.New("неизвестное состояние светофора")
+)
+
+// Названия ламп, совпадают с полями Lamps в JSON.
+const (
+	LampRed    = "red"
+	LampYellow = "yellow"
+	LampGreen  = "green"
+	LampArrow  = "arrow"
+)
+
+type Lamps struct {
+	Red           bool `json:"red"`
+	Yellow        bool `json:"yellow"`
+	Green         bool `json:"green"`
+	Arrow         bool `json:"arrow,omitempty"`
+	ArrowFlashing bool `json:"arrow_flashing,omitempty"`
+}
+
+var lampStates = [3][]Lamps{
+	{
+		{Red: true},    // Красный
+		{Yellow: true}, // Желтый
+		{Green: true},  // Зеленый
+	},
+	{
+		{Red: true},              // Красный
+		{Red: true, Arrow: true}, // Красный + стрелка
+		{Red: true, Arrow: true, ArrowFlashing: true}, // Красный + мигающая стрелка
+		{Red: true},               // Красный
+		{Red: true, Yellow: true}, // Красный + желтый
+		{Green: true},             // Зеленый
+		{Yellow: true},            // Желтый
+	},
+	{
+		{Red: true},   // Стоять
+		{Green: true}, // Идти
+	},
+}
+
+func StateLamps(trafficType, state int) (Lamps, error) {
+	if trafficType < 1 || trafficType > len(lampStates) {
+		return Lamps{}, errors.Wrapf(ErrUnknownState, "тип:%d", trafficType)
+	}
+	states := lampStates[trafficType-1]
+	if state < 1 || state > len(states) {
+		return Lamps{}, errors.Wrapf(ErrUnknownState, "тип:%d, состояние:%d", trafficType, state)
+	}
+	return states[state-1], nil
+}
+
+func StatesCount(trafficType int) int {
+	return len(lampStates[trafficType-1])
+}
+
+// Lit сообщает, горит ли лампа lamp.
+func (l Lamps) Lit(lamp string) bool {
+	switch lamp {
+	case LampRed:
+		return l.Red
+	case LampYellow:
+		return l.Yellow
+	case LampGreen:
+		return l.Green
+	case LampArrow:
+		return l.Arrow
+	default:
+		return false
+	}
+}
+
+// HasLamp сообщает, есть ли лампа lamp у светофора типа trafficType.
+func HasLamp(trafficType int, lamp string) bool {
+	if trafficType < 1 || trafficType > len(lampStates) {
+		return false
+	}
+	for _, l := range lampStates[trafficType-1] {
+		if l.Lit(lamp) {
+			return true
+		}
+	}
+	return false
+}
+
+// FailsafeState возвращает состояние, в котором горит только красный, или 0, если его нет.
+func FailsafeState(trafficType int) int {
+	for i, l := range lampStates[trafficType-1] {
+		if l == (Lamps{Red: true}) {
+			return i + 1
+		}
+	}
+	return 0
+}
+
+// FlashState возвращает состояние, в котором горит только желтый, или 0, если его нет.
+func FlashState(trafficType int) int {
+	for i, l := range lampStates[trafficType-1] {
+		if l == (Lamps{Yellow: true}) {
+			return i + 1
+		}
+	}
+	return 0
+}
@@ -4,11 +4,20 @@ import (
 	"encoding/json"
 	"fmt"
 	"strconv"
+	"sync"
+	"time"
 	"trafficlightAPI/internal/image_generator"
 
 	"github.com/bytedance/sonic"
+	"github.com/pkg/errors"
 )
 
+var (
+	ErrInvalidPlan = errors.New("некорректный план светофора")
+)
+
+const regularDefaultDuration = 20
+
 type TrafficRequest struct {
 	UUID         string `json:"uuid"`
 	CurrentState int    `json:"current_state"`
@@ -17,10 +26,22 @@ type TrafficRequest struct {
 }
 
 type TrafficResponse struct {
-	UUID              string `json:"uuid"`
-	NextState         string `json:"next_state"`
-	NextCountdownTime string `json:"next_countdown_time,omitempty"`
-	Image             string `json:"image,omitempty"`
+	UUID              string          `json:"uuid"`
+	NextState         string          `json:"next_state"`
+	NextCountdownTime string          `json:"next_countdown_time,omitempty"`
+	Image             string          `json:"image,omitempty"`
+	Override          *OverrideStatus `json:"override,omitempty"` // Светофор под ручным управлением
+	Faults            []string        `json:"faults,omitempty"`   // Неисправные лампы
+	Degraded          string          `json:"degraded,omitempty"` // Действие политики неисправностей
+	Flashing          bool            `json:"flashing,omitempty"` // NextState мигает
+	Failsafe          string          `json:"failsafe,omitempty"` // Авария монитора конфликтов
+}
+
+type OverrideStatus struct {
+	ID        string    `json:"id"`
+	Action    string    `json:"action"`
+	Reason    string    `json:"reason"`
+	ExpiresAt time.Time `json:"expires_at"`
 }
 
 type ErrorDetail struct {
@@ -35,16 +56,27 @@ type ErrorResponse struct {
 
 type TrafficLight interface {
 	GetNextState(TrafficRequest) (TrafficResponse, error)
+	Plan() []int
+	WithPlan([]int) (TrafficLight, error)
 }
 
 type RegularTrafficLight struct {
-	Data TrafficRequest
+	Data      TrafficRequest
+	Durations [3]int // Нулевая длительность означает 20с
+}
+
+func (r *RegularTrafficLight) duration(idx int) int {
+	if r.Durations[idx] > 0 {
+		return r.Durations[idx]
+	}
+	return regularDefaultDuration
 }
 
 func (r *RegularTrafficLight) GetNextState(tr TrafficRequest) (TrafficResponse, error) {
 	response := TrafficResponse{UUID: tr.UUID}
+	idx := (tr.CurrentState - 1) % len(r.Durations)
 
-	if *tr.CurrentTime >= 19 {
+	if *tr.CurrentTime >= r.duration(idx)-1 {
 		response.NextState = strconv.Itoa(tr.CurrentState%3 + 1)
 	} else {
 		response.NextState = strconv.Itoa(tr.CurrentState)
@@ -61,6 +93,22 @@ func (r *RegularTrafficLight) GetNextState(tr TrafficRequest) (TrafficResponse,
 	return response, nil
 }
 
+func (r *RegularTrafficLight) Plan() []int {
+	plan := make([]int, len(r.Durations))
+	for i := range plan {
+		plan[i] = r.duration(i)
+	}
+	return plan
+}
+
+func (r *RegularTrafficLight) WithPlan(plan []int) (TrafficLight, error) {
+	light := &RegularTrafficLight{}
+	if err := copyPlan(light.Durations[:], plan); err != nil {
+		return nil, err
+	}
+	return light, nil
+}
+
 type TrafficLightWithRightArrow struct {
 	Durations [7]int
 }
@@ -87,6 +135,18 @@ func (r *TrafficLightWithRightArrow) GetNextState(tr TrafficRequest) (TrafficRes
 	return response, nil
 }
 
+func (r *TrafficLightWithRightArrow) Plan() []int {
+	return append([]int(nil), r.Durations[:]...)
+}
+
+func (r *TrafficLightWithRightArrow) WithPlan(plan []int) (TrafficLight, error) {
+	light := &TrafficLightWithRightArrow{}
+	if err := copyPlan(light.Durations[:], plan); err != nil {
+		return nil, err
+	}
+	return light, nil
+}
+
 type PedestrianTrafficLight struct {
 	Durations [2]int
 }
@@ -108,6 +168,33 @@ func (p *PedestrianTrafficLight) GetNextState(tr TrafficRequest) (TrafficRespons
 	return response, nil
 }
 
+func (p *PedestrianTrafficLight) Plan() []int {
+	return append([]int(nil), p.Durations[:]...)
+}
+
+func (p *PedestrianTrafficLight) WithPlan(plan []int) (TrafficLight, error) {
+	light := &PedestrianTrafficLight{}
+	if err := copyPlan(light.Durations[:], plan); err != nil {
+		return nil, err
+	}
+	return light, nil
+}
+
+func copyPlan(dst, plan []int) error {
+	if len(plan) != len(dst) {
+		return errors.Wrapf(ErrInvalidPlan, "ожидалось %d фаз, получено %d", len(dst), len(plan))
+	}
+	for i, d := range plan {
+		if d < 1 {
+			return errors.Wrapf(ErrInvalidPlan, "длительность фазы %d: %d", i+1, d)
+		}
+	}
+	copy(dst, plan)
+	return nil
+}
+
+var trafficLightsMu sync.RWMutex
+
 var trafficLights = [3]TrafficLight{
 	&RegularTrafficLight{},
 	&TrafficLightWithRightArrow{
@@ -118,9 +205,240 @@ var trafficLights = [3]TrafficLight{
 	},
 }
 
+// TypesCount возвращает число поддерживаемых типов светофоров, типы нумеруются с 1.
+func TypesCount() int {
+	return len(trafficLights)
+}
+
+func Light(trafficType int) TrafficLight {
+	trafficLightsMu.RLock()
+	defer trafficLightsMu.RUnlock()
+	return trafficLights[trafficType-1]
+}
+
+func Plan(trafficType int) []int {
+	return Light(trafficType).Plan()
+}
+
+func MaxDuration(trafficType int) int {
+	maxDuration := 0
+	for _, d := range Plan(trafficType) {
+		maxDuration = max(maxDuration, d)
+	}
+	return maxDuration
+}
+
+func ApplyPlan(trafficType int, plan []int) error {
+	if trafficType < 1 || trafficType > len(trafficLights) {
+		return errors.Wrapf(ErrInvalidPlan, "неизвестный тип светофора %d", trafficType)
+	}
+
+	trafficLightsMu.Lock()
+	light, err := trafficLights[trafficType-1].WithPlan(plan)
+	if err != nil {
+		trafficLightsMu.Unlock()
+		return err
+	}
+	trafficLights[trafficType-1] = light
+	trafficLightsMu.Unlock()
+
+	notifyPlanChange(trafficType, light.Plan())
+	return nil
+}
+
+var (
+	planHooksMu sync.RWMutex
+	planHooks   []func(trafficType int, plan []int)
+)
+
+// OnPlanChange регистрирует обработчик, вызываемый после применения плана.
+func OnPlanChange(hook func(trafficType int, plan []int)) {
+	planHooksMu.Lock()
+	defer planHooksMu.Unlock()
+	planHooks = append(planHooks, hook)
+}
+
+func notifyPlanChange(trafficType int, plan []int) {
+	planHooksMu.RLock()
+	defer planHooksMu.RUnlock()
+	for _, hook := range planHooks {
+		hook(trafficType, plan)
+	}
+}
+
+// Режимы работы светофора.
+const (
+	ModeNormal   = "normal"
+	ModeManual   = "manual"
+	ModeDegraded = "degraded" // Работа изменена из-за неисправных ламп
+	ModeFailsafe = "failsafe" // Мигающий красный по аварии монитора конфликтов
+)
+
+// Transition - смена состояния светофора, вычисленная ManageLights.
+type Transition struct {
+	UUID string
+	Type int
+	From int
+	To   int
+	Time time.Time
+	Plan []int // Действующий план на момент смены
+	Mode string
+}
+
+var (
+	transitionHooksMu sync.RWMutex
+	transitionHooks   []func(Transition)
+)
+
+func OnTransition(hook func(Transition)) {
+	transitionHooksMu.Lock()
+	defer transitionHooksMu.Unlock()
+	transitionHooks = append(transitionHooks, hook)
+}
+
+func notifyTransition(t Transition) {
+	transitionHooksMu.RLock()
+	defer transitionHooksMu.RUnlock()
+	for _, hook := range transitionHooks {
+		hook(t)
+	}
+}
+
+var (
+	overrideMu   sync.RWMutex
+	overrideHook func(TrafficRequest, int, *TrafficResponse) bool
+)
+
+// SetOverride задает обработчик ручного управления: он может заменить
+// вычисленный ответ и возвращает true, если светофор под ручным управлением.
+func SetOverride(hook func(data TrafficRequest, trafficType int, response *TrafficResponse) bool) {
+	overrideMu.Lock()
+	defer overrideMu.Unlock()
+	overrideHook = hook
+}
+
+func applyOverride(data TrafficRequest, trafficType int, response *TrafficResponse) bool {
+	overrideMu.RLock()
+	defer overrideMu.RUnlock()
+	return overrideHook != nil && overrideHook(data, trafficType, response)
+}
+
+var (
+	faultsMu   sync.RWMutex
+	faultsHook func(TrafficRequest, int, *TrafficResponse) bool
+)
+
+// SetFaults задает обработчик неисправностей ламп: он вызывается после
+// ручного управления и возвращает true, если ответ изменен политикой.
+func SetFaults(hook func(data TrafficRequest, trafficType int, response *TrafficResponse) bool) {
+	faultsMu.Lock()
+	defer faultsMu.Unlock()
+	faultsHook = hook
+}
+
+func applyFaults(data TrafficRequest, trafficType int, response *TrafficResponse) bool {
+	faultsMu.RLock()
+	defer faultsMu.RUnlock()
+	return faultsHook != nil && faultsHook(data, trafficType, response)
+}
+
+var (
+	failsafeMu   sync.RWMutex
+	failsafeHook func(TrafficRequest, int, *TrafficResponse) bool
+)
+
+// SetFailsafe задает обработчик аварийного режима. Он вызывается последним
+// и возвращает true, если светофор переведен в аварийный режим.
+func SetFailsafe(hook func(data TrafficRequest, trafficType int, response *TrafficResponse) bool) {
+	failsafeMu.Lock()
+	defer failsafeMu.Unlock()
+	failsafeHook = hook
+}
+
+func applyFailsafe(data TrafficRequest, trafficType int, response *TrafficResponse) bool {
+	failsafeMu.RLock()
+	defer failsafeMu.RUnlock()
+	return failsafeHook != nil && failsafeHook(data, trafficType, response)
+}
+
+var (
+	pinsMu sync.RWMutex
+	pins   []func(TrafficRequest, int) bool
+	held   = make(map[string]int) // uuid - состояние, удержанное дольше плана
+)
+
+// AddPin регистрирует проверку, удерживает ли ручное управление, неисправность
+// или авария текущее состояние светофора дольше плана.
+func AddPin(hook func(data TrafficRequest, trafficType int) bool) {
+	pinsMu.Lock()
+	defer pinsMu.Unlock()
+	pins = append(pins, hook)
+}
+
+// Held сообщает, что текущее состояние светофора удерживается дольше плана
+// сейчас или удерживалось до последнего ответа и план его еще не сменил.
+// Тогда current_time устройства может превышать длительности плана.
+func Held(data TrafficRequest, trafficType int) bool {
+	pinsMu.RLock()
+	defer pinsMu.RUnlock()
+	if state, ok := held[data.UUID]; ok && state == data.CurrentState {
+		return true
+	}
+	for _, pin := range pins {
+		if pin(data, trafficType) {
+			return true
+		}
+	}
+	return false
+}
+
+// markHeld запоминает удержанное состояние до первой смены.
+func markHeld(uuid string, state int, keep bool) {
+	pinsMu.Lock()
+	defer pinsMu.Unlock()
+	if keep {
+		held[uuid] = state
+	} else {
+		delete(held, uuid)
+	}
+}
+
+// StateImage рисует состояние state с перечеркнутыми лампами failed.
+// Для типов без изображения возвращает пустую строку.
+func StateImage(trafficType, state int, failed []string) (string, error) {
+	var faults image_generator.LampFaults
+	for _, lamp := range failed {
+		switch lamp {
+		case LampRed:
+			faults.Lights[0] = true
+		case LampYellow:
+			faults.Lights[1] = true
+		case LampGreen:
+			faults.Lights[2] = true
+		case LampArrow:
+			faults.Arrow = true
+		}
+	}
+	if state < 1 || state > StatesCount(trafficType) {
+		return "", errors.Wrapf(ErrUnknownState, "тип:%d, состояние:%d", trafficType, state)
+	}
+
+	var image string
+	var err error
+	switch trafficType {
+	case 1:
+		image, err = image_generator.TrafficLight1ImageWithFaults(state, faults)
+	case 2:
+		image, err = image_generator.TrafficLight2ImageWithFaults(state, faults)
+	}
+	if err != nil {
+		return "", fmt.Errorf("ошибка при создании изображения: %w", err)
+	}
+	return image, nil
+}
+
 func ManageLights(data TrafficRequest, trafficType int) (json.RawMessage, error) {
-	light := trafficLights[trafficType-1]
-	nextState, err := light.GetNextState(data)
+	nextState, err := NextState(data, trafficType)
 	if err != nil {
 		return nil, err
 	}
@@ -132,3 +450,44 @@ func ManageLights(data TrafficRequest, trafficType int) (json.RawMessage, error)
 
 	return response, nil
 }
+
+// NextState вычисляет ответ светофору с учетом координации, ручного управления,
+// неисправностей и монитора конфликтов и сообщает о смене состояния. Запрос должен быть проверен ValidateRequest.
+func NextState(data TrafficRequest, trafficType int) (TrafficResponse, error) {
+	light, coordination := lightFor(data.UUID, trafficType)
+	nextState, err := light.GetNextState(data)
+	if err != nil {
+		return TrafficResponse{}, err
+	}
+	synchronized := coordination != nil && coordination.synchronize(data, &nextState)
+
+	mode := ModeNormal
+	if applyOverride(data, trafficType, &nextState) {
+		mode = ModeManual
+	}
+	if applyFaults(data, trafficType, &nextState) {
+		mode = ModeDegraded
+	}
+	if applyFailsafe(data, trafficType, &nextState) {
+		mode = ModeFailsafe
+	}
+
+	next, err := strconv.Atoi(nextState.NextState)
+	if err == nil && next == data.CurrentState && (mode != ModeNormal || synchronized) {
+		markHeld(data.UUID, next, true)
+	}
+	if err == nil && next != data.CurrentState {
+		markHeld(data.UUID, next, false)
+		notifyTransition(Transition{
+			UUID: data.UUID,
+			Type: trafficType,
+			From: data.CurrentState,
+			To:   next,
+			Time: time.Now(),
+			Plan: light.Plan(),
+			Mode: mode,
+		})
+	}
+
+	return nextState, nil
+}
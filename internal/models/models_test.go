@@ -1,7 +1,9 @@
 package models_test
 
 import (
+	"reflect"
 	"testing"
+	"time"
 
 	. "trafficlightAPI/internal/models"
 )
@@ -22,7 +24,7 @@ func TestRegularTrafficLight(t *testing.T) {
 		if tt.err != nil && err == nil {
 			t.Errorf("expected error %v, got nil", tt.err)
 		}
-		if tt.err == nil && got != tt.want {
+		if tt.err == nil && !reflect.DeepEqual(got, tt.want) {
 			t.Errorf("got %v, want %v", got, tt.want)
 		}
 	}
@@ -45,7 +47,7 @@ func TestTrafficLightWithRightArrow(t *testing.T) {
 		if tt.err != nil && err == nil {
 			t.Errorf("expected error %v, got nil", tt.err)
 		}
-		if tt.err == nil && got != tt.want {
+		if tt.err == nil && !reflect.DeepEqual(got, tt.want) {
 			t.Errorf("got %v, want %v", got, tt.want)
 		}
 	}
@@ -68,7 +70,7 @@ func TestPedestrianTrafficLight(t *testing.T) {
 		if tt.err != nil && err == nil {
 			t.Errorf("expected error %v, got nil", tt.err)
 		}
-		if tt.err == nil && got != tt.want {
+		if tt.err == nil && !reflect.DeepEqual(got, tt.want) {
 			t.Errorf("got %v, want %v", got, tt.want)
 		}
 	}
@@ -95,5 +97,103 @@ func TestManageLights(t *testing.T) {
 	}
 }
 
+func TestApplyPlan(t *testing.T) {
+	original := Plan(3)
+	defer ApplyPlan(3, original)
+
+	if err := ApplyPlan(3, []int{30, 15}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := Plan(3); got[0] != 30 || got[1] != 15 {
+		t.Errorf("got plan %v, want [30 15]", got)
+	}
+	if got := MaxDuration(3); got != 30 {
+		t.Errorf("got max duration %d, want 30", got)
+	}
+
+	invalid := [][]int{{30}, {30, 0}, {1, 2, 3}}
+	for _, plan := range invalid {
+		if err := ApplyPlan(3, plan); err == nil {
+			t.Errorf("expected error for plan %v, got nil", plan)
+		}
+	}
+	if err := ApplyPlan(4, []int{1}); err == nil {
+		t.Errorf("expected error for unknown type, got nil")
+	}
+}
+
+func TestApplyDefinitions(t *testing.T) {
+	original := Plan(3)
+	defer ApplyPlan(3, original)
+
+	tests := []struct {
+		name string
+		defs []LightDefinition
+		ok   bool
+	}{
+		{name: "one plan per type", defs: []LightDefinition{{Name: "a", Type: 3, Durations: []int{30, 15}}}, ok: true},
+		{name: "two plans for one type", defs: []LightDefinition{{Name: "a", Type: 3, Durations: []int{25, 15}}, {Name: "b", Type: 3, Durations: []int{40, 10}}}},
+		{name: "offset", defs: []LightDefinition{{Name: "a", Type: 3, Durations: []int{25, 15}, Offset: 10}}},
+		{name: "coordinated lights of one type", defs: []LightDefinition{{Name: "a", UUID: "definitions-a", Type: 1, Durations: []int{30, 3, 37}, Offset: 10}, {Name: "b", UUID: "definitions-b", Type: 1, Durations: []int{35, 3, 32}, Offset: 20}}, ok: true},
+		{name: "two plans for one uuid", defs: []LightDefinition{{Name: "a", UUID: "definitions-c", Type: 1, Durations: []int{30, 3, 37}}, {Name: "b", UUID: "definitions-c", Type: 1, Durations: []int{35, 3, 32}}}},
+		{name: "invalid plan after a valid one", defs: []LightDefinition{{Name: "a", Type: 3, Durations: []int{25, 15}}, {Name: "b", Type: 1, Durations: []int{0}}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := ApplyDefinitions(Definitions{Lights: tt.defs}); (err == nil) != tt.ok {
+				t.Fatalf("ApplyDefinitions() err = %v, want ok %v", err, tt.ok)
+			}
+			// Отклоненный файл не меняет ни одного плана.
+			if got := Plan(3); !reflect.DeepEqual(got, []int{30, 15}) {
+				t.Errorf("plan = %v, want [30 15]", got)
+			}
+		})
+	}
+}
+
+func TestCoordinatedLight(t *testing.T) {
+	defs := Definitions{Lights: []LightDefinition{{Name: "a", UUID: "coordinated", Type: 1, Durations: []int{10, 3, 7}, Offset: 5}}}
+	if err := ApplyDefinitions(defs); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer SetClock(time.Now)
+
+	// Цикл 20с, конец красного - позиция 9: (unix + 5) mod 20 == 9.
+	tests := []struct {
+		name  string
+		unix  int64
+		state int
+		time  int
+		want  string
+	}{
+		{name: "red ends on position", unix: 4, state: 1, time: 9, want: "2"},
+		{name: "red ends within window", unix: 6, state: 1, time: 9, want: "2"},
+		{name: "red held until position", unix: 10, state: 1, time: 9, want: "1"},
+		{name: "held red past plan duration", unix: 24, state: 1, time: 12, want: "2"},
+		{name: "other states follow durations", unix: 10, state: 2, time: 2, want: "3"},
+		{name: "type plan for other lights", unix: 10, state: 1, time: 19, want: "2"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			SetClock(func() time.Time { return time.Unix(tt.unix, 0) })
+			uuid := "coordinated"
+			if tt.name == "type plan for other lights" {
+				uuid = "other"
+			}
+			req := TrafficRequest{UUID: uuid, CurrentState: tt.state, CurrentTime: intPtr(tt.time)}
+			if err := ValidateRequest(req, 1); err != nil {
+				t.Fatalf("unexpected validation error: %v", err)
+			}
+			got, err := NextState(req, 1)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got.NextState != tt.want {
+				t.Errorf("got next state %s, want %s", got.NextState, tt.want)
+			}
+		})
+	}
+}
+
 // Helper function
 func intPtr(i int) *int { return &i }
@@ -0,0 +1,36 @@
+package models
+
+import "github.com/pkg/errors"
+
+var (
+	ErrNoCurrentTime  = errors.New("отсутствует поле current_time")
+	ErrNoCurrentState = errors.New("отсутствует поле current_state")
+	ErrNotValidData   = errors.New("некорректные входные данные")
+)
+
+// ValidateRequest проверяет запрос устройства. Общая для HTTP и остальных протоколов.
+func ValidateRequest(v TrafficRequest, trafficType int) error {
+	if v.CurrentTime == nil {
+		return ErrNoCurrentTime
+	}
+	if v.CurrentState == 0 {
+		return ErrNoCurrentState
+	}
+
+	light, _ := lightFor(v.UUID, trafficType)
+	plan := light.Plan()
+	maxTime := 0
+	for _, d := range plan {
+		maxTime = max(maxTime, d-1)
+	}
+	statesCount := len(plan)
+	if v.UUID == "" || v.CurrentState < 1 || v.CurrentState > statesCount || *v.CurrentTime < 0 {
+		return errors.Wrapf(ErrNotValidData, "uuid:%s, current_state:%d, current_time:%d", v.UUID, v.CurrentState, *v.CurrentTime)
+	}
+	// Удержанное состояние длится дольше плана, время устройства растет дальше.
+	if *v.CurrentTime > maxTime && !Held(v, trafficType) {
+		return errors.Wrapf(ErrNotValidData, "uuid:%s, current_state:%d, current_time:%d", v.UUID, v.CurrentState, *v.CurrentTime)
+	}
+
+	return nil
+}
@@ -0,0 +1,239 @@
+package mqttapi
+
+import (
+	"bytes"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+	"trafficlightAPI/internal/middleware/auth"
+	"trafficlightAPI/internal/middleware/prometheus"
+
+	paho "github.com/eclipse/paho.mqtt.golang"
+	mqtt "github.com/mochi-mqtt/server/v2"
+	mochiauth "github.com/mochi-mqtt/server/v2/hooks/auth"
+	"github.com/mochi-mqtt/server/v2/listeners"
+	"github.com/mochi-mqtt/server/v2/packets"
+	"github.com/pkg/errors"
+)
+
+var ErrTimeout = errors.New("брокер MQTT не ответил")
+
+// timeout - ожидание ответа внешнего брокера.
+const timeout = 10 * time.Second
+
+// Embedded - встроенный брокер. Клиенты передают API ключ в пароле CONNECT,
+// имя пользователя любое непустое (MQTT 3.1.1 не допускает пароль без
+// имени) и не проверяется. Подписка требует роль viewer, публикация
+// разрешена только в топики команд и требует роль operator.
+type Embedded struct {
+	server *mqtt.Server
+	tcp    *listeners.TCP
+	hook   *authHook // nil без аутентификации
+	qos    byte
+}
+
+// NewEmbedded создает брокер на address. Без аутентификации доступ открыт всем.
+func NewEmbedded(address, prefix string, qos byte, authn *auth.Authenticator, logger *slog.Logger) (*Embedded, error) {
+	e := &Embedded{server: mqtt.New(&mqtt.Options{InlineClient: true, Logger: logger}), qos: qos}
+	var err error
+	if authn != nil && authn.Enabled() {
+		e.hook = &authHook{authn: authn, prefix: strings.Trim(prefix, "/"), clients: make(map[string]client)}
+		err = e.server.AddHook(e.hook, nil)
+	} else {
+		err = e.server.AddHook(new(mochiauth.AllowHook), nil)
+	}
+	if err != nil {
+		return nil, err
+	}
+	e.tcp = listeners.NewTCP(listeners.Config{ID: "tcp", Address: address})
+	if err := e.server.AddListener(e.tcp); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+// Serve запускает прием соединений и не блокируется.
+func (e *Embedded) Serve() error {
+	return e.server.Serve()
+}
+
+// Address возвращает адрес, на котором слушает брокер.
+func (e *Embedded) Address() string {
+	return e.tcp.Address()
+}
+
+func (e *Embedded) Publish(topic string, payload []byte, retain bool) error {
+	return e.server.Publish(topic, payload, retain, e.qos)
+}
+
+func (e *Embedded) Subscribe(filter string, handler func(Message)) error {
+	return e.server.Subscribe(filter, 1, func(_ *mqtt.Client, _ packets.Subscription, pk packets.Packet) {
+		m := Message{Topic: pk.TopicName, Payload: pk.Payload, Retained: pk.FixedHeader.Retain}
+		if e.hook != nil {
+			m.User = e.hook.user(pk.Origin)
+		}
+		handler(m)
+	})
+}
+
+func (e *Embedded) Close() error {
+	return e.server.Close()
+}
+
+type client struct {
+	cl       *mqtt.Client
+	identity auth.Identity
+}
+
+// authHook проверяет API ключи и роли клиентов встроенного брокера.
+type authHook struct {
+	mqtt.HookBase
+	authn  *auth.Authenticator
+	prefix string
+
+	mu      sync.RWMutex
+	clients map[string]client // ID клиента
+}
+
+func (h *authHook) ID() string {
+	return "trafficlight-auth"
+}
+
+func (h *authHook) Provides(b byte) bool {
+	return bytes.Contains([]byte{mqtt.OnConnectAuthenticate, mqtt.OnACLCheck, mqtt.OnDisconnect}, []byte{b})
+}
+
+func (h *authHook) OnConnectAuthenticate(cl *mqtt.Client, pk packets.Packet) bool {
+	identity, err := h.authn.Credentials(string(pk.Connect.Password), "")
+	if err != nil {
+		h.Log.Warn("отказ в подключении MQTT", slog.String("client", cl.ID), slog.String("remote", cl.Net.Remote), slog.Any("err", err))
+		prometheus.MQTTConnections.WithLabelValues("denied").Inc()
+		return false
+	}
+	if identity.Role < auth.RoleViewer {
+		prometheus.MQTTConnections.WithLabelValues("denied").Inc()
+		return false
+	}
+	h.mu.Lock()
+	h.clients[cl.ID] = client{cl: cl, identity: identity}
+	h.mu.Unlock()
+	prometheus.MQTTConnections.WithLabelValues("ok").Inc()
+	return true
+}
+
+func (h *authHook) OnACLCheck(cl *mqtt.Client, topic string, write bool) bool {
+	h.mu.RLock()
+	c, ok := h.clients[cl.ID]
+	h.mu.RUnlock()
+	if !ok || c.cl != cl {
+		return false
+	}
+	if !write {
+		return c.identity.Role >= auth.RoleViewer
+	}
+	_, command := commandUUID(h.prefix, topic)
+	return c.identity.Role >= auth.RoleOperator && command
+}
+
+func (h *authHook) OnDisconnect(cl *mqtt.Client, _ error, _ bool) {
+	h.mu.Lock()
+	// При повторном подключении с тем же ID старый клиент отключается после
+	// аутентификации нового, его запись удалять нельзя.
+	if c, ok := h.clients[cl.ID]; ok && c.cl == cl {
+		delete(h.clients, cl.ID)
+	}
+	h.mu.Unlock()
+}
+
+func (h *authHook) user(clientID string) string {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return h.clients[clientID].identity.Name
+}
+
+// External - подключение к внешнему брокеру. Пользователь команд во внешнем
+// брокере неизвестен, в ручном управлении записывается User.
+type External struct {
+	client paho.Client
+	qos    byte
+	group  string // Группа общей подписки; пусто - обычная подписка
+
+	mu   sync.Mutex
+	subs map[string]func(Message) // Восстанавливаются при переподключении
+}
+
+// NewExternal подключается к broker вида tcp://host:1883 и переподключается
+// при потере соединения.
+func NewExternal(broker, clientID, username, password, group string, qos byte, logger *slog.Logger) (*External, error) {
+	e := &External{qos: qos, group: group, subs: make(map[string]func(Message))}
+	opts := paho.NewClientOptions().
+		AddBroker(broker).
+		SetClientID(clientID).
+		SetUsername(username).
+		SetPassword(password).
+		SetAutoReconnect(true).
+		SetConnectRetry(true).
+		SetOnConnectHandler(func(c paho.Client) {
+			logger.Info("подключение к брокеру MQTT", slog.String("broker", broker))
+			prometheus.MQTTConnections.WithLabelValues("ok").Inc()
+			e.mu.Lock()
+			defer e.mu.Unlock()
+			for filter, handler := range e.subs {
+				c.Subscribe(e.shared(filter), e.qos, e.callback(handler))
+			}
+		}).
+		SetConnectionLostHandler(func(_ paho.Client, err error) {
+			logger.Warn("потеряно соединение с брокером MQTT", slog.String("broker", broker), slog.Any("err", err))
+			prometheus.MQTTConnections.WithLabelValues("lost").Inc()
+		})
+	e.client = paho.NewClient(opts)
+	// С SetConnectRetry токен завершается только после подключения, поэтому
+	// недоступный брокер не мешает запуску, подключение продолжится в фоне.
+	if token := e.client.Connect(); token.WaitTimeout(timeout) && token.Error() != nil {
+		return nil, token.Error()
+	}
+	return e, nil
+}
+
+func (e *External) Publish(topic string, payload []byte, retain bool) error {
+	return wait(e.client.Publish(topic, e.qos, retain, payload))
+}
+
+func (e *External) Subscribe(filter string, handler func(Message)) error {
+	e.mu.Lock()
+	e.subs[filter] = handler
+	e.mu.Unlock()
+	if !e.client.IsConnectionOpen() {
+		return nil
+	}
+	return wait(e.client.Subscribe(e.shared(filter), e.qos, e.callback(handler)))
+}
+
+// shared возвращает фильтр общей подписки $share/{group}/{filter}: брокер
+// доставляет сообщение одному клиенту группы, поэтому команду выполняет
+// одна реплика, а не каждая.
+func (e *External) shared(filter string) string {
+	if e.group == "" {
+		return filter
+	}
+	return "$share/" + e.group + "/" + filter
+}
+
+func (e *External) Close() error {
+	e.client.Disconnect(uint(timeout / time.Millisecond))
+	return nil
+}
+
+func (e *External) callback(handler func(Message)) paho.MessageHandler {
+	return func(_ paho.Client, m paho.Message) {
+		handler(Message{Topic: m.Topic(), Payload: m.Payload(), Retained: m.Retained()})
+	}
+}
+
+func wait(token paho.Token) error {
+	if !token.WaitTimeout(timeout) {
+		return ErrTimeout
+	}
+	return token.Error()
+}
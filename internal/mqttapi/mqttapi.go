@@ -0,0 +1,320 @@
+// Package mqttapi публикует состояния светофоров в MQTT и принимает команды
+// ручного управления. Брокер встроенный (mochi-mqtt) или внешний (paho).
+package mqttapi
+
+import (
+	"encoding/json"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+	"trafficlightAPI/internal/lights"
+	"trafficlightAPI/internal/middleware/auth"
+	"trafficlightAPI/internal/middleware/prometheus"
+	"trafficlightAPI/internal/middleware/signing"
+	"trafficlightAPI/internal/overrides"
+	"trafficlightAPI/internal/storage"
+	"trafficlightAPI/internal/stream"
+
+	"github.com/bytedance/sonic"
+	"github.com/pkg/errors"
+)
+
+var (
+	ErrStreamDisabled    = errors.New("поток состояний не настроен")
+	ErrOverridesDisabled = errors.New("ручное управление не настроено")
+	ErrInvalidCommand    = errors.New("некорректная команда MQTT")
+	ErrInvalidExpiry     = errors.New("некорректный срок ручного управления")
+)
+
+// User - имя пользователя в ручном управлении и аудите при выключенной аутентификации.
+const User = "mqtt"
+
+// ActionRelease снимает действующее ручное управление светофором.
+const ActionRelease = "release"
+
+// Окончания топиков светофора {prefix}/{uuid}/...
+const (
+	TopicState         = "state"
+	TopicCommand       = "command"
+	TopicCommandResult = "command/result"
+)
+
+// Message - сообщение из подписки.
+type Message struct {
+	Topic    string
+	Payload  []byte
+	Retained bool
+	User     string // Имя аутентифицированного клиента; пусто - неизвестно
+}
+
+// Broker - соединение с брокером MQTT.
+type Broker interface {
+	Publish(topic string, payload []byte, retain bool) error
+	Subscribe(filter string, handler func(Message)) error
+	Close() error
+}
+
+// Command - команда в {prefix}/{uuid}/command, поля как в POST /overrides.
+type Command struct {
+	ID        string `json:"id,omitempty"`    // Возвращается в ответе
+	Action    string `json:"action"`          // force, hold, advance, release
+	State     int    `json:"state,omitempty"` // Для force
+	Reason    string `json:"reason"`
+	ExpiresIn int    `json:"expires_in,omitempty"` // с, по умолчанию overrides.default_duration
+}
+
+// SignedCommand - команда из внешнего брокера при включенной аутентификации.
+// Подпись - hex HMAC-SHA256 секретом клиента от строки signing.StringToSign
+// с методом PUBLISH, путем - топиком команды, пустым query и Command вместо тела,
+// поэтому секрет не передается через брокер, а подписанную команду нельзя
+// повторить или отправить другому светофору.
+type SignedCommand struct {
+	Client    string          `json:"client"`
+	Timestamp string          `json:"timestamp"` // Unix время в секундах
+	Nonce     string          `json:"nonce"`
+	Signature string          `json:"signature"`
+	Command   json.RawMessage `json:"command"`
+}
+
+// SignMethod - метод в строке для подписи команды.
+const SignMethod = "PUBLISH"
+
+// Result - ответ в {prefix}/{uuid}/command/result.
+type Result struct {
+	ID       string            `json:"id,omitempty"`
+	Override *storage.Override `json:"override,omitempty"`
+	Error    string            `json:"error,omitempty"`
+}
+
+type Config struct {
+	Prefix          string
+	Commands        bool // Подписаться на команды
+	DefaultDuration time.Duration
+	MaxDuration     time.Duration
+}
+
+// Bridge переносит события потока состояний в retained сообщения
+// {prefix}/{uuid}/state и выполняет команды из {prefix}/{uuid}/command.
+type Bridge struct {
+	cfg      Config
+	broker   Broker
+	authn    *auth.Authenticator
+	verifier *signing.Verifier // Секреты клиентов внешнего брокера
+	logger   *slog.Logger
+
+	mu     sync.Mutex
+	sub    *stream.Subscription
+	closed bool
+}
+
+func New(cfg Config, broker Broker, authn *auth.Authenticator, verifier *signing.Verifier, logger *slog.Logger) *Bridge {
+	cfg.Prefix = strings.Trim(cfg.Prefix, "/")
+	return &Bridge{cfg: cfg, broker: broker, authn: authn, verifier: verifier, logger: logger}
+}
+
+func (b *Bridge) topic(uuid, suffix string) string {
+	return b.cfg.Prefix + "/" + uuid + "/" + suffix
+}
+
+// Serve публикует состояния до Close. Если мост не успевает публиковать и
+// хаб закрывает подписку, он подписывается снова и повторяет снимок.
+func (b *Bridge) Serve() error {
+	hub := stream.Default()
+	if hub == nil {
+		return ErrStreamDisabled
+	}
+	if b.cfg.Commands {
+		if err := b.broker.Subscribe(b.topic("+", TopicCommand), b.command); err != nil {
+			return errors.Wrap(err, "подписка на команды")
+		}
+	}
+
+	for {
+		b.mu.Lock()
+		if b.closed {
+			b.mu.Unlock()
+			return nil
+		}
+		sub := hub.Subscribe("mqtt", "", User, stream.Filter{All: true})
+		b.sub = sub
+		b.mu.Unlock()
+
+		for _, e := range hub.Snapshot(sub.Filter()) {
+			b.publish(e)
+		}
+		for open := true; open; {
+			select {
+			case <-sub.Done():
+				open = false
+			case e := <-sub.Events():
+				b.publish(e)
+			}
+		}
+		if sub.Reason() == stream.ReasonSlowConsumer {
+			b.logger.Warn("MQTT не успевает публиковать состояния, повторная подписка")
+		}
+	}
+}
+
+// Close останавливает публикацию. Брокер закрывает вызывающий.
+func (b *Bridge) Close() {
+	b.mu.Lock()
+	b.closed = true
+	sub := b.sub
+	b.mu.Unlock()
+	if hub := stream.Default(); hub != nil && sub != nil {
+		hub.Unsubscribe(sub, stream.ReasonShutdown)
+	}
+}
+
+func (b *Bridge) publish(e stream.Event) {
+	payload, err := sonic.Marshal(e)
+	if err == nil {
+		err = b.broker.Publish(b.topic(e.UUID, TopicState), payload, true)
+	}
+	if err != nil {
+		prometheus.MQTTPublished.WithLabelValues("error").Inc()
+		b.logger.Error("ошибка публикации состояния в MQTT", slog.String("uuid", e.UUID), slog.Any("err", err))
+		return
+	}
+	prometheus.MQTTPublished.WithLabelValues("ok").Inc()
+}
+
+// command выполняет команду и публикует результат. Retained команды
+// игнорируются, чтобы старая команда не выполнилась при переподключении.
+func (b *Bridge) command(m Message) {
+	if m.Retained {
+		b.logger.Warn("retained команда MQTT проигнорирована", slog.String("topic", m.Topic))
+		prometheus.MQTTCommands.WithLabelValues("", "retained").Inc()
+		return
+	}
+	uuid, ok := commandUUID(b.cfg.Prefix, m.Topic)
+	if !ok {
+		return
+	}
+
+	var c Command
+	var result Result
+	// id возвращается и в отказе, если тело команды удалось разобрать.
+	user, payload, err := b.authorize(m)
+	if payload != nil {
+		if parseErr := sonic.Unmarshal(payload, &c); parseErr != nil && err == nil {
+			err = errors.Wrap(ErrInvalidCommand, parseErr.Error())
+		}
+		result.ID = c.ID
+	}
+	if err == nil {
+		result.Override, err = b.execute(uuid, c, user)
+	}
+
+	status := "ok"
+	switch {
+	case errors.Is(err, auth.ErrUnauthenticated), errors.Is(err, signing.ErrSignature), errors.Is(err, signing.ErrBusy):
+		status = "denied"
+		b.logger.Warn("команда MQTT отклонена", slog.String("uuid", uuid), slog.Any("err", err))
+	case errors.Is(err, ErrInvalidCommand), errors.Is(err, ErrInvalidExpiry), errors.Is(err, overrides.ErrInvalidOverride), errors.Is(err, overrides.ErrOverrideNotFound):
+		status = "invalid"
+	case err != nil:
+		status = "error"
+		b.logger.Error("ошибка команды MQTT", slog.String("uuid", uuid), slog.Any("err", err))
+	}
+	prometheus.MQTTCommands.WithLabelValues(c.Action, status).Inc()
+	if err != nil {
+		result.Error = err.Error()
+	} else {
+		b.logger.Info("ручное управление по MQTT", slog.String("uuid", uuid), slog.String("action", c.Action), slog.String("user", user))
+	}
+
+	payload, err = sonic.Marshal(result)
+	if err == nil {
+		err = b.broker.Publish(b.topic(uuid, TopicCommandResult), payload, false)
+	}
+	if err != nil {
+		b.logger.Error("ошибка публикации результата команды MQTT", slog.String("uuid", uuid), slog.Any("err", err))
+	}
+}
+
+// authorize возвращает пользователя и тело команды. Встроенный брокер сообщает
+// клиента, проверенного при подключении. Внешний брокер не сообщает, кто
+// опубликовал команду, поэтому при включенной аутентификации команда должна
+// быть подписана секретом клиента (SignedCommand).
+func (b *Bridge) authorize(m Message) (string, []byte, error) {
+	if m.User != "" {
+		return m.User, m.Payload, nil
+	}
+	if b.authn == nil || !b.authn.Enabled() {
+		return User, m.Payload, nil
+	}
+	if b.verifier == nil || !b.verifier.Enabled() {
+		return "", nil, errors.Wrap(auth.ErrUnauthenticated, "секреты клиентов MQTT не настроены")
+	}
+
+	var signed SignedCommand
+	if err := sonic.Unmarshal(m.Payload, &signed); err != nil {
+		return "", nil, errors.Wrap(ErrInvalidCommand, err.Error())
+	}
+	stringToSign := signing.StringToSign(SignMethod, m.Topic, "", signed.Timestamp, signed.Nonce, signed.Command)
+	if err := b.verifier.Check(signed.Client, signed.Timestamp, signed.Nonce, signed.Signature, stringToSign); err != nil {
+		return "", signed.Command, err
+	}
+	return signed.Client, signed.Command, nil
+}
+
+// commandUUID возвращает uuid из топика команд {prefix}/{uuid}/command.
+func commandUUID(prefix, topic string) (string, bool) {
+	uuid, ok := strings.CutPrefix(topic, prefix+"/")
+	if ok {
+		uuid, ok = strings.CutSuffix(uuid, "/"+TopicCommand)
+	}
+	return uuid, ok && uuid != "" && !strings.ContainsAny(uuid, "/+#")
+}
+
+func (b *Bridge) execute(uuid string, c Command, user string) (*storage.Override, error) {
+	manager := overrides.Default()
+	if manager == nil {
+		return nil, ErrOverridesDisabled
+	}
+
+	if c.Action == ActionRelease {
+		for _, o := range manager.Active(time.Now()) {
+			if o.UUID == uuid {
+				released, err := manager.Release(o.ID, c.Reason, user)
+				if err != nil {
+					return nil, err
+				}
+				return &released, nil
+			}
+		}
+		return nil, errors.Wrapf(overrides.ErrOverrideNotFound, "uuid:%s", uuid)
+	}
+
+	duration := b.cfg.DefaultDuration
+	if c.ExpiresIn != 0 {
+		duration = time.Duration(c.ExpiresIn) * time.Second
+	}
+	if duration <= 0 || duration > b.cfg.MaxDuration {
+		return nil, errors.Wrapf(ErrInvalidExpiry, "expires_in:%d, максимум %d", c.ExpiresIn, int(b.cfg.MaxDuration.Seconds()))
+	}
+
+	trafficType := 0
+	if registry := lights.Default(); registry != nil {
+		if entry, ok := registry.Get(uuid); ok {
+			trafficType = entry.Light.Type
+		}
+	}
+	now := time.Now()
+	o, err := manager.Create(storage.Override{
+		UUID:      uuid,
+		Action:    c.Action,
+		State:     c.State,
+		Reason:    c.Reason,
+		User:      user,
+		CreatedAt: now,
+		ExpiresAt: now.Add(duration),
+	}, trafficType)
+	if err != nil {
+		return nil, err
+	}
+	return &o, nil
+}
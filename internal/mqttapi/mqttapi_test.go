@@ -0,0 +1,407 @@
+package mqttapi_test
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"log/slog"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+	"trafficlightAPI/internal/audit"
+	"trafficlightAPI/internal/lights"
+	"trafficlightAPI/internal/middleware/auth"
+	"trafficlightAPI/internal/middleware/signing"
+	"trafficlightAPI/internal/models"
+	"trafficlightAPI/internal/mqttapi"
+	"trafficlightAPI/internal/overrides"
+	"trafficlightAPI/internal/storage"
+	"trafficlightAPI/internal/stream"
+
+	paho "github.com/eclipse/paho.mqtt.golang"
+)
+
+var keys = map[string]string{"viewer-key": "viewer", "operator-key": "operator"}
+
+// clientSecret - секрет клиента scada, подписывающего команды во внешнем брокере.
+const clientSecret = "scada-secret-0123456789"
+
+// setup регистрирует светофоры "a" (тип 1, зеленый) и "b" (тип 3, стоять)
+// и запускает встроенный брокер с ключами keys. Мост запускает serve.
+func setup(t *testing.T) (*mqttapi.Embedded, *overrides.Manager, *stream.Hub) {
+	t.Helper()
+	dir := t.TempDir()
+	store, err := storage.OpenBolt(filepath.Join(dir, "state.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { store.Close() })
+	auditLog, err := audit.Open(filepath.Join(dir, "audit.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { auditLog.Close() })
+
+	registry := lights.NewRegistry(store, slog.Default())
+	registry.Observe(models.Transition{UUID: "a", Type: 1, To: 3, Time: time.Now(), Mode: models.ModeNormal})
+	registry.Observe(models.Transition{UUID: "b", Type: 3, To: 1, Time: time.Now(), Mode: models.ModeNormal})
+	lights.SetDefault(registry)
+	manager := overrides.NewManager(store, auditLog, slog.Default())
+	overrides.SetDefault(manager)
+	hub := stream.NewHub(stream.Config{Buffer: 16, MaxDrops: 16}, nil)
+	stream.SetDefault(hub)
+	t.Cleanup(func() {
+		lights.SetDefault(nil)
+		overrides.SetDefault(nil)
+		stream.SetDefault(nil)
+	})
+
+	broker, err := mqttapi.NewEmbedded("127.0.0.1:0", "lights", 1, authenticator(t), slog.Default())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := broker.Serve(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { broker.Close() })
+	return broker, manager, hub
+}
+
+// authenticator проверяет ключи keys.
+func authenticator(t *testing.T) *auth.Authenticator {
+	t.Helper()
+	var apiKeys []auth.APIKey
+	for key, role := range keys {
+		hash := sha256.Sum256([]byte(key))
+		apiKeys = append(apiKeys, auth.APIKey{Name: role, SHA256: hex.EncodeToString(hash[:]), Role: role})
+	}
+	authn, err := auth.New(auth.Config{Enabled: true, APIKeys: apiKeys}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return authn
+}
+
+func serve(t *testing.T, broker mqttapi.Broker) {
+	t.Helper()
+	verifier := signing.New(signing.Config{Enabled: true, Secrets: map[string][]byte{"scada": []byte(clientSecret)}}, nil)
+	bridge := mqttapi.New(mqttapi.Config{Prefix: "lights", Commands: true, DefaultDuration: time.Minute, MaxDuration: time.Hour}, broker, authenticator(t), verifier, slog.Default())
+	go bridge.Serve()
+	t.Cleanup(bridge.Close)
+}
+
+func connect(t *testing.T, broker *mqttapi.Embedded, id, key string) (paho.Client, error) {
+	t.Helper()
+	client := paho.NewClient(paho.NewClientOptions().
+		AddBroker("tcp://" + broker.Address()).
+		SetClientID(id).
+		SetUsername(id).
+		SetPassword(key))
+	token := client.Connect()
+	if !token.WaitTimeout(2 * time.Second) {
+		t.Fatal("connect timeout")
+	}
+	if token.Error() != nil {
+		return nil, token.Error()
+	}
+	t.Cleanup(func() { client.Disconnect(0) })
+	return client, nil
+}
+
+// subscribe возвращает сообщения топика filter.
+func subscribe(t *testing.T, client paho.Client, filter string) <-chan paho.Message {
+	t.Helper()
+	messages := make(chan paho.Message, 16)
+	token := client.Subscribe(filter, 1, func(_ paho.Client, m paho.Message) { messages <- m })
+	if !token.WaitTimeout(2*time.Second) || token.Error() != nil {
+		t.Fatalf("subscribe %s: %v", filter, token.Error())
+	}
+	return messages
+}
+
+func publish(t *testing.T, client paho.Client, topic string, retained bool, payload any) {
+	t.Helper()
+	data, _ := json.Marshal(payload)
+	if token := client.Publish(topic, 1, retained, data); !token.WaitTimeout(2*time.Second) || token.Error() != nil {
+		t.Fatalf("publish %s: %v", topic, token.Error())
+	}
+}
+
+func receive(t *testing.T, messages <-chan paho.Message, v any) paho.Message {
+	t.Helper()
+	select {
+	case m := <-messages:
+		if err := json.Unmarshal(m.Payload(), v); err != nil {
+			t.Fatal(err)
+		}
+		return m
+	case <-time.After(2 * time.Second):
+		t.Fatal("no message")
+		return nil
+	}
+}
+
+func TestConnect(t *testing.T) {
+	broker, _, _ := setup(t)
+	if _, err := connect(t, broker, "unknown", "wrong-key"); err == nil {
+		t.Error("connect with unknown key succeeded")
+	}
+	if _, err := connect(t, broker, "viewer", "viewer-key"); err != nil {
+		t.Errorf("connect with viewer key: %v", err)
+	}
+}
+
+func TestState(t *testing.T) {
+	broker, _, hub := setup(t)
+	serve(t, broker)
+	client, err := connect(t, broker, "viewer", "viewer-key")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Снимок публикуется асинхронно, ждем обновления, пока retained не появится.
+	var states <-chan paho.Message
+	var e stream.Event
+	for deadline := time.Now().Add(2 * time.Second); ; {
+		states = subscribe(t, client, "lights/a/state")
+		select {
+		case m := <-states:
+			if err := json.Unmarshal(m.Payload(), &e); err != nil {
+				t.Fatal(err)
+			}
+			if !m.Retained() || e.UUID != "a" || e.State != 3 {
+				t.Errorf("retained state = %+v (retained %v), want a green", e, m.Retained())
+			}
+		case <-time.After(100 * time.Millisecond):
+			if time.Now().Before(deadline) {
+				client.Unsubscribe("lights/a/state").Wait()
+				continue
+			}
+			t.Fatal("no retained state")
+		}
+		break
+	}
+
+	hub.Publish(models.Transition{UUID: "a", Type: 1, To: 1, Time: time.Now(), Mode: models.ModeNormal})
+	if m := receive(t, states, &e); m.Topic() != "lights/a/state" || e.State != 1 {
+		t.Errorf("state after transition = %+v, want red", e)
+	}
+}
+
+func TestCommand(t *testing.T) {
+	broker, manager, _ := setup(t)
+	operator, err := connect(t, broker, "operator", "operator-key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Retained команда, оставленная до запуска, не должна выполниться.
+	publish(t, operator, "lights/b/command", true, mqttapi.Command{Action: overrides.ActionHold, Reason: "старая"})
+	serve(t, broker)
+	viewer, err := connect(t, broker, "viewer", "viewer-key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	results := subscribe(t, operator, "lights/a/command/result")
+
+	tests := []struct {
+		name    string
+		client  paho.Client
+		command mqttapi.Command
+		action  string // Ожидаемое ручное управление; пусто - нет
+		error   bool
+	}{
+		{
+			name:    "viewer cannot publish commands",
+			client:  viewer,
+			command: mqttapi.Command{ID: "1", Action: overrides.ActionHold, Reason: "проверка"},
+		},
+		{
+			name:    "expiry above maximum",
+			client:  operator,
+			command: mqttapi.Command{ID: "2", Action: overrides.ActionHold, Reason: "проверка", ExpiresIn: 7200},
+			error:   true,
+		},
+		{
+			name:    "operator holds",
+			client:  operator,
+			command: mqttapi.Command{ID: "3", Action: overrides.ActionHold, Reason: "проверка"},
+			action:  overrides.ActionHold,
+		},
+		{
+			name:    "operator releases",
+			client:  operator,
+			command: mqttapi.Command{ID: "4", Action: mqttapi.ActionRelease, Reason: "готово"},
+		},
+		{
+			name:    "release without override",
+			client:  operator,
+			command: mqttapi.Command{ID: "5", Action: mqttapi.ActionRelease, Reason: "готово"},
+			error:   true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			publish(t, tt.client, "lights/a/command", false, tt.command)
+			if tt.client == viewer {
+				select {
+				case m := <-results:
+					t.Fatalf("denied command got result %s", m.Payload())
+				case <-time.After(200 * time.Millisecond):
+				}
+			} else {
+				var result mqttapi.Result
+				receive(t, results, &result)
+				if result.ID != tt.command.ID || (result.Error != "") != tt.error {
+					t.Fatalf("result = %+v", result)
+				}
+			}
+
+			active := manager.Active(time.Now())
+			if tt.action == "" {
+				if len(active) != 0 {
+					t.Errorf("active = %+v, want none", active)
+				}
+				return
+			}
+			if len(active) != 1 || active[0].UUID != "a" || active[0].Action != tt.action || active[0].User != "operator" {
+				t.Errorf("active = %+v, want %s by operator", active, tt.action)
+			}
+		})
+	}
+}
+
+// externalBroker - внешний брокер, который не сообщает клиента команды.
+type externalBroker struct {
+	handlers chan func(mqttapi.Message)
+	results  chan []byte
+}
+
+func (e *externalBroker) Publish(topic string, payload []byte, _ bool) error {
+	if strings.HasSuffix(topic, "/"+mqttapi.TopicCommandResult) {
+		e.results <- payload
+	}
+	return nil
+}
+
+func (e *externalBroker) Subscribe(_ string, handler func(mqttapi.Message)) error {
+	e.handlers <- handler
+	return nil
+}
+
+func (e *externalBroker) Close() error {
+	return nil
+}
+
+// sign подписывает команду для топика topic секретом secret.
+func sign(t *testing.T, topic, secret, nonce string, c mqttapi.Command) mqttapi.SignedCommand {
+	t.Helper()
+	command, _ := json.Marshal(c)
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	return mqttapi.SignedCommand{
+		Client:    "scada",
+		Timestamp: timestamp,
+		Nonce:     nonce,
+		Signature: signing.Sign([]byte(secret), signing.StringToSign(mqttapi.SignMethod, topic, "", timestamp, nonce, command)),
+		Command:   command,
+	}
+}
+
+func TestExternalCommand(t *testing.T) {
+	_, manager, _ := setup(t)
+	broker := &externalBroker{handlers: make(chan func(mqttapi.Message), 1), results: make(chan []byte, 16)}
+	serve(t, broker)
+	handler := <-broker.handlers
+
+	hold := func(id string) mqttapi.Command {
+		return mqttapi.Command{ID: id, Action: overrides.ActionHold, Reason: "проверка"}
+	}
+	valid := sign(t, "lights/a/command", clientSecret, "n4", hold("4"))
+	tests := []struct {
+		name    string
+		payload any
+		id      string
+		action  string // Ожидаемое ручное управление; пусто - нет
+	}{
+		{name: "unsigned", payload: hold("1")},
+		{name: "wrong secret", payload: sign(t, "lights/a/command", "wrong-secret-0123456789", "n2", hold("2")), id: "2"},
+		{name: "signed for another light", payload: sign(t, "lights/b/command", clientSecret, "n3", hold("3")), id: "3"},
+		{name: "signed", payload: valid, id: "4", action: overrides.ActionHold},
+		{name: "replay", payload: valid, id: "4", action: overrides.ActionHold},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			payload, _ := json.Marshal(tt.payload)
+			handler(mqttapi.Message{Topic: "lights/a/command", Payload: payload})
+
+			var result mqttapi.Result
+			select {
+			case data := <-broker.results:
+				if err := json.Unmarshal(data, &result); err != nil {
+					t.Fatal(err)
+				}
+			case <-time.After(2 * time.Second):
+				t.Fatal("no result")
+			}
+			if result.ID != tt.id || (result.Error == "") != (tt.name == "signed") {
+				t.Fatalf("result = %+v", result)
+			}
+			if strings.Contains(string(payload), clientSecret) {
+				t.Fatal("secret sent through the broker")
+			}
+
+			active := manager.Active(time.Now())
+			if tt.action == "" {
+				if len(active) != 0 {
+					t.Errorf("active = %+v, want none", active)
+				}
+				return
+			}
+			if len(active) != 1 || active[0].Action != tt.action || active[0].User != "scada" {
+				t.Errorf("active = %+v, want %s by scada", active, tt.action)
+			}
+		})
+	}
+}
+
+func TestExternalSharedSubscription(t *testing.T) {
+	broker, err := mqttapi.NewEmbedded("127.0.0.1:0", "lights", 1, nil, slog.Default())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := broker.Serve(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { broker.Close() })
+
+	// Две реплики в одной группе: каждая команда доставляется одной из них.
+	received := make(chan string, 16)
+	for _, id := range []string{"node1", "node2"} {
+		external, err := mqttapi.NewExternal("tcp://"+broker.Address(), id, "", "", "trafficlight", 1, slog.Default())
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { external.Close() })
+		if err := external.Subscribe("lights/+/command", func(m mqttapi.Message) { received <- m.Topic }); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := broker.Publish("lights/a/command", []byte(`{}`), false); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case topic := <-received:
+		if topic != "lights/a/command" {
+			t.Errorf("topic = %s, want lights/a/command", topic)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("no command")
+	}
+	select {
+	case topic := <-received:
+		t.Errorf("command %s delivered twice", topic)
+	case <-time.After(200 * time.Millisecond):
+	}
+}
@@ -0,0 +1,446 @@
+// Package ntcip - агент SNMP v2c/v3 с частью объектов NTCIP 1202: таблицы фаз,
+// группы состояния и управления фазами, мигание и аварии контроллера.
+package ntcip
+
+import (
+	"log/slog"
+	"net"
+	"slices"
+	"sync"
+	"time"
+	"trafficlightAPI/internal/middleware/auth"
+	"trafficlightAPI/internal/middleware/prometheus"
+	"trafficlightAPI/internal/models"
+	"trafficlightAPI/internal/overrides"
+
+	"github.com/gosnmp/gosnmp"
+	"github.com/pkg/errors"
+)
+
+var (
+	ErrInvalidConfig     = errors.New("некорректная настройка SNMP")
+	ErrOverridesDisabled = errors.New("ручное управление не настроено")
+	ErrV2cDisabled       = errors.New("SNMP v2c не принимается при включенной аутентификации")
+)
+
+// DefaultUser - имя пользователя v2c в логах.
+const DefaultUser = "snmp"
+
+const (
+	maxMessage   = 65507
+	maxBulkBinds = 256 // Переменных в ответе GetBulk
+)
+
+type Config struct {
+	Description     string   // sysDescr
+	Phases          []string // Светофоры фаз 1..N; пусто - все светофоры в порядке UUID
+	EngineID        []byte   // Пусто - DefaultEngineID
+	Boots           uint32   // snmpEngineBoots, см. NextBoots
+	Users           []User
+	CommandDuration time.Duration
+}
+
+// Agent отвечает на запросы SNMP. v2c только читает и принимается лишь при выключенной
+// аутентификации: community передается открыто. v3: пользователь USM сопоставляется
+// API ключу с тем же именем. Роли для записи те же, что в HTTP API.
+type Agent struct {
+	cfg      Config
+	authn    *auth.Authenticator
+	logger   *slog.Logger
+	engineID string
+	users    map[string]usmUser
+	started  time.Time
+	reports  map[string]uint32 // Счетчики отчетов USM по OID
+
+	mu     sync.Mutex
+	conn   net.PacketConn
+	closed bool
+}
+
+func New(cfg Config, authn *auth.Authenticator, logger *slog.Logger) (*Agent, error) {
+	if len(cfg.EngineID) == 0 {
+		cfg.EngineID = DefaultEngineID()
+	}
+	if len(cfg.EngineID) < 5 || len(cfg.EngineID) > 32 {
+		return nil, errors.Wrapf(ErrInvalidConfig, "engine_id: %d байт, допустимо 5..32", len(cfg.EngineID))
+	}
+	if cfg.CommandDuration <= 0 {
+		return nil, errors.Wrapf(ErrInvalidConfig, "срок команды %s", cfg.CommandDuration)
+	}
+
+	a := &Agent{
+		cfg:      cfg,
+		authn:    authn,
+		logger:   logger,
+		engineID: string(cfg.EngineID),
+		users:    make(map[string]usmUser, len(cfg.Users)),
+		started:  time.Now(),
+		reports:  make(map[string]uint32),
+	}
+	for _, u := range cfg.Users {
+		if _, ok := a.users[u.Name]; ok {
+			return nil, errors.Wrapf(ErrInvalidUser, "%q указан дважды", u.Name)
+		}
+		user, err := newUSMUser(u, a.engineID)
+		if err != nil {
+			return nil, err
+		}
+		a.users[u.Name] = user
+	}
+	return a, nil
+}
+
+// Serve обрабатывает запросы из conn до Close.
+func (a *Agent) Serve(conn net.PacketConn) error {
+	a.mu.Lock()
+	if a.closed {
+		a.mu.Unlock()
+		conn.Close()
+		return nil
+	}
+	a.conn = conn
+	a.mu.Unlock()
+
+	buf := make([]byte, maxMessage)
+	for {
+		n, addr, err := conn.ReadFrom(buf)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+		if response := a.handle(slices.Clone(buf[:n]), addr.String()); response != nil {
+			if _, err := conn.WriteTo(response, addr); err != nil {
+				a.logger.Debug("ошибка отправки ответа SNMP", slog.String("remote", addr.String()), slog.Any("err", err))
+			}
+		}
+	}
+}
+
+func (a *Agent) Close() error {
+	a.mu.Lock()
+	a.closed = true
+	conn := a.conn
+	a.mu.Unlock()
+	if conn != nil {
+		return conn.Close()
+	}
+	return nil
+}
+
+// engineTime - snmpEngineTime, секунды с запуска агента.
+func (a *Agent) engineTime() uint32 {
+	return uint32(time.Since(a.started) / time.Second)
+}
+
+// handle возвращает ответ на сообщение msg или nil, если отвечать не нужно.
+func (a *Agent) handle(msg []byte, remote string) []byte {
+	version, err := messageVersion(msg)
+	if err != nil {
+		a.logger.Debug("некорректное сообщение SNMP", slog.String("remote", remote), slog.Any("err", err))
+		return nil
+	}
+	var response []byte
+	switch gosnmp.SnmpVersion(version) {
+	case gosnmp.Version2c:
+		response, err = a.handleV2c(msg, remote)
+	case gosnmp.Version3:
+		response, err = a.handleV3(msg, remote)
+	default:
+		err = errors.Wrapf(ErrInvalidMessage, "версия %d", version)
+	}
+	if err != nil {
+		a.logger.Debug("сообщение SNMP отброшено", slog.String("remote", remote), slog.Any("err", err))
+		return nil
+	}
+	return response
+}
+
+func (a *Agent) handleV2c(msg []byte, remote string) ([]byte, error) {
+	request, err := (&gosnmp.GoSNMP{Version: gosnmp.Version2c}).SnmpDecodePacket(msg)
+	if err != nil {
+		return nil, errors.Wrap(ErrInvalidMessage, err.Error())
+	}
+	if a.authn != nil && a.authn.Enabled() {
+		// Отбрасывается без ответа, как запрос с неверной community в RFC 3584.
+		prometheus.SNMPRequests.WithLabelValues(request.PDUType.String(), gosnmp.AuthorizationError.String()).Inc()
+		return nil, ErrV2cDisabled
+	}
+	var response *gosnmp.SnmpPacket
+	if request.PDUType == gosnmp.SetRequest {
+		// Запись только через v3 с проверкой пользователя.
+		response = &gosnmp.SnmpPacket{PDUType: gosnmp.GetResponse, RequestID: request.RequestID, Error: gosnmp.NoAccess, ErrorIndex: 1, Variables: request.Variables}
+		a.logger.Warn("запись SNMP v2c отклонена", slog.String("remote", remote))
+		prometheus.SNMPRequests.WithLabelValues(request.PDUType.String(), response.Error.String()).Inc()
+	} else {
+		response = a.process(request, auth.Identity{Name: DefaultUser, Role: auth.RoleViewer}, remote)
+	}
+	response.Version, response.Community = gosnmp.Version2c, request.Community
+	return response.MarshalMsg()
+}
+
+// identity возвращает пользователя для имени USM, прошедшего проверку подписи.
+// Пользователь без API ключа не получает никакой роли.
+func (a *Agent) identity(name string) auth.Identity {
+	if a.authn == nil || !a.authn.Enabled() {
+		return auth.Identity{Name: name, Role: auth.RoleAdmin}
+	}
+	if identity, ok := a.authn.Named(name); ok {
+		return identity
+	}
+	return auth.Identity{Name: name}
+}
+
+func (a *Agent) handleV3(msg []byte, remote string) ([]byte, error) {
+	h, err := parseV3Header(msg)
+	if err != nil {
+		return nil, err
+	}
+	if h.securityModel != int64(gosnmp.UserSecurityModel) {
+		return nil, errors.Wrapf(ErrInvalidMessage, "модель безопасности %d", h.securityModel)
+	}
+
+	// Обнаружение движка: клиент узнает engineID, boots и time из отчета.
+	if h.engineID != a.engineID {
+		return a.report(msg, h, OIDUnknownEngineIDs, nil)
+	}
+	user, ok := a.users[h.userName]
+	if !ok {
+		return a.report(msg, h, OIDUnknownUserNames, nil)
+	}
+	if h.flags&gosnmp.AuthPriv != user.level {
+		return a.report(msg, h, OIDUnsupportedSecLevels, nil)
+	}
+	// gosnmp сравнивает код аутентификации по длине присланного, поэтому
+	// пустой или короткий код прошел бы проверку.
+	if len(h.authParams) != macLength[user.params.AuthenticationProtocol] {
+		return a.report(msg, h, OIDWrongDigests, nil)
+	}
+
+	decoder := &gosnmp.GoSNMP{
+		Version:            gosnmp.Version3,
+		SecurityModel:      gosnmp.UserSecurityModel,
+		MsgFlags:           h.flags,
+		SecurityParameters: user.params.Copy(),
+	}
+	request, err := decoder.UnmarshalTrap(slices.Clone(msg), true)
+	if err != nil {
+		// Ошибка подписи или расшифровки.
+		a.logger.Debug("ошибка проверки сообщения SNMPv3", slog.String("remote", remote), slog.String("user", h.userName), slog.Any("err", err))
+		return a.report(msg, h, OIDWrongDigests, nil)
+	}
+	if h.boots != int64(a.cfg.Boots) || h.time < int64(a.engineTime())-timeWindow || h.time > int64(a.engineTime())+timeWindow {
+		return a.report(msg, h, OIDNotInTimeWindows, &user)
+	}
+
+	response := a.process(request, a.identity(h.userName), remote)
+	response.ContextEngineID, response.ContextName = request.ContextEngineID, request.ContextName
+	return a.marshalV3(response, h, &user, h.flags&^gosnmp.Reportable)
+}
+
+// report отправляет отчет USM, если клиент его ждет. Отчет о времени
+// подписывается ключом пользователя, остальные - без аутентификации.
+func (a *Agent) report(msg []byte, h v3Header, oid OID, user *usmUser) ([]byte, error) {
+	key := oid.String()
+	a.reports[key]++
+	prometheus.SNMPRequests.WithLabelValues(gosnmp.Report.String(), reportNames[key]).Inc()
+	if h.flags&gosnmp.Reportable == 0 {
+		return nil, errors.Wrapf(ErrInvalidMessage, "отчет %s не запрошен", reportNames[key])
+	}
+
+	packet := &gosnmp.SnmpPacket{
+		PDUType:         gosnmp.Report,
+		ContextEngineID: a.engineID,
+		Variables:       []gosnmp.SnmpPDU{{Name: key, Type: gosnmp.Counter32, Value: a.reports[key]}},
+	}
+	flags := gosnmp.NoAuthNoPriv
+	if user == nil {
+		user = &usmUser{params: &gosnmp.UsmSecurityParameters{AuthenticationProtocol: gosnmp.NoAuth, PrivacyProtocol: gosnmp.NoPriv}}
+		// Из запроса без аутентификации можно взять request-id.
+		if h.flags&gosnmp.AuthNoPriv == 0 {
+			decoder := &gosnmp.GoSNMP{
+				Version:            gosnmp.Version3,
+				SecurityModel:      gosnmp.UserSecurityModel,
+				MsgFlags:           h.flags,
+				SecurityParameters: user.params.Copy(),
+			}
+			if request, err := decoder.UnmarshalTrap(slices.Clone(msg), true); err == nil {
+				packet.RequestID = request.RequestID
+			}
+		}
+	} else {
+		flags = gosnmp.AuthNoPriv
+	}
+	return a.marshalV3(packet, h, user, flags)
+}
+
+func (a *Agent) marshalV3(packet *gosnmp.SnmpPacket, h v3Header, user *usmUser, flags gosnmp.SnmpV3MsgFlags) ([]byte, error) {
+	sp := user.params.Copy().(*gosnmp.UsmSecurityParameters)
+	sp.AuthoritativeEngineID = a.engineID
+	sp.AuthoritativeEngineBoots = a.cfg.Boots
+	sp.AuthoritativeEngineTime = a.engineTime()
+	sp.UserName = h.userName
+
+	packet.Version = gosnmp.Version3
+	packet.SecurityModel = gosnmp.UserSecurityModel
+	packet.MsgFlags = flags
+	packet.MsgID = h.msgID
+	packet.SecurityParameters = sp
+	if err := sp.InitPacket(packet); err != nil {
+		return nil, err
+	}
+	return packet.MarshalMsg()
+}
+
+// process выполняет запрос и возвращает GetResponse без полей версии.
+func (a *Agent) process(request *gosnmp.SnmpPacket, identity auth.Identity, remote string) *gosnmp.SnmpPacket {
+	response := &gosnmp.SnmpPacket{PDUType: gosnmp.GetResponse, RequestID: request.RequestID}
+	now := time.Now()
+
+	switch {
+	case identity.Role < auth.RoleViewer:
+		response.Error, response.Variables = gosnmp.AuthorizationError, request.Variables
+	case request.PDUType == gosnmp.GetRequest:
+		response.Variables = a.get(a.objects(now), request.Variables)
+	case request.PDUType == gosnmp.GetNextRequest:
+		response.Variables = a.next(a.objects(now), request.Variables)
+	case request.PDUType == gosnmp.GetBulkRequest:
+		response.Variables = a.bulk(a.objects(now), request)
+	case request.PDUType == gosnmp.SetRequest:
+		response.Error, response.ErrorIndex = a.set(a.objects(now), request.Variables, identity, remote)
+		response.Variables = request.Variables
+	default:
+		response.Error, response.Variables = gosnmp.GenErr, request.Variables
+	}
+	if response.Error == gosnmp.AuthorizationError {
+		a.logger.Warn("запрос SNMP отклонен", slog.String("remote", remote), slog.String("user", identity.Name), slog.String("pdu", request.PDUType.String()))
+	}
+	prometheus.SNMPRequests.WithLabelValues(request.PDUType.String(), response.Error.String()).Inc()
+	return response
+}
+
+func find(objects []object, oid OID) (object, bool) {
+	i, ok := slices.BinarySearchFunc(objects, oid, func(o object, oid OID) int { return slices.Compare(o.oid, oid) })
+	if !ok {
+		return object{}, false
+	}
+	return objects[i], true
+}
+
+func binding(o object) gosnmp.SnmpPDU {
+	return gosnmp.SnmpPDU{Name: o.oid.String(), Type: o.kind, Value: o.value}
+}
+
+func (a *Agent) get(objects []object, variables []gosnmp.SnmpPDU) []gosnmp.SnmpPDU {
+	result := make([]gosnmp.SnmpPDU, len(variables))
+	for i, v := range variables {
+		result[i] = gosnmp.SnmpPDU{Name: v.Name, Type: gosnmp.NoSuchObject}
+		oid, err := ParseOID(v.Name)
+		if err != nil || len(oid) == 0 {
+			continue
+		}
+		if o, ok := find(objects, oid); ok {
+			result[i] = binding(o)
+			continue
+		}
+		// Объект есть, но такой строки таблицы нет.
+		column := oid[:len(oid)-1]
+		if slices.ContainsFunc(objects, func(o object) bool { return o.oid.HasPrefix(column) && len(o.oid) == len(oid) }) {
+			result[i].Type = gosnmp.NoSuchInstance
+		}
+	}
+	return result
+}
+
+// successor возвращает первую переменную после oid.
+func successor(objects []object, name string) gosnmp.SnmpPDU {
+	oid, _ := ParseOID(name)
+	i, ok := slices.BinarySearchFunc(objects, oid, func(o object, oid OID) int { return slices.Compare(o.oid, oid) })
+	if ok {
+		i++
+	}
+	if i >= len(objects) {
+		return gosnmp.SnmpPDU{Name: name, Type: gosnmp.EndOfMibView}
+	}
+	return binding(objects[i])
+}
+
+func (a *Agent) next(objects []object, variables []gosnmp.SnmpPDU) []gosnmp.SnmpPDU {
+	result := make([]gosnmp.SnmpPDU, len(variables))
+	for i, v := range variables {
+		result[i] = successor(objects, v.Name)
+	}
+	return result
+}
+
+// bulk выполняет GetBulk (RFC 3416 4.2.3): первые NonRepeaters переменных
+// по одному шагу, остальные до MaxRepetitions шагов.
+func (a *Agent) bulk(objects []object, request *gosnmp.SnmpPacket) []gosnmp.SnmpPDU {
+	nonRepeaters := min(int(request.NonRepeaters), len(request.Variables))
+	result := a.next(objects, request.Variables[:nonRepeaters])
+
+	repeaters := slices.Clone(request.Variables[nonRepeaters:])
+	if len(repeaters) == 0 {
+		return result
+	}
+	repetitions := min(int(request.MaxRepetitions), (maxBulkBinds-len(result))/len(repeaters))
+	for r := 0; r < repetitions; r++ {
+		done := true
+		for i, v := range repeaters {
+			next := successor(objects, v.Name)
+			result = append(result, next)
+			if next.Type != gosnmp.EndOfMibView {
+				repeaters[i] = next
+				done = false
+			}
+		}
+		if done {
+			break
+		}
+	}
+	return result
+}
+
+// set проверяет все переменные и только затем применяет изменения по порядку.
+func (a *Agent) set(objects []object, variables []gosnmp.SnmpPDU, identity auth.Identity, remote string) (gosnmp.SNMPError, uint8) {
+	commits := make([]func(c caller) error, len(variables))
+	for i, v := range variables {
+		index := uint8(min(i+1, 255))
+		oid, err := ParseOID(v.Name)
+		if err != nil {
+			return gosnmp.NoCreation, index
+		}
+		o, ok := find(objects, oid)
+		if !ok {
+			return gosnmp.NoCreation, index
+		}
+		if o.set == nil {
+			return gosnmp.NotWritable, index
+		}
+		if identity.Role < o.role {
+			a.logger.Warn("недостаточно прав для записи SNMP", slog.String("user", identity.Name), slog.String("oid", v.Name),
+				slog.Any("err", errors.Wrapf(auth.ErrForbidden, "%s: роль %s, требуется %s", identity.Name, identity.Role, o.role)))
+			return gosnmp.AuthorizationError, index
+		}
+		commit, status := o.set(v)
+		if status != gosnmp.NoError {
+			return status, index
+		}
+		commits[i] = commit
+	}
+
+	c := caller{user: identity.Name, reason: "SNMP " + remote}
+	for i, commit := range commits {
+		if err := commit(c); err != nil {
+			index := uint8(min(i+1, 255))
+			if errors.Is(err, overrides.ErrInvalidOverride) || errors.Is(err, models.ErrInvalidPlan) {
+				return gosnmp.InconsistentValue, index
+			}
+			a.logger.Error("ошибка записи SNMP", slog.String("oid", variables[i].Name), slog.String("remote", remote), slog.Any("err", err))
+			return gosnmp.CommitFailed, index
+		}
+	}
+	a.logger.Info("запись SNMP", slog.String("user", identity.Name), slog.String("remote", remote), slog.Int("variables", len(variables)))
+	return gosnmp.NoError, 0
+}
@@ -0,0 +1,467 @@
+package ntcip
+
+import (
+	"log/slog"
+	"os"
+	"slices"
+	"time"
+	"trafficlightAPI/internal/audit"
+	"trafficlightAPI/internal/faults"
+	"trafficlightAPI/internal/heartbeat"
+	"trafficlightAPI/internal/lights"
+	"trafficlightAPI/internal/middleware/auth"
+	"trafficlightAPI/internal/mmu"
+	"trafficlightAPI/internal/models"
+	"trafficlightAPI/internal/overrides"
+	"trafficlightAPI/internal/storage"
+
+	"github.com/gosnmp/gosnmp"
+	"github.com/pkg/errors"
+)
+
+// Объекты NTCIP 1202 (asc), номера столбцов таблиц совпадают со стандартом.
+var (
+	OIDSystem = OID{1, 3, 6, 1, 2, 1, 1}
+	OIDASC    = OID{1, 3, 6, 1, 4, 1, 1206, 4, 2, 1}
+
+	OIDMaxPhases         = OIDASC.Append(1, 1, 0)
+	OIDPhaseEntry        = OIDASC.Append(1, 2, 1)
+	OIDMaxPhaseGroups    = OIDASC.Append(1, 3, 0)
+	OIDPhaseStatusGroup  = OIDASC.Append(1, 4, 1)
+	OIDPhaseControlGroup = OIDASC.Append(1, 5, 1)
+	OIDUnitControlStatus = OIDASC.Append(3, 5, 0)
+	OIDUnitFlashStatus   = OIDASC.Append(3, 6, 0)
+	OIDShortAlarmStatus  = OIDASC.Append(3, 9, 0)
+	OIDUnitControl       = OIDASC.Append(3, 10, 0)
+	OIDMaxAlarmGroups    = OIDASC.Append(3, 11, 0)
+	OIDAlarmGroupEntry   = OIDASC.Append(3, 12, 1)
+)
+
+// Столбцы phaseEntry.
+const (
+	PhaseNumber       = 1
+	PhaseWalk         = 2
+	PhaseMinimumGreen = 4
+	PhaseMaximum1     = 6
+	PhaseYellowChange = 8 // Десятые доли секунды
+	PhaseRedClear     = 9 // Десятые доли секунды
+)
+
+// Столбцы phaseStatusGroupEntry.
+const (
+	StatusGroupNumber    = 1
+	StatusGroupReds      = 2
+	StatusGroupYellows   = 3
+	StatusGroupGreens    = 4
+	StatusGroupDontWalks = 5
+	StatusGroupWalks     = 7
+	StatusGroupPhaseOns  = 10
+)
+
+// Столбцы phaseControlGroupEntry.
+const (
+	ControlGroupNumber   = 1
+	ControlGroupHold     = 4
+	ControlGroupForceOff = 5
+)
+
+// Значения unitFlashStatus.
+const (
+	FlashNone      = 2 // notFlash
+	FlashAutomatic = 3 // automatic: мигающий желтый по команде
+	FlashMMU       = 6 // mmu: авария монитора конфликтов
+)
+
+// Биты shortAlarmStatus.
+const (
+	AlarmTFFlash       = 1 << 1 // Светофор в режиме failsafe
+	AlarmLocalOverride = 1 << 3 // Действует ручное управление
+	AlarmNonCritical   = 1 << 6 // Неисправность ламп или потеря связи
+	AlarmCritical      = 1 << 7 // Зафиксированная авария монитора конфликтов
+)
+
+// UnitControlFlash - бит unitControl, переводящий все фазы в мигающий желтый.
+const UnitControlFlash = 1 << 0
+
+const systemControl = 2 // unitControlStatus
+
+// phase - светофор, сопоставленный фазе контроллера.
+type phase struct {
+	number      int
+	uuid        string
+	trafficType int
+	state       *storage.State
+	lamps       models.Lamps
+	override    *storage.Override
+	alarm       bool
+}
+
+// caller - автор команды для ручного управления и аудита.
+type caller struct {
+	user   string
+	reason string
+}
+
+// object - переменная MIB. set проверяет значение и возвращает изменение,
+// которое применяется после проверки всех переменных запроса. role - роль,
+// которую HTTP API требует для того же действия.
+type object struct {
+	oid   OID
+	kind  gosnmp.Asn1BER
+	value any
+	role  auth.Role
+	set   func(pdu gosnmp.SnmpPDU) (func(c caller) error, gosnmp.SNMPError)
+}
+
+// phaseUUIDs возвращает uuid светофоров в порядке фаз.
+func (a *Agent) phaseUUIDs() []string {
+	if len(a.cfg.Phases) > 0 {
+		return a.cfg.Phases
+	}
+	registry := lights.Default()
+	if registry == nil {
+		return nil
+	}
+	entries := registry.All()
+	uuids := make([]string, len(entries))
+	for i, e := range entries {
+		uuids[i] = e.Light.UUID
+	}
+	return uuids
+}
+
+func (a *Agent) phases(now time.Time) []phase {
+	registry, manager, lampFaults, monitor, links := lights.Default(), overrides.Default(), faults.Default(), mmu.Default(), heartbeat.Default()
+
+	active := make(map[string]storage.Override)
+	if manager != nil {
+		for _, o := range manager.Active(now) {
+			active[o.UUID] = o
+		}
+	}
+	alarms := make(map[string]bool)
+	if monitor != nil {
+		for _, f := range monitor.Latched() {
+			for _, uuid := range f.UUIDs {
+				alarms[uuid] = true
+			}
+		}
+	}
+	if lampFaults != nil {
+		for _, f := range lampFaults.List("") {
+			alarms[f.UUID] = true
+		}
+	}
+	if links != nil {
+		for _, d := range links.Stale() {
+			alarms[d.UUID] = true
+		}
+	}
+
+	uuids := a.phaseUUIDs()
+	phases := make([]phase, len(uuids))
+	for i, uuid := range uuids {
+		p := phase{number: i + 1, uuid: uuid, alarm: alarms[uuid]}
+		if registry != nil {
+			if entry, ok := registry.Get(uuid); ok {
+				p.trafficType = entry.Light.Type
+				p.state = entry.State
+				if p.state != nil {
+					p.lamps, _ = models.StateLamps(p.state.Type, p.state.State)
+				}
+			}
+		}
+		if o, ok := active[uuid]; ok {
+			p.override = &o
+		}
+		phases[i] = p
+	}
+	return phases
+}
+
+func knownType(trafficType int) bool {
+	return trafficType >= 1 && trafficType <= models.TypesCount()
+}
+
+// stateWith возвращает состояние, в котором горят ровно лампы lamps, или 0.
+func stateWith(trafficType int, lamps models.Lamps) int {
+	if !knownType(trafficType) {
+		return 0
+	}
+	for state := 1; state <= models.StatesCount(trafficType); state++ {
+		if l, _ := models.StateLamps(trafficType, state); l == lamps {
+			return state
+		}
+	}
+	return 0
+}
+
+func pedestrian(trafficType int) bool {
+	return trafficType == 3
+}
+
+// command сообщает, каким действием ручного управления занята фаза.
+func (p phase) command() string {
+	if p.override == nil {
+		return ""
+	}
+	if p.override.Action == overrides.ActionForce {
+		if knownType(p.trafficType) && p.override.State == models.FlashState(p.trafficType) {
+			return commandFlash
+		}
+		return ""
+	}
+	return p.override.Action
+}
+
+const (
+	commandHold    = overrides.ActionHold
+	commandAdvance = overrides.ActionAdvance
+	commandFlash   = "flash"
+)
+
+// groups возвращает число групп по 8 фаз.
+func groups(n int) int {
+	return (n + 7) / 8
+}
+
+// bits собирает битовую маску группы group по признаку has.
+func bits(phases []phase, group int, has func(p phase) bool) int {
+	mask := 0
+	for i := 0; i < 8; i++ {
+		n := (group-1)*8 + i
+		if n < len(phases) && has(phases[n]) {
+			mask |= 1 << i
+		}
+	}
+	return mask
+}
+
+func integer(oid OID, v int) object {
+	return object{oid: oid, kind: gosnmp.Integer, value: v}
+}
+
+// objects строит снимок MIB в порядке OID.
+func (a *Agent) objects(now time.Time) []object {
+	phases := a.phases(now)
+	hostname, _ := os.Hostname()
+	objects := []object{
+		{oid: OIDSystem.Append(1, 0), kind: gosnmp.OctetString, value: a.cfg.Description},
+		{oid: OIDSystem.Append(2, 0), kind: gosnmp.ObjectIdentifier, value: OIDASC.String()},
+		{oid: OIDSystem.Append(3, 0), kind: gosnmp.TimeTicks, value: uint32(now.Sub(a.started) / (10 * time.Millisecond))},
+		{oid: OIDSystem.Append(5, 0), kind: gosnmp.OctetString, value: hostname},
+		integer(OIDMaxPhases, len(phases)),
+		integer(OIDMaxPhaseGroups, groups(len(phases))),
+	}
+
+	for _, p := range phases {
+		var walk, green, yellow int
+		if knownType(p.trafficType) {
+			plan := models.Plan(p.trafficType)
+			if s := stateWith(p.trafficType, models.Lamps{Green: true}); s > 0 {
+				green = plan[s-1]
+			}
+			if s := models.FlashState(p.trafficType); s > 0 {
+				yellow = plan[s-1] * 10
+			}
+			if pedestrian(p.trafficType) {
+				walk = green
+			}
+		}
+		objects = append(objects,
+			integer(OIDPhaseEntry.Append(PhaseNumber, p.number), p.number),
+			integer(OIDPhaseEntry.Append(PhaseWalk, p.number), walk),
+			integer(OIDPhaseEntry.Append(PhaseMinimumGreen, p.number), green),
+			a.timing(OIDPhaseEntry.Append(PhaseMaximum1, p.number), p, green, models.Lamps{Green: true}, 1),
+			a.timing(OIDPhaseEntry.Append(PhaseYellowChange, p.number), p, yellow, models.Lamps{Yellow: true}, 10),
+			integer(OIDPhaseEntry.Append(PhaseRedClear, p.number), 0),
+		)
+	}
+
+	lit := func(lamp func(l models.Lamps) bool, ped bool) func(p phase) bool {
+		return func(p phase) bool { return p.state != nil && pedestrian(p.trafficType) == ped && lamp(p.lamps) }
+	}
+	for g := 1; g <= groups(len(phases)); g++ {
+		objects = append(objects,
+			integer(OIDPhaseStatusGroup.Append(StatusGroupNumber, g), g),
+			integer(OIDPhaseStatusGroup.Append(StatusGroupReds, g), bits(phases, g, lit(func(l models.Lamps) bool { return l.Red }, false))),
+			integer(OIDPhaseStatusGroup.Append(StatusGroupYellows, g), bits(phases, g, lit(func(l models.Lamps) bool { return l.Yellow }, false))),
+			integer(OIDPhaseStatusGroup.Append(StatusGroupGreens, g), bits(phases, g, lit(func(l models.Lamps) bool { return l.Green }, false))),
+			integer(OIDPhaseStatusGroup.Append(StatusGroupDontWalks, g), bits(phases, g, lit(func(l models.Lamps) bool { return l.Red }, true))),
+			integer(OIDPhaseStatusGroup.Append(StatusGroupWalks, g), bits(phases, g, lit(func(l models.Lamps) bool { return l.Green }, true))),
+			integer(OIDPhaseStatusGroup.Append(StatusGroupPhaseOns, g), bits(phases, g, func(p phase) bool { return p.state != nil && (p.lamps.Green || p.lamps.Yellow) })),
+		)
+		objects = append(objects,
+			integer(OIDPhaseControlGroup.Append(ControlGroupNumber, g), g),
+			a.control(OIDPhaseControlGroup.Append(ControlGroupHold, g), phases, g, commandHold),
+			a.control(OIDPhaseControlGroup.Append(ControlGroupForceOff, g), phases, g, commandAdvance),
+		)
+	}
+
+	flash, alarm := FlashNone, 0
+	for _, p := range phases {
+		switch {
+		case p.state != nil && p.state.Mode == models.ModeFailsafe:
+			flash = FlashMMU
+			alarm |= AlarmTFFlash
+		case p.command() == commandFlash && flash != FlashMMU:
+			flash = FlashAutomatic
+		}
+		if p.override != nil {
+			alarm |= AlarmLocalOverride
+		}
+		if p.alarm {
+			alarm |= AlarmNonCritical
+		}
+	}
+	if monitor := mmu.Default(); monitor != nil && len(monitor.Latched()) > 0 {
+		alarm |= AlarmCritical
+	}
+	objects = append(objects,
+		integer(OIDUnitControlStatus, systemControl),
+		integer(OIDUnitFlashStatus, flash),
+		integer(OIDShortAlarmStatus, alarm),
+		a.unitControl(phases),
+		integer(OIDMaxAlarmGroups, groups(len(phases))),
+	)
+	for g := 1; g <= groups(len(phases)); g++ {
+		objects = append(objects,
+			integer(OIDAlarmGroupEntry.Append(1, g), g),
+			integer(OIDAlarmGroupEntry.Append(2, g), bits(phases, g, func(p phase) bool { return p.alarm })),
+		)
+	}
+
+	slices.SortFunc(objects, func(x, y object) int { return slices.Compare(x.oid, y.oid) })
+	return objects
+}
+
+// timing - длительность состояния с лампами lamps в единицах unit секунды.
+// Запись меняет план всех светофоров того же типа.
+func (a *Agent) timing(oid OID, p phase, value int, lamps models.Lamps, unit int) object {
+	o := integer(oid, value)
+	o.role = auth.RoleEngineer
+	o.set = func(pdu gosnmp.SnmpPDU) (func(c caller) error, gosnmp.SNMPError) {
+		v, ok := pdu.Value.(int)
+		if pdu.Type != gosnmp.Integer || !ok {
+			return nil, gosnmp.WrongType
+		}
+		state := stateWith(p.trafficType, lamps)
+		if state == 0 {
+			return nil, gosnmp.InconsistentValue
+		}
+		if v < unit || v%unit != 0 {
+			return nil, gosnmp.WrongValue
+		}
+		return func(c caller) error {
+			from := models.Plan(p.trafficType)
+			plan := slices.Clone(from)
+			plan[state-1] = v / unit
+			if err := models.ApplyPlan(p.trafficType, plan); err != nil {
+				return err
+			}
+			if err := audit.Record(audit.Entry{
+				Action: audit.ActionPlanChange, User: c.user, Reason: "snmp",
+				Details: map[string]any{"type": p.trafficType, "from": from, "to": models.Plan(p.trafficType)},
+			}); err != nil {
+				a.logger.Error("ошибка записи аудита", slog.String("action", audit.ActionPlanChange), slog.Any("err", err))
+			}
+			return nil
+		}, gosnmp.NoError
+	}
+	return o
+}
+
+// control - маска фаз группы под ручным управлением command. Запись включает
+// его для установленных битов и снимает для сброшенных.
+func (a *Agent) control(oid OID, phases []phase, group int, command string) object {
+	o := integer(oid, bits(phases, group, func(p phase) bool { return p.command() == command }))
+	o.role = auth.RoleOperator
+	o.set = func(pdu gosnmp.SnmpPDU) (func(c caller) error, gosnmp.SNMPError) {
+		v, ok := pdu.Value.(int)
+		if pdu.Type != gosnmp.Integer || !ok {
+			return nil, gosnmp.WrongType
+		}
+		if v < 0 || v > 0xff {
+			return nil, gosnmp.WrongValue
+		}
+		var targets []phase
+		for i := 0; i < 8; i++ {
+			n := (group-1)*8 + i
+			if n < len(phases) {
+				targets = append(targets, phases[n])
+			} else if v&(1<<i) != 0 {
+				return nil, gosnmp.WrongValue
+			}
+		}
+		return func(c caller) error {
+			for i, p := range targets {
+				if err := a.command(p, command, v&(1<<i) != 0, c); err != nil {
+					return err
+				}
+			}
+			return nil
+		}, gosnmp.NoError
+	}
+	return o
+}
+
+// unitControl - бит UnitControlFlash переводит в мигающий желтый все фазы, у которых он есть.
+func (a *Agent) unitControl(phases []phase) object {
+	value := 0
+	for _, p := range phases {
+		if p.command() == commandFlash {
+			value |= UnitControlFlash
+		}
+	}
+	o := integer(OIDUnitControl, value)
+	o.role = auth.RoleOperator
+	o.set = func(pdu gosnmp.SnmpPDU) (func(c caller) error, gosnmp.SNMPError) {
+		v, ok := pdu.Value.(int)
+		if pdu.Type != gosnmp.Integer || !ok {
+			return nil, gosnmp.WrongType
+		}
+		if v & ^UnitControlFlash != 0 {
+			return nil, gosnmp.WrongValue
+		}
+		return func(c caller) error {
+			for _, p := range phases {
+				if knownType(p.trafficType) && models.FlashState(p.trafficType) > 0 {
+					if err := a.command(p, commandFlash, v&UnitControlFlash != 0, c); err != nil {
+						return err
+					}
+				}
+			}
+			return nil
+		}, gosnmp.NoError
+	}
+	return o
+}
+
+// command включает или снимает ручное управление фазы. Повторная команда ничего не меняет.
+func (a *Agent) command(p phase, command string, on bool, c caller) error {
+	manager := overrides.Default()
+	if manager == nil {
+		return ErrOverridesDisabled
+	}
+	if on == (p.command() == command) {
+		return nil
+	}
+	if !on {
+		_, err := manager.Release(p.override.ID, c.reason, c.user)
+		if errors.Is(err, overrides.ErrOverrideNotFound) {
+			return nil
+		}
+		return err
+	}
+
+	now := time.Now()
+	o := storage.Override{UUID: p.uuid, Action: command, Reason: c.reason, User: c.user, CreatedAt: now, ExpiresAt: now.Add(a.cfg.CommandDuration)}
+	if command == commandFlash {
+		if !knownType(p.trafficType) || models.FlashState(p.trafficType) == 0 {
+			return errors.Wrapf(overrides.ErrInvalidOverride, "у светофора %s нет желтого", p.uuid)
+		}
+		o.Action, o.State = overrides.ActionForce, models.FlashState(p.trafficType)
+	}
+	_, err := manager.Create(o, p.trafficType)
+	return err
+}
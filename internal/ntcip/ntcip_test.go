@@ -0,0 +1,304 @@
+package ntcip_test
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"log/slog"
+	"net"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"testing"
+	"time"
+	"trafficlightAPI/internal/audit"
+	"trafficlightAPI/internal/lights"
+	"trafficlightAPI/internal/middleware/auth"
+	"trafficlightAPI/internal/models"
+	"trafficlightAPI/internal/ntcip"
+	"trafficlightAPI/internal/overrides"
+	"trafficlightAPI/internal/storage"
+
+	"github.com/gosnmp/gosnmp"
+	"github.com/pkg/errors"
+)
+
+var keys = map[string]string{"viewer-key": "viewer", "operator-key": "operator", "engineer-key": "engineer"}
+
+// setup регистрирует светофор "a" (тип 1, зеленый) и "b" (тип 3, стоять)
+// и запускает агент с ключами keys и пользователями v3 с теми же именами.
+func setup(t *testing.T, authEnabled bool) (string, *overrides.Manager) {
+	t.Helper()
+	dir := t.TempDir()
+	store, err := storage.OpenBolt(filepath.Join(dir, "state.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { store.Close() })
+	auditLog, err := audit.Open(filepath.Join(dir, "audit.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { auditLog.Close() })
+
+	registry := lights.NewRegistry(store, slog.Default())
+	registry.Observe(models.Transition{UUID: "a", Type: 1, To: 3, Time: time.Now(), Mode: models.ModeNormal})
+	registry.Observe(models.Transition{UUID: "b", Type: 3, To: 1, Time: time.Now(), Mode: models.ModeNormal})
+	lights.SetDefault(registry)
+	manager := overrides.NewManager(store, auditLog, slog.Default())
+	overrides.SetDefault(manager)
+	t.Cleanup(func() {
+		lights.SetDefault(nil)
+		overrides.SetDefault(nil)
+	})
+
+	var apiKeys []auth.APIKey
+	for key, role := range keys {
+		hash := sha256.Sum256([]byte(key))
+		apiKeys = append(apiKeys, auth.APIKey{Name: role, SHA256: hex.EncodeToString(hash[:]), Role: role})
+	}
+	authn, err := auth.New(auth.Config{Enabled: authEnabled, APIKeys: apiKeys}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var users []ntcip.User
+	for _, role := range keys {
+		users = append(users, ntcip.User{Name: role, AuthProtocol: "SHA256", AuthPassphrase: "auth-secret", PrivProtocol: "AES", PrivPassphrase: "priv-secret"})
+	}
+	agent, err := ntcip.New(ntcip.Config{
+		Description:     "test",
+		Boots:           1,
+		Users:           users,
+		CommandDuration: time.Minute,
+	}, authn, slog.Default())
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go agent.Serve(conn)
+	t.Cleanup(func() { agent.Close() })
+	return conn.LocalAddr().String(), manager
+}
+
+func connect(t *testing.T, address string, client *gosnmp.GoSNMP) *gosnmp.GoSNMP {
+	t.Helper()
+	host, port, _ := net.SplitHostPort(address)
+	p, _ := strconv.Atoi(port)
+	client.Target, client.Port = host, uint16(p)
+	client.Timeout, client.Retries = 500*time.Millisecond, 0
+	if err := client.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.Conn.Close() })
+	return client
+}
+
+func v2c(t *testing.T, address, community string) *gosnmp.GoSNMP {
+	return connect(t, address, &gosnmp.GoSNMP{Version: gosnmp.Version2c, Community: community})
+}
+
+func v3(t *testing.T, address, user, authPassphrase string) *gosnmp.GoSNMP {
+	return connect(t, address, &gosnmp.GoSNMP{
+		Version:       gosnmp.Version3,
+		SecurityModel: gosnmp.UserSecurityModel,
+		MsgFlags:      gosnmp.AuthPriv,
+		SecurityParameters: &gosnmp.UsmSecurityParameters{
+			UserName:                 user,
+			AuthenticationProtocol:   gosnmp.SHA256,
+			AuthenticationPassphrase: authPassphrase,
+			PrivacyProtocol:          gosnmp.AES,
+			PrivacyPassphrase:        "priv-secret",
+		},
+	})
+}
+
+func column(table ntcip.OID, col, row int) string {
+	return table.Append(col, row).String()
+}
+
+func TestGet(t *testing.T) {
+	address, _ := setup(t, true)
+	client := v3(t, address, "viewer", "auth-secret")
+
+	tests := []struct {
+		name string
+		oid  string
+		kind gosnmp.Asn1BER
+		want any
+	}{
+		{name: "sysObjectID", oid: ".1.3.6.1.2.1.1.2.0", kind: gosnmp.ObjectIdentifier, want: ntcip.OIDASC.String()},
+		{name: "maxPhases", oid: ntcip.OIDMaxPhases.String(), kind: gosnmp.Integer, want: 2},
+		{name: "greens", oid: column(ntcip.OIDPhaseStatusGroup, ntcip.StatusGroupGreens, 1), kind: gosnmp.Integer, want: 0b01},
+		{name: "reds", oid: column(ntcip.OIDPhaseStatusGroup, ntcip.StatusGroupReds, 1), kind: gosnmp.Integer, want: 0},
+		{name: "dont walks", oid: column(ntcip.OIDPhaseStatusGroup, ntcip.StatusGroupDontWalks, 1), kind: gosnmp.Integer, want: 0b10},
+		{name: "yellow change", oid: column(ntcip.OIDPhaseEntry, ntcip.PhaseYellowChange, 1), kind: gosnmp.Integer, want: models.Plan(1)[1] * 10},
+		{name: "pedestrian walk", oid: column(ntcip.OIDPhaseEntry, ntcip.PhaseWalk, 2), kind: gosnmp.Integer, want: models.Plan(3)[1]},
+		{name: "flash status", oid: ntcip.OIDUnitFlashStatus.String(), kind: gosnmp.Integer, want: ntcip.FlashNone},
+		{name: "no such instance", oid: column(ntcip.OIDPhaseEntry, ntcip.PhaseNumber, 3), kind: gosnmp.NoSuchInstance},
+		{name: "no such object", oid: ".1.3.6.1.4.1.1206.4.2.99.0", kind: gosnmp.NoSuchObject},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := client.Get([]string{tt.oid})
+			if err != nil {
+				t.Fatal(err)
+			}
+			v := result.Variables[0]
+			if v.Type != tt.kind || (tt.want != nil && v.Value != tt.want) {
+				t.Errorf("%s = %v %v, want %v %v", tt.oid, v.Type, v.Value, tt.kind, tt.want)
+			}
+		})
+	}
+}
+
+func TestV2c(t *testing.T) {
+	// При включенной аутентификации v2c не принимается: community передается открыто.
+	address, _ := setup(t, true)
+	if _, err := v2c(t, address, "viewer-key").Get([]string{ntcip.OIDMaxPhases.String()}); err == nil {
+		t.Error("v2c request answered with auth enabled")
+	}
+
+	// Без аутентификации v2c только читает.
+	address, _ = setup(t, false)
+	client := v2c(t, address, "public")
+	result, err := client.Get([]string{ntcip.OIDMaxPhases.String()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v := result.Variables[0]; v.Type != gosnmp.Integer || v.Value != 2 {
+		t.Errorf("maxPhases = %v %v", v.Type, v.Value)
+	}
+	hold := column(ntcip.OIDPhaseControlGroup, ntcip.ControlGroupHold, 1)
+	result, err = client.Set([]gosnmp.SnmpPDU{{Name: hold, Type: gosnmp.Integer, Value: 0b01}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Error != gosnmp.NoAccess {
+		t.Errorf("v2c set error = %v, want %v", result.Error, gosnmp.NoAccess)
+	}
+}
+
+func TestWalk(t *testing.T) {
+	address, _ := setup(t, false)
+	client := v2c(t, address, "public")
+
+	walked, err := client.WalkAll(ntcip.OIDASC.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	bulk, err := client.BulkWalkAll(ntcip.OIDASC.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(walked) == 0 || len(walked) != len(bulk) {
+		t.Fatalf("walk %d variables, bulk walk %d", len(walked), len(bulk))
+	}
+	var previous ntcip.OID
+	for i, v := range walked {
+		oid, err := ntcip.ParseOID(v.Name)
+		if err != nil || slices.Compare(oid, previous) <= 0 || bulk[i].Name != v.Name {
+			t.Fatalf("variable %d: %s after %s, bulk %s", i, v.Name, previous, bulk[i].Name)
+		}
+		previous = oid
+	}
+}
+
+func TestSet(t *testing.T) {
+	address, manager := setup(t, true)
+	plan := models.Plan(1)
+	t.Cleanup(func() { models.ApplyPlan(1, plan) })
+
+	hold := column(ntcip.OIDPhaseControlGroup, ntcip.ControlGroupHold, 1)
+	yellow := column(ntcip.OIDPhaseEntry, ntcip.PhaseYellowChange, 1)
+	integer := func(oid string, v int) []gosnmp.SnmpPDU {
+		return []gosnmp.SnmpPDU{{Name: oid, Type: gosnmp.Integer, Value: v}}
+	}
+
+	tests := []struct {
+		name string
+		user string
+		pdus []gosnmp.SnmpPDU
+		want gosnmp.SNMPError
+	}{
+		{name: "viewer cannot hold", user: "viewer", pdus: integer(hold, 0b01), want: gosnmp.AuthorizationError},
+		{name: "operator cannot change plan", user: "operator", pdus: integer(yellow, 30), want: gosnmp.AuthorizationError},
+		{name: "read-only object", user: "operator", pdus: integer(ntcip.OIDMaxPhases.String(), 3), want: gosnmp.NotWritable},
+		{name: "wrong type", user: "operator", pdus: []gosnmp.SnmpPDU{{Name: hold, Type: gosnmp.OctetString, Value: "1"}}, want: gosnmp.WrongType},
+		{name: "phase outside group", user: "operator", pdus: integer(hold, 0b100), want: gosnmp.WrongValue},
+		{name: "yellow in seconds", user: "engineer", pdus: integer(yellow, 25), want: gosnmp.WrongValue},
+		{name: "pedestrian has no yellow", user: "engineer", pdus: integer(column(ntcip.OIDPhaseEntry, ntcip.PhaseYellowChange, 2), 30), want: gosnmp.InconsistentValue},
+		{name: "operator holds", user: "operator", pdus: integer(hold, 0b01)},
+		{name: "engineer changes plan", user: "engineer", pdus: integer(yellow, 30)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := v3(t, address, tt.user, "auth-secret").Set(tt.pdus)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if result.Error != tt.want {
+				t.Errorf("error = %v, want %v", result.Error, tt.want)
+			}
+		})
+	}
+
+	active := manager.Active(time.Now())
+	if len(active) != 1 || active[0].UUID != "a" || active[0].Action != overrides.ActionHold || active[0].User != "operator" {
+		t.Errorf("overrides = %+v", active)
+	}
+	if got := models.Plan(1)[1]; got != 3 {
+		t.Errorf("yellow = %d, want 3", got)
+	}
+
+	// Мигание заменяет держание и действует только на фазы с желтым.
+	client := v3(t, address, "operator", "auth-secret")
+	if result, err := client.Set(integer(ntcip.OIDUnitControl.String(), ntcip.UnitControlFlash)); err != nil || result.Error != gosnmp.NoError {
+		t.Fatalf("unitControl: %v %v", result, err)
+	}
+	active = manager.Active(time.Now())
+	if len(active) != 1 || active[0].Action != overrides.ActionForce || active[0].State != models.FlashState(1) {
+		t.Errorf("overrides after flash = %+v", active)
+	}
+	result, err := client.Get([]string{ntcip.OIDUnitFlashStatus.String(), hold, ntcip.OIDShortAlarmStatus.String()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Variables[0].Value != ntcip.FlashAutomatic || result.Variables[1].Value != 0 || result.Variables[2].Value != ntcip.AlarmLocalOverride {
+		t.Errorf("after flash: %v", result.Variables)
+	}
+	client.Set(integer(ntcip.OIDUnitControl.String(), 0))
+	if active := manager.Active(time.Now()); len(active) != 0 {
+		t.Errorf("overrides after release = %+v", active)
+	}
+}
+
+func TestV3(t *testing.T) {
+	address, _ := setup(t, true)
+
+	result, err := v3(t, address, "engineer", "auth-secret").Get([]string{ntcip.OIDMaxPhases.String()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v := result.Variables[0]; v.Type != gosnmp.Integer || v.Value != 2 {
+		t.Errorf("maxPhases = %v %v", v.Type, v.Value)
+	}
+
+	tests := []struct {
+		name           string
+		user           string
+		authPassphrase string
+		want           error
+	}{
+		{name: "wrong passphrase", user: "engineer", authPassphrase: "wrong-secret", want: gosnmp.ErrWrongDigest},
+		{name: "unknown user", user: "nobody", authPassphrase: "auth-secret", want: gosnmp.ErrUnknownUsername},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := v3(t, address, tt.user, tt.authPassphrase).Get([]string{ntcip.OIDMaxPhases.String()}); !errors.Is(err, tt.want) {
+				t.Errorf("err = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,47 @@
+package ntcip
+
+import (
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	ErrInvalidOID = errors.New("некорректный OID")
+)
+
+// OID - идентификатор объекта SNMP.
+type OID []int
+
+func ParseOID(s string) (OID, error) {
+	parts := strings.Split(strings.TrimPrefix(s, "."), ".")
+	oid := make(OID, len(parts))
+	for i, part := range parts {
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 0 {
+			return nil, errors.Wrapf(ErrInvalidOID, "%q", s)
+		}
+		oid[i] = n
+	}
+	return oid, nil
+}
+
+// String возвращает OID с ведущей точкой, как в gosnmp.
+func (o OID) String() string {
+	var b strings.Builder
+	for _, n := range o {
+		b.WriteByte('.')
+		b.WriteString(strconv.Itoa(n))
+	}
+	return b.String()
+}
+
+func (o OID) Append(sub ...int) OID {
+	return append(slices.Clip(o), sub...)
+}
+
+func (o OID) HasPrefix(prefix OID) bool {
+	return len(o) >= len(prefix) && slices.Equal(o[:len(prefix)], prefix)
+}
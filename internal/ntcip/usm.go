@@ -0,0 +1,270 @@
+package ntcip
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/gosnmp/gosnmp"
+	"github.com/pkg/errors"
+)
+
+var (
+	ErrInvalidMessage = errors.New("некорректное сообщение SNMP")
+	ErrInvalidUser    = errors.New("некорректный пользователь SNMPv3")
+)
+
+// Счетчики отчетов USM (RFC 3414).
+var (
+	OIDUnsupportedSecLevels = OID{1, 3, 6, 1, 6, 3, 15, 1, 1, 1, 0}
+	OIDNotInTimeWindows     = OID{1, 3, 6, 1, 6, 3, 15, 1, 1, 2, 0}
+	OIDUnknownUserNames     = OID{1, 3, 6, 1, 6, 3, 15, 1, 1, 3, 0}
+	OIDUnknownEngineIDs     = OID{1, 3, 6, 1, 6, 3, 15, 1, 1, 4, 0}
+	OIDWrongDigests         = OID{1, 3, 6, 1, 6, 3, 15, 1, 1, 5, 0}
+)
+
+// reportNames - названия счетчиков для метрик и журнала.
+var reportNames = map[string]string{
+	OIDUnsupportedSecLevels.String(): "unsupportedSecLevels",
+	OIDNotInTimeWindows.String():     "notInTimeWindows",
+	OIDUnknownUserNames.String():     "unknownUserNames",
+	OIDUnknownEngineIDs.String():     "unknownEngineIDs",
+	OIDWrongDigests.String():         "wrongDigests",
+}
+
+// timeWindow - допустимое расхождение времени движка, с.
+const timeWindow = 150
+
+// User - пользователь SNMPv3. Аутентификация обязательна, шифрование - нет.
+type User struct {
+	Name           string
+	AuthProtocol   string
+	AuthPassphrase string
+	PrivProtocol   string
+	PrivPassphrase string
+}
+
+var authProtocols = []gosnmp.SnmpV3AuthProtocol{gosnmp.MD5, gosnmp.SHA, gosnmp.SHA224, gosnmp.SHA256, gosnmp.SHA384, gosnmp.SHA512}
+
+var privProtocols = []gosnmp.SnmpV3PrivProtocol{gosnmp.DES, gosnmp.AES, gosnmp.AES192, gosnmp.AES256, gosnmp.AES192C, gosnmp.AES256C}
+
+// macLength - длина кода аутентификации в сообщении.
+var macLength = map[gosnmp.SnmpV3AuthProtocol]int{
+	gosnmp.MD5: 12, gosnmp.SHA: 12, gosnmp.SHA224: 16, gosnmp.SHA256: 24, gosnmp.SHA384: 32, gosnmp.SHA512: 48,
+}
+
+// usmUser - пользователь с ключами, локализованными к движку агента.
+type usmUser struct {
+	params *gosnmp.UsmSecurityParameters
+	level  gosnmp.SnmpV3MsgFlags
+}
+
+func newUSMUser(u User, engineID string) (usmUser, error) {
+	sp := &gosnmp.UsmSecurityParameters{
+		AuthoritativeEngineID:    engineID,
+		UserName:                 u.Name,
+		AuthenticationProtocol:   gosnmp.NoAuth,
+		AuthenticationPassphrase: u.AuthPassphrase,
+		PrivacyProtocol:          gosnmp.NoPriv,
+		PrivacyPassphrase:        u.PrivPassphrase,
+	}
+	for _, p := range authProtocols {
+		if strings.EqualFold(u.AuthProtocol, p.String()) {
+			sp.AuthenticationProtocol = p
+		}
+	}
+	if sp.AuthenticationProtocol == gosnmp.NoAuth {
+		return usmUser{}, errors.Wrapf(ErrInvalidUser, "%q: неизвестный протокол аутентификации %q", u.Name, u.AuthProtocol)
+	}
+	// RFC 3414 требует пароль не короче 8 символов.
+	if len(u.AuthPassphrase) < 8 {
+		return usmUser{}, errors.Wrapf(ErrInvalidUser, "%q: пароль аутентификации короче 8 символов", u.Name)
+	}
+	level := gosnmp.AuthNoPriv
+	if u.PrivProtocol != "" {
+		for _, p := range privProtocols {
+			if strings.EqualFold(u.PrivProtocol, p.String()) {
+				sp.PrivacyProtocol = p
+			}
+		}
+		if sp.PrivacyProtocol == gosnmp.NoPriv {
+			return usmUser{}, errors.Wrapf(ErrInvalidUser, "%q: неизвестный протокол шифрования %q", u.Name, u.PrivProtocol)
+		}
+		if len(u.PrivPassphrase) < 8 {
+			return usmUser{}, errors.Wrapf(ErrInvalidUser, "%q: пароль шифрования короче 8 символов", u.Name)
+		}
+		level = gosnmp.AuthPriv
+	}
+	if err := sp.InitSecurityKeys(); err != nil {
+		return usmUser{}, errors.Wrapf(err, "пользователь %q", u.Name)
+	}
+	return usmUser{params: sp, level: level}, nil
+}
+
+// DefaultEngineID строит snmpEngineID из номера предприятия NTCIP (1206)
+// и имени хоста в текстовом формате RFC 3411.
+func DefaultEngineID() []byte {
+	hostname, _ := os.Hostname()
+	if hostname == "" {
+		hostname = "trafficlight"
+	}
+	id := []byte{0x80, 0x00, 0x04, 0xb6, 0x04}
+	return append(id, hostname[:min(len(hostname), 27)]...)
+}
+
+// NextBoots увеличивает snmpEngineBoots, сохраненный в path, и возвращает новое значение.
+func NextBoots(path string) (uint32, error) {
+	var boots uint64
+	data, err := os.ReadFile(path)
+	switch {
+	case err == nil:
+		boots, err = strconv.ParseUint(strings.TrimSpace(string(data)), 10, 31)
+		if err != nil {
+			return 0, errors.Wrapf(err, "счетчик перезапусков %s", path)
+		}
+	case !os.IsNotExist(err):
+		return 0, err
+	}
+	boots++
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return 0, err
+	}
+	if err := os.WriteFile(path, []byte(strconv.FormatUint(boots, 10)), 0o644); err != nil {
+		return 0, err
+	}
+	return uint32(boots), nil
+}
+
+// v3Header - поля SNMPv3, нужные до проверки подписи и расшифровки.
+type v3Header struct {
+	msgID         uint32
+	flags         gosnmp.SnmpV3MsgFlags
+	securityModel int64
+	engineID      string
+	boots         int64
+	time          int64
+	userName      string
+	authParams    []byte
+}
+
+// tlv читает элемент BER и возвращает тег, содержимое и остаток.
+func tlv(b []byte) (byte, []byte, []byte, error) {
+	if len(b) < 2 {
+		return 0, nil, nil, ErrInvalidMessage
+	}
+	tag, n, i := b[0], int(b[1]), 2
+	if n&0x80 != 0 {
+		size := n & 0x7f
+		if size == 0 || size > 3 || len(b) < 2+size {
+			return 0, nil, nil, ErrInvalidMessage
+		}
+		n = 0
+		for _, c := range b[2 : 2+size] {
+			n = n<<8 | int(c)
+		}
+		i += size
+	}
+	if n > len(b)-i {
+		return 0, nil, nil, ErrInvalidMessage
+	}
+	return tag, b[i : i+n], b[i+n:], nil
+}
+
+// field читает элемент с тегом tag. gosnmp кодирует целые не минимально,
+// поэтому encoding/asn1 для разбора не подходит.
+func field(b []byte, tag byte) ([]byte, []byte, error) {
+	t, content, rest, err := tlv(b)
+	if err != nil {
+		return nil, nil, err
+	}
+	if t != tag {
+		return nil, nil, errors.Wrapf(ErrInvalidMessage, "тег 0x%02x, ожидается 0x%02x", t, tag)
+	}
+	return content, rest, nil
+}
+
+func integerField(b []byte) (int64, []byte, error) {
+	content, rest, err := field(b, byte(gosnmp.Integer))
+	if err != nil {
+		return 0, nil, err
+	}
+	if len(content) == 0 || len(content) > 8 {
+		return 0, nil, errors.Wrap(ErrInvalidMessage, "целое")
+	}
+	v := int64(int8(content[0]))
+	for _, c := range content[1:] {
+		v = v<<8 | int64(c)
+	}
+	return v, rest, nil
+}
+
+// messageVersion возвращает версию сообщения SNMP.
+func messageVersion(msg []byte) (int64, error) {
+	body, _, err := field(msg, byte(gosnmp.Sequence))
+	if err != nil {
+		return 0, err
+	}
+	version, _, err := integerField(body)
+	return version, err
+}
+
+func parseV3Header(msg []byte) (v3Header, error) {
+	var h v3Header
+	body, _, err := field(msg, byte(gosnmp.Sequence))
+	if err != nil {
+		return h, err
+	}
+	if _, body, err = integerField(body); err != nil {
+		return h, err
+	}
+	global, body, err := field(body, byte(gosnmp.Sequence))
+	if err != nil {
+		return h, err
+	}
+	msgID, global, err := integerField(global)
+	if err != nil {
+		return h, err
+	}
+	h.msgID = uint32(msgID)
+	if _, global, err = integerField(global); err != nil {
+		return h, err
+	}
+	flags, global, err := field(global, byte(gosnmp.OctetString))
+	if err != nil || len(flags) != 1 {
+		return h, errors.Wrap(ErrInvalidMessage, "msgFlags")
+	}
+	h.flags = gosnmp.SnmpV3MsgFlags(flags[0])
+	if h.securityModel, _, err = integerField(global); err != nil {
+		return h, err
+	}
+
+	params, _, err := field(body, byte(gosnmp.OctetString))
+	if err != nil {
+		return h, err
+	}
+	usm, _, err := field(params, byte(gosnmp.Sequence))
+	if err != nil {
+		return h, err
+	}
+	engineID, usm, err := field(usm, byte(gosnmp.OctetString))
+	if err != nil {
+		return h, err
+	}
+	h.engineID = string(engineID)
+	if h.boots, usm, err = integerField(usm); err != nil {
+		return h, err
+	}
+	if h.time, usm, err = integerField(usm); err != nil {
+		return h, err
+	}
+	userName, usm, err := field(usm, byte(gosnmp.OctetString))
+	if err != nil {
+		return h, err
+	}
+	h.userName = string(userName)
+	if h.authParams, _, err = field(usm, byte(gosnmp.OctetString)); err != nil {
+		return h, err
+	}
+	return h, nil
+}
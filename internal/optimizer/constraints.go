@@ -0,0 +1,86 @@
+package optimizer
+
+import (
+	"os"
+	"trafficlightAPI/internal/simulator"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+)
+
+var (
+	ErrInvalidConstraints = errors.New("некорректные ограничения оптимизации")
+)
+
+const (
+	ObjectiveDelay = "delay"
+	ObjectiveStops = "stops"
+)
+
+// LightConstraints задает границы длительности каждого состояния светофора.
+// Состояния с Min == Max (например, желтый) не изменяются.
+type LightConstraints struct {
+	Name           string `yaml:"name"`
+	Min            []int  `yaml:"min"`
+	Max            []int  `yaml:"max"`
+	OptimizeOffset bool   `yaml:"optimize_offset,omitempty"`
+}
+
+type Constraints struct {
+	Objective string             `yaml:"objective"`
+	MinCycle  int                `yaml:"min_cycle"`
+	MaxCycle  int                `yaml:"max_cycle"`
+	Lights    []LightConstraints `yaml:"lights"`
+}
+
+func LoadConstraints(path string) (Constraints, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Constraints{}, errors.Wrap(ErrInvalidConstraints, err.Error())
+	}
+
+	var c Constraints
+	if err := yaml.Unmarshal(data, &c); err != nil {
+		return Constraints{}, errors.Wrap(ErrInvalidConstraints, err.Error())
+	}
+	return c, nil
+}
+
+func (c Constraints) validate(in simulator.Intersection) error {
+	switch c.Objective {
+	case ObjectiveDelay, ObjectiveStops:
+	default:
+		return errors.Wrapf(ErrInvalidConstraints, "неизвестная целевая функция %q", c.Objective)
+	}
+	if c.MinCycle < 1 || c.MaxCycle < c.MinCycle {
+		return errors.Wrapf(ErrInvalidConstraints, "границы цикла [%d, %d]", c.MinCycle, c.MaxCycle)
+	}
+
+	lights := make(map[string]int, len(in.Lights))
+	for _, light := range in.Lights {
+		lights[light.Name] = len(light.Durations)
+	}
+
+	for _, lc := range c.Lights {
+		states, ok := lights[lc.Name]
+		if !ok {
+			return errors.Wrapf(ErrInvalidConstraints, "неизвестный светофор %q", lc.Name)
+		}
+		if len(lc.Min) != states || len(lc.Max) != states {
+			return errors.Wrapf(ErrInvalidConstraints, "светофор %q: ожидалось %d границ", lc.Name, states)
+		}
+
+		minCycle, maxCycle := 0, 0
+		for i := range lc.Min {
+			if lc.Min[i] < 1 || lc.Max[i] < lc.Min[i] {
+				return errors.Wrapf(ErrInvalidConstraints, "светофор %q: состояние %d [%d, %d]", lc.Name, i+1, lc.Min[i], lc.Max[i])
+			}
+			minCycle += lc.Min[i]
+			maxCycle += lc.Max[i]
+		}
+		if minCycle > c.MaxCycle || maxCycle < c.MinCycle {
+			return errors.Wrapf(ErrInvalidConstraints, "светофор %q: границы состояний несовместимы с циклом", lc.Name)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,400 @@
+package optimizer
+
+import (
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"trafficlightAPI/internal/models"
+	"trafficlightAPI/internal/simulator"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+)
+
+var (
+	ErrOptimization = errors.New("ошибка оптимизации")
+)
+
+type Options struct {
+	Seed         uint64
+	Workers      int // По умолчанию число ядер
+	Population   int
+	Generations  int
+	Candidates   int // Сколько лучших планов вернуть
+	Replications int // Прогонов симуляции с разными зернами на один план
+	Simulation   simulator.Options
+}
+
+type Candidate struct {
+	Rank         int                      `yaml:"rank" json:"rank"`
+	Score        float64                  `yaml:"score" json:"score"`
+	AverageDelay float64                  `yaml:"average_delay" json:"average_delay"`
+	Stops        float64                  `yaml:"stops" json:"stops"`
+	Throughput   float64                  `yaml:"throughput" json:"throughput"`
+	Lights       []models.LightDefinition `yaml:"lights" json:"lights"`
+}
+
+func (c Candidate) Definitions() models.Definitions {
+	return models.Definitions{Lights: c.Lights}
+}
+
+// WritePlan сохраняет план кандидата в формате plans.yaml. План проверяется
+// так же, как при загрузке сервером: смещение и несколько светофоров одного
+// типа допустимы только для светофоров с uuid.
+func WritePlan(w io.Writer, c Candidate) error {
+	if err := models.ValidateDefinitions(c.Definitions()); err != nil {
+		return errors.Wrap(ErrOptimization, err.Error())
+	}
+
+	encoder := yaml.NewEncoder(w)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(c.Definitions()); err != nil {
+		return err
+	}
+	return encoder.Close()
+}
+
+type genome []models.LightDefinition
+
+func (g genome) key() string {
+	var b strings.Builder
+	for _, light := range g {
+		fmt.Fprintf(&b, "%v@%d;", light.Durations, light.Offset)
+	}
+	return b.String()
+}
+
+func (g genome) clone() genome {
+	cloned := make(genome, len(g))
+	for i, light := range g {
+		cloned[i] = light
+		cloned[i].Durations = append([]int(nil), light.Durations...)
+	}
+	return cloned
+}
+
+type evaluation struct {
+	genome genome
+	key    string
+	result Candidate
+}
+
+type bound struct {
+	LightConstraints
+	index int // Индекс светофора в перекрестке
+}
+
+type optimizer struct {
+	in          simulator.Intersection
+	constraints Constraints
+	bounds      []bound
+	opts        Options
+	rng         *rand.Rand
+	cache       map[string]*evaluation
+}
+
+// Optimize ищет длительности состояний и смещения, минимизирующие суммарную
+// задержку или число остановок: генетический алгоритм с последующим
+// локальным спуском от лучших планов. Результат детерминирован при
+// одинаковом Seed независимо от числа воркеров.
+func Optimize(in simulator.Intersection, constraints Constraints, opts Options) ([]Candidate, error) {
+	if err := in.Validate(); err != nil {
+		return nil, err
+	}
+	if err := constraints.validate(in); err != nil {
+		return nil, err
+	}
+	if opts.Workers < 1 {
+		opts.Workers = runtime.NumCPU()
+	}
+	opts.Population = max(opts.Population, 4)
+	opts.Candidates = max(opts.Candidates, 1)
+	opts.Replications = max(opts.Replications, 1)
+
+	o := &optimizer{
+		in:          in,
+		constraints: constraints,
+		opts:        opts,
+		rng:         rand.New(rand.NewPCG(opts.Seed, 0x6f7074)),
+		cache:       make(map[string]*evaluation),
+	}
+	for _, lc := range constraints.Lights {
+		for i, light := range in.Lights {
+			if light.Name == lc.Name {
+				o.bounds = append(o.bounds, bound{LightConstraints: lc, index: i})
+			}
+		}
+	}
+
+	baseline := o.repair(genome(in.Lights).clone())
+	population := []genome{baseline}
+	for len(population) < opts.Population {
+		population = append(population, o.random())
+	}
+
+	for range opts.Generations {
+		if err := o.evaluate(population); err != nil {
+			return nil, err
+		}
+		population = o.nextGeneration(o.rank(population))
+	}
+	if err := o.evaluate(population); err != nil {
+		return nil, err
+	}
+
+	for _, best := range o.rank(population)[:min(opts.Candidates, len(population))] {
+		if err := o.climb(best); err != nil {
+			return nil, err
+		}
+	}
+
+	all := make([]genome, 0, len(o.cache))
+	for _, e := range o.cache {
+		all = append(all, e.genome)
+	}
+	ranked := o.rank(all)
+
+	candidates := make([]Candidate, 0, opts.Candidates)
+	for i, g := range ranked[:min(opts.Candidates, len(ranked))] {
+		candidate := o.cache[g.key()].result
+		candidate.Rank = i + 1
+		candidates = append(candidates, candidate)
+	}
+	return candidates, nil
+}
+
+func (o *optimizer) random() genome {
+	g := genome(o.in.Lights).clone()
+	for _, lc := range o.bounds {
+		i := lc.index
+		for s := range g[i].Durations {
+			g[i].Durations[s] = lc.Min[s] + o.rng.IntN(lc.Max[s]-lc.Min[s]+1)
+		}
+		if lc.OptimizeOffset {
+			g[i].Offset = o.rng.IntN(o.constraints.MaxCycle)
+		}
+	}
+	return o.repair(g)
+}
+
+// repair возвращает план в допустимую область: границы состояний и цикла.
+func (o *optimizer) repair(g genome) genome {
+	for _, lc := range o.bounds {
+		i := lc.index
+		durations := g[i].Durations
+		cycle := 0
+		for s := range durations {
+			durations[s] = min(max(durations[s], lc.Min[s]), lc.Max[s])
+			cycle += durations[s]
+		}
+
+		for s := 0; cycle < o.constraints.MinCycle; s = (s + 1) % len(durations) {
+			if durations[s] < lc.Max[s] {
+				durations[s]++
+				cycle++
+			}
+		}
+		for s := 0; cycle > o.constraints.MaxCycle; s = (s + 1) % len(durations) {
+			if durations[s] > lc.Min[s] {
+				durations[s]--
+				cycle--
+			}
+		}
+
+		if lc.OptimizeOffset {
+			g[i].Offset = (g[i].Offset%cycle + cycle) % cycle
+		}
+	}
+	return g
+}
+
+func (o *optimizer) mutate(g genome) genome {
+	for _, lc := range o.bounds {
+		i := lc.index
+		for s := range g[i].Durations {
+			if lc.Min[s] == lc.Max[s] || o.rng.Float64() > 0.3 {
+				continue
+			}
+			step := max(1, (lc.Max[s]-lc.Min[s])/5)
+			g[i].Durations[s] += o.rng.IntN(2*step+1) - step
+		}
+		if lc.OptimizeOffset && o.rng.Float64() < 0.3 {
+			g[i].Offset += o.rng.IntN(11) - 5
+		}
+	}
+	return o.repair(g)
+}
+
+func (o *optimizer) crossover(a, b genome) genome {
+	child := a.clone()
+	for i := range child {
+		for s := range child[i].Durations {
+			if o.rng.IntN(2) == 0 {
+				child[i].Durations[s] = b[i].Durations[s]
+			}
+		}
+		if o.rng.IntN(2) == 0 {
+			child[i].Offset = b[i].Offset
+		}
+	}
+	return child
+}
+
+func (o *optimizer) tournament(ranked []genome) genome {
+	best := o.rng.IntN(len(ranked))
+	for range 2 {
+		best = min(best, o.rng.IntN(len(ranked)))
+	}
+	return ranked[best]
+}
+
+func (o *optimizer) nextGeneration(ranked []genome) []genome {
+	elite := max(1, len(ranked)/10)
+	next := make([]genome, 0, len(ranked))
+	for _, g := range ranked[:elite] {
+		next = append(next, g)
+	}
+	for len(next) < len(ranked) {
+		next = append(next, o.mutate(o.crossover(o.tournament(ranked), o.tournament(ranked))))
+	}
+	return next
+}
+
+// climb выполняет локальный спуск: на каждом шаге перебираются соседние планы
+// (±1 и ±5 секунд для каждого изменяемого состояния и смещения) и выбирается лучший.
+func (o *optimizer) climb(start genome) error {
+	current := start
+	for range 50 {
+		var neighbours []genome
+		for _, lc := range o.bounds {
+			i := lc.index
+			for s := range current[i].Durations {
+				if lc.Min[s] == lc.Max[s] {
+					continue
+				}
+				for _, delta := range []int{-5, -1, 1, 5} {
+					n := current.clone()
+					n[i].Durations[s] += delta
+					neighbours = append(neighbours, o.repair(n))
+				}
+			}
+			if lc.OptimizeOffset {
+				for _, delta := range []int{-5, -1, 1, 5} {
+					n := current.clone()
+					n[i].Offset += delta
+					neighbours = append(neighbours, o.repair(n))
+				}
+			}
+		}
+		if len(neighbours) == 0 {
+			return nil
+		}
+		if err := o.evaluate(neighbours); err != nil {
+			return err
+		}
+
+		best := o.rank(append(neighbours, current))[0]
+		if best.key() == current.key() {
+			return nil
+		}
+		current = best
+	}
+	return nil
+}
+
+func (o *optimizer) rank(population []genome) []genome {
+	ranked := append([]genome(nil), population...)
+	sort.SliceStable(ranked, func(i, j int) bool {
+		a, b := o.cache[ranked[i].key()], o.cache[ranked[j].key()]
+		if a.result.Score != b.result.Score {
+			return a.result.Score < b.result.Score
+		}
+		return a.key < b.key
+	})
+
+	unique := ranked[:0]
+	seen := make(map[string]bool, len(ranked))
+	for _, g := range ranked {
+		if !seen[g.key()] {
+			seen[g.key()] = true
+			unique = append(unique, g)
+		}
+	}
+	return unique
+}
+
+// evaluate параллельно симулирует еще не оцененные планы.
+func (o *optimizer) evaluate(population []genome) error {
+	var pending []*evaluation
+	for _, g := range population {
+		key := g.key()
+		if _, ok := o.cache[key]; ok {
+			continue
+		}
+		e := &evaluation{genome: g.clone(), key: key}
+		o.cache[key] = e
+		pending = append(pending, e)
+	}
+
+	jobs := make(chan *evaluation)
+	errs := make(chan error, len(pending))
+	var wg sync.WaitGroup
+	for range min(o.opts.Workers, len(pending)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for e := range jobs {
+				if err := o.simulate(e); err != nil {
+					errs <- err
+				}
+			}
+		}()
+	}
+	for _, e := range pending {
+		jobs <- e
+	}
+	close(jobs)
+	wg.Wait()
+	close(errs)
+
+	if err, ok := <-errs; ok {
+		return errors.Wrap(ErrOptimization, err.Error())
+	}
+	return nil
+}
+
+func (o *optimizer) simulate(e *evaluation) error {
+	in := o.in
+	in.Lights = e.genome
+
+	result := Candidate{Lights: e.genome}
+	for r := range o.opts.Replications {
+		opts := o.opts.Simulation
+		opts.Seed = o.opts.Seed + uint64(r)
+
+		report, err := simulator.Run(in, opts)
+		if err != nil {
+			return err
+		}
+		result.AverageDelay += report.AverageDelay
+		result.Stops += float64(report.Stops)
+		result.Throughput += float64(report.Throughput)
+		if o.constraints.Objective == ObjectiveStops {
+			result.Score += float64(report.Stops)
+		} else {
+			result.Score += report.TotalDelay
+		}
+	}
+
+	n := float64(o.opts.Replications)
+	result.AverageDelay /= n
+	result.Stops /= n
+	result.Throughput /= n
+	result.Score /= n
+	e.result = result
+	return nil
+}
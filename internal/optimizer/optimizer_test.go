@@ -0,0 +1,168 @@
+package optimizer_test
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"trafficlightAPI/internal/models"
+	"trafficlightAPI/internal/optimizer"
+	"trafficlightAPI/internal/simulator"
+
+	"github.com/pkg/errors"
+)
+
+var intersection = simulator.Intersection{
+	Lights: []models.LightDefinition{{Name: "main", Type: 1, Durations: []int{40, 3, 10}}},
+	Approaches: []simulator.Approach{
+		{Name: "north", Light: "main", Movement: simulator.MovementThrough, Rate: 500},
+	},
+}
+
+var constraints = optimizer.Constraints{
+	Objective: optimizer.ObjectiveDelay,
+	MinCycle:  30,
+	MaxCycle:  90,
+	Lights: []optimizer.LightConstraints{
+		{Name: "main", Min: []int{10, 3, 10}, Max: []int{60, 3, 60}},
+	},
+}
+
+func options(workers int) optimizer.Options {
+	return optimizer.Options{
+		Seed:        7,
+		Workers:     workers,
+		Population:  12,
+		Generations: 5,
+		Candidates:  3,
+		Simulation:  simulator.Options{Duration: 1200},
+	}
+}
+
+func TestOptimizeImprovesBaseline(t *testing.T) {
+	baseline, err := simulator.Run(intersection, simulator.Options{Duration: 1200, Seed: 7})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	ranked, err := optimizer.Optimize(intersection, constraints, options(2))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(ranked) != 3 {
+		t.Fatalf("got %d candidates, want 3", len(ranked))
+	}
+
+	for i, c := range ranked {
+		if c.Rank != i+1 || (i > 0 && c.Score < ranked[i-1].Score) {
+			t.Errorf("candidates are not ranked: %+v", ranked)
+		}
+		cycle := 0
+		for s, d := range c.Lights[0].Durations {
+			if d < constraints.Lights[0].Min[s] || d > constraints.Lights[0].Max[s] {
+				t.Errorf("state %d duration %d violates bounds", s+1, d)
+			}
+			cycle += d
+		}
+		if cycle < constraints.MinCycle || cycle > constraints.MaxCycle {
+			t.Errorf("cycle %d violates bounds", cycle)
+		}
+		if _, err := models.NewLight(c.Lights[0]); err != nil {
+			t.Errorf("candidate is not a valid definition: %v", err)
+		}
+	}
+
+	if ranked[0].Score > baseline.TotalDelay {
+		t.Errorf("best candidate %.0f is worse than baseline %.0f", ranked[0].Score, baseline.TotalDelay)
+	}
+}
+
+func TestOptimizeReproducible(t *testing.T) {
+	first, err := optimizer.Optimize(intersection, constraints, options(1))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	second, err := optimizer.Optimize(intersection, constraints, options(4))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(first, second) {
+		t.Errorf("same seed produced different results:\n%+v\n%+v", first, second)
+	}
+}
+
+func TestOptimizeInvalidConstraints(t *testing.T) {
+	tests := []struct {
+		name        string
+		constraints optimizer.Constraints
+	}{
+		{name: "unknown objective", constraints: optimizer.Constraints{Objective: "speed", MinCycle: 30, MaxCycle: 90}},
+		{name: "inverted cycle", constraints: optimizer.Constraints{Objective: optimizer.ObjectiveDelay, MinCycle: 90, MaxCycle: 30}},
+		{
+			name: "unknown light",
+			constraints: optimizer.Constraints{Objective: optimizer.ObjectiveDelay, MinCycle: 30, MaxCycle: 90,
+				Lights: []optimizer.LightConstraints{{Name: "other", Min: []int{1, 1, 1}, Max: []int{2, 2, 2}}}},
+		},
+		{
+			name: "infeasible cycle",
+			constraints: optimizer.Constraints{Objective: optimizer.ObjectiveDelay, MinCycle: 30, MaxCycle: 90,
+				Lights: []optimizer.LightConstraints{{Name: "main", Min: []int{50, 3, 50}, Max: []int{60, 3, 60}}}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := optimizer.Optimize(intersection, tt.constraints, options(1))
+			if !errors.Is(err, optimizer.ErrInvalidConstraints) {
+				t.Errorf("got error '%v', want '%v'", err, optimizer.ErrInvalidConstraints)
+			}
+		})
+	}
+}
+
+// План из examples должен загружаться сервером так же, как plans_path, вместе со смещением.
+func TestWritePlanLoadsOnServer(t *testing.T) {
+	in, err := simulator.LoadIntersection("../../examples/intersection.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := optimizer.LoadConstraints("../../examples/optimizer.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ranked, err := optimizer.Optimize(in, c, options(0))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(t.TempDir(), "plan_01.yaml")
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := optimizer.WritePlan(file, ranked[0]); err != nil {
+		t.Fatal(err)
+	}
+	file.Close()
+
+	defs, err := models.LoadDefinitions(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := models.ApplyDefinitions(defs); err != nil {
+		t.Fatalf("server rejected optimizer plan: %v", err)
+	}
+	for _, light := range ranked[0].Lights {
+		if _, offset, ok := models.Coordinated(light.UUID); !ok || offset != light.Offset {
+			t.Errorf("%s: offset %d (ok %v), want %d", light.Name, offset, ok, light.Offset)
+		}
+	}
+}
+
+func TestWritePlanWithoutUUID(t *testing.T) {
+	candidate := optimizer.Candidate{Lights: []models.LightDefinition{{Name: "a", Type: 3, Durations: []int{20, 10}, Offset: 5}}}
+	if err := optimizer.WritePlan(io.Discard, candidate); !errors.Is(err, optimizer.ErrOptimization) {
+		t.Errorf("got error '%v', want '%v'", err, optimizer.ErrOptimization)
+	}
+}
@@ -0,0 +1,332 @@
+package overrides
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"log/slog"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"trafficlightAPI/internal/audit"
+	"trafficlightAPI/internal/models"
+	"trafficlightAPI/internal/storage"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	ErrInvalidOverride  = errors.New("некорректное ручное управление")
+	ErrOverrideNotFound = errors.New("ручное управление не найдено")
+)
+
+const (
+	ActionForce   = "force"   // Дойти по плану до заданного состояния и держать его
+	ActionHold    = "hold"    // Держать текущее состояние
+	ActionAdvance = "advance" // Однократно перейти к следующему состоянию
+)
+
+// Manager хранит действующие ручные переключения, по одному на светофор.
+// Сроки задаются абсолютным временем, поэтому истечение одинаково на всех репликах.
+type Manager struct {
+	store  storage.Store
+	audit  *audit.Log
+	logger *slog.Logger
+
+	mu     sync.Mutex
+	byUUID map[string]storage.Override
+
+	stop chan struct{}
+	wg   sync.WaitGroup
+}
+
+func NewManager(store storage.Store, auditLog *audit.Log, logger *slog.Logger) *Manager {
+	return &Manager{
+		store:  store,
+		audit:  auditLog,
+		logger: logger,
+		byUUID: make(map[string]storage.Override),
+		stop:   make(chan struct{}),
+	}
+}
+
+// Restore загружает переключения из хранилища from, обычно локального.
+func (m *Manager) Restore(from storage.Store) error {
+	overrides, err := from.Overrides()
+	if err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, o := range overrides {
+		m.byUUID[o.UUID] = o
+	}
+	return nil
+}
+
+func newID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func validate(o storage.Override, trafficType int) error {
+	if o.UUID == "" {
+		return errors.Wrap(ErrInvalidOverride, "отсутствует uuid")
+	}
+	if strings.TrimSpace(o.Reason) == "" {
+		return errors.Wrap(ErrInvalidOverride, "отсутствует причина")
+	}
+	if !o.ExpiresAt.After(o.CreatedAt) {
+		return errors.Wrap(ErrInvalidOverride, "срок действия должен быть положительным")
+	}
+
+	switch o.Action {
+	case ActionHold, ActionAdvance:
+		return nil
+	case ActionForce:
+		if o.State < 1 {
+			return errors.Wrapf(ErrInvalidOverride, "состояние %d", o.State)
+		}
+		if trafficType > 0 && o.State > models.StatesCount(trafficType) {
+			return errors.Wrapf(ErrInvalidOverride, "состояние %d для светофора типа %d", o.State, trafficType)
+		}
+		return nil
+	default:
+		return errors.Wrapf(ErrInvalidOverride, "неизвестное действие %q", o.Action)
+	}
+}
+
+// Create включает ручное управление, заменяя действующее на том же светофоре.
+// trafficType - известный тип светофора для проверки состояния или 0.
+func (m *Manager) Create(o storage.Override, trafficType int) (storage.Override, error) {
+	if o.CreatedAt.IsZero() {
+		o.CreatedAt = time.Now()
+	}
+	o.CreatedAt, o.ExpiresAt = o.CreatedAt.UTC(), o.ExpiresAt.UTC()
+	if err := validate(o, trafficType); err != nil {
+		return storage.Override{}, err
+	}
+	o.ID = newID()
+
+	m.mu.Lock()
+	previous, replaced := m.byUUID[o.UUID]
+	m.mu.Unlock()
+	if replaced {
+		if err := m.release(previous, "заменено "+o.ID, o.User, audit.ActionOverrideRelease); err != nil && !errors.Is(err, storage.ErrNotFound) {
+			return storage.Override{}, err
+		}
+	}
+
+	if err := m.store.PutOverride(o); err != nil {
+		return storage.Override{}, err
+	}
+	m.mu.Lock()
+	m.byUUID[o.UUID] = o
+	m.mu.Unlock()
+
+	m.record(audit.Entry{
+		Time: o.CreatedAt, Action: audit.ActionOverrideCreate, UUID: o.UUID, User: o.User, Reason: o.Reason,
+		Details: map[string]any{"id": o.ID, "action": o.Action, "state": o.State, "expires_at": o.ExpiresAt},
+	})
+	return o, nil
+}
+
+// Release снимает ручное управление по ID.
+func (m *Manager) Release(id, reason, user string) (storage.Override, error) {
+	if strings.TrimSpace(reason) == "" {
+		return storage.Override{}, errors.Wrap(ErrInvalidOverride, "отсутствует причина")
+	}
+
+	m.mu.Lock()
+	var found *storage.Override
+	for _, o := range m.byUUID {
+		if o.ID == id {
+			found = &o
+			break
+		}
+	}
+	m.mu.Unlock()
+	if found == nil {
+		return storage.Override{}, errors.Wrapf(ErrOverrideNotFound, "id:%s", id)
+	}
+
+	if err := m.release(*found, reason, user, audit.ActionOverrideRelease); err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			return storage.Override{}, errors.Wrapf(ErrOverrideNotFound, "id:%s", id)
+		}
+		return storage.Override{}, err
+	}
+	return *found, nil
+}
+
+// release удаляет переключение и пишет аудит, если удаление выполнено этим вызовом.
+func (m *Manager) release(o storage.Override, reason, user, action string) error {
+	m.mu.Lock()
+	if current, ok := m.byUUID[o.UUID]; ok && current.ID == o.ID {
+		delete(m.byUUID, o.UUID)
+	}
+	m.mu.Unlock()
+
+	if err := m.store.DeleteOverride(o.ID); err != nil {
+		return err
+	}
+	m.record(audit.Entry{
+		Action: action, UUID: o.UUID, User: user, Reason: reason,
+		Details: map[string]any{"id": o.ID, "action": o.Action, "reason": o.Reason},
+	})
+	return nil
+}
+
+func (m *Manager) record(e audit.Entry) {
+	if m.audit == nil {
+		return
+	}
+	if err := m.audit.Write(e); err != nil {
+		m.logger.Error("ошибка записи аудита", slog.String("action", e.Action), slog.Any("err", err))
+	}
+}
+
+// Active возвращает действующие на момент now переключения в порядке создания.
+func (m *Manager) Active(now time.Time) []storage.Override {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	active := make([]storage.Override, 0, len(m.byUUID))
+	for _, o := range m.byUUID {
+		if now.Before(o.ExpiresAt) {
+			active = append(active, o)
+		}
+	}
+	sort.Slice(active, func(i, j int) bool {
+		if !active[i].CreatedAt.Equal(active[j].CreatedAt) {
+			return active[i].CreatedAt.Before(active[j].CreatedAt)
+		}
+		return active[i].ID < active[j].ID
+	})
+	return active
+}
+
+// Resolve заменяет ответ /trafficlight для светофора под ручным управлением.
+func (m *Manager) Resolve(data models.TrafficRequest, trafficType int, response *models.TrafficResponse) bool {
+	now := time.Now()
+	m.mu.Lock()
+	o, ok := m.byUUID[data.UUID]
+	if !ok || !now.Before(o.ExpiresAt) || (o.Action == ActionForce && o.State > models.StatesCount(trafficType)) {
+		m.mu.Unlock()
+		return false
+	}
+	if o.Action == ActionAdvance {
+		// Переход выполняется один раз, следующий запрос обрабатывается по плану.
+		delete(m.byUUID, data.UUID)
+	}
+	m.mu.Unlock()
+
+	status := &models.OverrideStatus{ID: o.ID, Action: o.Action, Reason: o.Reason, ExpiresAt: o.ExpiresAt}
+	state := data.CurrentState
+	switch o.Action {
+	case ActionForce:
+		if data.CurrentState != o.State {
+			// До заданного состояния светофор идет по плану, чтобы не пропустить
+			// желтый и другие промежуточные состояния.
+			response.Override = status
+			return true
+		}
+	case ActionAdvance:
+		state = data.CurrentState%models.StatesCount(trafficType) + 1
+		if err := m.release(o, "выполнено", "", audit.ActionOverrideConsume); err != nil && !errors.Is(err, storage.ErrNotFound) {
+			m.logger.Error("ошибка снятия ручного управления", slog.String("id", o.ID), slog.Any("err", err))
+		}
+	}
+
+	response.NextState = strconv.Itoa(state)
+	response.NextCountdownTime = ""
+	response.Override = status
+	return true
+}
+
+// Pinned сообщает, что ручное управление удерживает текущее состояние светофора.
+func (m *Manager) Pinned(data models.TrafficRequest, trafficType int) bool {
+	m.mu.Lock()
+	o, ok := m.byUUID[data.UUID]
+	m.mu.Unlock()
+	if !ok || !time.Now().Before(o.ExpiresAt) {
+		return false
+	}
+	return o.Action == ActionHold || (o.Action == ActionForce && o.State == data.CurrentState)
+}
+
+// Expire снимает истекшие к моменту now переключения.
+func (m *Manager) Expire(now time.Time) {
+	m.mu.Lock()
+	var expired []storage.Override
+	for _, o := range m.byUUID {
+		if !now.Before(o.ExpiresAt) {
+			expired = append(expired, o)
+		}
+	}
+	m.mu.Unlock()
+
+	for _, o := range expired {
+		// Другая реплика могла уже снять переключение.
+		if err := m.release(o, "истек срок", "", audit.ActionOverrideExpire); err != nil && !errors.Is(err, storage.ErrNotFound) {
+			m.logger.Error("ошибка снятия истекшего ручного управления", slog.String("id", o.ID), slog.Any("err", err))
+		}
+	}
+}
+
+// RunExpiry периодически снимает истекшие переключения до вызова Close.
+func (m *Manager) RunExpiry(interval time.Duration) {
+	m.wg.Add(1)
+	go func() {
+		defer m.wg.Done()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-m.stop:
+				return
+			case now := <-ticker.C:
+				m.Expire(now)
+			}
+		}
+	}()
+}
+
+// ApplyMutation обновляет память по изменению, примененному другим узлом кластера.
+func (m *Manager) ApplyMutation(mu storage.Mutation) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	switch {
+	case mu.Op == storage.OpPutOverride && mu.Override != nil:
+		m.byUUID[mu.Override.UUID] = *mu.Override
+	case mu.Op == storage.OpDeleteOverride:
+		for uuid, o := range m.byUUID {
+			if o.ID == mu.Key {
+				delete(m.byUUID, uuid)
+			}
+		}
+	}
+}
+
+func (m *Manager) Close() {
+	close(m.stop)
+	m.wg.Wait()
+}
+
+var (
+	defaultMu      sync.RWMutex
+	defaultManager *Manager
+)
+
+func SetDefault(m *Manager) {
+	defaultMu.Lock()
+	defer defaultMu.Unlock()
+	defaultManager = m
+}
+
+func Default() *Manager {
+	defaultMu.RLock()
+	defer defaultMu.RUnlock()
+	return defaultManager
+}
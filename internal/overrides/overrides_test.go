@@ -0,0 +1,204 @@
+package overrides_test
+
+import (
+	"bufio"
+	"encoding/json"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+	"trafficlightAPI/internal/audit"
+	"trafficlightAPI/internal/models"
+	"trafficlightAPI/internal/overrides"
+	"trafficlightAPI/internal/storage"
+
+	"github.com/pkg/errors"
+)
+
+func intPtr(v int) *int { return &v }
+
+func newManager(t *testing.T) (*overrides.Manager, storage.Store, string) {
+	t.Helper()
+	dir := t.TempDir()
+	store, err := storage.OpenBolt(filepath.Join(dir, "state.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { store.Close() })
+
+	auditPath := filepath.Join(dir, "audit.log")
+	auditLog, err := audit.Open(auditPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { auditLog.Close() })
+
+	return overrides.NewManager(store, auditLog, slog.Default()), store, auditPath
+}
+
+func auditActions(t *testing.T, path string) []string {
+	t.Helper()
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	var actions []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var e audit.Entry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			t.Fatal(err)
+		}
+		actions = append(actions, e.Action)
+	}
+	return actions
+}
+
+func TestResolve(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		name      string
+		override  storage.Override
+		request   models.TrafficRequest
+		wantState string
+		active    bool // Остается ли действующим после запроса
+	}{
+		{
+			name:      "force",
+			override:  storage.Override{UUID: "a", Action: overrides.ActionForce, State: 1, Reason: "ДТП"},
+			request:   models.TrafficRequest{UUID: "a", CurrentState: 3, CurrentTime: intPtr(19)},
+			wantState: "1", active: true,
+		},
+		{
+			name:      "force goes through the plan",
+			override:  storage.Override{UUID: "a", Action: overrides.ActionForce, State: 2, Reason: "ДТП"},
+			request:   models.TrafficRequest{UUID: "a", CurrentState: 3, CurrentTime: intPtr(19)},
+			wantState: "1", active: true,
+		},
+		{
+			name:      "force holds the target",
+			override:  storage.Override{UUID: "a", Action: overrides.ActionForce, State: 2, Reason: "ДТП"},
+			request:   models.TrafficRequest{UUID: "a", CurrentState: 2, CurrentTime: intPtr(25)},
+			wantState: "2", active: true,
+		},
+		{
+			name:      "hold",
+			override:  storage.Override{UUID: "a", Action: overrides.ActionHold, Reason: "ремонт"},
+			request:   models.TrafficRequest{UUID: "a", CurrentState: 2, CurrentTime: intPtr(19)},
+			wantState: "2", active: true,
+		},
+		{
+			name:      "advance",
+			override:  storage.Override{UUID: "a", Action: overrides.ActionAdvance, Reason: "колонна"},
+			request:   models.TrafficRequest{UUID: "a", CurrentState: 3, CurrentTime: intPtr(0)},
+			wantState: "1", active: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			manager, store, _ := newManager(t)
+			tt.override.CreatedAt, tt.override.ExpiresAt = now, now.Add(time.Minute)
+			created, err := manager.Create(tt.override, 1)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			response, err := models.Light(1).GetNextState(tt.request)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !manager.Resolve(tt.request, 1, &response) {
+				t.Fatal("override not applied")
+			}
+			if response.NextState != tt.wantState || response.Override == nil || response.Override.ID != created.ID {
+				t.Errorf("response = %+v, want state %s with override %s", response, tt.wantState, created.ID)
+			}
+
+			stored, err := store.Overrides()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if active := len(manager.Active(now)) == 1; active != tt.active || (len(stored) == 1) != tt.active {
+				t.Errorf("active = %v, stored = %d, want %v", active, len(stored), tt.active)
+			}
+
+			// Другие светофоры не затрагиваются.
+			if manager.Resolve(models.TrafficRequest{UUID: "b", CurrentState: 1, CurrentTime: intPtr(0)}, 1, &response) {
+				t.Error("override applied to another light")
+			}
+		})
+	}
+}
+
+func TestCreateValidation(t *testing.T) {
+	manager, _, _ := newManager(t)
+	now := time.Now()
+	tests := []struct {
+		name     string
+		override storage.Override
+	}{
+		{name: "no reason", override: storage.Override{UUID: "a", Action: overrides.ActionHold, ExpiresAt: now.Add(time.Minute)}},
+		{name: "no uuid", override: storage.Override{Action: overrides.ActionHold, Reason: "x", ExpiresAt: now.Add(time.Minute)}},
+		{name: "unknown action", override: storage.Override{UUID: "a", Action: "flash", Reason: "x", ExpiresAt: now.Add(time.Minute)}},
+		{name: "state out of range", override: storage.Override{UUID: "a", Action: overrides.ActionForce, State: 4, Reason: "x", ExpiresAt: now.Add(time.Minute)}},
+		{name: "expired", override: storage.Override{UUID: "a", Action: overrides.ActionHold, Reason: "x", ExpiresAt: now.Add(-time.Minute)}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.override.CreatedAt = now
+			if _, err := manager.Create(tt.override, 1); !errors.Is(err, overrides.ErrInvalidOverride) {
+				t.Errorf("err = %v, want ErrInvalidOverride", err)
+			}
+		})
+	}
+}
+
+func TestLifecycleAudit(t *testing.T) {
+	manager, _, auditPath := newManager(t)
+	now := time.Now()
+
+	first, err := manager.Create(storage.Override{UUID: "a", Action: overrides.ActionHold, Reason: "ремонт", CreatedAt: now, ExpiresAt: now.Add(time.Minute)}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Новое переключение заменяет действующее на том же светофоре.
+	if _, err := manager.Create(storage.Override{UUID: "a", Action: overrides.ActionForce, State: 1, Reason: "ДТП", CreatedAt: now, ExpiresAt: now.Add(time.Minute)}, 0); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := manager.Release(first.ID, "ошибка", "operator"); !errors.Is(err, overrides.ErrOverrideNotFound) {
+		t.Errorf("release replaced: err = %v, want ErrOverrideNotFound", err)
+	}
+	if _, err := manager.Create(storage.Override{UUID: "b", Action: overrides.ActionHold, Reason: "ремонт", CreatedAt: now, ExpiresAt: now.Add(time.Second)}, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	manager.Expire(now.Add(2 * time.Second))
+	active := manager.Active(now.Add(2 * time.Second))
+	if len(active) != 1 || active[0].UUID != "a" {
+		t.Fatalf("active = %+v, want only a", active)
+	}
+	if _, err := manager.Release(active[0].ID, "", "operator"); !errors.Is(err, overrides.ErrInvalidOverride) {
+		t.Errorf("release without reason: err = %v, want ErrInvalidOverride", err)
+	}
+	if _, err := manager.Release(active[0].ID, "движение восстановлено", "operator"); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{
+		audit.ActionOverrideCreate, audit.ActionOverrideRelease, audit.ActionOverrideCreate,
+		audit.ActionOverrideCreate, audit.ActionOverrideExpire, audit.ActionOverrideRelease,
+	}
+	got := auditActions(t, auditPath)
+	if len(got) != len(want) {
+		t.Fatalf("audit = %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("audit[%d] = %s, want %s", i, got[i], want[i])
+		}
+	}
+}
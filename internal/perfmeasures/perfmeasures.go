@@ -0,0 +1,352 @@
+package perfmeasures
+
+import (
+	"math"
+	"slices"
+	"sort"
+	"time"
+	"trafficlightAPI/internal/atspm"
+	"trafficlightAPI/internal/image_generator"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	ErrUnknownFormat = errors.New("неизвестный формат диаграммы")
+)
+
+const (
+	splitFailureThreshold = 0.8             // Порог GOR и ROR5
+	redOccupancyWindow    = 5 * time.Second // Начало красного для ROR5
+)
+
+type Options struct {
+	Detectors []int // Каналы детекторов фазы, пусто - все
+}
+
+// Cycle - цикл основной фазы в терминах ATSPM: от начала красного до начала следующего красного.
+type Cycle struct {
+	Start       time.Time `json:"start"`
+	GreenStart  time.Time `json:"green_start"`
+	YellowStart time.Time `json:"yellow_start"`
+	End         time.Time `json:"end"`
+}
+
+func (c Cycle) Green() time.Duration  { return c.YellowStart.Sub(c.GreenStart) }
+func (c Cycle) Yellow() time.Duration { return c.End.Sub(c.YellowStart) }
+func (c Cycle) Red() time.Duration    { return c.GreenStart.Sub(c.Start) }
+
+type ArrivalsOnGreen struct {
+	Arrivals int     `json:"arrivals"`
+	OnGreen  int     `json:"on_green"`
+	Percent  float64 `json:"percent"`
+}
+
+type Split struct {
+	CycleStart time.Time `json:"cycle_start"`
+	Green      float64   `json:"green"`
+	Yellow     float64   `json:"yellow"`
+	Red        float64   `json:"red"`
+}
+
+type SplitMonitor struct {
+	Splits  []Split `json:"splits"`
+	Average float64 `json:"average"`
+	Min     float64 `json:"min"`
+	Max     float64 `json:"max"`
+	P85     float64 `json:"p85"`
+}
+
+type PedestrianDelay struct {
+	Calls   int     `json:"calls"`
+	Average float64 `json:"average"`
+	Max     float64 `json:"max"`
+}
+
+type SplitFailure struct {
+	CycleStart     time.Time `json:"cycle_start"`
+	GreenOccupancy float64   `json:"green_occupancy"`
+	RedOccupancy5s float64   `json:"red_occupancy_5s"`
+	Failed         bool      `json:"failed"`
+}
+
+type SplitFailures struct {
+	Count  int            `json:"count"`
+	Cycles []SplitFailure `json:"cycles"`
+}
+
+type Report struct {
+	SignalID        string          `json:"signal_id"`
+	From            time.Time       `json:"from"`
+	To              time.Time       `json:"to"`
+	Cycles          int             `json:"cycles"`
+	ArrivalsOnGreen ArrivalsOnGreen `json:"arrivals_on_green"`
+	SplitMonitor    SplitMonitor    `json:"split_monitor"`
+	PedestrianDelay PedestrianDelay `json:"pedestrian_delay"`
+	SplitFailures   SplitFailures   `json:"split_failures"`
+}
+
+type interval struct {
+	start, end time.Time
+}
+
+func (o Options) detector(param int) bool {
+	return len(o.Detectors) == 0 || slices.Contains(o.Detectors, param)
+}
+
+// Cycles восстанавливает полные циклы основной фазы: цикл начинается с красного
+// (код 10), зеленый - с кода 1, конец зеленого - код 8 или, у светофоров без
+// желтого после зеленого (обычный светофор переходит из зеленого в красный),
+// код 10 следующего цикла. Тогда желтый цикла нулевой.
+func Cycles(events []atspm.Event) []Cycle {
+	var cycles []Cycle
+	var current *Cycle
+	for _, e := range events {
+		if e.Param != atspm.VehiclePhase {
+			continue
+		}
+		switch e.Code {
+		case atspm.CodePhaseBeginRedClearance:
+			if current != nil && !current.GreenStart.IsZero() {
+				if current.YellowStart.IsZero() {
+					current.YellowStart = e.Timestamp
+				}
+				current.End = e.Timestamp
+				cycles = append(cycles, *current)
+			}
+			current = &Cycle{Start: e.Timestamp}
+		case atspm.CodePhaseBeginGreen:
+			if current != nil {
+				current.GreenStart = e.Timestamp
+			}
+		case atspm.CodePhaseBeginYellowClearance:
+			if current != nil && !current.GreenStart.IsZero() {
+				current.YellowStart = e.Timestamp
+			}
+		}
+	}
+	return cycles
+}
+
+func cycleAt(cycles []Cycle, t time.Time) (int, bool) {
+	i := sort.Search(len(cycles), func(i int) bool { return cycles[i].End.After(t) })
+	if i < len(cycles) && !t.Before(cycles[i].Start) {
+		return i, true
+	}
+	return 0, false
+}
+
+// Arrivals возвращает моменты срабатывания детекторов фазы.
+func Arrivals(events []atspm.Event, opts Options) []time.Time {
+	var arrivals []time.Time
+	for _, e := range events {
+		if e.Code == atspm.CodeDetectorOn && opts.detector(e.Param) {
+			arrivals = append(arrivals, e.Timestamp)
+		}
+	}
+	return arrivals
+}
+
+// occupancy - интервалы занятости детекторов по парам событий включения и выключения.
+func occupancy(events []atspm.Event, opts Options) []interval {
+	on := make(map[int]time.Time)
+	var intervals []interval
+	for _, e := range events {
+		if !opts.detector(e.Param) {
+			continue
+		}
+		switch e.Code {
+		case atspm.CodeDetectorOn:
+			on[e.Param] = e.Timestamp
+		case atspm.CodeDetectorOff:
+			if start, ok := on[e.Param]; ok {
+				intervals = append(intervals, interval{start: start, end: e.Timestamp})
+				delete(on, e.Param)
+			}
+		}
+	}
+	return intervals
+}
+
+// occupied возвращает долю окна, в течение которой был занят хотя бы один детектор.
+func occupied(intervals []interval, window interval) float64 {
+	length := window.end.Sub(window.start)
+	if length <= 0 {
+		return 0
+	}
+
+	var clipped []interval
+	for _, iv := range intervals {
+		start, end := maxTime(iv.start, window.start), minTime(iv.end, window.end)
+		if end.After(start) {
+			clipped = append(clipped, interval{start: start, end: end})
+		}
+	}
+	sort.Slice(clipped, func(i, j int) bool { return clipped[i].start.Before(clipped[j].start) })
+
+	var total time.Duration
+	var cursor time.Time
+	for _, iv := range clipped {
+		start := maxTime(iv.start, cursor)
+		if iv.end.After(start) {
+			total += iv.end.Sub(start)
+			cursor = iv.end
+		}
+	}
+	return float64(total) / float64(length)
+}
+
+// Load читает события светофора из журнала контроллера.
+func Load(recorder *atspm.Recorder, signalID string, from, to time.Time) ([]atspm.Event, error) {
+	var events []atspm.Event
+	err := recorder.Query(signalID, from, to, func(e atspm.Event) error {
+		events = append(events, e)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.SliceStable(events, func(i, j int) bool { return events[i].Timestamp.Before(events[j].Timestamp) })
+	return events, nil
+}
+
+func Compute(signalID string, from, to time.Time, events []atspm.Event, opts Options) Report {
+	report := Report{SignalID: signalID, From: from, To: to}
+	cycles := Cycles(events)
+	report.Cycles = len(cycles)
+
+	for _, t := range Arrivals(events, opts) {
+		i, ok := cycleAt(cycles, t)
+		if !ok {
+			continue
+		}
+		report.ArrivalsOnGreen.Arrivals++
+		if !t.Before(cycles[i].GreenStart) && t.Before(cycles[i].YellowStart) {
+			report.ArrivalsOnGreen.OnGreen++
+		}
+	}
+	if report.ArrivalsOnGreen.Arrivals > 0 {
+		report.ArrivalsOnGreen.Percent = 100 * float64(report.ArrivalsOnGreen.OnGreen) / float64(report.ArrivalsOnGreen.Arrivals)
+	}
+
+	report.SplitMonitor = splitMonitor(cycles)
+	report.PedestrianDelay = pedestrianDelay(events)
+
+	intervals := occupancy(events, opts)
+	report.SplitFailures.Cycles = []SplitFailure{}
+	for i, c := range cycles {
+		failure := SplitFailure{
+			CycleStart:     c.Start,
+			GreenOccupancy: occupied(intervals, interval{start: c.GreenStart, end: c.YellowStart}),
+		}
+		// ROR5 считается по началу красного, следующего за зеленым этого цикла.
+		red := interval{start: c.End, end: c.End.Add(redOccupancyWindow)}
+		if i+1 < len(cycles) {
+			red.end = minTime(red.end, cycles[i+1].GreenStart)
+		}
+		failure.RedOccupancy5s = occupied(intervals, red)
+		failure.Failed = failure.GreenOccupancy >= splitFailureThreshold && failure.RedOccupancy5s >= splitFailureThreshold
+		if failure.Failed {
+			report.SplitFailures.Count++
+		}
+		report.SplitFailures.Cycles = append(report.SplitFailures.Cycles, failure)
+	}
+
+	return report
+}
+
+// Diagram рисует диаграмму координации Purdue в формате png или svg.
+func Diagram(from, to time.Time, events []atspm.Event, opts Options, format string) ([]byte, error) {
+	cycles := Cycles(events)
+	points := make([]image_generator.PurdueCycle, len(cycles))
+	for i, c := range cycles {
+		points[i] = image_generator.PurdueCycle{
+			Start:  c.Start.Sub(from).Seconds(),
+			Green:  c.GreenStart.Sub(c.Start).Seconds(),
+			Yellow: c.YellowStart.Sub(c.Start).Seconds(),
+			End:    c.End.Sub(c.Start).Seconds(),
+		}
+	}
+	for _, t := range Arrivals(events, opts) {
+		if i, ok := cycleAt(cycles, t); ok {
+			points[i].Arrivals = append(points[i].Arrivals, t.Sub(cycles[i].Start).Seconds())
+		}
+	}
+
+	window := to.Sub(from).Seconds()
+	switch format {
+	case "", "png":
+		return image_generator.PurdueDiagram(points, window)
+	case "svg":
+		return image_generator.PurdueDiagramSVG(points, window), nil
+	default:
+		return nil, errors.Wrapf(ErrUnknownFormat, "format:%s", format)
+	}
+}
+
+func splitMonitor(cycles []Cycle) SplitMonitor {
+	monitor := SplitMonitor{Splits: []Split{}}
+	if len(cycles) == 0 {
+		return monitor
+	}
+
+	greens := make([]float64, 0, len(cycles))
+	for _, c := range cycles {
+		monitor.Splits = append(monitor.Splits, Split{
+			CycleStart: c.Start,
+			Green:      c.Green().Seconds(),
+			Yellow:     c.Yellow().Seconds(),
+			Red:        c.Red().Seconds(),
+		})
+		greens = append(greens, c.Green().Seconds())
+		monitor.Average += c.Green().Seconds()
+	}
+	monitor.Average /= float64(len(cycles))
+
+	sort.Float64s(greens)
+	monitor.Min, monitor.Max = greens[0], greens[len(greens)-1]
+	monitor.P85 = greens[int(math.Ceil(0.85*float64(len(greens))))-1]
+	return monitor
+}
+
+// pedestrianDelay - время от первого вызова до начала разрешающего сигнала.
+func pedestrianDelay(events []atspm.Event) PedestrianDelay {
+	var delay PedestrianDelay
+	var call time.Time
+	var total float64
+	for _, e := range events {
+		switch e.Code {
+		case atspm.CodePedestrianCallRegistered:
+			if call.IsZero() {
+				call = e.Timestamp
+			}
+		case atspm.CodePedestrianBeginWalk:
+			if call.IsZero() {
+				continue
+			}
+			d := e.Timestamp.Sub(call).Seconds()
+			delay.Calls++
+			total += d
+			delay.Max = math.Max(delay.Max, d)
+			call = time.Time{}
+		}
+	}
+	if delay.Calls > 0 {
+		delay.Average = total / float64(delay.Calls)
+	}
+	return delay
+}
+
+func minTime(a, b time.Time) time.Time {
+	if a.Before(b) {
+		return a
+	}
+	return b
+}
+
+func maxTime(a, b time.Time) time.Time {
+	if a.After(b) {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,171 @@
+package perfmeasures_test
+
+import (
+	"bytes"
+	"log/slog"
+	"math"
+	"strconv"
+	"testing"
+	"time"
+	"trafficlightAPI/internal/atspm"
+	"trafficlightAPI/internal/models"
+	"trafficlightAPI/internal/perfmeasures"
+)
+
+var base = time.Date(2026, 10, 19, 8, 0, 0, 0, time.UTC)
+
+func at(seconds float64, code, param int) atspm.Event {
+	return atspm.Event{SignalID: "x", Timestamp: base.Add(time.Duration(seconds * float64(time.Second))), Code: code, Param: param}
+}
+
+// cycles пишет в журнал контроллера смены состояний обычного светофора с планом
+// [27, 3, 30] за n циклов по 60с и читает их обратно: красный 0-27, желтый 27-30,
+// зеленый 30-60, желтого после зеленого у обычного светофора нет.
+func cycles(t *testing.T, n int) []atspm.Event {
+	t.Helper()
+	recorder, err := atspm.NewRecorder(t.TempDir(), 0, 0, slog.Default())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer recorder.Close()
+
+	light, err := models.Light(1).WithPlan([]int{27, 3, 30})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	record := func(from, to int, seconds int) {
+		tr := models.Transition{UUID: "x", Type: 1, From: from, To: to, Time: base.Add(time.Duration(seconds) * time.Second)}
+		if err := recorder.RecordTransition(tr); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	record(3, 1, 0)
+	state, elapsed := 1, 0
+	for second := 1; second <= 60*n; second++ {
+		response, err := light.GetNextState(models.TrafficRequest{UUID: "x", CurrentState: state, CurrentTime: &elapsed})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		next, _ := strconv.Atoi(response.NextState)
+		if next == state {
+			elapsed++
+			continue
+		}
+		record(state, next, second)
+		state, elapsed = next, 0
+	}
+
+	events, err := perfmeasures.Load(recorder, "x", base, base.Add(time.Duration(60*n+1)*time.Second))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return events
+}
+
+func merge(groups ...[]atspm.Event) []atspm.Event {
+	var events []atspm.Event
+	for _, g := range groups {
+		events = append(events, g...)
+	}
+	for i := 1; i < len(events); i++ {
+		for j := i; j > 0 && events[j].Timestamp.Before(events[j-1].Timestamp); j-- {
+			events[j], events[j-1] = events[j-1], events[j]
+		}
+	}
+	return events
+}
+
+func TestCompute(t *testing.T) {
+	tests := []struct {
+		name          string
+		events        []atspm.Event
+		opts          perfmeasures.Options
+		cycles        int
+		arrivals      int
+		onGreen       int
+		pedCalls      int
+		pedAverage    float64
+		splitFailures int
+	}{
+		{
+			name:   "cycles only",
+			events: cycles(t, 3),
+			cycles: 3,
+		},
+		{
+			name: "arrivals on green",
+			events: merge(cycles(t, 2), []atspm.Event{
+				at(10, atspm.CodeDetectorOn, 1), at(40, atspm.CodeDetectorOn, 1),
+				at(100, atspm.CodeDetectorOn, 1), at(110, atspm.CodeDetectorOn, 1),
+				at(500, atspm.CodeDetectorOn, 1),
+			}),
+			cycles: 2, arrivals: 4, onGreen: 3,
+		},
+		{
+			name: "detector filter",
+			events: merge(cycles(t, 1), []atspm.Event{
+				at(40, atspm.CodeDetectorOn, 1), at(41, atspm.CodeDetectorOn, 2),
+			}),
+			opts:   perfmeasures.Options{Detectors: []int{2}},
+			cycles: 1, arrivals: 1, onGreen: 1,
+		},
+		{
+			name: "pedestrian delay",
+			events: merge(cycles(t, 1), []atspm.Event{
+				at(5, atspm.CodePedestrianCallRegistered, 2), at(8, atspm.CodePedestrianCallRegistered, 2),
+				at(25, atspm.CodePedestrianBeginWalk, 2),
+				at(40, atspm.CodePedestrianCallRegistered, 2), at(50, atspm.CodePedestrianBeginWalk, 2),
+			}),
+			cycles: 1, pedCalls: 2, pedAverage: 15,
+		},
+		{
+			name: "split failure",
+			events: merge(cycles(t, 2), []atspm.Event{
+				at(30, atspm.CodeDetectorOn, 1), at(66, atspm.CodeDetectorOff, 1),
+			}),
+			cycles: 2, arrivals: 1, onGreen: 1, splitFailures: 1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			report := perfmeasures.Compute("x", base, base.Add(time.Hour), tt.events, tt.opts)
+			if report.Cycles != tt.cycles {
+				t.Errorf("cycles = %d, want %d", report.Cycles, tt.cycles)
+			}
+			if report.ArrivalsOnGreen.Arrivals != tt.arrivals || report.ArrivalsOnGreen.OnGreen != tt.onGreen {
+				t.Errorf("arrivals = %+v, want %d/%d", report.ArrivalsOnGreen, tt.onGreen, tt.arrivals)
+			}
+			if report.PedestrianDelay.Calls != tt.pedCalls || math.Abs(report.PedestrianDelay.Average-tt.pedAverage) > 1e-9 {
+				t.Errorf("pedestrian delay = %+v, want %d calls, %.1f average", report.PedestrianDelay, tt.pedCalls, tt.pedAverage)
+			}
+			if report.SplitFailures.Count != tt.splitFailures {
+				t.Errorf("split failures = %+v, want %d", report.SplitFailures, tt.splitFailures)
+			}
+			for _, split := range report.SplitMonitor.Splits {
+				if split.Green != 30 || split.Yellow != 0 || split.Red != 30 {
+					t.Errorf("split = %+v, want 30/0/30", split)
+				}
+			}
+		})
+	}
+}
+
+func TestDiagram(t *testing.T) {
+	events := merge(cycles(t, 2), []atspm.Event{at(40, atspm.CodeDetectorOn, 1)})
+
+	png, err := perfmeasures.Diagram(base, base.Add(2*time.Minute), events, perfmeasures.Options{}, "png")
+	if err != nil || !bytes.HasPrefix(png, []byte("\x89PNG")) {
+		t.Fatalf("png: err = %v, prefix = %q", err, png[:min(len(png), 4)])
+	}
+
+	svg, err := perfmeasures.Diagram(base, base.Add(2*time.Minute), events, perfmeasures.Options{}, "svg")
+	if err != nil || !bytes.Contains(svg, []byte("<circle")) {
+		t.Fatalf("svg: err = %v, %s", err, svg)
+	}
+
+	if _, err := perfmeasures.Diagram(base, base.Add(time.Minute), events, perfmeasures.Options{}, "gif"); err == nil {
+		t.Fatal("expected error for unknown format")
+	}
+}
@@ -0,0 +1,79 @@
+package simulator
+
+import (
+	"encoding/csv"
+	"io"
+	"math/rand/v2"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	ErrInvalidCounts = errors.New("некорректный файл подсчетов")
+)
+
+// Count - число машин, прибывших на подход за интервал [Start, Start+Duration) секунд.
+type Count struct {
+	Approach string
+	Start    float64
+	Duration float64
+	Count    int
+}
+
+// ReadCounts разбирает CSV с заголовком approach,start,duration,count.
+func ReadCounts(r io.Reader) ([]Count, error) {
+	reader := csv.NewReader(r)
+	reader.TrimLeadingSpace = true
+
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, errors.Wrap(ErrInvalidCounts, err.Error())
+	}
+	if len(records) == 0 {
+		return nil, errors.Wrap(ErrInvalidCounts, "пустой файл")
+	}
+
+	header := strings.Join(records[0], ",")
+	if header != "approach,start,duration,count" {
+		return nil, errors.Wrapf(ErrInvalidCounts, "неожиданный заголовок %q", header)
+	}
+
+	counts := make([]Count, 0, len(records)-1)
+	for i, record := range records[1:] {
+		start, err1 := strconv.ParseFloat(record[1], 64)
+		duration, err2 := strconv.ParseFloat(record[2], 64)
+		count, err3 := strconv.Atoi(record[3])
+		if err1 != nil || err2 != nil || err3 != nil || start < 0 || duration <= 0 || count < 0 {
+			return nil, errors.Wrapf(ErrInvalidCounts, "строка %d: %v", i+2, record)
+		}
+		counts = append(counts, Count{Approach: record[0], Start: start, Duration: duration, Count: count})
+	}
+	return counts, nil
+}
+
+func LoadCounts(path string) ([]Count, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, errors.Wrap(ErrInvalidCounts, err.Error())
+	}
+	defer file.Close()
+	return ReadCounts(file)
+}
+
+// poissonArrivals генерирует моменты прибытия пуассоновского потока с
+// интенсивностью rate авт/ч на интервале [start, end).
+func poissonArrivals(rng *rand.Rand, rate, start, end float64) []float64 {
+	if rate <= 0 {
+		return nil
+	}
+	perSecond := rate / 3600
+
+	var arrivals []float64
+	for t := start + rng.ExpFloat64()/perSecond; t < end; t += rng.ExpFloat64() / perSecond {
+		arrivals = append(arrivals, t)
+	}
+	return arrivals
+}
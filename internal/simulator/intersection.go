@@ -0,0 +1,85 @@
+package simulator
+
+import (
+	"os"
+	"trafficlightAPI/internal/models"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+)
+
+var (
+	ErrInvalidIntersection = errors.New("некорректное описание перекрестка")
+)
+
+const (
+	MovementThrough    = "through"
+	MovementRight      = "right"
+	MovementPedestrian = "pedestrian"
+
+	defaultSaturationFlow = 1800
+)
+
+type Approach struct {
+	Name           string  `yaml:"name" json:"name"`
+	Light          string  `yaml:"light" json:"light"`
+	Movement       string  `yaml:"movement,omitempty" json:"movement,omitempty"`
+	Rate           float64 `yaml:"rate,omitempty" json:"rate,omitempty"`                       // авт/ч, пуассоновский поток
+	SaturationFlow float64 `yaml:"saturation_flow,omitempty" json:"saturation_flow,omitempty"` // авт/ч зеленого
+}
+
+type Intersection struct {
+	Lights     []models.LightDefinition `yaml:"lights" json:"lights"`
+	Approaches []Approach               `yaml:"approaches" json:"approaches"`
+}
+
+func LoadIntersection(path string) (Intersection, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Intersection{}, errors.Wrap(ErrInvalidIntersection, err.Error())
+	}
+
+	var intersection Intersection
+	if err := yaml.Unmarshal(data, &intersection); err != nil {
+		return Intersection{}, errors.Wrap(ErrInvalidIntersection, err.Error())
+	}
+	return intersection, intersection.Validate()
+}
+
+func (in Intersection) Validate() error {
+	names := make(map[string]bool, len(in.Lights))
+	for _, light := range in.Lights {
+		if light.Name == "" || names[light.Name] {
+			return errors.Wrapf(ErrInvalidIntersection, "пустое или повторяющееся имя светофора %q", light.Name)
+		}
+		names[light.Name] = true
+		if _, err := models.NewLight(light); err != nil {
+			return errors.Wrapf(ErrInvalidIntersection, "светофор %q: %v", light.Name, err)
+		}
+	}
+
+	for _, approach := range in.Approaches {
+		if !names[approach.Light] {
+			return errors.Wrapf(ErrInvalidIntersection, "подход %q ссылается на неизвестный светофор %q", approach.Name, approach.Light)
+		}
+		switch approach.Movement {
+		case "", MovementThrough, MovementRight, MovementPedestrian:
+		default:
+			return errors.Wrapf(ErrInvalidIntersection, "подход %q: неизвестное направление %q", approach.Name, approach.Movement)
+		}
+		if approach.Rate < 0 || approach.SaturationFlow < 0 {
+			return errors.Wrapf(ErrInvalidIntersection, "подход %q: отрицательная интенсивность", approach.Name)
+		}
+	}
+	return nil
+}
+
+// served сообщает, разрешено ли движение для направления при данном наборе сигналов.
+func served(movement string, lamps models.Lamps) bool {
+	switch movement {
+	case MovementRight:
+		return lamps.Green || lamps.Arrow
+	default:
+		return lamps.Green
+	}
+}
@@ -0,0 +1,322 @@
+package simulator
+
+import (
+	"container/heap"
+	"math/rand/v2"
+	"sort"
+	"strconv"
+	"trafficlightAPI/internal/models"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	ErrSimulation = errors.New("ошибка симуляции")
+)
+
+type Options struct {
+	Duration float64 // Длительность симуляции, с
+	Seed     uint64
+	Counts   []Count // Если заданы, заменяют Rate подходов, для которых есть подсчеты
+}
+
+type ApproachStats struct {
+	Name         string  `json:"name"`
+	Arrivals     int     `json:"arrivals"`
+	Throughput   int     `json:"throughput"`
+	AverageDelay float64 `json:"average_delay"` // с/авт среди проехавших
+	MaxQueue     int     `json:"max_queue"`
+	Stops        int     `json:"stops"`     // Включая стоящие в очереди на момент окончания
+	Remaining    int     `json:"remaining"` // Очередь на момент окончания
+}
+
+type Report struct {
+	Duration     float64         `json:"duration"`
+	Seed         uint64          `json:"seed"`
+	TotalDelay   float64         `json:"total_delay"` // авт*с, включая ожидание оставшихся в очереди
+	AverageDelay float64         `json:"average_delay"`
+	Stops        int             `json:"stops"`
+	Throughput   int             `json:"throughput"`
+	Approaches   []ApproachStats `json:"approaches"`
+}
+
+const (
+	eventSignal = iota
+	eventDischarge
+	eventArrival
+)
+
+type event struct {
+	time  float64
+	kind  int
+	index int
+	seq   int
+}
+
+type eventQueue []event
+
+func (q eventQueue) Len() int { return len(q) }
+func (q eventQueue) Less(i, j int) bool {
+	if q[i].time != q[j].time {
+		return q[i].time < q[j].time
+	}
+	if q[i].kind != q[j].kind {
+		return q[i].kind < q[j].kind
+	}
+	return q[i].seq < q[j].seq
+}
+func (q eventQueue) Swap(i, j int) { q[i], q[j] = q[j], q[i] }
+func (q *eventQueue) Push(x any)   { *q = append(*q, x.(event)) }
+func (q *eventQueue) Pop() any {
+	old := *q
+	e := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return e
+}
+
+type signal struct {
+	name      string
+	light     models.TrafficLight
+	lightType int
+	state     int
+	elapsed   int
+	lamps     models.Lamps
+}
+
+// step продвигает светофор на одну секунду, используя его собственную логику GetNextState.
+func (s *signal) step() error {
+	elapsed := s.elapsed
+	response, err := s.light.GetNextState(models.TrafficRequest{
+		UUID:         s.name,
+		CurrentState: s.state,
+		CurrentTime:  &elapsed,
+	})
+	if err != nil {
+		return err
+	}
+
+	next, err := strconv.Atoi(response.NextState)
+	if err != nil {
+		return err
+	}
+	if next == s.state {
+		s.elapsed++
+		return nil
+	}
+
+	s.state, s.elapsed = next, 0
+	s.lamps, err = models.StateLamps(s.lightType, s.state)
+	return err
+}
+
+type approachState struct {
+	Approach
+	signal   *signal
+	headway  float64
+	arrivals []float64
+	next     int
+	queue    []float64
+	stopped  []bool
+	nextFree float64
+	pending  bool
+	delay    float64
+	stats    ApproachStats
+}
+
+func (a *approachState) served() bool {
+	return served(a.Movement, a.signal.lamps)
+}
+
+type simulation struct {
+	events     eventQueue
+	seq        int
+	signals    []*signal
+	approaches []*approachState
+}
+
+func (s *simulation) schedule(time float64, kind, index int) {
+	s.seq++
+	heap.Push(&s.events, event{time: time, kind: kind, index: index, seq: s.seq})
+}
+
+func (s *simulation) scheduleArrival(i int) {
+	a := s.approaches[i]
+	if a.next < len(a.arrivals) {
+		s.schedule(a.arrivals[a.next], eventArrival, i)
+		a.next++
+	}
+}
+
+func (s *simulation) tryDischarge(i int, now float64) {
+	a := s.approaches[i]
+	if a.pending || len(a.queue) == 0 || !a.served() {
+		return
+	}
+	a.pending = true
+	s.schedule(max(now, a.nextFree), eventDischarge, i)
+}
+
+func (s *simulation) arrive(i int, now float64) {
+	a := s.approaches[i]
+	a.stats.Arrivals++
+
+	if a.served() && len(a.queue) == 0 && now >= a.nextFree {
+		a.stats.Throughput++
+		a.nextFree = now + a.headway
+		return
+	}
+
+	a.queue = append(a.queue, now)
+	a.stopped = append(a.stopped, !a.served() || len(a.queue) > 1)
+	a.stats.MaxQueue = max(a.stats.MaxQueue, len(a.queue))
+	s.tryDischarge(i, now)
+}
+
+func (s *simulation) discharge(i int, now float64) {
+	a := s.approaches[i]
+	a.pending = false
+	if len(a.queue) == 0 || !a.served() {
+		return
+	}
+
+	a.delay += now - a.queue[0]
+	if a.stopped[0] {
+		a.stats.Stops++
+	}
+	a.queue, a.stopped = a.queue[1:], a.stopped[1:]
+	a.stats.Throughput++
+	a.nextFree = now + a.headway
+	s.tryDischarge(i, now)
+}
+
+func newSimulation(in Intersection, opts Options) (*simulation, error) {
+	if err := in.Validate(); err != nil {
+		return nil, err
+	}
+	if opts.Duration <= 0 {
+		return nil, errors.Wrapf(ErrSimulation, "некорректная длительность %.1f", opts.Duration)
+	}
+
+	sim := &simulation{}
+	signals := make(map[string]*signal, len(in.Lights))
+	for _, def := range in.Lights {
+		light, err := models.NewLight(def)
+		if err != nil {
+			return nil, err
+		}
+		sig := &signal{name: def.Name, light: light, lightType: def.Type, state: 1}
+		if sig.lamps, err = models.StateLamps(def.Type, 1); err != nil {
+			return nil, err
+		}
+		for range def.Offset {
+			if err := sig.step(); err != nil {
+				return nil, errors.Wrap(ErrSimulation, err.Error())
+			}
+		}
+		signals[def.Name] = sig
+		sim.signals = append(sim.signals, sig)
+	}
+
+	counts := make(map[string][]Count)
+	for _, c := range opts.Counts {
+		counts[c.Approach] = append(counts[c.Approach], c)
+	}
+
+	for i, approach := range in.Approaches {
+		saturationFlow := approach.SaturationFlow
+		if saturationFlow == 0 {
+			saturationFlow = defaultSaturationFlow
+		}
+
+		rng := rand.New(rand.NewPCG(opts.Seed, uint64(i)))
+		var arrivals []float64
+		if approachCounts, ok := counts[approach.Name]; ok {
+			for _, c := range approachCounts {
+				arrivals = append(arrivals, poissonArrivals(rng, float64(c.Count)*3600/c.Duration, c.Start, min(c.Start+c.Duration, opts.Duration))...)
+			}
+			sort.Float64s(arrivals)
+		} else {
+			arrivals = poissonArrivals(rng, approach.Rate, 0, opts.Duration)
+		}
+
+		sim.approaches = append(sim.approaches, &approachState{
+			Approach: approach,
+			signal:   signals[approach.Light],
+			headway:  3600 / saturationFlow,
+			arrivals: arrivals,
+			stats:    ApproachStats{Name: approach.Name},
+		})
+	}
+
+	return sim, nil
+}
+
+// Run выполняет событийную симуляцию очередей на перекрестке. При одинаковом
+// Seed прибытия совпадают для любых планов, что позволяет сравнивать планы между собой.
+func Run(in Intersection, opts Options) (Report, error) {
+	sim, err := newSimulation(in, opts)
+	if err != nil {
+		return Report{}, err
+	}
+
+	for i := range sim.signals {
+		sim.schedule(1, eventSignal, i)
+	}
+	for i := range sim.approaches {
+		sim.scheduleArrival(i)
+	}
+
+	for sim.events.Len() > 0 {
+		e := heap.Pop(&sim.events).(event)
+		if e.time >= opts.Duration {
+			break
+		}
+
+		switch e.kind {
+		case eventSignal:
+			if err := sim.signals[e.index].step(); err != nil {
+				return Report{}, errors.Wrap(ErrSimulation, err.Error())
+			}
+			for i, a := range sim.approaches {
+				if a.signal == sim.signals[e.index] {
+					sim.tryDischarge(i, e.time)
+				}
+			}
+			sim.schedule(e.time+1, eventSignal, e.index)
+		case eventDischarge:
+			sim.discharge(e.index, e.time)
+		case eventArrival:
+			sim.arrive(e.index, e.time)
+			sim.scheduleArrival(e.index)
+		}
+	}
+
+	report := Report{Duration: opts.Duration, Seed: opts.Seed}
+	var departedDelay float64
+	for _, a := range sim.approaches {
+		if a.stats.Throughput > 0 {
+			a.stats.AverageDelay = a.delay / float64(a.stats.Throughput)
+		}
+		a.stats.Remaining = len(a.queue)
+		for _, stopped := range a.stopped {
+			if stopped {
+				a.stats.Stops++
+			}
+		}
+
+		waiting := 0.0
+		for _, arrival := range a.queue {
+			waiting += opts.Duration - arrival
+		}
+		report.TotalDelay += a.delay + waiting
+		departedDelay += a.delay
+		report.Stops += a.stats.Stops
+		report.Throughput += a.stats.Throughput
+		report.Approaches = append(report.Approaches, a.stats)
+	}
+	if report.Throughput > 0 {
+		report.AverageDelay = departedDelay / float64(report.Throughput)
+	}
+
+	return report, nil
+}
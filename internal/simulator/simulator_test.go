@@ -0,0 +1,132 @@
+package simulator_test
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"trafficlightAPI/internal/models"
+	"trafficlightAPI/internal/simulator"
+
+	"github.com/pkg/errors"
+)
+
+func intersection(durations []int) simulator.Intersection {
+	return simulator.Intersection{
+		Lights: []models.LightDefinition{{Name: "main", Type: 1, Durations: durations}},
+		Approaches: []simulator.Approach{
+			{Name: "north", Light: "main", Movement: simulator.MovementThrough, Rate: 400},
+		},
+	}
+}
+
+func TestRunReproducible(t *testing.T) {
+	opts := simulator.Options{Duration: 1800, Seed: 42}
+
+	first, err := simulator.Run(intersection([]int{20, 3, 30}), opts)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	second, err := simulator.Run(intersection([]int{20, 3, 30}), opts)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(first, second) {
+		t.Errorf("same seed produced different reports: %+v vs %+v", first, second)
+	}
+
+	opts.Seed = 43
+	third, err := simulator.Run(intersection([]int{20, 3, 30}), opts)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if reflect.DeepEqual(first, third) {
+		t.Errorf("different seeds produced identical reports")
+	}
+}
+
+func TestRunLongerGreenReducesDelay(t *testing.T) {
+	opts := simulator.Options{Duration: 3600, Seed: 1}
+
+	short, err := simulator.Run(intersection([]int{40, 3, 10}), opts)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	long, err := simulator.Run(intersection([]int{10, 3, 40}), opts)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if short.Approaches[0].Arrivals != long.Approaches[0].Arrivals {
+		t.Fatalf("arrivals differ between plans with the same seed")
+	}
+	if long.AverageDelay >= short.AverageDelay {
+		t.Errorf("expected longer green to reduce delay: %.1f >= %.1f", long.AverageDelay, short.AverageDelay)
+	}
+	if long.Approaches[0].MaxQueue > short.Approaches[0].MaxQueue {
+		t.Errorf("expected longer green to reduce max queue: %d > %d", long.Approaches[0].MaxQueue, short.Approaches[0].MaxQueue)
+	}
+}
+
+func TestRunCounts(t *testing.T) {
+	counts, err := simulator.ReadCounts(strings.NewReader("approach,start,duration,count\nnorth,0,600,100\nnorth,600,600,0\n"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	report, err := simulator.Run(intersection([]int{20, 3, 30}), simulator.Options{Duration: 1200, Seed: 5, Counts: counts})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if arrivals := report.Approaches[0].Arrivals; arrivals < 60 || arrivals > 140 {
+		t.Errorf("got %d arrivals, expected about 100", arrivals)
+	}
+}
+
+func TestValidation(t *testing.T) {
+	tests := []struct {
+		name    string
+		in      simulator.Intersection
+		counts  string
+		wantErr error
+	}{
+		{
+			name: "unknown light",
+			in: simulator.Intersection{
+				Lights:     []models.LightDefinition{{Name: "main", Type: 1, Durations: []int{20, 3, 30}}},
+				Approaches: []simulator.Approach{{Name: "north", Light: "other"}},
+			},
+			wantErr: simulator.ErrInvalidIntersection,
+		},
+		{
+			name: "wrong plan length",
+			in: simulator.Intersection{
+				Lights: []models.LightDefinition{{Name: "main", Type: 3, Durations: []int{20, 3, 30}}},
+			},
+			wantErr: simulator.ErrInvalidIntersection,
+		},
+		{
+			name:    "bad counts header",
+			counts:  "a,b,c\n",
+			wantErr: simulator.ErrInvalidCounts,
+		},
+		{
+			name:    "negative count",
+			counts:  "approach,start,duration,count\nnorth,0,60,-1\n",
+			wantErr: simulator.ErrInvalidCounts,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var err error
+			if tt.counts != "" {
+				_, err = simulator.ReadCounts(strings.NewReader(tt.counts))
+			} else {
+				err = tt.in.Validate()
+			}
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("got error '%v', want '%v'", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,217 @@
+package storage
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	bucketMeta      = []byte("meta")
+	bucketLights    = []byte("lights")
+	bucketPlans     = []byte("plans")
+	bucketOverrides = []byte("overrides")
+	bucketStates    = []byte("states")
+	bucketDevices   = []byte("devices")
+	bucketFaults    = []byte("faults")
+	bucketMonitor   = []byte("monitor_faults")
+
+	keySchemaVersion = []byte("schema_version")
+)
+
+// migrations[i] переводит схему с версии i на i+1. Новые миграции
+// добавляются только в конец, существующие не меняются.
+var migrations = []func(tx *bolt.Tx) error{
+	func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{bucketLights, bucketPlans, bucketOverrides, bucketStates} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	},
+	func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(bucketDevices)
+		return err
+	},
+	func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(bucketFaults)
+		return err
+	},
+	func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(bucketMonitor)
+		return err
+	},
+}
+
+// LatestSchemaVersion - версия схемы, до которой мигрирует OpenBolt.
+func LatestSchemaVersion() int {
+	return len(migrations)
+}
+
+type BoltStore struct {
+	db *bolt.DB
+}
+
+// OpenBolt открывает файл хранилища и применяет недостающие миграции.
+func OpenBolt(path string) (*BoltStore, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, errors.Wrap(ErrStorage, err.Error())
+	}
+	db, err := bolt.Open(path, 0644, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, errors.Wrapf(ErrStorage, "%s: %v", path, err)
+	}
+
+	s := &BoltStore{db: db}
+	if err := s.migrate(); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *BoltStore) migrate() error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		meta, err := tx.CreateBucketIfNotExists(bucketMeta)
+		if err != nil {
+			return errors.Wrap(ErrStorage, err.Error())
+		}
+
+		version := 0
+		if v := meta.Get(keySchemaVersion); v != nil {
+			version = int(binary.BigEndian.Uint64(v))
+		}
+		if version > len(migrations) {
+			return errors.Wrapf(ErrSchemaNew, "версия %d, поддерживается %d", version, len(migrations))
+		}
+
+		for ; version < len(migrations); version++ {
+			if err := migrations[version](tx); err != nil {
+				return errors.Wrapf(ErrStorage, "миграция %d -> %d: %v", version, version+1, err)
+			}
+		}
+		return meta.Put(keySchemaVersion, binary.BigEndian.AppendUint64(nil, uint64(version)))
+	})
+}
+
+func (s *BoltStore) SchemaVersion() (int, error) {
+	version := 0
+	err := s.db.View(func(tx *bolt.Tx) error {
+		if v := tx.Bucket(bucketMeta).Get(keySchemaVersion); v != nil {
+			version = int(binary.BigEndian.Uint64(v))
+		}
+		return nil
+	})
+	return version, err
+}
+
+func (s *BoltStore) put(bucket []byte, key string, value any) error {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return errors.Wrap(ErrStorage, err.Error())
+	}
+	err = s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucket).Put([]byte(key), data)
+	})
+	if err != nil {
+		return errors.Wrap(ErrStorage, err.Error())
+	}
+	return nil
+}
+
+func (s *BoltStore) delete(bucket []byte, key string) error {
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(bucket)
+		if b.Get([]byte(key)) == nil {
+			return errors.Wrapf(ErrNotFound, "%s:%s", bucket, key)
+		}
+		return b.Delete([]byte(key))
+	})
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		return errors.Wrap(ErrStorage, err.Error())
+	}
+	return err
+}
+
+// list декодирует все значения бакета в порядке ключей.
+func list[T any](s *BoltStore, bucket []byte) ([]T, error) {
+	var items []T
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucket).ForEach(func(k, v []byte) error {
+			var item T
+			if err := json.Unmarshal(v, &item); err != nil {
+				return errors.Wrapf(err, "%s:%s", bucket, k)
+			}
+			items = append(items, item)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, errors.Wrap(ErrStorage, err.Error())
+	}
+	return items, nil
+}
+
+func (s *BoltStore) Lights() ([]Light, error)       { return list[Light](s, bucketLights) }
+func (s *BoltStore) PutLight(l Light) error         { return s.put(bucketLights, l.UUID, l) }
+func (s *BoltStore) DeleteLight(uuid string) error  { return s.delete(bucketLights, uuid) }
+func (s *BoltStore) Overrides() ([]Override, error) { return list[Override](s, bucketOverrides) }
+func (s *BoltStore) PutOverride(o Override) error   { return s.put(bucketOverrides, o.ID, o) }
+func (s *BoltStore) DeleteOverride(id string) error { return s.delete(bucketOverrides, id) }
+func (s *BoltStore) States() ([]State, error)       { return list[State](s, bucketStates) }
+func (s *BoltStore) PutState(st State) error        { return s.put(bucketStates, st.UUID, st) }
+func (s *BoltStore) DeleteState(uuid string) error  { return s.delete(bucketStates, uuid) }
+func (s *BoltStore) Devices() ([]Device, error)     { return list[Device](s, bucketDevices) }
+func (s *BoltStore) PutDevice(d Device) error       { return s.put(bucketDevices, d.UUID, d) }
+func (s *BoltStore) DeleteDevice(uuid string) error { return s.delete(bucketDevices, uuid) }
+func (s *BoltStore) Faults() ([]Fault, error)       { return list[Fault](s, bucketFaults) }
+func (s *BoltStore) PutFault(f Fault) error         { return s.put(bucketFaults, f.Key(), f) }
+func (s *BoltStore) DeleteFault(key string) error   { return s.delete(bucketFaults, key) }
+func (s *BoltStore) MonitorFaults() ([]MonitorFault, error) {
+	return list[MonitorFault](s, bucketMonitor)
+}
+func (s *BoltStore) PutMonitorFault(f MonitorFault) error {
+	return s.put(bucketMonitor, f.Intersection, f)
+}
+func (s *BoltStore) DeleteMonitorFault(intersection string) error {
+	return s.delete(bucketMonitor, intersection)
+}
+func (s *BoltStore) PutPlan(t int, plan []int) error {
+	return s.put(bucketPlans, strconv.Itoa(t), plan)
+}
+func (s *BoltStore) DeletePlan(t int) error {
+	return s.delete(bucketPlans, strconv.Itoa(t))
+}
+
+func (s *BoltStore) Plans() (map[int][]int, error) {
+	plans := make(map[int][]int)
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucketPlans).ForEach(func(k, v []byte) error {
+			trafficType, err := strconv.Atoi(string(k))
+			if err != nil {
+				return errors.Wrapf(err, "plans:%s", k)
+			}
+			var plan []int
+			if err := json.Unmarshal(v, &plan); err != nil {
+				return errors.Wrapf(err, "plans:%s", k)
+			}
+			plans[trafficType] = plan
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, errors.Wrap(ErrStorage, err.Error())
+	}
+	return plans, nil
+}
+
+func (s *BoltStore) Close() error {
+	return s.db.Close()
+}
@@ -0,0 +1,79 @@
+package storage
+
+import (
+	"github.com/pkg/errors"
+)
+
+var (
+	ErrUnknownMutation = errors.New("неизвестная операция хранилища")
+)
+
+const (
+	OpPutLight       = "put_light"
+	OpDeleteLight    = "delete_light"
+	OpPutPlan        = "put_plan"
+	OpDeletePlan     = "delete_plan"
+	OpPutOverride    = "put_override"
+	OpDeleteOverride = "delete_override"
+	OpPutState       = "put_state"
+	OpDeleteState    = "delete_state"
+	OpPutDevice      = "put_device"
+	OpDeleteDevice   = "delete_device"
+	OpPutFault       = "put_fault"
+	OpDeleteFault    = "delete_fault"
+
+	OpPutMonitorFault    = "put_monitor_fault"
+	OpDeleteMonitorFault = "delete_monitor_fault"
+)
+
+// Mutation - изменение хранилища в сериализуемом виде, например для журнала Raft.
+// Все моменты времени задает инициатор изменения, поэтому применение
+// детерминировано на любой реплике.
+type Mutation struct {
+	Op       string    `json:"op"`
+	Key      string    `json:"key,omitempty"` // UUID, ID, ключ неисправности или перекресток для удаления
+	Light    *Light    `json:"light,omitempty"`
+	State    *State    `json:"state,omitempty"`
+	Override *Override `json:"override,omitempty"`
+	Device   *Device   `json:"device,omitempty"`
+	Fault    *Fault    `json:"fault,omitempty"`
+
+	MonitorFault *MonitorFault `json:"monitor_fault,omitempty"`
+	Type         int           `json:"type,omitempty"`
+	Plan         []int         `json:"plan,omitempty"`
+}
+
+func (m Mutation) Apply(s Store) error {
+	switch {
+	case m.Op == OpPutLight && m.Light != nil:
+		return s.PutLight(*m.Light)
+	case m.Op == OpDeleteLight:
+		return s.DeleteLight(m.Key)
+	case m.Op == OpPutPlan:
+		return s.PutPlan(m.Type, m.Plan)
+	case m.Op == OpDeletePlan:
+		return s.DeletePlan(m.Type)
+	case m.Op == OpPutOverride && m.Override != nil:
+		return s.PutOverride(*m.Override)
+	case m.Op == OpDeleteOverride:
+		return s.DeleteOverride(m.Key)
+	case m.Op == OpPutState && m.State != nil:
+		return s.PutState(*m.State)
+	case m.Op == OpDeleteState:
+		return s.DeleteState(m.Key)
+	case m.Op == OpPutDevice && m.Device != nil:
+		return s.PutDevice(*m.Device)
+	case m.Op == OpDeleteDevice:
+		return s.DeleteDevice(m.Key)
+	case m.Op == OpPutFault && m.Fault != nil:
+		return s.PutFault(*m.Fault)
+	case m.Op == OpDeleteFault:
+		return s.DeleteFault(m.Key)
+	case m.Op == OpPutMonitorFault && m.MonitorFault != nil:
+		return s.PutMonitorFault(*m.MonitorFault)
+	case m.Op == OpDeleteMonitorFault:
+		return s.DeleteMonitorFault(m.Key)
+	default:
+		return errors.Wrapf(ErrUnknownMutation, "op:%s", m.Op)
+	}
+}
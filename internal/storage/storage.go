@@ -0,0 +1,123 @@
+package storage
+
+import (
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	ErrStorage   = errors.New("ошибка хранилища")
+	ErrNotFound  = errors.New("запись не найдена")
+	ErrSchemaNew = errors.New("версия схемы хранилища новее поддерживаемой")
+)
+
+// Light - светофор, известный сервису.
+type Light struct {
+	UUID         string    `json:"uuid"`
+	Type         int       `json:"type"`
+	RegisteredAt time.Time `json:"registered_at"`
+}
+
+// State - последнее известное состояние светофора.
+type State struct {
+	UUID  string    `json:"uuid"`
+	Type  int       `json:"type"`
+	State int       `json:"state"`
+	Since time.Time `json:"since"`
+	Mode  string    `json:"mode"`
+}
+
+// Override - ручное управление светофором.
+type Override struct {
+	ID        string    `json:"id"`
+	UUID      string    `json:"uuid"`
+	Action    string    `json:"action"`
+	State     int       `json:"state,omitempty"`
+	Reason    string    `json:"reason"`
+	User      string    `json:"user,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// Device - зарегистрированное устройство: тип светофора и его положение.
+type Device struct {
+	UUID              string    `json:"uuid"`
+	Type              int       `json:"type"`
+	Intersection      string    `json:"intersection,omitempty"`
+	Location          *Location `json:"location,omitempty"`
+	Description       string    `json:"description,omitempty"`
+	Tags              []string  `json:"tags,omitempty"`
+	HeartbeatInterval int       `json:"heartbeat_interval,omitempty"` // Ожидаемый интервал опроса, с; 0 - по типу
+	CreatedAt         time.Time `json:"created_at"`
+	UpdatedAt         time.Time `json:"updated_at"`
+}
+
+// Fault - неисправность лампы светофора, по одной на uuid и лампу.
+type Fault struct {
+	UUID       string    `json:"uuid"`
+	Type       int       `json:"type"`
+	Lamp       string    `json:"lamp"` // red, yellow, green или arrow
+	Reason     string    `json:"reason,omitempty"`
+	ReportedBy string    `json:"reported_by,omitempty"`
+	ReportedAt time.Time `json:"reported_at"`
+}
+
+// Key - ключ неисправности в хранилище.
+func (f Fault) Key() string {
+	return FaultKey(f.UUID, f.Lamp)
+}
+
+func FaultKey(uuid, lamp string) string {
+	return uuid + "/" + lamp
+}
+
+// MonitorFault - авария перекрестка, зафиксированная монитором конфликтов.
+// Держится до сброса оператором, по одной на перекресток.
+type MonitorFault struct {
+	Intersection string    `json:"intersection"`
+	Kind         string    `json:"kind"` // conflict, clearance или stuck
+	UUIDs        []string  `json:"uuids"`
+	Details      string    `json:"details"`
+	DetectedAt   time.Time `json:"detected_at"`
+}
+
+// Location - координаты WGS 84 в градусах.
+type Location struct {
+	Lat float64 `json:"lat"`
+	Lon float64 `json:"lon"`
+}
+
+// Store - постоянное хранилище состояния сервиса.
+type Store interface {
+	Lights() ([]Light, error)
+	PutLight(Light) error
+	DeleteLight(uuid string) error
+
+	Plans() (map[int][]int, error)
+	PutPlan(trafficType int, plan []int) error
+	DeletePlan(trafficType int) error
+
+	Overrides() ([]Override, error)
+	PutOverride(Override) error
+	DeleteOverride(id string) error
+
+	States() ([]State, error)
+	PutState(State) error
+	DeleteState(uuid string) error
+
+	Devices() ([]Device, error)
+	PutDevice(Device) error
+	DeleteDevice(uuid string) error
+
+	Faults() ([]Fault, error)
+	PutFault(Fault) error
+	DeleteFault(key string) error
+
+	MonitorFaults() ([]MonitorFault, error)
+	PutMonitorFault(MonitorFault) error
+	DeleteMonitorFault(intersection string) error
+
+	SchemaVersion() (int, error)
+	Close() error
+}
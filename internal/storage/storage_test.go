@@ -0,0 +1,121 @@
+package storage_test
+
+import (
+	"encoding/binary"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+	"trafficlightAPI/internal/storage"
+
+	"github.com/pkg/errors"
+	bolt "go.etcd.io/bbolt"
+)
+
+func TestBoltStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.db")
+	store, err := storage.OpenBolt(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if version, err := store.SchemaVersion(); err != nil || version != storage.LatestSchemaVersion() {
+		t.Fatalf("schema version = %d, %v, want %d", version, err, storage.LatestSchemaVersion())
+	}
+
+	ts := time.Date(2026, 10, 19, 8, 0, 0, 0, time.UTC)
+	light := storage.Light{UUID: "a", Type: 2, RegisteredAt: ts}
+	state := storage.State{UUID: "a", Type: 2, State: 3, Since: ts, Mode: "normal"}
+	override := storage.Override{ID: "o1", UUID: "a", Action: "hold", Reason: "ДТП", CreatedAt: ts, ExpiresAt: ts.Add(time.Hour)}
+	device := storage.Device{UUID: "a", Type: 2, Intersection: "Ленина-Мира", Location: &storage.Location{Lat: 55.75, Lon: 37.61}, Tags: []string{"центр"}, CreatedAt: ts, UpdatedAt: ts}
+	fault := storage.Fault{UUID: "a", Type: 2, Lamp: "arrow", Reason: "перегорела", ReportedAt: ts}
+	monitorFault := storage.MonitorFault{Intersection: "Ленина-Мира", Kind: "conflict", UUIDs: []string{"a", "b"}, Details: "два зеленых", DetectedAt: ts}
+	for _, err := range []error{
+		store.PutLight(light),
+		store.PutState(state),
+		store.PutOverride(override),
+		store.PutPlan(1, []int{30, 3, 25}),
+		store.PutDevice(device),
+		store.PutFault(fault),
+		store.PutMonitorFault(monitorFault),
+	} {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := store.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// После повторного открытия данные сохраняются, миграции не повторяются.
+	store, err = storage.OpenBolt(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	if lights, err := store.Lights(); err != nil || !reflect.DeepEqual(lights, []storage.Light{light}) {
+		t.Errorf("lights = %+v, %v", lights, err)
+	}
+	if states, err := store.States(); err != nil || !reflect.DeepEqual(states, []storage.State{state}) {
+		t.Errorf("states = %+v, %v", states, err)
+	}
+	if overrides, err := store.Overrides(); err != nil || !reflect.DeepEqual(overrides, []storage.Override{override}) {
+		t.Errorf("overrides = %+v, %v", overrides, err)
+	}
+	if devices, err := store.Devices(); err != nil || !reflect.DeepEqual(devices, []storage.Device{device}) {
+		t.Errorf("devices = %+v, %v", devices, err)
+	}
+	if faults, err := store.Faults(); err != nil || !reflect.DeepEqual(faults, []storage.Fault{fault}) {
+		t.Errorf("faults = %+v, %v", faults, err)
+	}
+	if mf, err := store.MonitorFaults(); err != nil || !reflect.DeepEqual(mf, []storage.MonitorFault{monitorFault}) {
+		t.Errorf("monitor faults = %+v, %v", mf, err)
+	}
+	if plans, err := store.Plans(); err != nil || !reflect.DeepEqual(plans, map[int][]int{1: {30, 3, 25}}) {
+		t.Errorf("plans = %v, %v", plans, err)
+	}
+
+	if err := store.DeleteState("a"); err != nil {
+		t.Fatal(err)
+	}
+	if states, err := store.States(); err != nil || len(states) != 0 {
+		t.Errorf("states after delete = %+v, %v", states, err)
+	}
+	if err := store.DeletePlan(1); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.DeletePlan(1); !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("second plan delete: err = %v, want ErrNotFound", err)
+	}
+
+	if err := store.DeleteOverride("o1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.DeleteOverride("o1"); !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("second delete: err = %v, want ErrNotFound", err)
+	}
+}
+
+func TestSchemaTooNew(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.db")
+	db, err := bolt.Open(path, 0644, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		meta, err := tx.CreateBucket([]byte("meta"))
+		if err != nil {
+			return err
+		}
+		return meta.Put([]byte("schema_version"), binary.BigEndian.AppendUint64(nil, uint64(storage.LatestSchemaVersion()+1)))
+	})
+	db.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := storage.OpenBolt(path); !errors.Is(err, storage.ErrSchemaNew) {
+		t.Errorf("err = %v, want ErrSchemaNew", err)
+	}
+}
@@ -0,0 +1,357 @@
+package stream
+
+import (
+	"slices"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+	"trafficlightAPI/internal/devices"
+	"trafficlightAPI/internal/lights"
+	prometheus "trafficlightAPI/internal/middleware/prometheus"
+	"trafficlightAPI/internal/models"
+)
+
+// Причины закрытия подписки.
+const (
+	ReasonClient       = "client"        // Клиент отключился
+	ReasonSlowConsumer = "slow_consumer" // Клиент не успевает читать события
+	ReasonWriteError   = "write_error"
+	ReasonShutdown     = "shutdown"
+)
+
+// Event - смена состояния светофора для подписчиков.
+type Event struct {
+	UUID         string       `json:"uuid"`
+	Type         int          `json:"type"`
+	Intersection string       `json:"intersection,omitempty"`
+	State        int          `json:"state"`
+	Lamps        models.Lamps `json:"lamps"`
+	Mode         string       `json:"mode"`
+	Since        time.Time    `json:"since"`
+	Duration     int          `json:"duration,omitempty"` // Длительность состояния по плану, с; только в режиме normal
+	NextAt       *time.Time   `json:"next_at,omitempty"`  // Ожидаемая смена состояния
+}
+
+// NewEvent строит событие по смене состояния. Обратный отсчет есть только
+// у работы по плану: в остальных режимах время смены неизвестно.
+func NewEvent(uuid string, trafficType, state int, mode string, since time.Time, plan []int, intersection string) Event {
+	e := Event{UUID: uuid, Type: trafficType, Intersection: intersection, State: state, Mode: mode, Since: since}
+	e.Lamps, _ = models.StateLamps(trafficType, state)
+	if (mode == models.ModeNormal || mode == "") && state >= 1 && state <= len(plan) {
+		e.Duration = plan[state-1]
+		next := since.Add(time.Duration(e.Duration) * time.Second)
+		e.NextAt = &next
+	}
+	return e
+}
+
+// Filter - uuid и перекрестки подписки. All - все светофоры, только для
+// интеграций сервиса, клиенты потоков его не задают.
+type Filter struct {
+	UUIDs         []string `json:"uuids,omitempty"`
+	Intersections []string `json:"intersections,omitempty"`
+	All           bool     `json:"all,omitempty"`
+}
+
+func (f Filter) Empty() bool {
+	return !f.All && len(f.UUIDs) == 0 && len(f.Intersections) == 0
+}
+
+func (f Filter) match(e Event) bool {
+	return f.All || slices.Contains(f.UUIDs, e.UUID) || (e.Intersection != "" && slices.Contains(f.Intersections, e.Intersection))
+}
+
+func merge(dst, src []string) []string {
+	for _, v := range src {
+		if v != "" && !slices.Contains(dst, v) {
+			dst = append(dst, v)
+		}
+	}
+	return dst
+}
+
+func remove(dst, src []string) []string {
+	return slices.DeleteFunc(dst, func(v string) bool { return slices.Contains(src, v) })
+}
+
+// ConnectionStats - состояние одного подключения для /stream/connections.
+type ConnectionStats struct {
+	ID          uint64    `json:"id"`
+	Transport   string    `json:"transport"`
+	Remote      string    `json:"remote"`
+	User        string    `json:"user,omitempty"`
+	Filter      Filter    `json:"filter"`
+	ConnectedAt time.Time `json:"connected_at"`
+	Sent        int64     `json:"sent"`
+	Dropped     int64     `json:"dropped"`
+	Queued      int       `json:"queued"`
+}
+
+// Subscription - очередь событий одного подключения. Очередь ограничена:
+// если клиент не успевает читать, новые события отбрасываются, а после
+// maxDrops отброшенных подряд подписка закрывается.
+type Subscription struct {
+	ID          uint64
+	Transport   string
+	Remote      string
+	User        string
+	ConnectedAt time.Time
+
+	events   chan Event
+	done     chan struct{}
+	reason   atomic.Value
+	maxDrops int64
+
+	mu     sync.RWMutex
+	filter Filter
+
+	sent    atomic.Int64
+	dropped atomic.Int64
+	pending atomic.Int64 // Отброшено с последней отправки
+}
+
+func (s *Subscription) Events() <-chan Event  { return s.events }
+func (s *Subscription) Done() <-chan struct{} { return s.done }
+func (s *Subscription) Filter() Filter {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return Filter{UUIDs: slices.Clone(s.filter.UUIDs), Intersections: slices.Clone(s.filter.Intersections), All: s.filter.All}
+}
+
+// Reason возвращает причину закрытия подписки.
+func (s *Subscription) Reason() string {
+	reason, _ := s.reason.Load().(string)
+	return reason
+}
+
+// Subscribe добавляет uuid и перекрестки к подписке.
+func (s *Subscription) Subscribe(f Filter) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.filter.UUIDs = merge(s.filter.UUIDs, f.UUIDs)
+	s.filter.Intersections = merge(s.filter.Intersections, f.Intersections)
+	s.filter.All = s.filter.All || f.All
+}
+
+// Unsubscribe убирает uuid и перекрестки из подписки.
+func (s *Subscription) Unsubscribe(f Filter) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.filter.UUIDs = remove(s.filter.UUIDs, f.UUIDs)
+	s.filter.Intersections = remove(s.filter.Intersections, f.Intersections)
+	s.filter.All = s.filter.All && !f.All
+}
+
+func (s *Subscription) match(e Event) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.filter.match(e)
+}
+
+// offer ставит событие в очередь без ожидания. Возвращает false, если
+// клиент слишком долго не читает и подписку нужно закрыть.
+func (s *Subscription) offer(e Event) bool {
+	select {
+	case s.events <- e:
+		return true
+	default:
+	}
+	s.dropped.Add(1)
+	prometheus.StreamEventsDropped.WithLabelValues(s.Transport).Inc()
+	return s.pending.Add(1) <= s.maxDrops
+}
+
+// Delivered отмечает отправку события клиенту и возвращает число событий,
+// отброшенных перед ним, чтобы клиент мог запросить актуальное состояние.
+func (s *Subscription) Delivered() int64 {
+	s.sent.Add(1)
+	prometheus.StreamEventsSent.WithLabelValues(s.Transport).Inc()
+	return s.pending.Swap(0)
+}
+
+func (s *Subscription) stats() ConnectionStats {
+	return ConnectionStats{
+		ID:          s.ID,
+		Transport:   s.Transport,
+		Remote:      s.Remote,
+		User:        s.User,
+		Filter:      s.Filter(),
+		ConnectedAt: s.ConnectedAt,
+		Sent:        s.sent.Load(),
+		Dropped:     s.dropped.Load(),
+		Queued:      len(s.events),
+	}
+}
+
+type Config struct {
+	Buffer   int // Размер очереди подключения
+	MaxDrops int // Отброшенных подряд событий до отключения
+}
+
+// Hub рассылает смены состояний подпискам.
+type Hub struct {
+	cfg            Config
+	intersectionOf func(uuid string) string
+
+	mu     sync.RWMutex
+	subs   map[uint64]*Subscription
+	nextID uint64
+}
+
+func NewHub(cfg Config, intersectionOf func(uuid string) string) *Hub {
+	if cfg.Buffer < 1 {
+		cfg.Buffer = 1
+	}
+	return &Hub{cfg: cfg, intersectionOf: intersectionOf, subs: make(map[uint64]*Subscription)}
+}
+
+// Intersection возвращает перекресток светофора или "".
+func (h *Hub) Intersection(uuid string) string {
+	if h.intersectionOf == nil {
+		return ""
+	}
+	return h.intersectionOf(uuid)
+}
+
+// Subscribe регистрирует подключение. Подписка закрывается Unsubscribe
+// или хабом, если клиент не успевает читать.
+func (h *Hub) Subscribe(transport, remote, user string, f Filter) *Subscription {
+	h.mu.Lock()
+	h.nextID++
+	s := &Subscription{
+		ID:          h.nextID,
+		Transport:   transport,
+		Remote:      remote,
+		User:        user,
+		ConnectedAt: time.Now().UTC(),
+		events:      make(chan Event, h.cfg.Buffer),
+		done:        make(chan struct{}),
+		maxDrops:    int64(h.cfg.MaxDrops),
+	}
+	s.Subscribe(f)
+	h.subs[s.ID] = s
+	h.mu.Unlock()
+
+	prometheus.StreamConnections.WithLabelValues(transport).Inc()
+	return s
+}
+
+// Unsubscribe закрывает подписку с причиной reason. Повторные вызовы ничего не делают.
+func (h *Hub) Unsubscribe(s *Subscription, reason string) {
+	h.mu.Lock()
+	_, ok := h.subs[s.ID]
+	if ok {
+		delete(h.subs, s.ID)
+		s.reason.Store(reason)
+		close(s.done)
+	}
+	h.mu.Unlock()
+
+	if ok {
+		prometheus.StreamConnections.WithLabelValues(s.Transport).Dec()
+		prometheus.StreamDisconnects.WithLabelValues(s.Transport, reason).Inc()
+	}
+}
+
+// Publish рассылает смену состояния. Не блокируется на медленных клиентах.
+func (h *Hub) Publish(t models.Transition) {
+	h.Send(NewEvent(t.UUID, t.Type, t.To, t.Mode, t.Time.UTC(), t.Plan, h.Intersection(t.UUID)))
+}
+
+func (h *Hub) Send(e Event) {
+	var slow []*Subscription
+	h.mu.RLock()
+	for _, s := range h.subs {
+		if s.match(e) && !s.offer(e) {
+			slow = append(slow, s)
+		}
+	}
+	h.mu.RUnlock()
+
+	for _, s := range slow {
+		h.Unsubscribe(s, ReasonSlowConsumer)
+	}
+}
+
+// Snapshot возвращает последние известные состояния светофоров фильтра,
+// чтобы новый подписчик не ждал следующей смены.
+func (h *Hub) Snapshot(f Filter) []Event {
+	registry := lights.Default()
+	if registry == nil {
+		return nil
+	}
+	uuids := slices.Clone(f.UUIDs)
+	if f.All {
+		for _, e := range registry.All() {
+			uuids = append(uuids, e.Light.UUID)
+		}
+	}
+	if registry := devices.Default(); registry != nil {
+		for _, intersection := range f.Intersections {
+			for _, d := range registry.List(devices.Filter{Intersection: intersection}) {
+				uuids = append(uuids, d.UUID)
+			}
+		}
+	}
+
+	seen := make(map[string]bool, len(uuids))
+	var events []Event
+	for _, uuid := range uuids {
+		if seen[uuid] {
+			continue
+		}
+		seen[uuid] = true
+		entry, ok := registry.Get(uuid)
+		if !ok || entry.State == nil {
+			continue
+		}
+		s := entry.State
+		events = append(events, NewEvent(uuid, s.Type, s.State, s.Mode, s.Since, models.Plan(s.Type), h.Intersection(uuid)))
+	}
+	return events
+}
+
+// Connections возвращает подключения в порядке ID.
+func (h *Hub) Connections() []ConnectionStats {
+	h.mu.RLock()
+	stats := make([]ConnectionStats, 0, len(h.subs))
+	for _, s := range h.subs {
+		stats = append(stats, s.stats())
+	}
+	h.mu.RUnlock()
+
+	sort.Slice(stats, func(i, j int) bool { return stats[i].ID < stats[j].ID })
+	return stats
+}
+
+// Close отключает всех подписчиков.
+func (h *Hub) Close() {
+	h.mu.RLock()
+	subs := make([]*Subscription, 0, len(h.subs))
+	for _, s := range h.subs {
+		subs = append(subs, s)
+	}
+	h.mu.RUnlock()
+
+	for _, s := range subs {
+		h.Unsubscribe(s, ReasonShutdown)
+	}
+}
+
+var (
+	defaultMu  sync.RWMutex
+	defaultHub *Hub
+)
+
+func SetDefault(h *Hub) {
+	defaultMu.Lock()
+	defer defaultMu.Unlock()
+	defaultHub = h
+}
+
+func Default() *Hub {
+	defaultMu.RLock()
+	defer defaultMu.RUnlock()
+	return defaultHub
+}
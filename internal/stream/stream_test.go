@@ -0,0 +1,119 @@
+package stream_test
+
+import (
+	"testing"
+	"time"
+	"trafficlightAPI/internal/models"
+	"trafficlightAPI/internal/stream"
+)
+
+var start = time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+func transition(uuid string, to int, mode string) models.Transition {
+	return models.Transition{UUID: uuid, Type: 1, To: to, Time: start, Plan: []int{10, 3, 20}, Mode: mode}
+}
+
+func TestNewEvent(t *testing.T) {
+	tests := []struct {
+		name     string
+		mode     string
+		state    int
+		duration int
+		next     bool
+	}{
+		{name: "normal mode has countdown", mode: models.ModeNormal, state: 3, duration: 20, next: true},
+		{name: "manual mode has no countdown", mode: models.ModeManual, state: 1},
+		{name: "failsafe has no countdown", mode: models.ModeFailsafe, state: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := stream.NewEvent("a", 1, tt.state, tt.mode, start, []int{10, 3, 20}, "x")
+			if e.Duration != tt.duration || (e.NextAt != nil) != tt.next {
+				t.Fatalf("event = %+v", e)
+			}
+			if tt.next && !e.NextAt.Equal(start.Add(time.Duration(tt.duration)*time.Second)) {
+				t.Errorf("next_at = %v", e.NextAt)
+			}
+		})
+	}
+	if e := stream.NewEvent("a", 1, 3, models.ModeNormal, start, nil, ""); !e.Lamps.Green {
+		t.Errorf("lamps = %+v, want green", e.Lamps)
+	}
+}
+
+func TestFilter(t *testing.T) {
+	hub := stream.NewHub(stream.Config{Buffer: 8, MaxDrops: 8}, func(uuid string) string {
+		if uuid == "b" {
+			return "Ленина-Мира"
+		}
+		return ""
+	})
+	byUUID := hub.Subscribe("ws", "", "", stream.Filter{UUIDs: []string{"a"}})
+	byIntersection := hub.Subscribe("sse", "", "", stream.Filter{Intersections: []string{"Ленина-Мира"}})
+	all := hub.Subscribe("mqtt", "", "", stream.Filter{All: true})
+
+	hub.Publish(transition("a", 1, models.ModeNormal))
+	hub.Publish(transition("b", 2, models.ModeNormal))
+	hub.Publish(transition("c", 3, models.ModeNormal))
+
+	if got := len(byUUID.Events()); got != 1 {
+		t.Errorf("uuid subscription got %d events, want 1", got)
+	}
+	if e := <-byIntersection.Events(); e.UUID != "b" || e.Intersection != "Ленина-Мира" || len(byIntersection.Events()) != 0 {
+		t.Errorf("intersection subscription got %+v", e)
+	}
+	if got := len(all.Events()); got != 3 {
+		t.Errorf("all subscription got %d events, want 3", got)
+	}
+
+	byUUID.Subscribe(stream.Filter{UUIDs: []string{"c"}})
+	byUUID.Unsubscribe(stream.Filter{UUIDs: []string{"a"}})
+	hub.Publish(transition("a", 2, models.ModeNormal))
+	hub.Publish(transition("c", 1, models.ModeNormal))
+	<-byUUID.Events()
+	if e := <-byUUID.Events(); e.UUID != "c" {
+		t.Errorf("after resubscribe got %+v, want c", e)
+	}
+}
+
+func TestSlowConsumer(t *testing.T) {
+	hub := stream.NewHub(stream.Config{Buffer: 2, MaxDrops: 3}, nil)
+	sub := hub.Subscribe("sse", "127.0.0.1:1", "viewer", stream.Filter{UUIDs: []string{"a"}})
+
+	// Очередь из двух событий заполнена, следующие отбрасываются.
+	for i := 0; i < 4; i++ {
+		hub.Publish(transition("a", i%3+1, models.ModeNormal))
+	}
+	stats := hub.Connections()
+	if len(stats) != 1 || stats[0].Queued != 2 || stats[0].Dropped != 2 {
+		t.Fatalf("connections = %+v", stats)
+	}
+
+	// Отправка сообщает клиенту, сколько событий пропущено.
+	<-sub.Events()
+	if dropped := sub.Delivered(); dropped != 2 {
+		t.Errorf("Delivered() = %d, want 2", dropped)
+	}
+	if dropped := sub.Delivered(); dropped != 0 {
+		t.Errorf("second Delivered() = %d, want 0", dropped)
+	}
+
+	// Больше MaxDrops отброшенных подряд - отключение.
+	for i := 0; i < 5; i++ {
+		hub.Publish(transition("a", i%3+1, models.ModeNormal))
+	}
+	select {
+	case <-sub.Done():
+	default:
+		t.Fatal("slow consumer is still connected")
+	}
+	if sub.Reason() != stream.ReasonSlowConsumer || len(hub.Connections()) != 0 {
+		t.Errorf("reason = %q, connections = %d", sub.Reason(), len(hub.Connections()))
+	}
+
+	// Повторное закрытие ничего не меняет.
+	hub.Unsubscribe(sub, stream.ReasonClient)
+	if sub.Reason() != stream.ReasonSlowConsumer {
+		t.Errorf("reason after second unsubscribe = %q", sub.Reason())
+	}
+}
@@ -0,0 +1,313 @@
+package sumo
+
+import (
+	"encoding/xml"
+	"fmt"
+	"math"
+	"os"
+	"strings"
+	"trafficlightAPI/internal/models"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+)
+
+var (
+	ErrInvalidProgram = errors.New("некорректная программа SUMO")
+	ErrUnknownProgram = errors.New("программа SUMO не соответствует ни одному типу светофора")
+	ErrInvalidLinks   = errors.New("некорректная карта связей SUMO")
+)
+
+// Phase - фаза программы. SUMO записывает длительности и смещение дробными
+// секундами, при импорте они округляются до целых.
+type Phase struct {
+	Duration float64 `xml:"duration,attr"`
+	State    string  `xml:"state,attr"`
+}
+
+type TLLogic struct {
+	ID        string  `xml:"id,attr"`
+	Type      string  `xml:"type,attr"`
+	ProgramID string  `xml:"programID,attr"`
+	Offset    float64 `xml:"offset,attr"`
+	Phases    []Phase `xml:"phase"`
+}
+
+type Additional struct {
+	XMLName  xml.Name  `xml:"additional"`
+	TLLogics []TLLogic `xml:"tlLogic"`
+}
+
+// linkState возвращает символ состояния SUMO для одной связи.
+// Светофор со стрелкой управляет двумя связями: прямо и направо.
+func linkState(lamps models.Lamps, arrow bool) byte {
+	if arrow && lamps.Arrow {
+		if lamps.ArrowFlashing {
+			return 'g'
+		}
+		return 'G'
+	}
+	switch {
+	case lamps.Red && lamps.Yellow:
+		return 'u'
+	case lamps.Green:
+		return 'G'
+	case lamps.Yellow:
+		return 'y'
+	default:
+		return 'r'
+	}
+}
+
+func stateString(trafficType, state int) (string, error) {
+	lamps, err := models.StateLamps(trafficType, state)
+	if err != nil {
+		return "", err
+	}
+	s := []byte{linkState(lamps, false)}
+	if trafficType == 2 {
+		s = append(s, linkState(lamps, true))
+	}
+	return string(s), nil
+}
+
+func ToTLLogic(def models.LightDefinition) (TLLogic, error) {
+	if _, err := models.NewLight(def); err != nil {
+		return TLLogic{}, err
+	}
+
+	logic := TLLogic{ID: def.Name, Type: "static", ProgramID: "0", Offset: float64(def.Offset)}
+	for i, d := range def.Durations {
+		state, err := stateString(def.Type, i+1)
+		if err != nil {
+			return TLLogic{}, err
+		}
+		logic.Phases = append(logic.Phases, Phase{Duration: float64(d), State: state})
+	}
+	return logic, nil
+}
+
+// FromTLLogic определяет тип светофора по последовательности состояний фаз.
+// Каждая фаза - одна связь (две у светофора со стрелкой); программы
+// перекрестков с многими связями импортируются по карте связей (LinkMap).
+func FromTLLogic(logic TLLogic) (models.LightDefinition, error) {
+	if err := checkStatic(logic); err != nil {
+		return models.LightDefinition{}, err
+	}
+
+	states := make([]string, len(logic.Phases))
+	for i, phase := range logic.Phases {
+		states[i] = phase.State
+	}
+
+	for trafficType := 1; trafficType <= 3; trafficType++ {
+		if !matches(trafficType, states) {
+			continue
+		}
+
+		def := models.LightDefinition{Name: logic.ID, Type: trafficType, Offset: seconds(logic.Offset)}
+		for _, phase := range logic.Phases {
+			def.Durations = append(def.Durations, seconds(phase.Duration))
+		}
+		if _, err := models.NewLight(def); err != nil {
+			return models.LightDefinition{}, errors.Wrapf(ErrInvalidProgram, "tlLogic %q: %v", logic.ID, err)
+		}
+		return def, nil
+	}
+
+	if len(states) > 0 && len(states[0]) > 2 {
+		return models.LightDefinition{}, errors.Wrapf(ErrUnknownProgram, "tlLogic %q: %d связей, нужна карта связей", logic.ID, len(states[0]))
+	}
+	return models.LightDefinition{}, errors.Wrapf(ErrUnknownProgram, "tlLogic %q: %s", logic.ID, strings.Join(states, ","))
+}
+
+func checkStatic(logic TLLogic) error {
+	if logic.Type != "" && logic.Type != "static" {
+		return errors.Wrapf(ErrInvalidProgram, "tlLogic %q: поддерживаются только static программы, получено %q", logic.ID, logic.Type)
+	}
+	return nil
+}
+
+// matches сообщает, что состояния фаз совпадают с состояниями типа по порядку.
+func matches(trafficType int, states []string) bool {
+	if models.StatesCount(trafficType) != len(states) {
+		return false
+	}
+	for i := range states {
+		if expected, _ := stateString(trafficType, i+1); expected != states[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// seconds округляет время SUMO до целых секунд.
+func seconds(value float64) int {
+	return int(math.Round(value))
+}
+
+// LinkMap - светофоры перекрестков с многими связями. Строка состояния
+// tlLogic из netconvert содержит символ на каждую связь (полосу и направление
+// движения, пешеходный переход), карта указывает, какие связи показывает светофор.
+type LinkMap struct {
+	Lights []LinkLight `yaml:"lights"`
+}
+
+type LinkLight struct {
+	TLLogic string `yaml:"tl_logic"` // id tlLogic перекрестка
+	Name    string `yaml:"name"`
+	UUID    string `yaml:"uuid,omitempty"`
+	Links   []int  `yaml:"links"` // Индексы в строке состояния: одна связь, у светофора со стрелкой - прямо и направо
+}
+
+// LoadLinks читает карту связей из YAML файла.
+func LoadLinks(path string) (LinkMap, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return LinkMap{}, errors.Wrap(ErrInvalidLinks, err.Error())
+	}
+	var links LinkMap
+	if err := yaml.Unmarshal(data, &links); err != nil {
+		return LinkMap{}, errors.Wrapf(ErrInvalidLinks, "%s: %v", path, err)
+	}
+	for _, light := range links.Lights {
+		if light.TLLogic == "" || light.Name == "" || len(light.Links) < 1 || len(light.Links) > 2 {
+			return LinkMap{}, errors.Wrapf(ErrInvalidLinks, "светофор %q: нужны tl_logic, name и одна или две связи", light.Name)
+		}
+	}
+	return links, nil
+}
+
+// segment - подряд идущие фазы, в которых связи светофора не меняются.
+type segment struct {
+	state    string
+	start    int
+	duration int
+}
+
+// FromLinks выделяет из программы перекрестка программу одного светофора:
+// состояния его связей по фазам, где соседние одинаковые состояния сливаются.
+// Цикл сервера начинается с первого состояния типа, поэтому программа
+// поворачивается, а смещение пересчитывается на начало повернутого цикла.
+func FromLinks(logic TLLogic, light LinkLight) (models.LightDefinition, error) {
+	if err := checkStatic(logic); err != nil {
+		return models.LightDefinition{}, err
+	}
+
+	var segments []segment
+	position := 0
+	for _, phase := range logic.Phases {
+		state := make([]byte, len(light.Links))
+		for i, link := range light.Links {
+			if link < 0 || link >= len(phase.State) {
+				return models.LightDefinition{}, errors.Wrapf(ErrInvalidProgram, "tlLogic %q: связи %d нет в состоянии %q", logic.ID, link, phase.State)
+			}
+			state[i] = phase.State[link]
+		}
+		duration := seconds(phase.Duration)
+		if n := len(segments); n > 0 && segments[n-1].state == string(state) {
+			segments[n-1].duration += duration
+		} else {
+			segments = append(segments, segment{state: string(state), start: position, duration: duration})
+		}
+		position += duration
+	}
+	if n := len(segments); n > 1 && segments[0].state == segments[n-1].state {
+		segments[0].start = segments[n-1].start
+		segments[0].duration += segments[n-1].duration
+		segments = segments[:n-1]
+	}
+
+	states := make([]string, len(segments))
+	for i, s := range segments {
+		states[i] = s.state
+	}
+	for trafficType := 1; trafficType <= 3; trafficType++ {
+		for shift := range segments {
+			rotated := append(append([]string(nil), states[shift:]...), states[:shift]...)
+			if !matches(trafficType, rotated) {
+				continue
+			}
+
+			def := models.LightDefinition{Name: light.Name, UUID: light.UUID, Type: trafficType}
+			for i := range segments {
+				def.Durations = append(def.Durations, segments[(shift+i)%len(segments)].duration)
+			}
+			if position > 0 {
+				def.Offset = ((seconds(logic.Offset)-segments[shift].start)%position + position) % position
+			}
+			if _, err := models.NewLight(def); err != nil {
+				return models.LightDefinition{}, errors.Wrapf(ErrInvalidProgram, "tlLogic %q, светофор %q: %v", logic.ID, light.Name, err)
+			}
+			return def, nil
+		}
+	}
+
+	return models.LightDefinition{}, errors.Wrapf(ErrUnknownProgram, "tlLogic %q, светофор %q: %s", logic.ID, light.Name, strings.Join(states, ","))
+}
+
+func Export(defs models.Definitions) ([]byte, error) {
+	additional := Additional{}
+	for _, def := range defs.Lights {
+		logic, err := ToTLLogic(def)
+		if err != nil {
+			return nil, errors.Wrapf(err, "светофор %q", def.Name)
+		}
+		additional.TLLogics = append(additional.TLLogics, logic)
+	}
+
+	data, err := xml.MarshalIndent(additional, "", "    ")
+	if err != nil {
+		return nil, fmt.Errorf("ошибка при создании XML: %w", err)
+	}
+	return append([]byte(xml.Header), append(data, '\n')...), nil
+}
+
+// Import принимает файл additional или net.xml: лишние элементы игнорируются.
+func Import(data []byte) (models.Definitions, error) {
+	return ImportLinks(data, LinkMap{})
+}
+
+// ImportLinks импортирует программы по карте связей: из каждого tlLogic карты
+// выделяются его светофоры, остальные tlLogic пропускаются. Пустая карта -
+// каждый tlLogic считается одним светофором.
+func ImportLinks(data []byte, links LinkMap) (models.Definitions, error) {
+	var root struct {
+		TLLogics []TLLogic `xml:"tlLogic"`
+	}
+	if err := xml.Unmarshal(data, &root); err != nil {
+		return models.Definitions{}, errors.Wrap(ErrInvalidProgram, err.Error())
+	}
+	if len(root.TLLogics) == 0 {
+		return models.Definitions{}, errors.Wrap(ErrInvalidProgram, "нет элементов tlLogic")
+	}
+
+	var defs models.Definitions
+	if len(links.Lights) == 0 {
+		for _, logic := range root.TLLogics {
+			def, err := FromTLLogic(logic)
+			if err != nil {
+				return models.Definitions{}, err
+			}
+			defs.Lights = append(defs.Lights, def)
+		}
+		return defs, nil
+	}
+
+	logics := make(map[string]TLLogic, len(root.TLLogics))
+	for _, logic := range root.TLLogics {
+		logics[logic.ID] = logic
+	}
+	for _, light := range links.Lights {
+		logic, ok := logics[light.TLLogic]
+		if !ok {
+			return models.Definitions{}, errors.Wrapf(ErrInvalidLinks, "светофор %q: нет tlLogic %q", light.Name, light.TLLogic)
+		}
+		def, err := FromLinks(logic, light)
+		if err != nil {
+			return models.Definitions{}, err
+		}
+		defs.Lights = append(defs.Lights, def)
+	}
+	return defs, nil
+}
@@ -0,0 +1,158 @@
+package sumo_test
+
+import (
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"trafficlightAPI/internal/models"
+	"trafficlightAPI/internal/sumo"
+
+	"github.com/pkg/errors"
+)
+
+func TestRoundTrip(t *testing.T) {
+	defs := models.Definitions{Lights: []models.LightDefinition{
+		{Name: "regular", Type: 1, Durations: []int{25, 3, 30}},
+		{Name: "arrow", Type: 2, Durations: []int{20, 20, 5, 10, 2, 20, 2}, Offset: 17},
+		{Name: "crossing", Type: 3, Durations: []int{20, 10}},
+	}}
+
+	data, err := sumo.Export(defs)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !strings.Contains(string(data), `state="rg"`) || !strings.Contains(string(data), `state="uu"`) {
+		t.Errorf("unexpected arrow light states:\n%s", data)
+	}
+
+	imported, err := sumo.Import(data)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(imported, defs) {
+		t.Errorf("round trip changed definitions:\ngot  %+v\nwant %+v", imported, defs)
+	}
+}
+
+func TestImport(t *testing.T) {
+	tests := []struct {
+		name    string
+		xml     string
+		want    models.LightDefinition
+		wantErr error
+	}{
+		{
+			name: "net file with extra attributes",
+			xml: `<net version="1.16"><edge id="e"/>
+				<tlLogic id="J1" type="static" programID="0" offset="5">
+					<phase duration="31" state="r" minDur="10" maxDur="40"/>
+					<phase duration="4" state="G"/>
+				</tlLogic></net>`,
+			want: models.LightDefinition{Name: "J1", Type: 3, Durations: []int{31, 4}, Offset: 5},
+		},
+		{
+			name: "fractional seconds",
+			xml: `<additional><tlLogic id="J1" type="static" programID="0" offset="4.60">
+					<phase duration="30.40" state="r"/>
+					<phase duration="9.50" state="G"/>
+				</tlLogic></additional>`,
+			want: models.LightDefinition{Name: "J1", Type: 3, Durations: []int{30, 10}, Offset: 5},
+		},
+		{
+			name:    "unknown sequence",
+			xml:     `<additional><tlLogic id="J1" type="static"><phase duration="31" state="Gr"/></tlLogic></additional>`,
+			wantErr: sumo.ErrUnknownProgram,
+		},
+		{
+			name:    "actuated program",
+			xml:     `<additional><tlLogic id="J1" type="actuated"><phase duration="31" state="r"/><phase duration="4" state="G"/></tlLogic></additional>`,
+			wantErr: sumo.ErrInvalidProgram,
+		},
+		{
+			name:    "zero duration",
+			xml:     `<additional><tlLogic id="J1"><phase duration="0" state="r"/><phase duration="4" state="G"/></tlLogic></additional>`,
+			wantErr: sumo.ErrInvalidProgram,
+		},
+		{
+			name:    "no programs",
+			xml:     `<additional/>`,
+			wantErr: sumo.ErrInvalidProgram,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defs, err := sumo.Import([]byte(tt.xml))
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("got error '%v', want '%v'", err, tt.wantErr)
+			}
+			if tt.wantErr == nil && !reflect.DeepEqual(defs.Lights[0], tt.want) {
+				t.Errorf("got %+v, want %+v", defs.Lights[0], tt.want)
+			}
+		})
+	}
+}
+
+func TestImportLinks(t *testing.T) {
+	data, err := os.ReadFile("testdata/cross.net.xml")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	links, err := sumo.LoadLinks("testdata/cross.links.yaml")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// Цикл 96 с, смещение 12.40 округляется до 12. Переход 12 зеленый в фазе
+	// с 48 с, переход 13 - в первой фазе; цикл сервера начинается с красного.
+	want := []models.LightDefinition{
+		{Name: "crossing-north", UUID: "cross-crossing-north", Type: 3, Durations: []int{65, 31}, Offset: 29},
+		{Name: "crossing-east", UUID: "cross-crossing-east", Type: 3, Durations: []int{65, 31}, Offset: 77},
+	}
+	defs, err := sumo.ImportLinks(data, links)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(defs.Lights, want) {
+		t.Errorf("got %+v, want %+v", defs.Lights, want)
+	}
+	if err := models.ValidateDefinitions(defs); err != nil {
+		t.Errorf("imported plan is not loadable: %v", err)
+	}
+
+	if _, err := sumo.Import(data); !errors.Is(err, sumo.ErrUnknownProgram) {
+		t.Errorf("import without links: got error '%v', want '%v'", err, sumo.ErrUnknownProgram)
+	}
+
+	tests := []struct {
+		name    string
+		light   sumo.LinkLight
+		wantErr error
+	}{
+		{
+			// Зеленый, желтый, красный: у обычного светофора сервера желтый перед зеленым.
+			name:    "vehicle link",
+			light:   sumo.LinkLight{TLLogic: "C", Name: "north", Links: []int{1}},
+			wantErr: sumo.ErrUnknownProgram,
+		},
+		{
+			name:    "link out of range",
+			light:   sumo.LinkLight{TLLogic: "C", Name: "north", Links: []int{16}},
+			wantErr: sumo.ErrInvalidProgram,
+		},
+		{
+			name:    "unknown tlLogic",
+			light:   sumo.LinkLight{TLLogic: "J1", Name: "north", Links: []int{0}},
+			wantErr: sumo.ErrInvalidLinks,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := sumo.ImportLinks(data, sumo.LinkMap{Lights: []sumo.LinkLight{tt.light}})
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("got error '%v', want '%v'", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,192 @@
+package webster
+
+import (
+	"math"
+	"sort"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	ErrNoApproaches      = errors.New("не заданы подходы к перекрестку")
+	ErrInvalidApproach   = errors.New("некорректные данные подхода")
+	ErrInvalidParameters = errors.New("некорректные параметры расчета")
+)
+
+type Approach struct {
+	Name           string  `json:"name"`
+	Flow           float64 `json:"flow"`            // авт/ч
+	SaturationFlow float64 `json:"saturation_flow"` // авт/ч зеленого
+	State          int     `json:"state"`           // Номер состояния светофора с зеленым для подхода
+}
+
+type Params struct {
+	LostTime float64 // Потерянное время на фазу, с
+	MinCycle float64
+	MaxCycle float64
+	MinGreen float64
+}
+
+type Split struct {
+	State     int     `json:"state"`
+	FlowRatio float64 `json:"flow_ratio"`
+	Green     float64 `json:"green"`
+}
+
+type Result struct {
+	Cycle         float64 `json:"cycle"`
+	LostTime      float64 `json:"lost_time"`
+	FlowRatio     float64 `json:"flow_ratio"`
+	Oversaturated bool    `json:"oversaturated"`
+	Splits        []Split `json:"splits"`
+}
+
+type ApproachDelay struct {
+	Name          string   `json:"name"`
+	Saturation    float64  `json:"saturation"`
+	Delay         *float64 `json:"delay,omitempty"` // с/авт, отсутствует при перенасыщении
+	Oversaturated bool     `json:"oversaturated,omitempty"`
+}
+
+type DelayEstimate struct {
+	Cycle      float64         `json:"cycle"`
+	Delay      *float64        `json:"delay,omitempty"` // Средневзвешенная по интенсивности задержка
+	Approaches []ApproachDelay `json:"approaches"`
+}
+
+func validate(approaches []Approach) error {
+	if len(approaches) == 0 {
+		return ErrNoApproaches
+	}
+	for _, a := range approaches {
+		if a.Flow < 0 || a.SaturationFlow <= 0 || a.State < 1 {
+			return errors.Wrapf(ErrInvalidApproach, "name:%s, flow:%.1f, saturation_flow:%.1f, state:%d",
+				a.Name, a.Flow, a.SaturationFlow, a.State)
+		}
+	}
+	return nil
+}
+
+// Compute рассчитывает оптимальный цикл и распределение зеленого по методу Вебстера.
+// Подходы с одинаковым State обслуживаются одной фазой, критическим считается
+// подход с наибольшим отношением интенсивности к потоку насыщения.
+func Compute(approaches []Approach, params Params) (Result, error) {
+	if err := validate(approaches); err != nil {
+		return Result{}, err
+	}
+	if params.LostTime < 0 || params.MinCycle <= 0 || params.MaxCycle < params.MinCycle || params.MinGreen < 0 {
+		return Result{}, errors.Wrapf(ErrInvalidParameters, "%+v", params)
+	}
+
+	critical := make(map[int]float64)
+	for _, a := range approaches {
+		critical[a.State] = math.Max(critical[a.State], a.Flow/a.SaturationFlow)
+	}
+
+	states := make([]int, 0, len(critical))
+	for state := range critical {
+		states = append(states, state)
+	}
+	sort.Ints(states)
+
+	result := Result{LostTime: params.LostTime * float64(len(states))}
+	for _, state := range states {
+		result.FlowRatio += critical[state]
+	}
+
+	if result.FlowRatio >= 1 {
+		result.Oversaturated = true
+		result.Cycle = params.MaxCycle
+	} else {
+		optimal := (1.5*result.LostTime + 5) / (1 - result.FlowRatio)
+		result.Cycle = math.Min(math.Max(optimal, params.MinCycle), params.MaxCycle)
+	}
+
+	effectiveGreen := result.Cycle - result.LostTime
+	for _, state := range states {
+		green := effectiveGreen / float64(len(states))
+		if result.FlowRatio > 0 {
+			green = effectiveGreen * critical[state] / result.FlowRatio
+		}
+		result.Splits = append(result.Splits, Split{
+			State:     state,
+			FlowRatio: critical[state],
+			Green:     math.Max(green, params.MinGreen),
+		})
+	}
+
+	return result, nil
+}
+
+// EstimateDelay оценивает среднюю задержку по формуле Вебстера для плана,
+// заданного длительностями состояний светофора.
+func EstimateDelay(approaches []Approach, plan []int) (DelayEstimate, error) {
+	if err := validate(approaches); err != nil {
+		return DelayEstimate{}, err
+	}
+
+	estimate := DelayEstimate{}
+	for _, d := range plan {
+		estimate.Cycle += float64(d)
+	}
+
+	var weighted, totalFlow float64
+	oversaturated := false
+	for _, a := range approaches {
+		if a.State > len(plan) {
+			return DelayEstimate{}, errors.Wrapf(ErrInvalidApproach, "name:%s, state:%d, фаз в плане:%d", a.Name, a.State, len(plan))
+		}
+
+		green := float64(plan[a.State-1])
+		delay, saturation, ok := approachDelay(estimate.Cycle, green, a.Flow, a.SaturationFlow)
+		item := ApproachDelay{Name: a.Name, Saturation: saturation}
+		if ok {
+			item.Delay = &delay
+			weighted += delay * a.Flow
+			totalFlow += a.Flow
+		} else {
+			item.Oversaturated = true
+			oversaturated = true
+		}
+		estimate.Approaches = append(estimate.Approaches, item)
+	}
+
+	if !oversaturated && totalFlow > 0 {
+		delay := weighted / totalFlow
+		estimate.Delay = &delay
+	}
+
+	return estimate, nil
+}
+
+func approachDelay(cycle, green, flow, saturationFlow float64) (float64, float64, bool) {
+	q := flow / 3600
+	s := saturationFlow / 3600
+	lambda := green / cycle
+	x := q / (lambda * s)
+	if x >= 1 {
+		return 0, x, false
+	}
+	if q == 0 {
+		return 0, x, true
+	}
+
+	uniform := cycle * (1 - lambda) * (1 - lambda) / (2 * (1 - lambda*x))
+	random := x * x / (2 * q * (1 - x))
+	correction := 0.65 * math.Cbrt(cycle/(q*q)) * math.Pow(x, 2+5*lambda)
+
+	return math.Max(uniform+random-correction, 0), x, true
+}
+
+// PlanFromSplits заменяет длительности зеленых состояний плана рассчитанными,
+// остальные состояния (переходные) остаются без изменений.
+func PlanFromSplits(plan []int, splits []Split) ([]int, error) {
+	recommended := append([]int(nil), plan...)
+	for _, split := range splits {
+		if split.State > len(recommended) {
+			return nil, errors.Wrapf(ErrInvalidApproach, "state:%d, фаз в плане:%d", split.State, len(plan))
+		}
+		recommended[split.State-1] = max(int(math.Round(split.Green)), 1)
+	}
+	return recommended, nil
+}
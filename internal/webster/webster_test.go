@@ -0,0 +1,124 @@
+package webster_test
+
+import (
+	"math"
+	"testing"
+	"trafficlightAPI/internal/webster"
+
+	"github.com/pkg/errors"
+)
+
+var params = webster.Params{LostTime: 4, MinCycle: 30, MaxCycle: 120, MinGreen: 5}
+
+func TestCompute(t *testing.T) {
+	tests := []struct {
+		name          string
+		approaches    []webster.Approach
+		wantCycle     float64
+		wantGreens    []float64
+		oversaturated bool
+		wantErr       error
+	}{
+		{
+			name: "two phases",
+			approaches: []webster.Approach{
+				{Name: "north", Flow: 540, SaturationFlow: 1800, State: 3},
+				{Name: "south", Flow: 450, SaturationFlow: 1800, State: 3},
+				{Name: "east", Flow: 450, SaturationFlow: 1800, State: 1},
+			},
+			wantCycle:  (1.5*8 + 5) / (1 - 0.55),
+			wantGreens: []float64{((1.5*8+5)/(1-0.55) - 8) * 0.25 / 0.55, ((1.5*8+5)/(1-0.55) - 8) * 0.3 / 0.55},
+		},
+		{
+			name:       "light traffic clamped to min cycle",
+			approaches: []webster.Approach{{Name: "a", Flow: 10, SaturationFlow: 1800, State: 1}},
+			wantCycle:  30,
+			wantGreens: []float64{26},
+		},
+		{
+			name: "oversaturated uses max cycle",
+			approaches: []webster.Approach{
+				{Name: "a", Flow: 1200, SaturationFlow: 1800, State: 1},
+				{Name: "b", Flow: 1200, SaturationFlow: 1800, State: 2},
+			},
+			wantCycle:     120,
+			wantGreens:    []float64{56, 56},
+			oversaturated: true,
+		},
+		{
+			name:    "no approaches",
+			wantErr: webster.ErrNoApproaches,
+		},
+		{
+			name:       "zero saturation flow",
+			approaches: []webster.Approach{{Name: "a", Flow: 10, SaturationFlow: 0, State: 1}},
+			wantErr:    webster.ErrInvalidApproach,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := webster.Compute(tt.approaches, params)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Compute() error = '%v', wantErr '%v'", err, tt.wantErr)
+			}
+			if tt.wantErr != nil {
+				return
+			}
+			if math.Abs(got.Cycle-tt.wantCycle) > 1e-6 {
+				t.Errorf("cycle = %v, want %v", got.Cycle, tt.wantCycle)
+			}
+			if got.Oversaturated != tt.oversaturated {
+				t.Errorf("oversaturated = %v, want %v", got.Oversaturated, tt.oversaturated)
+			}
+			if len(got.Splits) != len(tt.wantGreens) {
+				t.Fatalf("got %d splits, want %d", len(got.Splits), len(tt.wantGreens))
+			}
+			for i, split := range got.Splits {
+				if math.Abs(split.Green-tt.wantGreens[i]) > 1e-6 {
+					t.Errorf("split %d green = %v, want %v", i, split.Green, tt.wantGreens[i])
+				}
+			}
+		})
+	}
+}
+
+func TestEstimateDelay(t *testing.T) {
+	approaches := []webster.Approach{
+		{Name: "main", Flow: 600, SaturationFlow: 1800, State: 1},
+		{Name: "side", Flow: 300, SaturationFlow: 1800, State: 3},
+	}
+
+	balanced, err := webster.EstimateDelay(approaches, []int{30, 3, 20, 3})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if balanced.Cycle != 56 || balanced.Delay == nil {
+		t.Fatalf("unexpected estimate: %+v", balanced)
+	}
+
+	starved, err := webster.EstimateDelay(approaches, []int{5, 3, 45, 3})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if starved.Delay != nil || !starved.Approaches[0].Oversaturated {
+		t.Errorf("expected oversaturated main approach, got %+v", starved)
+	}
+
+	if _, err := webster.EstimateDelay(approaches, []int{30, 3}); !errors.Is(err, webster.ErrInvalidApproach) {
+		t.Errorf("expected ErrInvalidApproach, got %v", err)
+	}
+}
+
+func TestPlanFromSplits(t *testing.T) {
+	plan, err := webster.PlanFromSplits([]int{20, 20, 5, 10, 2, 20, 2}, []webster.Split{{State: 2, Green: 14.6}, {State: 6, Green: 31.2}})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := []int{20, 15, 5, 10, 2, 31, 2}
+	for i := range want {
+		if plan[i] != want[i] {
+			t.Fatalf("got %v, want %v", plan, want)
+		}
+	}
+}
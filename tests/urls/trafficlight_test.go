@@ -3,13 +3,22 @@ package urls
 import (
 	"bytes"
 	"encoding/json"
+	"fmt"
+	"log/slog"
 	"net/http"
 	"net/http/httptest"
+	"path/filepath"
 	"strings"
 	"testing"
+	"time"
+	"trafficlightAPI/internal/devices"
+	"trafficlightAPI/internal/faults"
 	"trafficlightAPI/internal/handlers"
 	"trafficlightAPI/internal/middleware/logger"
+	"trafficlightAPI/internal/mmu"
 	"trafficlightAPI/internal/models"
+	"trafficlightAPI/internal/overrides"
+	"trafficlightAPI/internal/storage"
 )
 
 func TestTrafficLightHandler(t *testing.T) {
@@ -128,6 +137,219 @@ func TestTrafficLightHandler(t *testing.T) {
 	}
 }
 
+func TestRegisteredDeviceType(t *testing.T) {
+	logger.InitLogger("../../logs/", "dev")
+
+	store, err := storage.OpenBolt(filepath.Join(t.TempDir(), "state.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	registry := devices.NewRegistry(store)
+	if _, err := registry.Create(storage.Device{UUID: "registered", Type: 2}); err != nil {
+		t.Fatal(err)
+	}
+	devices.SetDefault(registry)
+	defer devices.SetDefault(nil)
+
+	tests := []struct {
+		name       string
+		query      string
+		wantStatus int
+		wantErrMsg string
+	}{
+		{
+			name:       "type inferred",
+			query:      "?data={\"uuid\":\"registered\",\"current_state\":7,\"current_time\":19}",
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "matching type",
+			query:      "?type=2&data={\"uuid\":\"registered\",\"current_state\":7,\"current_time\":19}",
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "mismatched type",
+			query:      "?type=1&data={\"uuid\":\"registered\",\"current_state\":1,\"current_time\":10}",
+			wantStatus: http.StatusBadRequest,
+			wantErrMsg: handlers.ErrTypeMismatch.Error(),
+		},
+		{
+			name:       "unregistered without type",
+			query:      "?data={\"uuid\":\"unknown\",\"current_state\":1,\"current_time\":10}",
+			wantStatus: http.StatusBadRequest,
+			wantErrMsg: handlers.ErrNoType.Error(),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			handlers.ServeTrafficRoute(rr, httptest.NewRequest("GET", "/trafficlight"+tt.query, nil))
+
+			if rr.Code != tt.wantStatus {
+				t.Errorf("handler returned wrong status code: got %v want %v: %s", rr.Code, tt.wantStatus, rr.Body.String())
+			}
+			if tt.wantErrMsg != "" && !strings.Contains(rr.Body.String(), tt.wantErrMsg) {
+				t.Errorf("handler returned unexpected error: got %v want %v", rr.Body.String(), tt.wantErrMsg)
+			}
+		})
+	}
+}
+
+// poll опрашивает /trafficlight и возвращает код и ответ.
+func poll(t *testing.T, query string) (int, models.TrafficResponse) {
+	t.Helper()
+	rr := httptest.NewRecorder()
+	handlers.ServeTrafficRoute(rr, httptest.NewRequest("GET", "/trafficlight"+query, nil))
+	var resp models.TrafficResponse
+	if rr.Code == http.StatusOK {
+		if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return rr.Code, resp
+}
+
+func TestHeldLight(t *testing.T) {
+	logger.InitLogger("../../logs/", "dev")
+
+	store, err := storage.OpenBolt(filepath.Join(t.TempDir(), "state.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	manager := overrides.NewManager(store, nil, nil)
+	models.SetOverride(manager.Resolve)
+	defer models.SetOverride(nil)
+	models.AddPin(manager.Pinned)
+
+	hold, err := manager.Create(storage.Override{UUID: "held", Action: overrides.ActionHold, Reason: "проверка", ExpiresAt: time.Now().Add(time.Minute)}, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Время устройства растет дальше плана, пока состояние удерживается.
+	for _, currentTime := range []int{19, 20, 25, 120} {
+		code, resp := poll(t, fmt.Sprintf("?type=1&data={\"uuid\":\"held\",\"current_state\":3,\"current_time\":%d}", currentTime))
+		if code != http.StatusOK || resp.NextState != "3" || resp.Override == nil {
+			t.Fatalf("current_time %d: status %d, response %+v, want held green", currentTime, code, resp)
+		}
+	}
+
+	if _, err := manager.Release(hold.ID, "готово", "test"); err != nil {
+		t.Fatal(err)
+	}
+	// После снятия план переключает светофор, не отвергая накопленное время.
+	if code, resp := poll(t, "?type=1&data={\"uuid\":\"held\",\"current_state\":3,\"current_time\":121}"); code != http.StatusOK || resp.NextState != "1" {
+		t.Fatalf("after release: status %d, response %+v, want red", code, resp)
+	}
+	if code, _ := poll(t, "?type=1&data={\"uuid\":\"held\",\"current_state\":1,\"current_time\":25}"); code != http.StatusBadRequest {
+		t.Errorf("next state beyond plan: status %d, want 400", code)
+	}
+}
+
+func TestDegradedLight(t *testing.T) {
+	logger.InitLogger("../../logs/", "dev")
+
+	store, err := storage.OpenBolt(filepath.Join(t.TempDir(), "state.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	manager := faults.NewManager(store, faults.DefaultPolicy(), nil, nil)
+	models.SetFaults(manager.Resolve)
+	defer models.SetFaults(nil)
+	models.AddPin(manager.Pinned)
+
+	if _, err := manager.Report(storage.Fault{UUID: "degraded", Type: 1, Lamp: models.LampRed}); err != nil {
+		t.Fatal(err)
+	}
+	// Мигающий желтый держится, сколько бы ни длилось состояние, в том числе
+	// если первый опрос после неисправности пришел уже позже плана.
+	for _, currentTime := range []int{25, 19, 20, 120} {
+		code, resp := poll(t, fmt.Sprintf("?type=1&data={\"uuid\":\"degraded\",\"current_state\":2,\"current_time\":%d}", currentTime))
+		if code != http.StatusOK || resp.NextState != "2" || !resp.Flashing || resp.Degraded != faults.ActionFlashYellow {
+			t.Fatalf("current_time %d: status %d, response %+v, want flashing yellow", currentTime, code, resp)
+		}
+	}
+}
+
+func TestFailsafeLight(t *testing.T) {
+	logger.InitLogger("../../logs/", "dev")
+
+	store, err := storage.OpenBolt(filepath.Join(t.TempDir(), "state.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	matrix := mmu.Matrix{Intersections: []mmu.Intersection{{
+		Name:      "Ленина-Мира",
+		Lights:    []string{"failsafe-ns", "failsafe-ew"},
+		Conflicts: [][2]string{{"failsafe-ns", "failsafe-ew"}},
+	}}}
+	monitor := mmu.NewMonitor(mmu.Config{ReportTTL: 2 * time.Second, StuckAfter: 3 * time.Second}, matrix, store, nil, slog.Default())
+	monitor.SetCommanded(func(uuid string) (int, int, bool) { return 1, 3, true })
+	now := time.Now()
+	monitor.Report(mmu.Output{UUID: "failsafe-ns", Lamps: models.Lamps{Green: true}, Time: now})
+	if fault, err := monitor.Report(mmu.Output{UUID: "failsafe-ew", Lamps: models.Lamps{Green: true}, Time: now}); err != nil || fault == nil {
+		t.Fatalf("conflict is not latched: %v", err)
+	}
+	models.SetFailsafe(monitor.Resolve)
+	defer models.SetFailsafe(nil)
+	models.AddPin(monitor.Pinned)
+
+	// Мигающий красный держится до сброса, сколько бы ни длилось состояние.
+	for _, currentTime := range []int{25, 19, 20, 120} {
+		code, resp := poll(t, fmt.Sprintf("?type=1&data={\"uuid\":\"failsafe-ns\",\"current_state\":1,\"current_time\":%d}", currentTime))
+		if code != http.StatusOK || resp.NextState != "1" || !resp.Flashing || resp.Failsafe != mmu.KindConflict {
+			t.Fatalf("current_time %d: status %d, response %+v, want flashing red", currentTime, code, resp)
+		}
+	}
+}
+
 func intPtr(i int) *int {
 	return &i
 }
+
+func TestSUMOImport(t *testing.T) {
+	logger.InitLogger("../../logs/", "dev")
+	original := models.Plan(3)
+	defer models.ApplyPlan(3, original)
+
+	tests := []struct {
+		name       string
+		xml        string
+		wantStatus int
+	}{
+		{
+			name:       "one program per type",
+			xml:        `<additional><tlLogic id="P1"><phase duration="30" state="r"/><phase duration="15" state="G"/></tlLogic></additional>`,
+			wantStatus: http.StatusOK,
+		},
+		{
+			name: "two programs of one type",
+			xml: `<additional><tlLogic id="P1"><phase duration="25" state="r"/><phase duration="10" state="G"/></tlLogic>` +
+				`<tlLogic id="P2"><phase duration="40" state="r"/><phase duration="20" state="G"/></tlLogic></additional>`,
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "offset",
+			xml:        `<additional><tlLogic id="P1" offset="5"><phase duration="25" state="r"/><phase duration="10" state="G"/></tlLogic></additional>`,
+			wantStatus: http.StatusBadRequest,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			handlers.ServeSUMOImport(rr, httptest.NewRequest("POST", "/plans/sumo", strings.NewReader(tt.xml)))
+			if rr.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", rr.Code, tt.wantStatus, rr.Body.String())
+			}
+			// Отклоненный импорт не меняет планов.
+			if got := models.Plan(3); got[0] != 30 || got[1] != 15 {
+				t.Errorf("plan = %v, want [30 15]", got)
+			}
+		})
+	}
+}